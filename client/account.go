@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/plantexchange/app/models"
+)
+
+// RequestDataExport asks for a zip of the logged-in user's data. If an export is
+// already in progress it is returned instead.
+func (c *Client) RequestDataExport(ctx context.Context) (models.DataExport, error) {
+	var export models.DataExport
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/account/export", nil, nil, &export)
+	return export, err
+}
+
+// GetDataExports lists the logged-in user's data exports
+func (c *Client) GetDataExports(ctx context.Context) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := c.getJSON(ctx, "/api/v1/account/exports", nil, &exports)
+	return exports, err
+}
+
+// DownloadDataExport downloads the zip of a ready export
+func (c *Client) DownloadDataExport(ctx context.Context, id string) ([]byte, error) {
+	return c.download(ctx, "/api/v1/account/exports/"+escape(id)+"/download")
+}
+
+// GetAccountDeletion gets when the logged-in user's account will be deleted
+func (c *Client) GetAccountDeletion(ctx context.Context) (models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	err := c.getJSON(ctx, "/api/v1/account/deletion", nil, &deletion)
+	return deletion, err
+}
+
+// DeleteAccount schedules the logged-in user's account for deletion and logs out
+func (c *Client) DeleteAccount(ctx context.Context, password string) (models.AccountDeletion, error) {
+	request := struct {
+		Password string `json:"password"`
+	}{password}
+
+	var deletion models.AccountDeletion
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/account/deletion", nil, request, &deletion)
+	return deletion, err
+}
+
+// CancelAccountDeletion cancels a scheduled deletion of the logged-in user's account
+func (c *Client) CancelAccountDeletion(ctx context.Context) error {
+	return c.sendJSON(ctx, http.MethodDelete, "/api/v1/account/deletion", nil, nil, nil)
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/plantexchange/app/models"
+)
+
+// RegisterRequest is a new account
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	Location string `json:"location,omitempty"`
+	Bio      string `json:"bio,omitempty"`
+}
+
+// Register creates an account and logs the client in
+func (c *Client) Register(ctx context.Context, request RegisterRequest) (models.UserResponse, error) {
+	var user models.UserResponse
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/register", nil, request, &user)
+	return user, err
+}
+
+// Login logs the client in
+func (c *Client) Login(ctx context.Context, email, password string) (models.UserResponse, error) {
+	credentials := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{email, password}
+
+	var user models.UserResponse
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/login", nil, credentials, &user)
+	return user, err
+}
+
+// Logout ends the client's session
+func (c *Client) Logout(ctx context.Context) error {
+	return c.sendJSON(ctx, http.MethodPost, "/api/v1/logout", nil, nil, nil)
+}
+
+// CheckAuth reports whether the client is logged in, and as whom
+func (c *Client) CheckAuth(ctx context.Context) (models.AuthStatus, error) {
+	var status models.AuthStatus
+	err := c.getJSON(ctx, "/api/v1/check-auth", nil, &status)
+	return status, err
+}
@@ -0,0 +1,201 @@
+// Package client is a typed Go client for the Plant Exchange API.
+//
+// Each method calls the operation of the same name in the OpenAPI document served
+// at /api/v1/openapi.json and returns the models the server encodes. The client keeps
+// the session cookie set by Register and Login, so later calls are authenticated.
+//
+//	c, err := client.New("https://plants.example.com", nil)
+//	if err != nil { ... }
+//	if _, err := c.Login(ctx, "ada@example.com", "password"); err != nil { ... }
+//	listings, err := c.GetListings(ctx, client.ListingFilter{Type: "cutting"})
+//
+// Errors returned by the server are *Error values carrying the error code and
+// request ID.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the API of one server
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// New creates a client for the server at baseURL. If httpClient is nil a client with
+// its own cookie jar is used; a custom client needs a cookie jar to stay logged in.
+func New(baseURL string, httpClient *http.Client) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme and host are required", baseURL)
+	}
+
+	if httpClient == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{Jar: jar}
+	}
+
+	return &Client{baseURL: parsed, httpClient: httpClient}, nil
+}
+
+// Error is an error response from the API
+type Error struct {
+	Status    int               // HTTP status
+	Code      string            `json:"code"` // e.g. not_found or validation_failed
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields"` // per-field problems, keyed by JSON field name
+	Details   json.RawMessage   `json:"details"`
+	RequestID string            `json:"requestId"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s, request %s)", e.Message, e.Status, e.Code, e.RequestID)
+}
+
+// IsNotFound reports whether err is a not_found error from the API
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+// getJSON calls a GET operation and decodes the JSON response into result
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, result interface{}) error {
+	return c.sendJSON(ctx, http.MethodGet, path, query, nil, result)
+}
+
+// sendJSON calls an operation with an optional JSON body and decodes the JSON response into result
+func (c *Client) sendJSON(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	response, err := c.send(ctx, method, path, query, reader, contentType)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return decodeResponse(response, result)
+}
+
+// send makes a request and returns the response if its status is a success
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	request, err := c.newRequest(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+		return nil, readError(response)
+	}
+	return response, nil
+}
+
+// newRequest builds a request for a path relative to the base URL
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Request, error) {
+	target := *c.baseURL
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	request.Header.Set("Accept", "application/json")
+	return request, nil
+}
+
+// download calls a GET operation that returns a file and reads its content
+func (c *Client) download(ctx context.Context, path string) ([]byte, error) {
+	response, err := c.send(ctx, http.MethodGet, path, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+// decodeResponse decodes a JSON success response into result, if one is wanted
+func decodeResponse(response *http.Response, result interface{}) error {
+	if result == nil {
+		io.Copy(io.Discard, response.Body)
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("cannot decode %s response: %w", response.Request.URL.Path, err)
+	}
+	return nil
+}
+
+// readError converts an error response to an *Error. Responses that are not an
+// error envelope, e.g. from a proxy, keep their status and text.
+func readError(response *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+
+	var envelope struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal(data, &envelope); err == nil && envelope.Error != nil {
+		envelope.Error.Status = response.StatusCode
+		return envelope.Error
+	}
+
+	return &Error{
+		Status:    response.StatusCode,
+		Code:      strings.ToLower(strings.ReplaceAll(http.StatusText(response.StatusCode), " ", "_")),
+		Message:   strings.TrimSpace(string(data)),
+		RequestID: response.Header.Get("X-Request-ID"),
+	}
+}
+
+// success is the body of actions that return no resource
+type success struct {
+	Success bool `json:"success"`
+}
+
+// escape escapes an ID for use as a path segment
+func escape(id string) string {
+	return url.PathEscape(id)
+}
+
+// setPage sets the page and limit query parameters of paginated operations, if not zero
+func setPage(query url.Values, page, limit int) {
+	if page != 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/plantexchange/app/openapi"
+)
+
+// Health is the result of a health or readiness check
+type Health struct {
+	Status string `json:"status"` // ok, ready or unavailable
+	Error  string `json:"error,omitempty"`
+}
+
+// Healthz reports whether the server is alive
+func (c *Client) Healthz(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.getJSON(ctx, "/healthz", nil, &health)
+	return health, err
+}
+
+// Readyz reports whether the server can handle requests. An unready server
+// returns an *Error with status 503.
+func (c *Client) Readyz(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.getJSON(ctx, "/readyz", nil, &health)
+	return health, err
+}
+
+// Metrics returns the server's metrics in the Prometheus text format
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	response, err := c.send(ctx, http.MethodGet, "/metrics", nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	return string(data), err
+}
+
+// OpenAPI fetches the server's OpenAPI document
+func (c *Client) OpenAPI(ctx context.Context) (*openapi.Document, error) {
+	var doc openapi.Document
+	err := c.getJSON(ctx, "/api/v1/openapi.json", nil, &doc)
+	return &doc, err
+}
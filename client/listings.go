@@ -0,0 +1,263 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/plantexchange/app/models"
+)
+
+// ListingFilter narrows GetListings; empty fields match everything
+type ListingFilter struct {
+	UserID    string
+	Type      string // plant, seed or cutting
+	PlantType string
+	Location  string // part of the location, case-insensitive
+}
+
+// ListingUpdate changes a listing; nil fields are left unchanged
+type ListingUpdate struct {
+	Title       *string           `json:"title,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Type        *string           `json:"type,omitempty"`
+	PlantType   *string           `json:"plantType,omitempty"`
+	Price       *float64          `json:"price,omitempty"`
+	TradeFor    *string           `json:"tradeFor,omitempty"`
+	Location    *string           `json:"location,omitempty"`
+	Images      *[]string         `json:"images,omitempty"`
+	Status      *string           `json:"status,omitempty"`
+	CareSheet   *models.CareSheet `json:"careSheet,omitempty"`
+}
+
+// ImportRequest is a bulk listing import
+type ImportRequest struct {
+	Filename string // used to guess the format when Format is empty
+	File     []byte // CSV or JSON Lines listings
+	Images   []byte // optional zip of the images the rows refer to
+	Format   string // csv or jsonl
+	DryRun   bool
+	Partial  bool // create the valid rows even if others fail
+}
+
+// Favorite actions for ToggleFavorite
+const (
+	FavoriteAdd    = "add"
+	FavoriteRemove = "remove"
+)
+
+// GetListings lists published listings
+func (c *Client) GetListings(ctx context.Context, filter ListingFilter) ([]models.ListingWithUser, error) {
+	query := url.Values{}
+	setQuery(query, "userId", filter.UserID)
+	setQuery(query, "type", filter.Type)
+	setQuery(query, "plantType", filter.PlantType)
+	setQuery(query, "location", filter.Location)
+
+	var listings []models.ListingWithUser
+	err := c.getJSON(ctx, "/api/v1/listings", query, &listings)
+	return listings, err
+}
+
+// SearchListings searches published listings by title, description and plant type
+func (c *Client) SearchListings(ctx context.Context, q string) ([]models.ListingWithUser, error) {
+	var listings []models.ListingWithUser
+	err := c.getJSON(ctx, "/api/v1/listings/search", url.Values{"q": {q}}, &listings)
+	return listings, err
+}
+
+// GetArchivedListings lists the logged-in user's expired, sold and traded listings
+func (c *Client) GetArchivedListings(ctx context.Context) ([]models.Listing, error) {
+	var listings []models.Listing
+	err := c.getJSON(ctx, "/api/v1/listings/archive", nil, &listings)
+	return listings, err
+}
+
+// GetDraftListings lists the logged-in user's drafts and scheduled listings
+func (c *Client) GetDraftListings(ctx context.Context) ([]models.Listing, error) {
+	var listings []models.Listing
+	err := c.getJSON(ctx, "/api/v1/listings/drafts", nil, &listings)
+	return listings, err
+}
+
+// CreateListing creates a listing, or a draft if its status is draft. A listing
+// held for moderation has no ID and Held set.
+func (c *Client) CreateListing(ctx context.Context, listing models.Listing) (models.Listing, error) {
+	var created models.Listing
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/listings", nil, listing, &created)
+	return created, err
+}
+
+// ImportListings creates listings in bulk. When every row fails the result is
+// returned together with an *Error with status 422.
+func (c *Client) ImportListings(ctx context.Context, request ImportRequest) (models.ImportResult, error) {
+	// Build the multipart upload
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	filename := request.Filename
+	if filename == "" {
+		filename = "listings." + request.Format
+	}
+	if err := writeFormFile(form, "file", filename, request.File); err != nil {
+		return models.ImportResult{}, err
+	}
+	if request.Images != nil {
+		if err := writeFormFile(form, "images", "images.zip", request.Images); err != nil {
+			return models.ImportResult{}, err
+		}
+	}
+	form.WriteField("format", request.Format)
+	form.WriteField("dryRun", strconv.FormatBool(request.DryRun))
+	form.WriteField("partial", strconv.FormatBool(request.Partial))
+	if err := form.Close(); err != nil {
+		return models.ImportResult{}, err
+	}
+
+	httpRequest, err := c.newRequest(ctx, http.MethodPost, "/api/v1/listings/import", nil, &body, form.FormDataContentType())
+	if err != nil {
+		return models.ImportResult{}, err
+	}
+	response, err := c.httpClient.Do(httpRequest)
+	if err != nil {
+		return models.ImportResult{}, err
+	}
+	defer response.Body.Close()
+
+	// An import blocked by invalid rows still reports each row
+	var result models.ImportResult
+	switch {
+	case response.StatusCode == http.StatusUnprocessableEntity:
+		if err := decodeResponse(response, &result); err != nil {
+			return result, err
+		}
+		return result, &Error{
+			Status:    response.StatusCode,
+			Code:      "unprocessable",
+			Message:   "No listings were created",
+			RequestID: response.Header.Get("X-Request-ID"),
+		}
+	case response.StatusCode >= 400:
+		return result, readError(response)
+	}
+	err = decodeResponse(response, &result)
+	return result, err
+}
+
+// GetListing gets a listing with its seller. With a buyerLocation the listing's
+// regional restrictions are checked for it.
+func (c *Client) GetListing(ctx context.Context, id, buyerLocation string) (models.ListingWithUser, error) {
+	query := url.Values{}
+	setQuery(query, "buyerLocation", buyerLocation)
+
+	var listing models.ListingWithUser
+	err := c.getJSON(ctx, "/api/v1/listings/"+escape(id), query, &listing)
+	return listing, err
+}
+
+// UpdateListing updates one of the logged-in user's listings
+func (c *Client) UpdateListing(ctx context.Context, id string, update ListingUpdate) (models.Listing, error) {
+	var listing models.Listing
+	err := c.sendJSON(ctx, http.MethodPut, "/api/v1/listings/"+escape(id), nil, update, &listing)
+	return listing, err
+}
+
+// DeleteListing deletes one of the logged-in user's listings
+func (c *Client) DeleteListing(ctx context.Context, id string) error {
+	return c.sendJSON(ctx, http.MethodDelete, "/api/v1/listings/"+escape(id), nil, nil, nil)
+}
+
+// RenewListing makes an available or expired listing available for another lifetime
+func (c *Client) RenewListing(ctx context.Context, id string) (models.Listing, error) {
+	var listing models.Listing
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/listings/"+escape(id)+"/renew", nil, nil, &listing)
+	return listing, err
+}
+
+// PublishListing publishes a draft now, or schedules it if publishAt is given
+func (c *Client) PublishListing(ctx context.Context, id string, publishAt *time.Time) (models.Listing, error) {
+	var body interface{}
+	if publishAt != nil {
+		body = struct {
+			PublishAt *time.Time `json:"publishAt"`
+		}{publishAt}
+	}
+
+	var listing models.Listing
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/listings/"+escape(id)+"/publish", nil, body, &listing)
+	return listing, err
+}
+
+// GetListingRevisions lists a listing's revisions, oldest first
+func (c *Client) GetListingRevisions(ctx context.Context, id string) ([]models.ListingRevision, error) {
+	var revisions []models.ListingRevision
+	err := c.getJSON(ctx, "/api/v1/listings/"+escape(id)+"/revisions", nil, &revisions)
+	return revisions, err
+}
+
+// GetListingRevisionDiff compares two revisions of a listing. Zero revision
+// numbers default to the latest revision and the one before it.
+func (c *Client) GetListingRevisionDiff(ctx context.Context, id string, from, to int) (models.RevisionDiff, error) {
+	query := url.Values{}
+	if from > 0 {
+		query.Set("from", strconv.Itoa(from))
+	}
+	if to > 0 {
+		query.Set("to", strconv.Itoa(to))
+	}
+
+	var diff models.RevisionDiff
+	err := c.getJSON(ctx, "/api/v1/listings/"+escape(id)+"/revisions/diff", query, &diff)
+	return diff, err
+}
+
+// GetCareSheetDefaults suggests care information for a listing from the species dataset
+func (c *Client) GetCareSheetDefaults(ctx context.Context, title, plantType string) (models.CareSheet, error) {
+	query := url.Values{}
+	setQuery(query, "title", title)
+	setQuery(query, "plantType", plantType)
+
+	var careSheet models.CareSheet
+	err := c.getJSON(ctx, "/api/v1/care-sheets/defaults", query, &careSheet)
+	return careSheet, err
+}
+
+// ToggleFavorite adds or removes a favorite listing; action is FavoriteAdd or
+// FavoriteRemove. It reports false if the listing already was, or was not, a favorite.
+func (c *Client) ToggleFavorite(ctx context.Context, listingID, action string) (bool, error) {
+	request := struct {
+		ListingID string `json:"listingId"`
+		Action    string `json:"action"`
+	}{listingID, action}
+
+	var result success
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/favorites", nil, request, &result)
+	return result.Success, err
+}
+
+// GetFavorites lists the logged-in user's favorite listings
+func (c *Client) GetFavorites(ctx context.Context) ([]models.ListingWithUser, error) {
+	var listings []models.ListingWithUser
+	err := c.getJSON(ctx, "/api/v1/favorites", nil, &listings)
+	return listings, err
+}
+
+// setQuery sets a query parameter if value is not empty
+func setQuery(query url.Values, name, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
+
+// writeFormFile adds a file part to a multipart form
+func writeFormFile(form *multipart.Writer, field, filename string, data []byte) error {
+	part, err := form.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(data)
+	return err
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/plantexchange/app/models"
+)
+
+// GetMessages lists the messages the logged-in user sent or received
+func (c *Client) GetMessages(ctx context.Context) ([]models.MessageWithUser, error) {
+	var messages []models.MessageWithUser
+	err := c.getJSON(ctx, "/api/v1/messages", nil, &messages)
+	return messages, err
+}
+
+// SendMessage sends a message to ToID about ListingID. Photos are attached by
+// the IDs returned by UploadMessageAttachment.
+// A message held for moderation has no ID and Held set.
+func (c *Client) SendMessage(ctx context.Context, message models.Message) (models.SentMessage, error) {
+	body := struct {
+		models.Message
+		AttachmentIDs []string `json:"attachmentIds,omitempty"`
+	}{Message: message}
+	body.Attachments = nil
+	for _, attachment := range message.Attachments {
+		body.AttachmentIDs = append(body.AttachmentIDs, attachment.ID)
+	}
+
+	var sent models.SentMessage
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/messages", nil, body, &sent)
+	return sent, err
+}
+
+// MessageSearch is a search of the logged-in user's messages; empty filters match everything
+type MessageSearch struct {
+	Query     string // words, "quoted phrases", or and -excluded words
+	UserID    string // only messages with this user
+	ListingID string // only conversations about this listing
+}
+
+// SearchMessages searches the messages of the logged-in user's conversations, best
+// matches first. Zero page and limit use the server's defaults.
+func (c *Client) SearchMessages(ctx context.Context, search MessageSearch, page, limit int) (models.MessageSearchPage, error) {
+	query := url.Values{}
+	query.Set("q", search.Query)
+	setQuery(query, "userId", search.UserID)
+	setQuery(query, "listingId", search.ListingID)
+	setPage(query, page, limit)
+
+	var result models.MessageSearchPage
+	err := c.getJSON(ctx, "/api/v1/messages/search", query, &result)
+	return result, err
+}
+
+// UploadMessageAttachment uploads a JPEG, PNG or GIF photo to send with a message
+func (c *Client) UploadMessageAttachment(ctx context.Context, filename string, content []byte) (models.MessageAttachment, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := writeFormFile(form, "file", filename, content); err != nil {
+		return models.MessageAttachment{}, err
+	}
+	if err := form.Close(); err != nil {
+		return models.MessageAttachment{}, err
+	}
+
+	response, err := c.send(ctx, http.MethodPost, "/api/v1/messages/attachments", nil, &body, form.FormDataContentType())
+	if err != nil {
+		return models.MessageAttachment{}, err
+	}
+	defer response.Body.Close()
+
+	var attachment models.MessageAttachment
+	err = decodeResponse(response, &attachment)
+	return attachment, err
+}
+
+// GetMessageAttachment downloads an attachment's photo
+func (c *Client) GetMessageAttachment(ctx context.Context, id string) ([]byte, error) {
+	return c.download(ctx, "/api/v1/messages/attachments/"+escape(id))
+}
+
+// GetMessageAttachmentThumbnail downloads an attachment's JPEG thumbnail
+func (c *Client) GetMessageAttachmentThumbnail(ctx context.Context, id string) ([]byte, error) {
+	return c.download(ctx, "/api/v1/messages/attachments/"+escape(id)+"/thumbnail")
+}
+
+// GetMessage gets one of the logged-in user's messages
+func (c *Client) GetMessage(ctx context.Context, id string) (models.MessageWithUser, error) {
+	var message models.MessageWithUser
+	err := c.getJSON(ctx, "/api/v1/messages/"+escape(id), nil, &message)
+	return message, err
+}
+
+// EditMessage changes the content of a message the logged-in user sent, within
+// the server's edit window. An edit held for moderation has Held set and is saved
+// once it has been reviewed.
+func (c *Client) EditMessage(ctx context.Context, id, content string) (models.SentMessage, error) {
+	body := struct {
+		Content string `json:"content"`
+	}{Content: content}
+
+	var message models.SentMessage
+	err := c.sendJSON(ctx, http.MethodPut, "/api/v1/messages/"+escape(id), nil, body, &message)
+	return message, err
+}
+
+// DeleteMessage deletes a message for the logged-in user, or for both participants
+// if everyone is true, which only its sender may do
+func (c *Client) DeleteMessage(ctx context.Context, id string, everyone bool) error {
+	query := url.Values{}
+	if everyone {
+		query.Set("everyone", "true")
+	}
+	return c.sendJSON(ctx, http.MethodDelete, "/api/v1/messages/"+escape(id), query, nil, nil)
+}
+
+// GetMessageEdits lists the earlier versions of an edited message, oldest first
+func (c *Client) GetMessageEdits(ctx context.Context, id string) ([]models.MessageEdit, error) {
+	var edits []models.MessageEdit
+	err := c.getJSON(ctx, "/api/v1/messages/"+escape(id)+"/edits", nil, &edits)
+	return edits, err
+}
+
+// GetConversations lists the logged-in user's conversations, most recently active
+// first; archived conversations are listed instead if archived is true
+func (c *Client) GetConversations(ctx context.Context, archived bool) ([]models.Conversation, error) {
+	query := url.Values{}
+	if archived {
+		query.Set("archived", "true")
+	}
+
+	var conversations []models.Conversation
+	err := c.getJSON(ctx, "/api/v1/conversations", query, &conversations)
+	return conversations, err
+}
+
+// GetConversation gets all of the logged-in user's messages with a user, across
+// listings, and marks them read
+func (c *Client) GetConversation(ctx context.Context, userID string) (models.Conversation, error) {
+	var conversation models.Conversation
+	err := c.getJSON(ctx, "/api/v1/conversations/"+escape(userID), nil, &conversation)
+	return conversation, err
+}
+
+// GetConversationMessages gets one of the logged-in user's conversations with its messages and marks it read
+func (c *Client) GetConversationMessages(ctx context.Context, id string) (models.Conversation, error) {
+	var conversation models.Conversation
+	err := c.getJSON(ctx, "/api/v1/conversations/"+escape(id)+"/messages", nil, &conversation)
+	return conversation, err
+}
+
+// MarkConversationRead marks every message of a conversation read
+func (c *Client) MarkConversationRead(ctx context.Context, id string) (models.Conversation, error) {
+	return c.changeConversation(ctx, http.MethodPost, id, "read")
+}
+
+// ArchiveConversation archives a conversation until a new message arrives
+func (c *Client) ArchiveConversation(ctx context.Context, id string) (models.Conversation, error) {
+	return c.changeConversation(ctx, http.MethodPost, id, "archive")
+}
+
+// UnarchiveConversation brings a conversation back from the archive
+func (c *Client) UnarchiveConversation(ctx context.Context, id string) (models.Conversation, error) {
+	return c.changeConversation(ctx, http.MethodDelete, id, "archive")
+}
+
+// MuteConversation keeps a conversation archived when new messages arrive
+func (c *Client) MuteConversation(ctx context.Context, id string) (models.Conversation, error) {
+	return c.changeConversation(ctx, http.MethodPost, id, "mute")
+}
+
+// UnmuteConversation lets new messages bring a conversation back from the archive
+func (c *Client) UnmuteConversation(ctx context.Context, id string) (models.Conversation, error) {
+	return c.changeConversation(ctx, http.MethodDelete, id, "mute")
+}
+
+// changeConversation calls one of the actions on a conversation
+func (c *Client) changeConversation(ctx context.Context, method, id, action string) (models.Conversation, error) {
+	var conversation models.Conversation
+	err := c.sendJSON(ctx, method, "/api/v1/conversations/"+escape(id)+"/"+action, nil, nil, &conversation)
+	return conversation, err
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/plantexchange/app/models"
+)
+
+// GetNotifications lists the logged-in user's notifications, newest first
+func (c *Client) GetNotifications(ctx context.Context) ([]models.Notification, error) {
+	var notifications []models.Notification
+	err := c.getJSON(ctx, "/api/v1/notifications", nil, &notifications)
+	return notifications, err
+}
+
+// MarkNotificationRead marks one of the logged-in user's notifications as read
+func (c *Client) MarkNotificationRead(ctx context.Context, id string) error {
+	return c.sendJSON(ctx, http.MethodPost, "/api/v1/notifications/"+escape(id)+"/read", nil, nil, nil)
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/plantexchange/app/models"
+)
+
+// ProfileUpdate changes a profile; nil fields are left unchanged
+type ProfileUpdate struct {
+	Name       *string `json:"name,omitempty"`
+	Location   *string `json:"location,omitempty"`
+	Bio        *string `json:"bio,omitempty"`
+	ProfilePic *string `json:"profilePic,omitempty"` // data URL of the new picture
+}
+
+// GetUser gets a user's public profile
+func (c *Client) GetUser(ctx context.Context, id string) (models.UserResponse, error) {
+	var user models.UserResponse
+	err := c.getJSON(ctx, "/api/v1/users/"+escape(id), nil, &user)
+	return user, err
+}
+
+// UpdateUser updates the logged-in user's profile
+func (c *Client) UpdateUser(ctx context.Context, id string, update ProfileUpdate) (models.UserResponse, error) {
+	var user models.UserResponse
+	err := c.sendJSON(ctx, http.MethodPut, "/api/v1/users/"+escape(id), nil, update, &user)
+	return user, err
+}
+
+// GetCurrentUser gets the logged-in user
+func (c *Client) GetCurrentUser(ctx context.Context) (models.UserResponse, error) {
+	var user models.UserResponse
+	err := c.getJSON(ctx, "/api/v1/users/current", nil, &user)
+	return user, err
+}
+
+// GetUserListings gets a page of a user's active listings with their public profile
+// and trading stats. Zero page and limit use the server's defaults.
+func (c *Client) GetUserListings(ctx context.Context, id string, page, limit int) (models.UserListingsPage, error) {
+	query := url.Values{}
+	setPage(query, page, limit)
+
+	var result models.UserListingsPage
+	err := c.getJSON(ctx, "/api/v1/users/"+escape(id)+"/listings", query, &result)
+	return result, err
+}
+
+// FollowUser makes the logged-in user follow another user
+func (c *Client) FollowUser(ctx context.Context, id string) (models.FollowStatus, error) {
+	var status models.FollowStatus
+	err := c.sendJSON(ctx, http.MethodPost, "/api/v1/users/"+escape(id)+"/follow", nil, nil, &status)
+	return status, err
+}
+
+// UnfollowUser makes the logged-in user stop following another user
+func (c *Client) UnfollowUser(ctx context.Context, id string) (models.FollowStatus, error) {
+	var status models.FollowStatus
+	err := c.sendJSON(ctx, http.MethodDelete, "/api/v1/users/"+escape(id)+"/follow", nil, nil, &status)
+	return status, err
+}
+
+// GetFeed gets a page of the logged-in user's personalized feed. Pass an empty
+// cursor for the first page and the returned NextCursor for the next; a zero
+// limit uses the server's default.
+func (c *Client) GetFeed(ctx context.Context, cursor string, limit int) (models.FeedPage, error) {
+	query := url.Values{}
+	setQuery(query, "cursor", cursor)
+	setPage(query, 0, limit)
+
+	var feed models.FeedPage
+	err := c.getJSON(ctx, "/api/v1/feed", query, &feed)
+	return feed, err
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/plantexchange/app/client"
+	"github.com/plantexchange/app/config"
+	"github.com/plantexchange/app/handlers"
+	"github.com/plantexchange/app/models"
+	"github.com/plantexchange/app/utils"
+)
+
+// runCommand runs a command-line subcommand instead of the web server and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "import":
+		return runImportCommand(args[1:])
+	case "config":
+		return runConfigCommand(args[1:])
+	case "openapi":
+		return runOpenAPICommand(args[1:])
+	case "moderation":
+		return runModerationCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "Available commands: import, config, openapi, moderation")
+		return 2
+	}
+}
+
+// runConfigCommand inspects the configuration. "config print" shows the effective
+// settings with secrets redacted; it accepts the same flags as the server.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "Usage: config print [-config file.json] [server flags]")
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:])
+	if cfg == nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(cfg.Redacted())
+
+	// Print the configuration even when it is invalid, then explain why
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runImportCommand imports listings for a user from a CSV or JSON Lines file
+func runImportCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	userID := flags.String("user", "", "ID of the user who will own the listings")
+	file := flags.String("file", "", "CSV or JSON Lines file of listings")
+	images := flags.String("images", "", "zip of images referenced by filename in the listings file")
+	format := flags.String("format", "", "csv or jsonl, guessed from the file extension if empty")
+	dryRun := flags.Bool("dry-run", false, "validate the file without creating listings")
+	partial := flags.Bool("partial", false, "create the valid listings even if some rows fail")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *userID == "" || *file == "" {
+		fmt.Fprintln(os.Stderr, "Usage: import -user <id> -file <listings.csv|listings.jsonl> [-images images.zip] [-dry-run] [-partial]")
+		return 2
+	}
+
+	// Read input files
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read %s: %v\n", *file, err)
+		return 1
+	}
+	var imagesZip []byte
+	if *images != "" {
+		imagesZip, err = os.ReadFile(*images)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read %s: %v\n", *images, err)
+			return 1
+		}
+	}
+	if *format == "" {
+		*format = utils.ImportFormatFromFilename(*file)
+	}
+
+	// Load configuration
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	utils.Configure(cfg)
+
+	// Connect to the database and check the owner exists
+	utils.InitDB()
+	defer utils.CloseDB()
+
+	// Interrupting the command rolls back the import
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := utils.GetUser(ctx, *userID); err != nil {
+		fmt.Fprintf(os.Stderr, "User %s: %v\n", *userID, err)
+		return 1
+	}
+
+	// Run import
+	result, err := utils.RunListingImport(ctx, *userID, data, imagesZip, utils.ImportOptions{
+		Format:  *format,
+		DryRun:  *dryRun,
+		Partial: *partial,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		return 1
+	}
+
+	// Print per-row results as JSON and a summary to stderr
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+
+	fmt.Fprintf(os.Stderr, "%d rows, %d created, %d held for moderation, %d failed",
+		len(result.Rows), result.Created, result.Held, result.Failed)
+	if result.DryRun {
+		fmt.Fprint(os.Stderr, " (dry run)")
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runModerationCommand reviews messages and listings that screening held.
+// "moderation list" prints the held content with the given status, pending by
+// default; "moderation approve <id>" delivers it and "moderation reject <id>"
+// discards it, notifying the author either way.
+func runModerationCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "Usage: moderation list [-status pending|approved|rejected] | approve <id> | reject <id>")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	flags := flag.NewFlagSet("moderation "+args[0], flag.ContinueOnError)
+	status := flags.String("status", models.ModerationPending, "moderation status to list: pending, approved or rejected")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	switch {
+	case args[0] == "list" && flags.NArg() == 0 &&
+		(*status == models.ModerationPending || *status == models.ModerationApproved || *status == models.ModerationRejected):
+	case (args[0] == "approve" || args[0] == "reject") && flags.NArg() == 1:
+	default:
+		return usage()
+	}
+
+	// Load configuration
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	utils.Configure(cfg)
+
+	// Connect to the database
+	utils.InitDB()
+	defer utils.CloseDB()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "list":
+		held, err := utils.GetHeldContentByStatus(ctx, *status)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot list held content: %v\n", err)
+			return 1
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(held)
+		fmt.Fprintf(os.Stderr, "%d %s\n", len(held), *status)
+
+	case "approve":
+		id, err := utils.ApproveHeldContent(ctx, flags.Arg(0), time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot approve %s: %v\n", flags.Arg(0), err)
+			return 1
+		}
+		fmt.Printf("Approved %s, delivered as %s\n", flags.Arg(0), id)
+
+	case "reject":
+		if err := utils.RejectHeldContent(ctx, flags.Arg(0), time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot reject %s: %v\n", flags.Arg(0), err)
+			return 1
+		}
+		fmt.Printf("Rejected %s\n", flags.Arg(0))
+	}
+	return 0
+}
+
+// runOpenAPICommand works with the API description. "openapi print" writes the
+// OpenAPI document; "openapi check" compares it with the registered routes and the
+// Go client, and fails if a route is undocumented, a documented route is not
+// registered or an operation has no client method.
+func runOpenAPICommand(args []string) int {
+	if len(args) != 1 || (args[0] != "print" && args[0] != "check") {
+		fmt.Fprintln(os.Stderr, "Usage: openapi print|check")
+		return 2
+	}
+
+	spec := handlers.OpenAPISpec()
+	if args[0] == "print" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(spec)
+		return 0
+	}
+
+	registered, err := registeredRoutes(newRouter(config.Default()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	documented := spec.Routes()
+
+	problems := 0
+	for _, route := range difference(registered, documented) {
+		fmt.Fprintf(os.Stderr, "Not documented: %s\n", route)
+		problems++
+	}
+	for _, route := range difference(documented, registered) {
+		fmt.Fprintf(os.Stderr, "Not registered: %s\n", route)
+		problems++
+	}
+
+	// Every operation is called by the client method of the same name
+	clientType := reflect.TypeOf(&client.Client{})
+	for _, route := range handlers.APIRoutes {
+		if _, ok := clientType.MethodByName(route.ID); !ok {
+			fmt.Fprintf(os.Stderr, "No client method: %s (%s %s)\n", route.ID, route.Method, route.Path)
+			problems++
+		}
+	}
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems between the router, the OpenAPI document and the client\n", problems)
+		return 1
+	}
+
+	fmt.Printf("%d routes match the OpenAPI document\n", len(registered))
+	return 0
+}
+
+// registeredRoutes lists the router's routes as "METHOD /path", leaving out pages,
+// feeds and routes without methods such as static files
+func registeredRoutes(router *mux.Router) ([]string, error) {
+	isPage := map[string]bool{}
+	for _, page := range pages {
+		isPage[page.path] = true
+	}
+
+	var routes []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || isPage[path] {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routes = append(routes, method+" "+path)
+		}
+		return nil
+	})
+	sort.Strings(routes)
+	return routes, err
+}
+
+// difference returns the entries of a that are not in b
+func difference(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, entry := range b {
+		inB[entry] = true
+	}
+
+	var result []string
+	for _, entry := range a {
+		if !inB[entry] {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
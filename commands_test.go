@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/plantexchange/app/client"
+	"github.com/plantexchange/app/config"
+	"github.com/plantexchange/app/handlers"
+)
+
+// TestRoutesMatchOpenAPI runs the openapi check: every API route the router registers
+// is documented, every documented route is registered, and the client covers them all
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	registered, err := registeredRoutes(newRouter(config.Default()))
+	if err != nil {
+		t.Fatalf("cannot walk router: %v", err)
+	}
+	if len(registered) == 0 {
+		t.Fatal("router registers no API routes")
+	}
+	documented := handlers.OpenAPISpec().Routes()
+
+	for _, route := range difference(registered, documented) {
+		t.Errorf("not documented: %s", route)
+	}
+	for _, route := range difference(documented, registered) {
+		t.Errorf("not registered: %s", route)
+	}
+
+	clientType := reflect.TypeOf(&client.Client{})
+	for _, route := range handlers.APIRoutes {
+		if _, ok := clientType.MethodByName(route.ID); !ok {
+			t.Errorf("no client method: %s (%s %s)", route.ID, route.Method, route.Path)
+		}
+	}
+}
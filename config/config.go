@@ -0,0 +1,586 @@
+// Package config loads the application's settings.
+//
+// Settings come from, in increasing order of precedence: built-in defaults, an
+// optional JSON config file (-config or CONFIG_FILE), environment variables and
+// command-line flags. Load validates the result so that bad settings stop the
+// server at startup with a clear message.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environments
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// developmentSessionSecret signs session cookies when no secret is configured; refused in production
+const developmentSessionSecret = "plant-exchange-secret-key"
+
+// minSessionSecretLength is the shortest session secret accepted in production
+const minSessionSecretLength = 32
+
+// redacted replaces secrets in printed configuration
+const redacted = "[redacted]"
+
+// Config holds every setting of the application
+type Config struct {
+	Env       string          `json:"env"` // development or production
+	Server    ServerConfig    `json:"server"`
+	API       APIConfig       `json:"api"`
+	Database  DatabaseConfig  `json:"database"`
+	Session   SessionConfig   `json:"session"`
+	Listings  ListingsConfig  `json:"listings"`
+	Accounts  AccountsConfig  `json:"accounts"`
+	Messages  MessagesConfig  `json:"messages"`
+	Screening ScreeningConfig `json:"screening"`
+	Worker    WorkerConfig    `json:"worker"`
+	Log       LogConfig       `json:"log"`
+}
+
+// ServerConfig holds the HTTP server settings
+type ServerConfig struct {
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	StaticDir   string   `json:"staticDir"`
+	TemplateDir string   `json:"templateDir"`
+	CORSOrigins []string `json:"corsOrigins"`
+
+	// PublicURL is the site's address as users see it, e.g. https://leafconnect.example,
+	// used for absolute links in link previews, the sitemap and feeds. If empty the
+	// request's host is used.
+	PublicURL string `json:"publicURL"`
+
+	// Limits that stop slow or oversized requests from holding connections open
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"` // long enough for bulk import uploads
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	MaxHeaderBytes    int      `json:"maxHeaderBytes"`
+
+	// ShutdownTimeout is how long in-flight requests may take to finish after a shutdown signal
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+// APIConfig holds the API versioning settings
+type APIConfig struct {
+	// UnversionedSunset is the date, as YYYY-MM-DD, after which the deprecated
+	// unversioned /api routes may be removed; it is sent in their Sunset header
+	UnversionedSunset string `json:"unversionedSunset"`
+}
+
+// dateLayout is the layout of dates in settings
+const dateLayout = "2006-01-02"
+
+// SunsetTime is the unversioned sunset date as a time, midnight UTC
+func (api APIConfig) SunsetTime() time.Time {
+	sunset, _ := time.Parse(dateLayout, api.UnversionedSunset)
+	return sunset
+}
+
+// DatabaseConfig holds the database connection settings
+type DatabaseConfig struct {
+	URL string `json:"url"` // secret: may contain a password
+
+	// Connection pool; MaxOpenConns of 0 means unlimited
+	MaxOpenConns    int      `json:"maxOpenConns"`
+	MaxIdleConns    int      `json:"maxIdleConns"`
+	ConnMaxLifetime Duration `json:"connMaxLifetime"`
+	ConnMaxIdleTime Duration `json:"connMaxIdleTime"`
+
+	// QueryTimeout bounds a single query; TransactionTimeout bounds multi-statement
+	// work such as saving a listing with its images or purging an account
+	QueryTimeout       Duration `json:"queryTimeout"`
+	TransactionTimeout Duration `json:"transactionTimeout"`
+}
+
+// SessionConfig holds the session cookie settings
+type SessionConfig struct {
+	Secret     string `json:"secret"` // secret: signs session cookies
+	MaxAgeDays int    `json:"maxAgeDays"`
+	Secure     bool   `json:"secure"` // only send the cookie over HTTPS
+}
+
+// ListingsConfig holds the listing lifecycle settings
+type ListingsConfig struct {
+	LifetimeDays      int `json:"lifetimeDays"`
+	ExpiryWarningDays int `json:"expiryWarningDays"`
+}
+
+// AccountsConfig holds the account deletion and data export settings
+type AccountsConfig struct {
+	DeletionGraceDays      int `json:"deletionGraceDays"`
+	DataExportLifetimeDays int `json:"dataExportLifetimeDays"`
+}
+
+// MessagesConfig holds the message editing and retention settings
+type MessagesConfig struct {
+	// EditWindow is how long after sending a message its sender may edit it; 0
+	// turns editing off
+	EditWindow Duration `json:"editWindow"`
+
+	// RetentionMonths is how long messages about sold, traded or deleted listings
+	// are kept; 0 keeps them forever
+	RetentionMonths int `json:"retentionMonths"`
+}
+
+// ScreeningConfig holds the spam and scam screening settings for new messages and
+// listings. Each check adds its score to content it flags; content whose total
+// reaches HoldScore is held for moderation instead of delivered.
+type ScreeningConfig struct {
+	// HoldScore is the total score at which content is held; 0 holds nothing
+	HoldScore int `json:"holdScore"`
+
+	// Scores added by the built-in checks; 0 turns a check off
+	PaymentScore   int `json:"paymentScore"`   // asks to be paid outside the site
+	LinkScore      int `json:"linkScore"`      // links to other sites
+	DuplicateScore int `json:"duplicateScore"` // the same message sent to many users
+
+	// DuplicateRecipients is how many other users may have been sent the same
+	// message within DuplicateWindow before the duplicate check flags it
+	DuplicateRecipients int      `json:"duplicateRecipients"`
+	DuplicateWindow     Duration `json:"duplicateWindow"`
+
+	// MessagesPerHour is how many messages a user may send in an hour; 0 is unlimited
+	MessagesPerHour int `json:"messagesPerHour"`
+
+	// Rules are further keyword and pattern checks
+	Rules []ScreeningRule `json:"rules"`
+}
+
+// ScreeningRule flags content containing any of its keywords or matching its pattern
+type ScreeningRule struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords,omitempty"` // words or phrases, ignoring case
+	Pattern  string   `json:"pattern,omitempty"`  // regular expression, ignoring case
+	Score    int      `json:"score"`
+}
+
+// UnmarshalJSON reads a rule in place of the default rule at the same position
+// rather than merging into it
+func (rule *ScreeningRule) UnmarshalJSON(data []byte) error {
+	type plainRule ScreeningRule
+	var parsed plainRule
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*rule = ScreeningRule(parsed)
+	return nil
+}
+
+// WorkerConfig holds the background worker settings
+type WorkerConfig struct {
+	Interval Duration `json:"interval"`
+}
+
+// LogConfig holds the logging settings
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn or error
+	Format string `json:"format"` // json or text
+}
+
+// Duration is a time.Duration written as a Go duration string, e.g. "15m"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"15m\"")
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the settings used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Env: EnvDevelopment,
+		Server: ServerConfig{
+			Host:        "0.0.0.0",
+			Port:        8080,
+			StaticDir:   "./static",
+			TemplateDir: "templates",
+			CORSOrigins: []string{"*"},
+
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ReadTimeout:       Duration(2 * time.Minute),
+			WriteTimeout:      Duration(2 * time.Minute),
+			IdleTimeout:       Duration(2 * time.Minute),
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		API: APIConfig{
+			UnversionedSunset: "2027-04-30",
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:       25,
+			MaxIdleConns:       25,
+			ConnMaxLifetime:    Duration(30 * time.Minute),
+			ConnMaxIdleTime:    Duration(5 * time.Minute),
+			QueryTimeout:       Duration(5 * time.Second),
+			TransactionTimeout: Duration(30 * time.Second),
+		},
+		Session: SessionConfig{
+			Secret:     developmentSessionSecret,
+			MaxAgeDays: 30,
+		},
+		Listings: ListingsConfig{
+			LifetimeDays:      60,
+			ExpiryWarningDays: 7,
+		},
+		Accounts: AccountsConfig{
+			DeletionGraceDays:      14,
+			DataExportLifetimeDays: 7,
+		},
+		Messages: MessagesConfig{
+			EditWindow:      Duration(15 * time.Minute),
+			RetentionMonths: 12,
+		},
+		Screening: ScreeningConfig{
+			HoldScore:           10,
+			PaymentScore:        6,
+			LinkScore:           4,
+			DuplicateScore:      10,
+			DuplicateRecipients: 5,
+			DuplicateWindow:     Duration(24 * time.Hour),
+			MessagesPerHour:     30,
+			Rules: []ScreeningRule{
+				{Name: "shipping-fee-scam", Score: 6, Keywords: []string{
+					"shipping agent", "delivery agent", "courier fee", "refundable deposit", "customs clearance fee",
+				}},
+			},
+		},
+		Worker: WorkerConfig{
+			Interval: Duration(15 * time.Minute),
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the config file, the environment
+// and the given command-line arguments. If the result is invalid the configuration
+// is returned together with an error listing every problem.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	// Parse flags first to find the config file; they are applied last
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "JSON config file")
+	env := flags.String("env", "", "environment: development or production")
+	host := flags.String("host", "", "address to listen on")
+	port := flags.Int("port", 0, "port to listen on")
+	staticDir := flags.String("static-dir", "", "directory of static files")
+	templateDir := flags.String("template-dir", "", "directory of HTML templates")
+	corsOrigins := flags.String("cors-origins", "", "comma-separated origins allowed to call the API")
+	workerInterval := flags.Duration("worker-interval", 0, "how often background jobs run")
+	logLevel := flags.String("log-level", "", "minimum level to log: debug, info, warn or error")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 0, "how long in-flight requests may take to finish on shutdown")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Config file
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment
+	problems := cfg.loadEnv()
+
+	// Flags that were given explicitly
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			cfg.Env = *env
+		case "host":
+			cfg.Server.Host = *host
+		case "port":
+			cfg.Server.Port = *port
+		case "static-dir":
+			cfg.Server.StaticDir = *staticDir
+		case "template-dir":
+			cfg.Server.TemplateDir = *templateDir
+		case "cors-origins":
+			cfg.Server.CORSOrigins = splitList(*corsOrigins)
+		case "worker-interval":
+			cfg.Worker.Interval = Duration(*workerInterval)
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = Duration(*shutdownTimeout)
+		}
+	})
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return cfg, fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the settings in a JSON config file
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overlays the settings given in environment variables and returns any that cannot be parsed
+func (cfg *Config) loadEnv() []string {
+	var problems []string
+	setInt := func(name string, target *int) {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a whole number", name, value))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setString := func(name string, target *string) {
+		if value := os.Getenv(name); value != "" {
+			*target = value
+		}
+	}
+	setDuration := func(name string, target *Duration) {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a duration like \"15m\"", name, value))
+				return
+			}
+			*target = Duration(parsed)
+		}
+	}
+
+	setString("APP_ENV", &cfg.Env)
+	setString("HOST", &cfg.Server.Host)
+	setInt("PORT", &cfg.Server.Port)
+	setString("STATIC_DIR", &cfg.Server.StaticDir)
+	setString("TEMPLATE_DIR", &cfg.Server.TemplateDir)
+	setString("PUBLIC_URL", &cfg.Server.PublicURL)
+	if value := os.Getenv("CORS_ALLOWED_ORIGINS"); value != "" {
+		cfg.Server.CORSOrigins = splitList(value)
+	}
+	setDuration("SERVER_READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout)
+	setDuration("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	setDuration("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	setDuration("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	setInt("SERVER_MAX_HEADER_BYTES", &cfg.Server.MaxHeaderBytes)
+	setDuration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	setString("API_UNVERSIONED_SUNSET", &cfg.API.UnversionedSunset)
+	setString("DATABASE_URL", &cfg.Database.URL)
+	setInt("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	setInt("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	setDuration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	setDuration("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime)
+	setDuration("DB_QUERY_TIMEOUT", &cfg.Database.QueryTimeout)
+	setDuration("DB_TRANSACTION_TIMEOUT", &cfg.Database.TransactionTimeout)
+	setString("SESSION_SECRET", &cfg.Session.Secret)
+	setInt("SESSION_MAX_AGE_DAYS", &cfg.Session.MaxAgeDays)
+	if value := os.Getenv("SESSION_SECURE"); value != "" {
+		secure, err := strconv.ParseBool(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("SESSION_SECURE: %q is not true or false", value))
+		} else {
+			cfg.Session.Secure = secure
+		}
+	}
+	setInt("LISTING_LIFETIME_DAYS", &cfg.Listings.LifetimeDays)
+	setInt("LISTING_EXPIRY_WARNING_DAYS", &cfg.Listings.ExpiryWarningDays)
+	setInt("ACCOUNT_DELETION_GRACE_DAYS", &cfg.Accounts.DeletionGraceDays)
+	setInt("DATA_EXPORT_LIFETIME_DAYS", &cfg.Accounts.DataExportLifetimeDays)
+	setDuration("MESSAGE_EDIT_WINDOW", &cfg.Messages.EditWindow)
+	setInt("MESSAGE_RETENTION_MONTHS", &cfg.Messages.RetentionMonths)
+	setInt("SCREENING_HOLD_SCORE", &cfg.Screening.HoldScore)
+	setInt("SCREENING_PAYMENT_SCORE", &cfg.Screening.PaymentScore)
+	setInt("SCREENING_LINK_SCORE", &cfg.Screening.LinkScore)
+	setInt("SCREENING_DUPLICATE_SCORE", &cfg.Screening.DuplicateScore)
+	setInt("SCREENING_DUPLICATE_RECIPIENTS", &cfg.Screening.DuplicateRecipients)
+	setDuration("SCREENING_DUPLICATE_WINDOW", &cfg.Screening.DuplicateWindow)
+	setInt("SCREENING_MESSAGES_PER_HOUR", &cfg.Screening.MessagesPerHour)
+	setDuration("WORKER_INTERVAL", &cfg.Worker.Interval)
+	setString("LOG_LEVEL", &cfg.Log.Level)
+	setString("LOG_FORMAT", &cfg.Log.Format)
+
+	return problems
+}
+
+// validate lists every problem with the settings
+func (cfg *Config) validate() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.Env == EnvDevelopment || cfg.Env == EnvProduction, "env must be %s or %s, not %q", EnvDevelopment, EnvProduction, cfg.Env)
+	check(cfg.Server.Port > 0 && cfg.Server.Port <= 65535, "server.port must be between 1 and 65535, not %d", cfg.Server.Port)
+	check(isDir(cfg.Server.StaticDir), "server.staticDir %q is not a directory", cfg.Server.StaticDir)
+	check(isDir(cfg.Server.TemplateDir), "server.templateDir %q is not a directory", cfg.Server.TemplateDir)
+	check(cfg.Server.PublicURL == "" || isSiteURL(cfg.Server.PublicURL),
+		"server.publicURL must be an http or https URL without a path, not %q", cfg.Server.PublicURL)
+	check(len(cfg.Server.CORSOrigins) > 0, "server.corsOrigins must list at least one origin")
+	check(cfg.Server.ReadHeaderTimeout > 0, "server.readHeaderTimeout must be positive")
+	check(cfg.Server.ReadTimeout > 0, "server.readTimeout must be positive")
+	check(cfg.Server.WriteTimeout > 0, "server.writeTimeout must be positive")
+	check(cfg.Server.IdleTimeout > 0, "server.idleTimeout must be positive")
+	check(cfg.Server.MaxHeaderBytes >= 4<<10, "server.maxHeaderBytes must be at least 4096")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	_, err := time.Parse(dateLayout, cfg.API.UnversionedSunset)
+	check(err == nil, "api.unversionedSunset must be a date like 2027-04-30, not %q", cfg.API.UnversionedSunset)
+	check(cfg.Database.URL != "", "database.url is required (set DATABASE_URL)")
+	check(cfg.Database.MaxOpenConns >= 0, "database.maxOpenConns must not be negative")
+	check(cfg.Database.MaxIdleConns >= 0, "database.maxIdleConns must not be negative")
+	check(cfg.Database.MaxOpenConns == 0 || cfg.Database.MaxIdleConns <= cfg.Database.MaxOpenConns,
+		"database.maxIdleConns must not exceed database.maxOpenConns")
+	check(cfg.Database.ConnMaxLifetime >= 0, "database.connMaxLifetime must not be negative")
+	check(cfg.Database.ConnMaxIdleTime >= 0, "database.connMaxIdleTime must not be negative")
+	check(cfg.Database.QueryTimeout > 0, "database.queryTimeout must be positive")
+	check(cfg.Database.TransactionTimeout >= cfg.Database.QueryTimeout,
+		"database.transactionTimeout must be at least database.queryTimeout")
+	check(cfg.Session.MaxAgeDays > 0, "session.maxAgeDays must be positive")
+	check(cfg.Listings.LifetimeDays > 0, "listings.lifetimeDays must be positive")
+	check(cfg.Listings.ExpiryWarningDays > 0, "listings.expiryWarningDays must be positive")
+	check(cfg.Listings.ExpiryWarningDays < cfg.Listings.LifetimeDays, "listings.expiryWarningDays must be shorter than listings.lifetimeDays")
+	check(cfg.Accounts.DeletionGraceDays > 0, "accounts.deletionGraceDays must be positive")
+	check(cfg.Accounts.DataExportLifetimeDays > 0, "accounts.dataExportLifetimeDays must be positive")
+	check(cfg.Messages.EditWindow >= 0, "messages.editWindow must not be negative")
+	check(cfg.Messages.RetentionMonths >= 0, "messages.retentionMonths must not be negative")
+	check(cfg.Screening.HoldScore >= 0, "screening.holdScore must not be negative")
+	check(cfg.Screening.PaymentScore >= 0, "screening.paymentScore must not be negative")
+	check(cfg.Screening.LinkScore >= 0, "screening.linkScore must not be negative")
+	check(cfg.Screening.DuplicateScore >= 0, "screening.duplicateScore must not be negative")
+	check(cfg.Screening.DuplicateRecipients > 0, "screening.duplicateRecipients must be positive")
+	check(cfg.Screening.DuplicateWindow > 0, "screening.duplicateWindow must be positive")
+	check(cfg.Screening.MessagesPerHour >= 0, "screening.messagesPerHour must not be negative")
+	for i, rule := range cfg.Screening.Rules {
+		check(rule.Name != "", "screening.rules[%d].name is required", i)
+		check(rule.Score > 0, "screening.rules[%d].score must be positive", i)
+		check((len(rule.Keywords) > 0) != (rule.Pattern != ""), "screening.rules[%d] must have either keywords or a pattern", i)
+		if rule.Pattern != "" {
+			_, err := regexp.Compile(rule.Pattern)
+			check(err == nil, "screening.rules[%d].pattern is not a valid regular expression: %v", i, err)
+		}
+	}
+	check(cfg.Worker.Interval > 0, "worker.interval must be positive")
+	check(oneOf(cfg.Log.Level, "debug", "info", "warn", "error"), "log.level must be debug, info, warn or error, not %q", cfg.Log.Level)
+	check(oneOf(cfg.Log.Format, "json", "text"), "log.format must be json or text, not %q", cfg.Log.Format)
+
+	// Production must not run with development shortcuts
+	if cfg.Env == EnvProduction {
+		if cfg.Session.Secret == developmentSessionSecret {
+			problems = append(problems, "session.secret is required in production (set SESSION_SECRET)")
+		} else {
+			check(len(cfg.Session.Secret) >= minSessionSecretLength,
+				"session.secret must be at least %d characters in production", minSessionSecretLength)
+		}
+		for _, origin := range cfg.Server.CORSOrigins {
+			check(origin != "*", "server.corsOrigins must list explicit origins in production, not \"*\"")
+		}
+	}
+
+	return problems
+}
+
+// Addr is the address the HTTP server listens on
+func (cfg *Config) Addr() string {
+	return fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
+}
+
+// IsProduction reports whether the application runs in production mode
+func (cfg *Config) IsProduction() bool {
+	return cfg.Env == EnvProduction
+}
+
+// Redacted returns a copy of the configuration with secrets hidden, for printing
+func (cfg *Config) Redacted() Config {
+	copy := *cfg
+	copy.Server.CORSOrigins = append([]string(nil), cfg.Server.CORSOrigins...)
+	if copy.Session.Secret != "" {
+		copy.Session.Secret = redacted
+	}
+	if copy.Database.URL != "" {
+		if parsed, err := url.Parse(copy.Database.URL); err == nil && parsed.Scheme != "" {
+			copy.Database.URL = parsed.Redacted()
+		} else {
+			copy.Database.URL = redacted
+		}
+	}
+	return copy
+}
+
+// Days converts a number of days to a duration
+func Days(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// splitList splits a comma-separated list, dropping blank entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// oneOf reports whether value is one of the allowed values
+func oneOf(value string, allowed ...string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// isSiteURL reports whether value is an absolute http or https URL with no path beyond "/"
+func isSiteURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "" &&
+		(parsed.Path == "" || parsed.Path == "/") && parsed.RawQuery == ""
+}
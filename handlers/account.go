@@ -0,0 +1,239 @@
+package handlers
+
+import (
+        "context"
+        "net/http"
+        "time"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/utils"
+)
+
+// passwordRequest confirms a sensitive action with the user's password
+type passwordRequest struct {
+        Password string `json:"password"`
+}
+
+// Validate checks that the password was given
+func (req passwordRequest) Validate(v *utils.Validator) {
+        v.Required("password", req.Password)
+}
+
+// RequestDataExport queues a zip of the current user's data to be generated in the background
+func RequestDataExport(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Only one export may be in progress at a time
+        exports, err := utils.GetDataExportsByUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        for _, export := range exports {
+                if export.Status == models.DataExportPending || export.Status == models.DataExportProcessing {
+                        w.Header().Set("Content-Type", "application/json")
+                        w.WriteHeader(http.StatusAccepted)
+                        writeJSON(w, r, export)
+                        return
+                }
+        }
+
+        // Record the request
+        exportID, err := utils.CreateDataExport(r.Context(), userID, time.Now())
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Generate it in the background; the worker retries if this is interrupted
+        utils.RunInBackground(r.Context(), "data export "+exportID, func(ctx context.Context) error {
+                return utils.GenerateDataExport(ctx, exportID)
+        })
+
+        export, err := utils.GetDataExport(r.Context(), exportID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return the pending export
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+        writeJSON(w, r, export)
+}
+
+// GetDataExports lists the current user's data exports
+func GetDataExports(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        exports, err := utils.GetDataExportsByUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return exports
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, exports)
+}
+
+// DownloadDataExport sends the zip of a ready data export
+func DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Get export ID from URL path
+        vars := mux.Vars(r)
+        exportID := vars["id"]
+
+        // Find export; other users' exports are reported as missing
+        export, err := utils.GetDataExport(r.Context(), exportID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if export.UserID != userID {
+                httpError(w, r, "Export not found", http.StatusNotFound)
+                return
+        }
+        if export.Status != models.DataExportReady {
+                httpError(w, r, "Export is not ready", http.StatusConflict)
+                return
+        }
+
+        file, err := utils.GetDataExportFile(r.Context(), exportID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Send zip
+        w.Header().Set("Content-Type", "application/zip")
+        w.Header().Set("Content-Disposition", `attachment; filename="plant-exchange-data-`+export.RequestedAt.Format("2006-01-02")+`.zip"`)
+        w.Write(file)
+}
+
+// GetAccountDeletion reports whether the current user's account is scheduled for deletion
+func GetAccountDeletion(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if user.IsDeleted() {
+                httpError(w, r, "User not found", http.StatusNotFound)
+                return
+        }
+
+        // Return deletion status
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, models.AccountDeletion{DeletionScheduledAt: user.DeletionScheduledAt})
+}
+
+// DeleteAccount schedules the current user's account for deletion after a grace
+// period and logs out all of their sessions. Logging back in before then allows cancelling.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Parse request; the password must be confirmed
+        var request passwordRequest
+        if !decodeJSON(w, r, &request, maxJSONBodySize) {
+                return
+        }
+
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if user.IsDeleted() {
+                httpError(w, r, "User not found", http.StatusNotFound)
+                return
+        }
+        if !utils.CheckPassword(request.Password, user.Password) {
+                httpError(w, r, "Incorrect password", http.StatusForbidden)
+                return
+        }
+
+        // Schedule deletion, logging out every session including this one
+        now := time.Now()
+        deletionAt := now.Add(utils.AccountDeletionGrace())
+        if err := utils.ScheduleAccountDeletion(r.Context(), userID, now, deletionAt); err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Log out
+        session.Values = make(map[interface{}]interface{})
+        session.Save(r, w)
+
+        // Return deletion time
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, models.AccountDeletion{DeletionScheduledAt: &deletionAt})
+}
+
+// CancelAccountDeletion cancels a scheduled deletion of the current user's account
+func CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Cancel deletion
+        if err := utils.CancelAccountDeletion(r.Context(), userID); err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return success
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, successResponse{Success: true})
+}
@@ -1,10 +1,7 @@
 package handlers
 
 import (
-        "bytes"
-        "encoding/json"
-        "io"
-        "log"
+        "errors"
         "net/http"
         "time"
 
@@ -12,56 +9,72 @@ import (
         "github.com/plantexchange/app/utils"
 )
 
+// registerRequest is the body of a registration
+type registerRequest struct {
+        Email    string `json:"email"`
+        Username string `json:"username"`
+        Password string `json:"password"`
+        Name     string `json:"name"`
+        Location string `json:"location"`
+        Bio      string `json:"bio"`
+}
+
+// user returns the new account described by the request
+func (req registerRequest) user() models.User {
+        return models.User{
+                Email:    req.Email,
+                Username: req.Username,
+                Password: req.Password,
+                Name:     req.Name,
+                Location: req.Location,
+                Bio:      req.Bio,
+        }
+}
+
+// Validate checks the registration rules
+func (req registerRequest) Validate(v *utils.Validator) {
+        utils.ValidateNewUser(v, req.user())
+}
+
+// loginRequest is the body of a login
+type loginRequest struct {
+        Email    string `json:"email"`
+        Password string `json:"password"`
+}
+
+// Validate checks that both credentials were given
+func (req loginRequest) Validate(v *utils.Validator) {
+        v.Required("email", req.Email)
+        v.Required("password", req.Password)
+}
+
 // Register handles user registration
 func Register(w http.ResponseWriter, r *http.Request) {
-        // Read the full request body for debugging
-        bodyBytes, err := io.ReadAll(r.Body)
-        if err != nil {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusBadRequest)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Error reading request body: " + err.Error()})
+        // Parse and validate request body
+        var request registerRequest
+        if !decodeJSON(w, r, &request, maxJSONBodySize) {
                 return
         }
-        
-        // Log the request body for debugging
-        log.Printf("Register request body: %s", string(bodyBytes))
-        
-        // Create a new reader with the same body contents
-        r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-        
-        // Parse request body
-        var user models.User
-        if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusBadRequest)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Invalid request body: " + err.Error()})
-                return
-        }
-        
-        // Log the parsed user for debugging
-        log.Printf("Parsed user: %+v", user)
+        user := request.user()
 
-        // Validate required fields
-        if user.Email == "" || user.Username == "" || user.Password == "" {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusBadRequest)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Email, username, and password are required"})
-                return
-        }
+        logger := utils.Logger(r.Context())
+        logger.Info("Registering user", "username", user.Username)
 
         // Check if email already exists
-        if _, exists := utils.GetUserByEmail(user.Email); exists {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusConflict)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Email already registered"})
+        if _, err := utils.GetUserByEmail(r.Context(), user.Email); err == nil {
+                writeError(w, r, utils.ConflictError("Email already registered", map[string]string{"email": "already registered"}))
+                return
+        } else if !errors.Is(err, utils.ErrNotFound) {
+                writeError(w, r, err)
                 return
         }
 
         // Check if username already exists
-        if _, exists := utils.GetUserByUsername(user.Username); exists {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusConflict)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Username already taken"})
+        if _, err := utils.GetUserByUsername(r.Context(), user.Username); err == nil {
+                writeError(w, r, utils.ConflictError("Username already taken", map[string]string{"username": "already taken"}))
+                return
+        } else if !errors.Is(err, utils.ErrNotFound) {
+                writeError(w, r, err)
                 return
         }
 
@@ -72,106 +85,79 @@ func Register(w http.ResponseWriter, r *http.Request) {
         user.CreatedAt = time.Now()
         user.LastLoginAt = time.Now()
 
-        // Save user
-        userID := utils.SaveUser(user)
+        // Save user; the unique constraints catch a concurrent registration
+        userID, err := utils.SaveUser(r.Context(), user)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
         user.ID = userID
+        utils.UsersRegistered.Inc()
 
         // Create session
         session, _ := utils.SessionStore.Get(r, "session")
         session.Values["userID"] = user.ID
+        session.Values["issuedAt"] = time.Now().UnixNano()
         session.Save(r, w)
 
         // Return user info (without password)
         userResponse := user.ToUserResponse()
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(userResponse)
+        writeJSON(w, r, userResponse)
 }
 
 // Login handles user authentication
 func Login(w http.ResponseWriter, r *http.Request) {
-        log.Printf("Login attempt, cookies: %v", r.Cookies())
-        
+        logger := utils.Logger(r.Context())
+
         // Parse request
-        var credentials struct {
-                Email    string `json:"email"`
-                Password string `json:"password"`
-        }
-        
-        bodyBytes, err := io.ReadAll(r.Body)
-        if err != nil {
-                log.Printf("Error reading login request body: %v", err)
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusBadRequest)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Invalid request body: " + err.Error()})
-                return
-        }
-        
-        // Log the request body for debugging (strip password)
-        log.Printf("Login request body: %s", string(bodyBytes))
-        
-        // Create a new reader with the same body contents
-        r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-        
-        if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
-                log.Printf("Error decoding login credentials: %v", err)
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusBadRequest)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Invalid request body: " + err.Error()})
+        var credentials loginRequest
+        if !decodeJSON(w, r, &credentials, maxJSONBodySize) {
                 return
         }
 
-        log.Printf("Login attempt for email: %s", credentials.Email)
-
         // Find user by email
-        user, exists := utils.GetUserByEmail(credentials.Email)
-        if !exists {
-                log.Printf("User with email %s not found", credentials.Email)
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusUnauthorized)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Invalid email or password"})
+        user, err := utils.GetUserByEmail(r.Context(), credentials.Email)
+        if err != nil && !errors.Is(err, utils.ErrNotFound) {
+                writeError(w, r, err)
+                return
+        }
+        if err != nil {
+                logger.Info("Login failed: unknown email", "email", credentials.Email)
+                httpError(w, r, "Invalid email or password", http.StatusUnauthorized)
                 return
         }
-
-        log.Printf("Found user: %s (ID: %s)", user.Username, user.ID)
 
         // Check password
         if !utils.CheckPassword(credentials.Password, user.Password) {
-                log.Printf("Invalid password for user: %s", user.Username)
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusUnauthorized)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Invalid email or password"})
+                logger.Info("Login failed: wrong password", "userId", user.ID)
+                httpError(w, r, "Invalid email or password", http.StatusUnauthorized)
                 return
         }
 
-        log.Printf("Password validated for user: %s", user.Username)
-
         // Update last login time
         user.LastLoginAt = time.Now()
-        utils.SaveUser(user)
+        if _, err := utils.SaveUser(r.Context(), user); err != nil {
+                logger.Error("Cannot update last login", "userId", user.ID, "error", err)
+        }
 
         // Create session
         session, err := utils.SessionStore.Get(r, "session")
         if err != nil {
-                log.Printf("Error getting session: %v", err)
+                logger.Warn("Ignoring unreadable session cookie", "error", err)
         }
-        
-        log.Printf("Session before setting userID: %v", session.Values)
         session.Values["userID"] = user.ID
-        
-        err = session.Save(r, w)
-        if err != nil {
-                log.Printf("Error saving session: %v", err)
+        session.Values["issuedAt"] = time.Now().UnixNano()
+        if err := session.Save(r, w); err != nil {
+                logger.Error("Cannot save session", "userId", user.ID, "error", err)
         }
-        
-        log.Printf("Session after setting userID: %v", session.Values)
-        log.Printf("Set cookie header: %v", w.Header().Get("Set-Cookie"))
 
         // Return user info
         userResponse := user.ToUserResponse()
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(userResponse)
-        
-        log.Printf("Login successful for user: %s", user.Username)
+        writeJSON(w, r, userResponse)
+
+        logger.Info("Logged in", "userId", user.ID)
 }
 
 // Logout handles user logout
@@ -185,51 +171,44 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 
         // Return success
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]bool{"success": true})
+        writeJSON(w, r, successResponse{Success: true})
 }
 
 // CheckAuth checks if a user is authenticated
 func CheckAuth(w http.ResponseWriter, r *http.Request) {
-        log.Printf("CheckAuth called, cookies: %v", r.Cookies())
-        
         // Get current session
         session, err := utils.SessionStore.Get(r, "session")
         if err != nil {
-                log.Printf("Error getting session: %v", err)
+                utils.Logger(r.Context()).Debug("Ignoring unreadable session cookie", "error", err)
         }
         
-        log.Printf("Session values: %v", session.Values)
-        
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                log.Printf("No userID in session or not a string")
                 w.Header().Set("Content-Type", "application/json")
-                json.NewEncoder(w).Encode(map[string]bool{"authenticated": false})
+                writeJSON(w, r, models.AuthStatus{Authenticated: false})
                 return
         }
 
-        log.Printf("Found userID in session: %s", userID)
-
         // Get user data
-        user, exists := utils.GetUser(userID)
-        if !exists {
-                log.Printf("User with ID %s not found", userID)
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil && !errors.Is(err, utils.ErrNotFound) {
+                writeError(w, r, err)
+                return
+        }
+        if err != nil || user.IsDeleted() {
+                utils.Logger(r.Context()).Info("Session refers to a missing user", "userId", userID)
                 w.Header().Set("Content-Type", "application/json")
-                json.NewEncoder(w).Encode(map[string]bool{"authenticated": false})
+                writeJSON(w, r, models.AuthStatus{Authenticated: false})
                 return
         }
 
-        log.Printf("User authenticated: %s (%s)", user.Username, user.ID)
-
         // User is authenticated
-        response := map[string]interface{}{
-                "authenticated": true,
-                "user":          user.ToUserResponse(),
-        }
+        userResponse := user.ToUserResponse()
+        response := models.AuthStatus{Authenticated: true, User: &userResponse}
 
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(response)
+        writeJSON(w, r, response)
 }
 
 // GetCurrentUser returns the current authenticated user
@@ -240,23 +219,23 @@ func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusUnauthorized)
-                json.NewEncoder(w).Encode(map[string]string{"message": "Not authenticated"})
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
         // Get user data
-        user, exists := utils.GetUser(userID)
-        if !exists {
-                w.Header().Set("Content-Type", "application/json")
-                w.WriteHeader(http.StatusNotFound)
-                json.NewEncoder(w).Encode(map[string]string{"message": "User not found"})
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if user.IsDeleted() {
+                httpError(w, r, "User not found", http.StatusNotFound)
                 return
         }
 
         // Return user info
         userResponse := user.ToUserResponse()
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(userResponse)
+        writeJSON(w, r, userResponse)
 }
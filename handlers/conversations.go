@@ -0,0 +1,267 @@
+package handlers
+
+import (
+        "context"
+        "errors"
+        "net/http"
+        "sort"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/openapi"
+        "github.com/plantexchange/app/utils"
+)
+
+// conversationsParams documents the query parameters of GetConversations
+var conversationsParams = []openapi.Param{
+        {Name: "archived", Type: "boolean", Description: "List archived conversations instead of the others"},
+}
+
+// GetConversations gets the current user's conversations, most recently active first
+func GetConversations(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Get the conversations with their unread counts
+        archived := r.URL.Query().Get("archived") == "true"
+        conversations, err := utils.GetConversations(r.Context(), userID, archived)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return conversations
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, conversations)
+}
+
+// GetConversation gets every message between the current user and the user in the
+// URL path, across all their conversations, and marks them read. This is the v1
+// form; GetConversationMessages gets a single conversation by its ID.
+func GetConversation(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Check if partner exists
+        partnerID := mux.Vars(r)["userId"]
+        partner, err := utils.GetUser(r.Context(), partnerID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        currentUser, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
+                return
+        }
+
+        // Get the conversations with the partner, one per listing
+        conversations, err := utils.GetConversationsWithUser(r.Context(), userID, partnerID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Collect their messages, marking each conversation read
+        messages := []models.MessageWithUser{}
+        for _, conversation := range conversations {
+                conversationMessages, err := readConversationMessages(r.Context(), conversation, currentUser, partner)
+                if err != nil {
+                        writeError(w, r, err)
+                        return
+                }
+                messages = append(messages, conversationMessages...)
+        }
+        sort.SliceStable(messages, func(i, j int) bool {
+                return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+        })
+
+        // Return conversation
+        conversation := models.Conversation{
+                UserID:     partner.ID,
+                Username:   partner.Username,
+                ProfilePic: partner.ProfilePic,
+                Messages:   messages,
+                Unread:     0, // All messages marked as read
+        }
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, conversation)
+}
+
+// GetConversationMessages gets one of the current user's conversations with its
+// messages and marks it read
+func GetConversationMessages(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Find the conversation; other users' conversations are not found
+        conversationID := mux.Vars(r)["id"]
+        conversation, err := utils.GetConversation(r.Context(), conversationID, userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Get the participants
+        currentUser, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
+                return
+        }
+        partner, err := utils.GetUser(r.Context(), conversation.UserID)
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
+                return
+        }
+
+        // Get its messages and mark them read
+        conversation.Messages, err = readConversationMessages(r.Context(), conversation, currentUser, partner)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if len(conversation.Messages) > 0 {
+                conversation.LastReadMessageID = conversation.Messages[len(conversation.Messages)-1].ID
+        }
+        conversation.Unread = 0
+
+        // Return conversation
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, conversation)
+}
+
+// readConversationMessages gets the messages of a conversation between the current
+// user and a partner, with the users and the listing they are about, and marks the
+// conversation read
+func readConversationMessages(ctx context.Context, conversation models.Conversation, currentUser, partner models.User) ([]models.MessageWithUser, error) {
+        // Get its messages
+        messages, err := utils.GetConversationMessages(ctx, conversation.ID, currentUser.ID)
+        if err != nil {
+                return nil, err
+        }
+
+        // Get the listing shared by every message
+        var listing models.Listing
+        if conversation.ListingID != "" {
+                listing, err = utils.GetListing(ctx, conversation.ListingID)
+                if err != nil && !errors.Is(err, utils.ErrNotFound) {
+                        return nil, err
+                }
+        }
+
+        // Mark the conversation read
+        if err := utils.MarkConversationRead(ctx, conversation.ID, currentUser.ID); err != nil {
+                return nil, err
+        }
+
+        // Enhance messages with user and listing information
+        users := map[string]models.UserResponse{
+                currentUser.ID: currentUser.ToUserResponse(),
+                partner.ID:     partner.ToUserResponse(),
+        }
+        messagesWithInfo := []models.MessageWithUser{}
+        for _, msg := range messages {
+                if msg.ToID == currentUser.ID {
+                        msg.Read = true
+                }
+                messagesWithInfo = append(messagesWithInfo, models.MessageWithUser{
+                        Message:  msg,
+                        FromUser: users[msg.FromID],
+                        ToUser:   users[msg.ToID],
+                        Listing:  listing,
+                })
+        }
+
+        return messagesWithInfo, nil
+}
+
+// MarkConversationRead marks every message of one of the current user's conversations read
+func MarkConversationRead(w http.ResponseWriter, r *http.Request) {
+        changeConversation(w, r, utils.MarkConversationRead)
+}
+
+// ArchiveConversation hides a conversation from the current user's list until a new message arrives
+func ArchiveConversation(w http.ResponseWriter, r *http.Request) {
+        changeConversation(w, r, func(ctx context.Context, id, userID string) error {
+                return utils.SetConversationArchived(ctx, id, userID, true)
+        })
+}
+
+// UnarchiveConversation brings a conversation back to the current user's list
+func UnarchiveConversation(w http.ResponseWriter, r *http.Request) {
+        changeConversation(w, r, func(ctx context.Context, id, userID string) error {
+                return utils.SetConversationArchived(ctx, id, userID, false)
+        })
+}
+
+// MuteConversation keeps a conversation in the current user's archive when new messages arrive
+func MuteConversation(w http.ResponseWriter, r *http.Request) {
+        changeConversation(w, r, func(ctx context.Context, id, userID string) error {
+                return utils.SetConversationMuted(ctx, id, userID, true)
+        })
+}
+
+// UnmuteConversation lets new messages bring a conversation back from the current user's archive
+func UnmuteConversation(w http.ResponseWriter, r *http.Request) {
+        changeConversation(w, r, func(ctx context.Context, id, userID string) error {
+                return utils.SetConversationMuted(ctx, id, userID, false)
+        })
+}
+
+// changeConversation applies a change to the current user's view of the
+// conversation in the URL path and returns the conversation without its messages
+func changeConversation(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id, userID string) error) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Check the user is part of the conversation
+        conversationID := mux.Vars(r)["id"]
+        if _, err := utils.GetConversation(r.Context(), conversationID, userID); err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Apply the change
+        if err := change(r.Context(), conversationID, userID); err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return the updated conversation
+        conversation, err := utils.GetConversation(r.Context(), conversationID, userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, conversation)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "net/http"
+        "strings"
+
+        "github.com/plantexchange/app/utils"
+)
+
+// Limits on JSON request bodies
+const (
+        maxJSONBodySize    = 64 << 10 // most requests
+        maxProfileBodySize = 5 << 20  // profile pictures are sent as data URLs
+        maxListingBodySize = 25 << 20 // listing images are sent as data URLs
+)
+
+// validatable is implemented by request types with validation rules
+type validatable interface {
+        Validate(v *utils.Validator)
+}
+
+// decodeJSON decodes a JSON request body of at most maxBytes into dst, rejecting
+// unknown fields and trailing data, then applies dst's validation rules. On failure
+// it writes the error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) bool {
+        r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+        decoder := json.NewDecoder(r.Body)
+        decoder.DisallowUnknownFields()
+
+        if err := decoder.Decode(dst); err != nil {
+                writeDecodeError(w, r, err)
+                return false
+        }
+        if err := decoder.Decode(&struct{}{}); err != io.EOF {
+                httpError(w, r, "Request body must contain a single JSON object", http.StatusBadRequest)
+                return false
+        }
+
+        if request, ok := dst.(validatable); ok {
+                v := utils.NewValidator()
+                request.Validate(v)
+                if err := v.Err(); err != nil {
+                        writeError(w, r, err)
+                        return false
+                }
+        }
+
+        return true
+}
+
+// writeDecodeError explains why a request body could not be decoded
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+        var syntaxErr *json.SyntaxError
+        var typeErr *json.UnmarshalTypeError
+        var sizeErr *http.MaxBytesError
+
+        switch {
+        case errors.As(err, &sizeErr):
+                httpError(w, r, fmt.Sprintf("Request body must not be larger than %d bytes", sizeErr.Limit), http.StatusRequestEntityTooLarge)
+        case errors.Is(err, io.EOF):
+                httpError(w, r, "Request body is required", http.StatusBadRequest)
+        case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+                httpError(w, r, "Request body is not valid JSON", http.StatusBadRequest)
+        case errors.As(err, &typeErr) && typeErr.Field != "":
+                writeError(w, r, utils.ValidationError("Invalid request body",
+                        map[string]string{typeErr.Field: "must be a " + jsonTypeName(typeErr.Type.Kind().String())}))
+        case strings.HasPrefix(err.Error(), "json: unknown field "):
+                field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+                writeError(w, r, utils.ValidationError("Invalid request body", map[string]string{field: "is not a known field"}))
+        default:
+                httpError(w, r, "Invalid request body", http.StatusBadRequest)
+        }
+}
+
+// jsonTypeName describes a Go kind in JSON terms
+func jsonTypeName(kind string) string {
+        switch kind {
+        case "string":
+                return "string"
+        case "bool":
+                return "boolean"
+        case "slice", "array":
+                return "list"
+        case "struct", "map", "ptr":
+                return "object"
+        }
+        return "number"
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+        "context"
+        "encoding/json"
+        "errors"
+        "net/http"
+        "strings"
+
+        "github.com/plantexchange/app/utils"
+)
+
+// Error codes used in API error responses, alongside the HTTP status
+const (
+        codeBadRequest       = "bad_request"
+        codeUnauthenticated  = "unauthenticated"
+        codeForbidden        = "forbidden"
+        codeNotFound         = "not_found"
+        codeMethodNotAllowed = "method_not_allowed"
+        codeConflict         = "conflict"
+        codeTooLarge         = "payload_too_large"
+        codeUnprocessable    = "unprocessable"
+        codeRateLimited      = "rate_limited"
+        codeValidation       = "validation_failed"
+        codeInternal         = "internal"
+        codeUnavailable      = "unavailable"
+)
+
+// errorEnvelope is the JSON body of every API error response
+type errorEnvelope struct {
+        Error apiError `json:"error"`
+}
+
+// apiError describes an error to API clients
+type apiError struct {
+        Code      string            `json:"code"`
+        Message   string            `json:"message"`
+        Fields    map[string]string `json:"fields,omitempty"`  // per-field problems, keyed by JSON field name
+        Details   interface{}       `json:"details,omitempty"` // extra structured information for some errors
+        RequestID string            `json:"requestId"`
+}
+
+// statusCodes maps HTTP statuses to error codes
+var statusCodes = map[int]string{
+        http.StatusBadRequest:            codeBadRequest,
+        http.StatusUnauthorized:          codeUnauthenticated,
+        http.StatusForbidden:             codeForbidden,
+        http.StatusNotFound:              codeNotFound,
+        http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+        http.StatusConflict:              codeConflict,
+        http.StatusRequestEntityTooLarge: codeTooLarge,
+        http.StatusUnprocessableEntity:   codeUnprocessable,
+        http.StatusTooManyRequests:       codeRateLimited,
+        http.StatusInternalServerError:   codeInternal,
+        http.StatusServiceUnavailable:    codeUnavailable,
+}
+
+// writeError renders an error returned by the storage layer. Internal errors and
+// timeouts are logged with the request ID and reported without their cause.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+        status := errorStatus(err)
+        body := apiError{
+                Code:    statusCodes[status],
+                Message: utils.UserMessage(err),
+        }
+        if errors.Is(err, utils.ErrValidation) {
+                body.Code = codeValidation
+        }
+
+        var typed *utils.Error
+        if errors.As(err, &typed) {
+                body.Fields = typed.Fields
+        }
+
+        logError(r, status, err)
+        writeAPIError(w, r, status, body)
+}
+
+// errorStatus returns the HTTP status for an error returned by the storage layer
+func errorStatus(err error) int {
+        switch {
+        case errors.Is(err, utils.ErrNotFound):
+                return http.StatusNotFound
+        case errors.Is(err, utils.ErrConflict):
+                return http.StatusConflict
+        case errors.Is(err, utils.ErrValidation):
+                return http.StatusBadRequest
+        case errors.Is(err, utils.ErrTimeout):
+                return http.StatusServiceUnavailable
+        case errors.Is(err, utils.ErrRateLimited):
+                return http.StatusTooManyRequests
+        }
+        return http.StatusInternalServerError
+}
+
+// logError logs internal errors and timeouts with the request ID
+func logError(r *http.Request, status int, err error) {
+        logger := utils.Logger(r.Context())
+        switch {
+        case errors.Is(err, context.Canceled):
+                // The client went away; nobody will read the response
+                logger.Info("Request cancelled by client", "method", r.Method, "path", r.URL.Path)
+        case status == http.StatusServiceUnavailable:
+                logger.Warn("Request timed out", "method", r.Method, "path", r.URL.Path, "error", err)
+        case status == http.StatusInternalServerError:
+                logger.Error("Request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+        }
+}
+
+// httpError renders an error detected by a handler, like http.Error but as a JSON envelope
+func httpError(w http.ResponseWriter, r *http.Request, message string, status int) {
+        writeAPIError(w, r, status, apiError{Code: codeFor(status), Message: message})
+}
+
+// codeFor returns the error code for an HTTP status
+func codeFor(status int) string {
+        if code, ok := statusCodes[status]; ok {
+                return code
+        }
+        return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// writeAPIError writes the error envelope with the request ID filled in
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, body apiError) {
+        body.RequestID = utils.RequestIDFromContext(r.Context())
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        w.WriteHeader(status)
+        json.NewEncoder(w).Encode(errorEnvelope{Error: body})
+}
+
+// APINotFound renders unknown API routes as a JSON error
+func APINotFound(w http.ResponseWriter, r *http.Request) {
+        httpError(w, r, "No API route matches "+r.URL.Path, http.StatusNotFound)
+}
+
+// APIMethodNotAllowed renders a known API route called with the wrong method as a JSON error
+func APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+        httpError(w, r, r.Method+" is not allowed on "+r.URL.Path, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+        "context"
+        "encoding/xml"
+        "net/http"
+        "net/url"
+        "strings"
+        "time"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/utils"
+)
+
+// feedSize is how many of the latest listings a feed contains
+const feedSize = 50
+
+// maxSitemapURLs is the most URLs a single sitemap may list
+const maxSitemapURLs = 50000
+
+// feedCacheControl lets crawlers and feed readers cache responses for a while
+const feedCacheControl = "public, max-age=900"
+
+// sitemapURLSet is a sitemap in the sitemaps.org format
+type sitemapURLSet struct {
+        XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+        URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapURL is one page in a sitemap
+type sitemapURL struct {
+        Loc     string `xml:"loc"`
+        LastMod string `xml:"lastmod,omitempty"`
+}
+
+// atomFeed is an Atom (RFC 4287) feed
+type atomFeed struct {
+        XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+        ID      string      `xml:"id"`
+        Title   string      `xml:"title"`
+        Updated string      `xml:"updated"`
+        Links   []atomLink  `xml:"link"`
+        Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link from an Atom feed or entry
+type atomLink struct {
+        Rel  string `xml:"rel,attr,omitempty"`
+        Type string `xml:"type,attr,omitempty"`
+        Href string `xml:"href,attr"`
+}
+
+// atomEntry is one listing in an Atom feed
+type atomEntry struct {
+        ID         string         `xml:"id"`
+        Title      string         `xml:"title"`
+        Updated    string         `xml:"updated"`
+        Published  string         `xml:"published"`
+        Links      []atomLink     `xml:"link"`
+        Author     atomPerson     `xml:"author"`
+        Summary    string         `xml:"summary"`
+        Categories []atomCategory `xml:"category"`
+}
+
+// atomPerson names the author of an entry
+type atomPerson struct {
+        Name string `xml:"name"`
+        URI  string `xml:"uri,omitempty"`
+}
+
+// atomCategory tags an entry
+type atomCategory struct {
+        Term string `xml:"term,attr"`
+}
+
+// rssFeed is an RSS 2.0 feed
+type rssFeed struct {
+        XMLName xml.Name   `xml:"rss"`
+        Version string     `xml:"version,attr"`
+        AtomNS  string     `xml:"xmlns:atom,attr"`
+        Channel rssChannel `xml:"channel"`
+}
+
+// rssChannel describes an RSS feed and holds its items
+type rssChannel struct {
+        Title         string    `xml:"title"`
+        Link          string    `xml:"link"`
+        Description   string    `xml:"description"`
+        Self          atomLink  `xml:"atom:link"`
+        LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+        Items         []rssItem `xml:"item"`
+}
+
+// rssItem is one listing in an RSS feed
+type rssItem struct {
+        Title       string   `xml:"title"`
+        Link        string   `xml:"link"`
+        GUID        rssGUID  `xml:"guid"`
+        PubDate     string   `xml:"pubDate"`
+        Description string   `xml:"description"`
+        Categories  []string `xml:"category"`
+}
+
+// rssGUID identifies an item; listing URLs are permanent
+type rssGUID struct {
+        IsPermaLink bool   `xml:"isPermaLink,attr"`
+        Value       string `xml:",chardata"`
+}
+
+// Sitemap lists the home page, every available listing and the public profiles of
+// their sellers for search engines
+func Sitemap(w http.ResponseWriter, r *http.Request) {
+        // Get available listings
+        listings, err := availableListings(r.Context(), listingFilter{}, maxSitemapURLs-1)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // List the home page, then each listing
+        urlSet := sitemapURLSet{URLs: []sitemapURL{{Loc: absoluteURL(r, "/")}}}
+        var sellers []string
+        sellerSeen := map[string]bool{}
+        for _, listing := range listings {
+                urlSet.URLs = append(urlSet.URLs, sitemapURL{
+                        Loc:     absoluteURL(r, "/listing/"+listing.ID),
+                        LastMod: listing.UpdatedAt.UTC().Format(time.RFC3339),
+                })
+                if !sellerSeen[listing.User.Username] {
+                        sellerSeen[listing.User.Username] = true
+                        sellers = append(sellers, listing.User.Username)
+                }
+        }
+
+        // Then the sellers' profiles, as far as the sitemap has room
+        for _, username := range sellers {
+                if len(urlSet.URLs) >= maxSitemapURLs {
+                        break
+                }
+                urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: absoluteURL(r, "/u/"+url.PathEscape(username))})
+        }
+
+        writeXML(w, r, "application/xml", urlSet)
+}
+
+// Robots points crawlers at the sitemap and keeps them out of the API and private pages
+func Robots(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        w.Header().Set("Cache-Control", feedCacheControl)
+        w.Write([]byte("User-agent: *\n" +
+                "Disallow: /api/\n" +
+                "Disallow: /dashboard\n" +
+                "Disallow: /messages\n" +
+                "Disallow: /profile\n" +
+                "Disallow: /create-listing\n" +
+                "Disallow: /edit-listing/\n" +
+                "\n" +
+                "Sitemap: " + absoluteURL(r, "/sitemap.xml") + "\n"))
+}
+
+// ListingsAtom serves the latest available listings as an Atom feed. It accepts
+// the userId, type, plantType and location filters of GetListings.
+func ListingsAtom(w http.ResponseWriter, r *http.Request) {
+        // Get the latest matching listings
+        filter := feedFilter(r)
+        listings, err := availableListings(r.Context(), filter, feedSize)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Build feed
+        self := absoluteURL(r, r.URL.RequestURI())
+        feed := atomFeed{
+                ID:      self,
+                Title:   feedTitle(filter),
+                Updated: feedUpdated(listings).Format(time.RFC3339),
+                Links: []atomLink{
+                        {Rel: "self", Type: "application/atom+xml", Href: self},
+                        {Rel: "alternate", Type: "text/html", Href: absoluteURL(r, "/"+filterQuery(filter))},
+                },
+        }
+        for _, listing := range listings {
+                link := absoluteURL(r, "/listing/"+listing.ID)
+                feed.Entries = append(feed.Entries, atomEntry{
+                        ID:        link,
+                        Title:     listing.Title,
+                        Updated:   listing.UpdatedAt.UTC().Format(time.RFC3339),
+                        Published: listing.CreatedAt.UTC().Format(time.RFC3339),
+                        Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: link}},
+                        Author:    atomPerson{Name: listing.User.Name},
+                        Summary:   feedSummary(listing),
+                        Categories: []atomCategory{
+                                {Term: listing.Type},
+                                {Term: listing.PlantType},
+                        },
+                })
+        }
+
+        writeXML(w, r, "application/atom+xml", feed)
+}
+
+// ListingsRSS serves the latest available listings as an RSS 2.0 feed, with the
+// same filters as ListingsAtom
+func ListingsRSS(w http.ResponseWriter, r *http.Request) {
+        // Get the latest matching listings
+        filter := feedFilter(r)
+        listings, err := availableListings(r.Context(), filter, feedSize)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Build feed
+        feed := rssFeed{
+                Version: "2.0",
+                AtomNS:  "http://www.w3.org/2005/Atom",
+                Channel: rssChannel{
+                        Title:         feedTitle(filter),
+                        Link:          absoluteURL(r, "/"+filterQuery(filter)),
+                        Description:   "The latest plants, seeds and cuttings on Leaf Connect",
+                        Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: absoluteURL(r, r.URL.RequestURI())},
+                        LastBuildDate: feedUpdated(listings).Format(time.RFC1123Z),
+                },
+        }
+        for _, listing := range listings {
+                link := absoluteURL(r, "/listing/"+listing.ID)
+                feed.Channel.Items = append(feed.Channel.Items, rssItem{
+                        Title:       listing.Title,
+                        Link:        link,
+                        GUID:        rssGUID{IsPermaLink: true, Value: link},
+                        PubDate:     listing.CreatedAt.UTC().Format(time.RFC1123Z),
+                        Description: feedSummary(listing),
+                        Categories:  []string{listing.Type, listing.PlantType},
+                })
+        }
+
+        writeXML(w, r, "application/rss+xml", feed)
+}
+
+// feedFilter reads the GetListings filters from the query string
+func feedFilter(r *http.Request) listingFilter {
+        queryParams := r.URL.Query()
+        return listingFilter{
+                UserID:    queryParams.Get("userId"),
+                Type:      queryParams.Get("type"),
+                PlantType: queryParams.Get("plantType"),
+                Location:  queryParams.Get("location"),
+        }
+}
+
+// availableListings returns up to limit published listings matching a filter that
+// can still be bought or traded, newest first
+func availableListings(ctx context.Context, filter listingFilter, limit int) ([]models.ListingWithUser, error) {
+        return utils.GetAvailableListings(ctx, utils.ListingFilter{
+                UserID:    filter.UserID,
+                Type:      filter.Type,
+                PlantType: filter.PlantType,
+                Location:  filter.Location,
+        }, limit)
+}
+
+// feedTitle names a feed after its filters, e.g. "Leaf Connect: seed, herb in Delhi"
+func feedTitle(filter listingFilter) string {
+        var kinds []string
+        for _, kind := range []string{filter.Type, filter.PlantType} {
+                if kind != "" {
+                        kinds = append(kinds, kind)
+                }
+        }
+
+        title := "Leaf Connect: latest listings"
+        if len(kinds) > 0 {
+                title = "Leaf Connect: " + strings.Join(kinds, ", ")
+        }
+        if filter.Location != "" {
+                title += " in " + filter.Location
+        }
+        return title
+}
+
+// filterQuery returns the home page query string for a filter, e.g. "?type=seed"
+func filterQuery(filter listingFilter) string {
+        query := url.Values{}
+        for name, value := range map[string]string{"type": filter.Type, "plantType": filter.PlantType, "location": filter.Location} {
+                if value != "" {
+                        query.Set(name, value)
+                }
+        }
+        if len(query) == 0 {
+                return ""
+        }
+        return "?" + query.Encode()
+}
+
+// feedUpdated is when a feed last changed: the latest update of its listings
+func feedUpdated(listings []models.ListingWithUser) time.Time {
+        var updated time.Time
+        for _, listing := range listings {
+                if listing.UpdatedAt.After(updated) {
+                        updated = listing.UpdatedAt
+                }
+        }
+        if updated.IsZero() {
+                updated = time.Now()
+        }
+        return updated.UTC()
+}
+
+// feedSummary describes a listing in a feed entry
+func feedSummary(listing models.ListingWithUser) string {
+        summary := formatPrice(listing.Price) + " · " + listing.Location
+        if listing.TradeFor != "" {
+                summary += " · will trade for " + listing.TradeFor
+        }
+        return summary + "\n\n" + truncate(500, listing.Description)
+}
+
+// writeXML writes an XML document with its declaration
+func writeXML(w http.ResponseWriter, r *http.Request, contentType string, document interface{}) {
+        body, err := xml.MarshalIndent(document, "", "  ")
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
+                return
+        }
+
+        w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+        w.Header().Set("Cache-Control", feedCacheControl)
+        w.Write([]byte(xml.Header))
+        w.Write(body)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+        "errors"
+        "net/http"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/openapi"
+        "github.com/plantexchange/app/utils"
+)
+
+// feedParams documents the query parameters of GetFeed
+var feedParams = []openapi.Param{
+        {Name: "cursor", Description: "nextCursor of the previous page; the first page if omitted"},
+        limitParam,
+}
+
+// FollowUser makes the logged-in user follow another user
+func FollowUser(w http.ResponseWriter, r *http.Request) {
+        changeFollow(w, r, true)
+}
+
+// UnfollowUser makes the logged-in user stop following another user
+func UnfollowUser(w http.ResponseWriter, r *http.Request) {
+        changeFollow(w, r, false)
+}
+
+// changeFollow follows or unfollows the user in the URL path. Following a user
+// twice or unfollowing one not followed changes nothing.
+func changeFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        currentUserID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Find the other user; purged accounts cannot be followed
+        userID := mux.Vars(r)["id"]
+        user, err := utils.GetUser(r.Context(), userID)
+        if err == nil && user.IsDeleted() {
+                err = utils.NotFoundError("User not found")
+        }
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        if follow {
+                err = utils.FollowUser(r.Context(), currentUserID, userID)
+        } else {
+                err = utils.UnfollowUser(r.Context(), currentUserID, userID)
+        }
+        if err != nil && !errors.Is(err, utils.ErrConflict) && !errors.Is(err, utils.ErrNotFound) {
+                writeError(w, r, err)
+                return
+        }
+
+        // Report the new follower count
+        user, err = utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, models.FollowStatus{Following: follow, FollowerCount: user.FollowerCount})
+}
+
+// GetFeed gets a page of the logged-in user's personalized feed: new listings by
+// the users they follow and listings of the plant types they have favorited
+func GetFeed(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Read the page size; the cursor is checked by the storage layer
+        limit, err := readLimit(r)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Get the page of listings
+        listings, next, err := utils.GetFeedListings(r.Context(), userID, r.URL.Query().Get("cursor"), limit)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Say why each listing is in the feed
+        following, err := utils.GetFollowing(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        followed := map[string]bool{}
+        for _, id := range following {
+                followed[id] = true
+        }
+
+        feed := models.FeedPage{Items: []models.FeedItem{}, NextCursor: next}
+        for _, listing := range listings {
+                // Get user info
+                user, err := utils.GetUser(r.Context(), listing.UserID)
+                if err != nil {
+                        continue
+                }
+
+                reason := models.FeedReasonPlantType
+                if followed[listing.UserID] {
+                        reason = models.FeedReasonFollowing
+                }
+                feed.Items = append(feed.Items, models.FeedItem{
+                        ListingWithUser: models.ListingWithUser{Listing: listing, User: user.ToUserResponse()},
+                        Reason:          reason,
+                })
+        }
+
+        // Return feed
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, feed)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+        "context"
+        "encoding/json"
+        "net/http"
+        "time"
+
+        "github.com/plantexchange/app/utils"
+)
+
+// readinessTimeout bounds the database checks made by Readyz
+const readinessTimeout = 2 * time.Second
+
+// healthResponse is the body of the health and readiness checks
+type healthResponse struct {
+        Status string `json:"status"` // ok, ready or unavailable
+        Error  string `json:"error,omitempty"`
+}
+
+// Healthz reports that the process is alive and serving requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(healthResponse{Status: "ok"})
+}
+
+// Readyz reports whether the server can handle traffic: the database must answer
+// and the schema must be in place
+func Readyz(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+        defer cancel()
+
+        w.Header().Set("Content-Type", "application/json")
+        if err := utils.CheckReady(ctx); err != nil {
+                w.WriteHeader(http.StatusServiceUnavailable)
+                json.NewEncoder(w).Encode(healthResponse{Status: "unavailable", Error: err.Error()})
+                return
+        }
+        json.NewEncoder(w).Encode(healthResponse{Status: "ready"})
+}
+
+// Metrics serves metrics in the Prometheus text exposition format
+func Metrics(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        utils.WriteMetrics(w)
+}
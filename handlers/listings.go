@@ -1,65 +1,202 @@
 package handlers
 
 import (
-        "encoding/json"
+        "context"
+        "errors"
+        "io"
         "net/http"
+        "strconv"
         "strings"
         "time"
 
         "github.com/gorilla/mux"
 
         "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/openapi"
         "github.com/plantexchange/app/utils"
 )
 
+// maxImportUploadSize caps the combined size of a bulk import upload
+const maxImportUploadSize = 100 << 20
+
+// importUpload describes the multipart form read by ImportListings
+type importUpload struct {
+        File    openapi.File `json:"file"`   // CSV or JSON Lines listings
+        Images  openapi.File `json:"images"` // optional zip of the images the rows refer to
+        Format  string       `json:"format"` // csv or jsonl; guessed from the file name if empty
+        DryRun  bool         `json:"dryRun"`
+        Partial bool         `json:"partial"`
+}
+
+// listingRequest is the body of a new listing
+type listingRequest struct {
+        models.Listing
+}
+
+// Validate checks the listing rules
+func (req listingRequest) Validate(v *utils.Validator) {
+        utils.ValidateListing(v, req.Listing)
+}
+
+// listingUpdateRequest is the body of a listing update; omitted fields are left unchanged
+type listingUpdateRequest struct {
+        Title       *string           `json:"title"`
+        Description *string           `json:"description"`
+        Type        *string           `json:"type"`
+        PlantType   *string           `json:"plantType"`
+        Price       *float64          `json:"price"`
+        TradeFor    *string           `json:"tradeFor"`
+        Location    *string           `json:"location"`
+        Images      *[]string         `json:"images"`
+        Status      *string           `json:"status"`
+        CareSheet   *models.CareSheet `json:"careSheet"`
+}
+
+// Validate checks the provided fields against the listing rules
+func (req listingUpdateRequest) Validate(v *utils.Validator) {
+        var listing models.Listing
+        req.applyTo(&listing)
+        utils.ValidateListing(v, listing)
+}
+
+// applyTo copies the provided fields onto a listing. Status is handled by the caller.
+func (req listingUpdateRequest) applyTo(listing *models.Listing) {
+        if req.Title != nil {
+                listing.Title = *req.Title
+        }
+        if req.Description != nil {
+                listing.Description = *req.Description
+        }
+        if req.Type != nil {
+                listing.Type = *req.Type
+        }
+        if req.PlantType != nil {
+                listing.PlantType = *req.PlantType
+        }
+        if req.Price != nil {
+                listing.Price = *req.Price
+        }
+        if req.TradeFor != nil {
+                listing.TradeFor = *req.TradeFor
+        }
+        if req.Location != nil {
+                listing.Location = *req.Location
+        }
+        if req.Images != nil {
+                listing.Images = *req.Images
+        }
+        if req.Status != nil {
+                listing.Status = *req.Status
+        }
+        if req.CareSheet != nil {
+                listing.CareSheet = req.CareSheet
+        }
+}
+
+// publishRequest is the optional body of a publish; without publishAt the draft is published now
+type publishRequest struct {
+        PublishAt *time.Time `json:"publishAt"`
+}
+
+// favoriteRequest is the body of a favorites change
+type favoriteRequest struct {
+        ListingID string `json:"listingId"`
+        Action    string `json:"action"` // "add" or "remove"
+}
+
+// Validate checks the favorites change
+func (req favoriteRequest) Validate(v *utils.Validator) {
+        v.Required("listingId", req.ListingID)
+        v.Required("action", req.Action)
+        v.OneOf("action", req.Action, []string{"add", "remove"})
+}
+
 // GetListings returns all listings, with optional filtering
 func GetListings(w http.ResponseWriter, r *http.Request) {
         // Get query parameters for filtering
         queryParams := r.URL.Query()
-        userID := queryParams.Get("userId")
-        listingType := queryParams.Get("type")
-        plantType := queryParams.Get("plantType")
-        location := queryParams.Get("location")
+        filter := listingFilter{
+                UserID:    queryParams.Get("userId"),
+                Type:      queryParams.Get("type"),
+                PlantType: queryParams.Get("plantType"),
+                Location:  queryParams.Get("location"),
+        }
+
+        // Get matching listings
+        filteredListings, err := publishedListings(r.Context(), filter)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return filtered listings
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, filteredListings)
+}
+
+// listingFilter selects published listings; empty fields match everything
+type listingFilter struct {
+        UserID    string
+        Type      string
+        PlantType string
+        Location  string // part of the location, case-insensitive
+}
+
+// matches reports whether a listing passes the filter
+func (filter listingFilter) matches(listing models.Listing) bool {
+        if filter.UserID != "" && listing.UserID != filter.UserID {
+                return false
+        }
+        if filter.Type != "" && listing.Type != filter.Type {
+                return false
+        }
+        if filter.PlantType != "" && listing.PlantType != filter.PlantType {
+                return false
+        }
+        if filter.Location != "" && !strings.Contains(strings.ToLower(listing.Location), strings.ToLower(filter.Location)) {
+                return false
+        }
+        return true
+}
 
+// publishedListings returns the published listings matching a filter, with their sellers
+func publishedListings(ctx context.Context, filter listingFilter) ([]models.ListingWithUser, error) {
         // Get all listings
-        allListings := utils.GetListings()
-        
-        // Filter listings based on query parameters
+        allListings, err := utils.GetListings(ctx)
+        if err != nil {
+                return nil, err
+        }
+
+        // Filter listings
         filteredListings := []models.ListingWithUser{}
-        
+
         for _, listing := range allListings {
-                // Apply filters
-                if userID != "" && listing.UserID != userID {
-                        continue
-                }
-                if listingType != "" && listing.Type != listingType {
+                // Expired listings only show up in their owner's archive, drafts in their dashboard
+                if listing.Status == models.ListingStatusExpired || listing.IsDraft() {
                         continue
                 }
-                if plantType != "" && listing.PlantType != plantType {
-                        continue
-                }
-                if location != "" && !strings.Contains(strings.ToLower(listing.Location), strings.ToLower(location)) {
+
+                // Apply filters
+                if !filter.matches(listing) {
                         continue
                 }
-                
+
                 // Get user info
-                user, exists := utils.GetUser(listing.UserID)
-                if !exists {
+                user, err := utils.GetUser(ctx, listing.UserID)
+                if err != nil {
                         continue
                 }
-                
+
                 // Create listing with user info
                 listingWithUser := models.ListingWithUser{
                         Listing: listing,
                         User:    user.ToUserResponse(),
                 }
-                
+
                 filteredListings = append(filteredListings, listingWithUser)
         }
-        
-        // Return filtered listings
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(filteredListings)
+
+        return filteredListings, nil
 }
 
 // GetListing returns a specific listing by ID
@@ -68,18 +205,39 @@ func GetListing(w http.ResponseWriter, r *http.Request) {
         vars := mux.Vars(r)
         listingID := vars["id"]
 
-        // Find listing
-        listing, exists := utils.GetListing(listingID)
-        if !exists {
-                http.Error(w, "Listing not found", http.StatusNotFound)
+        // Get current user, if any
+        session, _ := utils.SessionStore.Get(r, "session")
+        currentUserID, _ := session.Values["userID"].(string)
+
+        // Find listing with its seller
+        listingWithUser, err := visibleListing(r.Context(), listingID, currentUserID, r.URL.Query().Get("buyerLocation"))
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
+        // Return listing with user info
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, listingWithUser)
+}
+
+// visibleListing loads a listing with its seller as seen by the current user, who
+// may be logged out. Drafts are only visible to their owner. Regional restrictions
+// are checked against buyerLocation, or else the current user's location.
+func visibleListing(ctx context.Context, listingID, currentUserID, buyerLocation string) (models.ListingWithUser, error) {
+        // Find listing; drafts are only visible to their owner
+        listing, err := utils.GetListing(ctx, listingID)
+        if err != nil {
+                return models.ListingWithUser{}, err
+        }
+        if listing.IsDraft() && listing.UserID != currentUserID {
+                return models.ListingWithUser{}, utils.NotFoundError("Listing not found")
+        }
+
         // Get user info
-        user, exists := utils.GetUser(listing.UserID)
-        if !exists {
-                http.Error(w, "Listing owner not found", http.StatusInternalServerError)
-                return
+        user, err := utils.GetUser(ctx, listing.UserID)
+        if err != nil {
+                return models.ListingWithUser{}, err
         }
 
         // Create listing with user info
@@ -88,9 +246,23 @@ func GetListing(w http.ResponseWriter, r *http.Request) {
                 User:    user.ToUserResponse(),
         }
 
-        // Return listing with user info
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(listingWithUser)
+        // Check regional restrictions against the buyer's location, taken from the
+        // query string or the logged-in user's profile
+        if buyerLocation == "" {
+                if currentUserID != "" && currentUserID != listing.UserID {
+                        if currentUser, err := utils.GetUser(ctx, currentUserID); err == nil {
+                                buyerLocation = currentUser.Location
+                        }
+                }
+        }
+        if buyerLocation != "" {
+                check := utils.CheckShipping(listing, buyerLocation)
+                if check.BuyerRegion != nil {
+                        listingWithUser.Shipping = &check
+                }
+        }
+
+        return listingWithUser, nil
 }
 
 // CreateListing creates a new listing
@@ -101,16 +273,16 @@ func CreateListing(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
-        // Parse request
-        var listing models.Listing
-        if err := json.NewDecoder(r.Body).Decode(&listing); err != nil {
-                http.Error(w, "Invalid request body", http.StatusBadRequest)
+        // Parse and validate request
+        var request listingRequest
+        if !decodeJSON(w, r, &request, maxListingBodySize) {
                 return
         }
+        listing := request.Listing
 
         // Set user ID and timestamps
         listing.UserID = userID
@@ -122,19 +294,148 @@ func CreateListing(w http.ResponseWriter, r *http.Request) {
                 listing.Status = "available"
         }
 
-        // Validate required fields
-        if listing.Title == "" || listing.Description == "" || listing.Type == "" {
-                http.Error(w, "Title, description, and type are required", http.StatusBadRequest)
+        // New listings are either published straight away or saved as drafts;
+        // drafts are scheduled through the publish endpoint
+        listing.PublishAt = nil
+        switch listing.Status {
+        case models.ListingStatusAvailable:
+                // Validate all fields before publishing
+                if problems := listing.PublishProblems(); len(problems) > 0 {
+                        httpError(w, r, "Cannot publish listing: "+strings.Join(problems, ", "), http.StatusBadRequest)
+                        return
+                }
+
+                // Set expiry
+                listing.ExpiresAt = listing.CreatedAt.Add(utils.ListingLifetime())
+        case models.ListingStatusDraft:
+                // Drafts can be saved incomplete and are validated when published
+        default:
+                httpError(w, r, "New listings must be available or draft", http.StatusBadRequest)
+                return
+        }
+
+        // Fill any care fields the seller left blank from the species dataset
+        if listing.CareSheet != nil {
+                if defaults, found := utils.DefaultCareSheet(listing.Title, listing.PlantType); found {
+                        listing.CareSheet.FillFrom(defaults)
+                }
+        }
+
+        // Screen for spam and scams; suspicious listings wait for moderation instead of being created
+        screening, err := utils.Screen(r.Context(), utils.ScreenedContent{
+                Kind:   models.ContentListing,
+                UserID: userID,
+                Text:   strings.Join([]string{listing.Title, listing.Description, listing.TradeFor}, "\n"),
+        }, listing.CreatedAt)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if screening.Held {
+                heldID, err := utils.HoldContent(r.Context(), models.ContentListing, userID, listing, nil, screening, listing.CreatedAt)
+                if err != nil {
+                        writeError(w, r, err)
+                        return
+                }
+
+                // Return the listing as submitted
+                listing.Held = &models.ModerationHold{ID: heldID, Message: "Your listing will be created once it has been reviewed"}
+                w.Header().Set("Content-Type", "application/json")
+                w.WriteHeader(http.StatusAccepted)
+                writeJSON(w, r, listing)
                 return
         }
 
         // Save listing
-        listingID := utils.SaveListing(listing)
+        listingID, err := utils.SaveListing(r.Context(), listing)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
         listing.ID = listingID
+        utils.ListingsCreated.Inc("api")
 
         // Return created listing
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(listing)
+        writeJSON(w, r, listing)
+}
+
+// ImportListings creates listings in bulk from an uploaded CSV or JSON Lines file.
+// Images are referenced by filename in an optional zip uploaded alongside it.
+func ImportListings(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Parse the multipart upload
+        r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadSize)
+        if err := r.ParseMultipartForm(32 << 20); err != nil {
+                httpError(w, r, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+                return
+        }
+
+        file, header, err := r.FormFile("file")
+        if err != nil {
+                httpError(w, r, "Missing import file", http.StatusBadRequest)
+                return
+        }
+        defer file.Close()
+        data, err := io.ReadAll(file)
+        if err != nil {
+                httpError(w, r, "Cannot read import file", http.StatusBadRequest)
+                return
+        }
+
+        // Read the optional images zip
+        var imagesZip []byte
+        if imagesFile, _, err := r.FormFile("images"); err == nil {
+                defer imagesFile.Close()
+                imagesZip, err = io.ReadAll(imagesFile)
+                if err != nil {
+                        httpError(w, r, "Cannot read images zip", http.StatusBadRequest)
+                        return
+                }
+        }
+
+        // Options come from form fields or the query string
+        opts := utils.ImportOptions{
+                Format:  r.FormValue("format"),
+                DryRun:  r.FormValue("dryRun") == "true",
+                Partial: r.FormValue("partial") == "true",
+        }
+        if opts.Format == "" {
+                opts.Format = utils.ImportFormatFromFilename(header.Filename)
+        }
+
+        // Run import
+        result, err := utils.RunListingImport(r.Context(), userID, data, imagesZip, opts)
+        var storageErr *utils.Error
+        if errors.As(err, &storageErr) {
+                // Storage and screening failures; anything else is a problem with the upload
+                writeError(w, r, err)
+                return
+        }
+        if err != nil {
+                httpError(w, r, err.Error(), http.StatusBadRequest)
+                return
+        }
+
+        if !result.DryRun {
+                utils.ListingsCreated.Add(float64(result.Created), "import")
+        }
+
+        // Report per-row results; an import blocked by invalid rows is unprocessable
+        w.Header().Set("Content-Type", "application/json")
+        if !opts.DryRun && result.Created == 0 && result.Held == 0 && result.Failed > 0 {
+                w.WriteHeader(http.StatusUnprocessableEntity)
+        }
+        writeJSON(w, r, result)
 }
 
 // UpdateListing updates an existing listing
@@ -145,7 +446,7 @@ func UpdateListing(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
@@ -154,74 +455,106 @@ func UpdateListing(w http.ResponseWriter, r *http.Request) {
         listingID := vars["id"]
 
         // Find listing
-        listing, exists := utils.GetListing(listingID)
-        if !exists {
-                http.Error(w, "Listing not found", http.StatusNotFound)
+        listing, err := utils.GetListing(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
         // Check if user owns the listing
         if listing.UserID != userID {
-                http.Error(w, "Unauthorized", http.StatusForbidden)
+                httpError(w, r, "Unauthorized", http.StatusForbidden)
                 return
         }
 
-        // Parse request
-        var updates struct {
-                Title       *string   `json:"title"`
-                Description *string   `json:"description"`
-                Type        *string   `json:"type"`
-                PlantType   *string   `json:"plantType"`
-                Price       *float64  `json:"price"`
-                TradeFor    *string   `json:"tradeFor"`
-                Location    *string   `json:"location"`
-                Images      *[]string `json:"images"`
-                Status      *string   `json:"status"`
-        }
-        
-        if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-                http.Error(w, "Invalid request body", http.StatusBadRequest)
+        // Parse and validate request
+        var updates listingUpdateRequest
+        if !decodeJSON(w, r, &updates, maxListingBodySize) {
                 return
         }
 
-        // Update fields if provided
-        if updates.Title != nil {
-                listing.Title = *updates.Title
-        }
-        if updates.Description != nil {
-                listing.Description = *updates.Description
-        }
-        if updates.Type != nil {
-                listing.Type = *updates.Type
-        }
-        if updates.PlantType != nil {
-                listing.PlantType = *updates.PlantType
-        }
-        if updates.Price != nil {
-                listing.Price = *updates.Price
-        }
-        if updates.TradeFor != nil {
-                listing.TradeFor = *updates.TradeFor
-        }
-        if updates.Location != nil {
-                listing.Location = *updates.Location
-        }
-        if updates.Images != nil {
-                listing.Images = *updates.Images
-        }
+        // Check the status change before applying the update
         if updates.Status != nil {
-                listing.Status = *updates.Status
+                // Drafts go live through the publish endpoint and expired listings through renew
+                switch {
+                case listing.IsDraft() && *updates.Status != models.ListingStatusDraft && *updates.Status != listing.Status:
+                        httpError(w, r, "Use the publish endpoint to publish a draft", http.StatusBadRequest)
+                        return
+                case listing.Status == models.ListingStatusExpired && *updates.Status != models.ListingStatusExpired:
+                        httpError(w, r, "Use the renew endpoint to make an expired listing available again", http.StatusBadRequest)
+                        return
+                case listing.IsDraft() && *updates.Status == models.ListingStatusDraft:
+                        // Moving a scheduled listing back to draft cancels the schedule
+                        listing.PublishAt = nil
+                case !listing.IsDraft() && (*updates.Status == models.ListingStatusDraft ||
+                        *updates.Status == models.ListingStatusScheduled || *updates.Status == models.ListingStatusExpired):
+                        httpError(w, r, "Published listings cannot be changed to "+*updates.Status, http.StatusBadRequest)
+                        return
+                }
+        }
+
+        // Update fields if provided
+        original := listing
+        updates.applyTo(&listing)
+
+        // Published listings must stay complete
+        if !listing.IsDraft() {
+                if problems := listing.PublishProblems(); len(problems) > 0 {
+                        httpError(w, r, "Cannot update listing: "+strings.Join(problems, ", "), http.StatusBadRequest)
+                        return
+                }
         }
 
         // Update timestamp
         listing.UpdatedAt = time.Now()
 
+        // Screen changed text for spam and scams; suspicious changes wait for moderation
+        if listing.Title != original.Title || listing.Description != original.Description || listing.TradeFor != original.TradeFor {
+                screening, err := utils.Screen(r.Context(), utils.ScreenedContent{
+                        Kind:   models.ContentListingEdit,
+                        UserID: userID,
+                        Text:   strings.Join([]string{listing.Title, listing.Description, listing.TradeFor}, "\n"),
+                }, listing.UpdatedAt)
+                if err != nil {
+                        writeError(w, r, err)
+                        return
+                }
+                if screening.Held {
+                        heldID, err := utils.HoldContent(r.Context(), models.ContentListingEdit, userID, models.NewListingEdit(original, listing), nil, screening, listing.UpdatedAt)
+                        if err != nil {
+                                writeError(w, r, err)
+                                return
+                        }
+
+                        // A status change is not held with the rest of the edit
+                        if listing.Status != original.Status {
+                                original.Status = listing.Status
+                                original.PublishAt = listing.PublishAt
+                                original.UpdatedAt = listing.UpdatedAt
+                                if _, err := utils.SaveListing(r.Context(), original); err != nil {
+                                        writeError(w, r, err)
+                                        return
+                                }
+                        }
+
+                        // Return the listing as it would be after the edit
+                        listing.Held = &models.ModerationHold{ID: heldID, Message: "Your changes will be saved once they have been reviewed"}
+                        w.Header().Set("Content-Type", "application/json")
+                        w.WriteHeader(http.StatusAccepted)
+                        writeJSON(w, r, listing)
+                        return
+                }
+        }
+
         // Save updated listing
-        utils.SaveListing(listing)
+        if _, err := utils.SaveListing(r.Context(), listing); err != nil {
+                writeError(w, r, err)
+                return
+        }
 
         // Return updated listing
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(listing)
+        writeJSON(w, r, listing)
 }
 
 // DeleteListing deletes a listing
@@ -232,7 +565,7 @@ func DeleteListing(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
@@ -241,27 +574,344 @@ func DeleteListing(w http.ResponseWriter, r *http.Request) {
         listingID := vars["id"]
 
         // Find listing
-        listing, exists := utils.GetListing(listingID)
-        if !exists {
-                http.Error(w, "Listing not found", http.StatusNotFound)
+        listing, err := utils.GetListing(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
         // Check if user owns the listing
         if listing.UserID != userID {
-                http.Error(w, "Unauthorized", http.StatusForbidden)
+                httpError(w, r, "Unauthorized", http.StatusForbidden)
                 return
         }
 
         // Delete listing
-        if success := utils.DeleteListing(listingID); !success {
-                http.Error(w, "Failed to delete listing", http.StatusInternalServerError)
+        if err := utils.DeleteListing(r.Context(), listingID); err != nil {
+                writeError(w, r, err)
                 return
         }
 
         // Return success
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]bool{"success": true})
+        writeJSON(w, r, successResponse{Success: true})
+}
+
+// RenewListing extends a listing's lifetime and makes an expired listing available again
+func RenewListing(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+        
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Get listing ID from URL path
+        vars := mux.Vars(r)
+        listingID := vars["id"]
+
+        // Find listing
+        listing, err := utils.GetListing(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Check if user owns the listing
+        if listing.UserID != userID {
+                httpError(w, r, "Unauthorized", http.StatusForbidden)
+                return
+        }
+
+        // Sold and traded listings stay closed
+        if listing.Status != models.ListingStatusAvailable && listing.Status != models.ListingStatusExpired {
+                httpError(w, r, "Only available or expired listings can be renewed", http.StatusBadRequest)
+                return
+        }
+
+        // Renew listing
+        expiresAt := time.Now().Add(utils.ListingLifetime())
+        if err := utils.RenewListing(r.Context(), listingID, expiresAt); err != nil {
+                writeError(w, r, err)
+                return
+        }
+        listing.Status = models.ListingStatusAvailable
+        listing.ExpiresAt = expiresAt
+
+        // Return renewed listing
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, listing)
+}
+
+// PublishListing publishes a draft immediately, or schedules it when a future publishAt is given
+func PublishListing(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+        
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Get listing ID from URL path
+        vars := mux.Vars(r)
+        listingID := vars["id"]
+
+        // Find listing
+        listing, err := utils.GetListing(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Check if user owns the listing
+        if listing.UserID != userID {
+                httpError(w, r, "Unauthorized", http.StatusForbidden)
+                return
+        }
+
+        // Only drafts can be published
+        if !listing.IsDraft() {
+                httpError(w, r, "Listing is already published", http.StatusBadRequest)
+                return
+        }
+
+        // Parse optional schedule; an empty body publishes now
+        var request publishRequest
+        if r.ContentLength != 0 && !decodeJSON(w, r, &request, maxJSONBodySize) {
+                return
+        }
+
+        // Validate all fields now; scheduled listings are checked again when they go live
+        if problems := listing.PublishProblems(); len(problems) > 0 {
+                httpError(w, r, "Cannot publish listing: "+strings.Join(problems, ", "), http.StatusBadRequest)
+                return
+        }
+
+        now := time.Now()
+
+        // Schedule for later
+        if request.PublishAt != nil && request.PublishAt.After(now) {
+                listing.Status = models.ListingStatusScheduled
+                listing.PublishAt = request.PublishAt
+                listing.UpdatedAt = now
+                if _, err := utils.SaveListing(r.Context(), listing); err != nil {
+                        writeError(w, r, err)
+                        return
+                }
+
+                w.Header().Set("Content-Type", "application/json")
+                writeJSON(w, r, listing)
+                return
+        }
+
+        // Publish now
+        expiresAt := now.Add(utils.ListingLifetime())
+        if err := utils.PublishListing(r.Context(), listingID, now, expiresAt); err != nil {
+                writeError(w, r, err)
+                return
+        }
+        listing.Status = models.ListingStatusAvailable
+        listing.PublishAt = nil
+        listing.CreatedAt = now
+        listing.UpdatedAt = now
+        listing.ExpiresAt = expiresAt
+
+        // Return published listing
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, listing)
+}
+
+// GetDraftListings returns the current user's draft and scheduled listings
+func GetDraftListings(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+        
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        listings, err := utils.GetListingsByUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Keep only unpublished listings
+        draftListings := []models.Listing{}
+        for _, listing := range listings {
+                if listing.IsDraft() {
+                        draftListings = append(draftListings, listing)
+                }
+        }
+
+        // Return drafts
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, draftListings)
+}
+
+// GetArchivedListings returns the current user's expired, sold and traded listings
+func GetArchivedListings(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+        
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        listings, err := utils.GetListingsByUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Keep only listings that are no longer active
+        archivedListings := []models.Listing{}
+        for _, listing := range listings {
+                switch listing.Status {
+                case models.ListingStatusExpired, models.ListingStatusSold, models.ListingStatusTraded:
+                        archivedListings = append(archivedListings, listing)
+                }
+        }
+
+        // Return archived listings
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, archivedListings)
+}
+
+// GetListingRevisions returns the edit history of a listing, oldest first
+func GetListingRevisions(w http.ResponseWriter, r *http.Request) {
+        // Get listing ID from URL path
+        vars := mux.Vars(r)
+        listingID := vars["id"]
+
+        // Get current user, if any
+        session, _ := utils.SessionStore.Get(r, "session")
+        currentUserID, _ := session.Values["userID"].(string)
+
+        // Find listing; the history of a draft is only visible to its owner
+        listing, err := utils.GetListing(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if listing.IsDraft() && listing.UserID != currentUserID {
+                httpError(w, r, "Listing not found", http.StatusNotFound)
+                return
+        }
+
+        revisions, err := utils.GetListingRevisions(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return revisions
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, revisions)
+}
+
+// GetListingRevisionDiff returns the field-level changes between two revisions of a listing.
+// Without query parameters the latest revision is compared with the one before it.
+func GetListingRevisionDiff(w http.ResponseWriter, r *http.Request) {
+        // Get listing ID from URL path
+        vars := mux.Vars(r)
+        listingID := vars["id"]
+
+        // Get current user, if any
+        session, _ := utils.SessionStore.Get(r, "session")
+        currentUserID, _ := session.Values["userID"].(string)
+
+        // Find listing; the history of a draft is only visible to its owner
+        listing, err := utils.GetListing(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if listing.IsDraft() && listing.UserID != currentUserID {
+                httpError(w, r, "Listing not found", http.StatusNotFound)
+                return
+        }
+
+        revisions, err := utils.GetListingRevisions(r.Context(), listingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if len(revisions) == 0 {
+                httpError(w, r, "Listing has no revisions", http.StatusNotFound)
+                return
+        }
+
+        // Parse revision numbers, defaulting to the two most recent
+        queryParams := r.URL.Query()
+        to := revisions[len(revisions)-1].Revision
+        if value := queryParams.Get("to"); value != "" {
+                parsed, err := strconv.Atoi(value)
+                if err != nil {
+                        httpError(w, r, "Invalid 'to' revision", http.StatusBadRequest)
+                        return
+                }
+                to = parsed
+        }
+        from := to - 1
+        if value := queryParams.Get("from"); value != "" {
+                parsed, err := strconv.Atoi(value)
+                if err != nil {
+                        httpError(w, r, "Invalid 'from' revision", http.StatusBadRequest)
+                        return
+                }
+                from = parsed
+        }
+
+        // Find both revisions
+        var fromRevision, toRevision *models.ListingRevision
+        for i := range revisions {
+                if revisions[i].Revision == from {
+                        fromRevision = &revisions[i]
+                }
+                if revisions[i].Revision == to {
+                        toRevision = &revisions[i]
+                }
+        }
+        if fromRevision == nil || toRevision == nil {
+                httpError(w, r, "Revision not found", http.StatusNotFound)
+                return
+        }
+
+        // Return diff
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, models.DiffRevisions(*fromRevision, *toRevision))
+}
+
+// GetCareSheetDefaults suggests care information for a listing from the species dataset
+func GetCareSheetDefaults(w http.ResponseWriter, r *http.Request) {
+        // Get listing details from query parameters
+        queryParams := r.URL.Query()
+        title := queryParams.Get("title")
+        plantType := queryParams.Get("plantType")
+
+        // Look up defaults
+        careSheet, found := utils.DefaultCareSheet(title, plantType)
+        if !found {
+                httpError(w, r, "No care information found", http.StatusNotFound)
+                return
+        }
+
+        // Return suggested care sheet
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, careSheet)
 }
 
 // SearchListings searches for listings based on query
@@ -269,45 +919,66 @@ func SearchListings(w http.ResponseWriter, r *http.Request) {
         // Get search query
         query := r.URL.Query().Get("q")
         if query == "" {
-                http.Error(w, "Search query is required", http.StatusBadRequest)
+                httpError(w, r, "Search query is required", http.StatusBadRequest)
                 return
         }
 
+        // Find matching listings
+        searchResults, err := matchingListings(r.Context(), query)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return search results
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, searchResults)
+}
+
+// matchingListings returns the published listings whose title, description or
+// plant type contain the query, with their sellers
+func matchingListings(ctx context.Context, query string) ([]models.ListingWithUser, error) {
         // Convert query to lowercase for case-insensitive search
         queryLower := strings.ToLower(query)
 
         // Get all listings
-        allListings := utils.GetListings()
-        
+        allListings, err := utils.GetListings(ctx)
+        if err != nil {
+                return nil, err
+        }
+
         // Filter listings based on search query
         searchResults := []models.ListingWithUser{}
-        
+
         for _, listing := range allListings {
+                // Skip expired and unpublished listings
+                if listing.Status == models.ListingStatusExpired || listing.IsDraft() {
+                        continue
+                }
+
                 // Check if query matches title, description, or plant type
                 titleMatch := strings.Contains(strings.ToLower(listing.Title), queryLower)
                 descMatch := strings.Contains(strings.ToLower(listing.Description), queryLower)
                 typeMatch := strings.Contains(strings.ToLower(listing.PlantType), queryLower)
-                
+
                 if titleMatch || descMatch || typeMatch {
                         // Get user info
-                        user, exists := utils.GetUser(listing.UserID)
-                        if !exists {
+                        user, err := utils.GetUser(ctx, listing.UserID)
+                        if err != nil {
                                 continue
                         }
-                        
+
                         // Create listing with user info
                         listingWithUser := models.ListingWithUser{
                                 Listing: listing,
                                 User:    user.ToUserResponse(),
                         }
-                        
+
                         searchResults = append(searchResults, listingWithUser)
                 }
         }
-        
-        // Return search results
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(searchResults)
+
+        return searchResults, nil
 }
 
 // ToggleFavorite adds or removes a listing from a user's favorites
@@ -318,40 +989,42 @@ func ToggleFavorite(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
-        // Parse request
-        var request struct {
-                ListingID string `json:"listingId"`
-                Action    string `json:"action"` // "add" or "remove"
-        }
-        
-        if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-                http.Error(w, "Invalid request body", http.StatusBadRequest)
+        // Parse and validate request
+        var request favoriteRequest
+        if !decodeJSON(w, r, &request, maxJSONBodySize) {
                 return
         }
 
-        // Validate listing exists
-        if _, exists := utils.GetListing(request.ListingID); !exists {
-                http.Error(w, "Listing not found", http.StatusNotFound)
+        // Validate listing exists; other users' drafts cannot be favorited
+        listing, err := utils.GetListing(r.Context(), request.ListingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if request.Action == "add" && listing.IsDraft() && listing.UserID != userID {
+                httpError(w, r, "Listing not found", http.StatusNotFound)
                 return
         }
 
-        var success bool
         if request.Action == "add" {
-                success = utils.AddFavorite(userID, request.ListingID)
-        } else if request.Action == "remove" {
-                success = utils.RemoveFavorite(userID, request.ListingID)
+                err = utils.AddFavorite(r.Context(), userID, request.ListingID)
         } else {
-                http.Error(w, "Invalid action, must be 'add' or 'remove'", http.StatusBadRequest)
+                err = utils.RemoveFavorite(r.Context(), userID, request.ListingID)
+        }
+
+        // Adding an existing favorite or removing a missing one changes nothing
+        if err != nil && !errors.Is(err, utils.ErrConflict) && !errors.Is(err, utils.ErrNotFound) {
+                writeError(w, r, err)
                 return
         }
 
         // Return result
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]bool{"success": success})
+        writeJSON(w, r, successResponse{Success: err == nil})
 }
 
 // GetFavorites gets a user's favorite listings
@@ -362,25 +1035,34 @@ func GetFavorites(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
         // Get favorite listing IDs
-        favoriteIDs := utils.GetFavorites(userID)
+        favoriteIDs, err := utils.GetFavorites(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
         
         // Get favorite listings
         favoriteListings := []models.ListingWithUser{}
         
         for _, id := range favoriteIDs {
-                listing, exists := utils.GetListing(id)
-                if !exists {
+                listing, err := utils.GetListing(r.Context(), id)
+                if err != nil {
+                        continue
+                }
+
+                // Listings moved back to draft are hidden until they are published again
+                if listing.IsDraft() && listing.UserID != userID {
                         continue
                 }
                 
                 // Get user info
-                user, exists := utils.GetUser(listing.UserID)
-                if !exists {
+                user, err := utils.GetUser(r.Context(), listing.UserID)
+                if err != nil {
                         continue
                 }
                 
@@ -395,5 +1077,5 @@ func GetFavorites(w http.ResponseWriter, r *http.Request) {
         
         // Return favorites
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(favoriteListings)
+        writeJSON(w, r, favoriteListings)
 }
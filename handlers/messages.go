@@ -1,16 +1,64 @@
 package handlers
 
 import (
-        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
         "net/http"
+        "strings"
         "time"
 
         "github.com/gorilla/mux"
 
         "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/openapi"
         "github.com/plantexchange/app/utils"
 )
 
+// maxAttachmentUploadSize caps an attachment upload, leaving room for the multipart encoding
+const maxAttachmentUploadSize = utils.MaxAttachmentSize + 1<<20
+
+// attachmentUpload describes the multipart form read by UploadMessageAttachment
+type attachmentUpload struct {
+        File openapi.File `json:"file"` // JPEG, PNG or GIF photo
+}
+
+// messageRequest is the body of a new message
+type messageRequest struct {
+        models.Message
+        AttachmentIDs []string `json:"attachmentIds"` // photos uploaded with UploadMessageAttachment
+}
+
+// Validate checks the message rules
+func (req messageRequest) Validate(v *utils.Validator) {
+        utils.ValidateMessage(v, req.message())
+}
+
+// message returns the message to send, with the attachments referred to by ID
+func (req messageRequest) message() models.Message {
+        msg := req.Message
+        msg.Attachments = nil
+        for _, id := range req.AttachmentIDs {
+                msg.Attachments = append(msg.Attachments, models.MessageAttachment{ID: id})
+        }
+        return msg
+}
+
+// messageEditRequest is the body of a message edit
+type messageEditRequest struct {
+        Content string `json:"content"`
+}
+
+// Validate checks the message length; whether content is required depends on the message's photos
+func (req messageEditRequest) Validate(v *utils.Validator) {
+        v.MaxLength("content", req.Content, utils.MaxMessageLength)
+}
+
+// deleteMessageParams documents the query parameters of DeleteMessage
+var deleteMessageParams = []openapi.Param{
+        {Name: "everyone", Type: "boolean", Description: "Delete the message for both participants; only its sender may"},
+}
+
 // GetMessages gets all messages for the current user
 func GetMessages(w http.ResponseWriter, r *http.Request) {
         // Get current session
@@ -19,27 +67,31 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
         // Get all messages for this user
-        allMessages := utils.GetMessagesByUser(userID)
+        allMessages, err := utils.GetMessagesByUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
         
         // Enhance messages with user and listing information
         messagesWithInfo := []models.MessageWithUser{}
         
         for _, msg := range allMessages {
                 // Get from user
-                fromUser, fromExists := utils.GetUser(msg.FromID)
+                fromUser, fromErr := utils.GetUser(r.Context(), msg.FromID)
                 
                 // Get to user
-                toUser, toExists := utils.GetUser(msg.ToID)
+                toUser, toErr := utils.GetUser(r.Context(), msg.ToID)
                 
                 // Get listing
-                listing, listingExists := utils.GetListing(msg.ListingID)
+                listing, listingErr := utils.GetListing(r.Context(), msg.ListingID)
                 
-                if fromExists && toExists && listingExists {
+                if fromErr == nil && toErr == nil && listingErr == nil {
                         msgWithInfo := models.MessageWithUser{
                                 Message:  msg,
                                 FromUser: fromUser.ToUserResponse(),
@@ -53,7 +105,64 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
         
         // Return messages
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(messagesWithInfo)
+        writeJSON(w, r, messagesWithInfo)
+}
+
+// maxMessageSearchLength is the longest message search query accepted
+const maxMessageSearchLength = 200
+
+// searchMessagesParams documents the query parameters of SearchMessages
+var searchMessagesParams = append([]openapi.Param{
+        {Name: "q", Required: true, Description: `Search text: words, "quoted phrases", or and -excluded words`},
+        {Name: "userId", Description: "Only messages with this user"},
+        {Name: "listingId", Description: "Only conversations about this listing"},
+}, paginationParams...)
+
+// SearchMessages searches the messages of the current user's conversations, best matches first
+func SearchMessages(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Read the search and the requested page
+        queryParams := r.URL.Query()
+        search := utils.MessageSearch{
+                Query:     strings.TrimSpace(queryParams.Get("q")),
+                PartnerID: queryParams.Get("userId"),
+                ListingID: queryParams.Get("listingId"),
+        }
+        if search.Query == "" {
+                httpError(w, r, "Search query is required", http.StatusBadRequest)
+                return
+        }
+        v := utils.NewValidator()
+        v.MaxLength("q", search.Query, maxMessageSearchLength)
+        if err := v.Err(); err != nil {
+                writeError(w, r, err)
+                return
+        }
+        page, err := readPagination(r)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Search the user's messages
+        results, err := utils.SearchMessages(r.Context(), userID, search, &page)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return the page of results
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, models.MessageSearchPage{Results: results, Pagination: page})
 }
 
 // GetMessage gets a specific message by ID
@@ -64,7 +173,7 @@ func GetMessage(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
@@ -73,35 +182,49 @@ func GetMessage(w http.ResponseWriter, r *http.Request) {
         messageID := vars["id"]
 
         // Find message
-        msg, exists := utils.GetMessage(messageID)
-        if !exists {
-                http.Error(w, "Message not found", http.StatusNotFound)
+        msg, err := utils.GetMessage(r.Context(), messageID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
         // Check if user is part of the conversation
         if msg.FromID != userID && msg.ToID != userID {
-                http.Error(w, "Unauthorized", http.StatusForbidden)
+                httpError(w, r, "Unauthorized", http.StatusForbidden)
+                return
+        }
+        if msg.HiddenFor(userID) {
+                httpError(w, r, "Message not found", http.StatusNotFound)
                 return
         }
 
         // Mark message as read if recipient is viewing it
         if msg.ToID == userID && !msg.Read {
-                utils.MarkMessageAsRead(messageID)
+                if err := utils.MarkMessageAsRead(r.Context(), messageID); err != nil {
+                        writeError(w, r, err)
+                        return
+                }
                 msg.Read = true
         }
 
         // Get from user
-        fromUser, fromExists := utils.GetUser(msg.FromID)
+        fromUser, err := utils.GetUser(r.Context(), msg.FromID)
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
+                return
+        }
         
         // Get to user
-        toUser, toExists := utils.GetUser(msg.ToID)
+        toUser, err := utils.GetUser(r.Context(), msg.ToID)
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
+                return
+        }
         
         // Get listing
-        listing, listingExists := utils.GetListing(msg.ListingID)
-        
-        if !fromExists || !toExists || !listingExists {
-                http.Error(w, "Message references missing data", http.StatusInternalServerError)
+        listing, err := utils.GetListing(r.Context(), msg.ListingID)
+        if err != nil {
+                writeError(w, r, utils.InternalError(err))
                 return
         }
 
@@ -115,7 +238,7 @@ func GetMessage(w http.ResponseWriter, r *http.Request) {
 
         // Return message
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(msgWithInfo)
+        writeJSON(w, r, msgWithInfo)
 }
 
 // SendMessage sends a new message
@@ -126,32 +249,49 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         fromID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
-        // Parse request
-        var msg models.Message
-        if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-                http.Error(w, "Invalid request body", http.StatusBadRequest)
+        // Parse and validate request
+        var request messageRequest
+        if !decodeJSON(w, r, &request, maxJSONBodySize) {
                 return
         }
+        msg := request.message()
 
-        // Validate required fields
-        if msg.ToID == "" || msg.ListingID == "" || msg.Content == "" {
-                http.Error(w, "Recipient, listing, and message content are required", http.StatusBadRequest)
+        // Check if recipient exists
+        recipient, err := utils.GetUser(r.Context(), msg.ToID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
-        // Check if recipient exists
-        if _, exists := utils.GetUser(msg.ToID); !exists {
-                http.Error(w, "Recipient not found", http.StatusNotFound)
+        // Check if listing exists
+        listing, err := utils.GetListing(r.Context(), msg.ListingID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if listing.IsDraft() && listing.UserID != fromID {
+                httpError(w, r, "Listing not found", http.StatusNotFound)
                 return
         }
 
-        // Check if listing exists
-        if _, exists := utils.GetListing(msg.ListingID); !exists {
-                http.Error(w, "Listing not found", http.StatusNotFound)
+        // Check regional restrictions for whichever participant is the buyer
+        buyerLocation := recipient.Location
+        if listing.UserID != fromID {
+                if sender, err := utils.GetUser(r.Context(), fromID); err == nil {
+                        buyerLocation = sender.Location
+                }
+        }
+        check := utils.CheckShipping(listing, buyerLocation)
+        if check.Blocked() {
+                writeAPIError(w, r, http.StatusForbidden, apiError{
+                        Code:    codeForbidden,
+                        Message: "This listing cannot be sent to the buyer's region",
+                        Details: map[string]interface{}{"restrictions": check.Restrictions},
+                })
                 return
         }
 
@@ -160,154 +300,363 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
         msg.CreatedAt = time.Now()
         msg.Read = false
 
+        // Screen for spam and scams; suspicious messages wait for moderation instead of being delivered
+        screening, err := utils.Screen(r.Context(), utils.ScreenedContent{
+                Kind:        models.ContentMessage,
+                UserID:      fromID,
+                RecipientID: msg.ToID,
+                Text:        msg.Content,
+        }, msg.CreatedAt)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if screening.Held {
+                heldID, err := utils.HoldContent(r.Context(), models.ContentMessage, fromID, msg, request.AttachmentIDs, screening, msg.CreatedAt)
+                if err != nil {
+                        writeError(w, r, err)
+                        return
+                }
+
+                // Return the message as submitted
+                response := models.SentMessage{
+                        Message:  msg,
+                        Warnings: check.Restrictions,
+                        Held:     &models.ModerationHold{ID: heldID, Message: "Your message will be delivered once it has been reviewed"},
+                }
+                w.Header().Set("Content-Type", "application/json")
+                w.WriteHeader(http.StatusAccepted)
+                writeJSON(w, r, response)
+                return
+        }
+
         // Save message
-        messageID := utils.SaveMessage(msg)
-        msg.ID = messageID
+        messageID, err := utils.SaveMessage(r.Context(), msg)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        utils.MessagesSent.Inc()
+
+        // Reload the message with its attachments
+        msg, err = utils.GetMessage(r.Context(), messageID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
 
-        // Return created message
+        // Return created message along with any regional warnings
+        response := models.SentMessage{
+                Message:  msg,
+                Warnings: check.Restrictions,
+        }
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(msg)
+        writeJSON(w, r, response)
 }
 
-// GetConversations gets all conversations for the current user
-func GetConversations(w http.ResponseWriter, r *http.Request) {
+// EditMessage changes the content of one of the current user's messages within
+// the edit window; the earlier content is kept in its edit history
+func EditMessage(w http.ResponseWriter, r *http.Request) {
         // Get current session
         session, _ := utils.SessionStore.Get(r, "session")
-        
+
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
-        // Get all messages for this user
-        allMessages := utils.GetMessagesByUser(userID)
-        
-        // Group messages by conversation partner
-        conversationPartners := make(map[string][]models.Message)
-        
-        for _, msg := range allMessages {
-                var partnerID string
-                if msg.FromID == userID {
-                        partnerID = msg.ToID
-                } else {
-                        partnerID = msg.FromID
-                }
-                
-                conversationPartners[partnerID] = append(conversationPartners[partnerID], msg)
+        // Parse and validate request
+        var request messageEditRequest
+        if !decodeJSON(w, r, &request, maxJSONBodySize) {
+                return
         }
-        
-        // Create conversation summaries
-        conversations := []models.Conversation{}
-        
-        for partnerID, messages := range conversationPartners {
-                // Get partner user info
-                partner, exists := utils.GetUser(partnerID)
-                if !exists {
-                        continue
+
+        // Find the message
+        msg, ok := participantMessage(w, r, userID)
+        if !ok {
+                return
+        }
+
+        // Only the sender may edit, and only for a while after sending
+        now := time.Now()
+        window := utils.MessageEditWindow()
+        switch {
+        case msg.FromID != userID:
+                httpError(w, r, "Only the sender can edit a message", http.StatusForbidden)
+                return
+        case msg.IsDeleted():
+                httpError(w, r, "Deleted messages cannot be edited", http.StatusConflict)
+                return
+        case window == 0:
+                httpError(w, r, "Messages cannot be edited", http.StatusForbidden)
+                return
+        case now.Sub(msg.CreatedAt) > window:
+                httpError(w, r, fmt.Sprintf("Messages can only be edited within %s of sending",
+                        formatDuration(int64(window/time.Second))), http.StatusForbidden)
+                return
+        }
+
+        // Messages without photos still need some text
+        if len(msg.Attachments) == 0 {
+                v := utils.NewValidator()
+                v.Required("content", request.Content)
+                if err := v.Err(); err != nil {
+                        writeError(w, r, err)
+                        return
                 }
-                
-                // Find the most recent message
-                var lastMessage models.Message
-                var lastActivity time.Time
-                unreadCount := 0
-                
-                for _, msg := range messages {
-                        if msg.CreatedAt.After(lastActivity) {
-                                lastMessage = msg
-                                lastActivity = msg.CreatedAt
-                        }
-                        
-                        // Count unread messages sent to the current user
-                        if msg.ToID == userID && !msg.Read {
-                                unreadCount++
-                        }
+        }
+
+        // Screen the new text; suspicious edits wait for moderation and the message keeps its current text
+        screening, err := utils.Screen(r.Context(), utils.ScreenedContent{
+                Kind:        models.ContentMessageEdit,
+                UserID:      userID,
+                RecipientID: msg.ToID,
+                Text:        request.Content,
+        }, now)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+        if screening.Held {
+                msg.Content = request.Content
+                heldID, err := utils.HoldContent(r.Context(), models.ContentMessageEdit, userID, msg, nil, screening, now)
+                if err != nil {
+                        writeError(w, r, err)
+                        return
                 }
-                
-                // Create conversation
-                conversation := models.Conversation{
-                        UserID:       partnerID,
-                        Username:     partner.Username,
-                        ProfilePic:   partner.ProfilePic,
-                        LastMessage:  lastMessage.Content,
-                        LastActivity: lastActivity,
-                        Unread:       unreadCount,
+
+                // Return the message as it would be after the edit
+                response := models.SentMessage{
+                        Message: msg,
+                        Held:    &models.ModerationHold{ID: heldID, Message: "Your edit will be saved once it has been reviewed"},
                 }
-                
-                conversations = append(conversations, conversation)
+                w.Header().Set("Content-Type", "application/json")
+                w.WriteHeader(http.StatusAccepted)
+                writeJSON(w, r, response)
+                return
+        }
+
+        // Save the edit
+        if err := utils.EditMessage(r.Context(), msg.ID, userID, request.Content, now, now.Add(-window)); err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return the edited message
+        msg, err = utils.GetMessage(r.Context(), msg.ID)
+        if err != nil {
+                writeError(w, r, err)
+                return
         }
-        
-        // Return conversations
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(conversations)
+        writeJSON(w, r, models.SentMessage{Message: msg})
 }
 
-// GetConversation gets all messages between current user and another user
-func GetConversation(w http.ResponseWriter, r *http.Request) {
+// GetMessageEdits gets the earlier versions of an edited message
+func GetMessageEdits(w http.ResponseWriter, r *http.Request) {
         // Get current session
         session, _ := utils.SessionStore.Get(r, "session")
-        
+
         // Check if userID exists in session
         userID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
-        // Get partner ID from URL path
-        vars := mux.Vars(r)
-        partnerID := vars["userId"]
+        // Find the message
+        msg, ok := participantMessage(w, r, userID)
+        if !ok {
+                return
+        }
 
-        // Check if partner exists
-        partner, exists := utils.GetUser(partnerID)
-        if !exists {
-                http.Error(w, "User not found", http.StatusNotFound)
+        // Get its edit history
+        edits, err := utils.GetMessageEdits(r.Context(), msg.ID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
-        // Get messages between these users
-        messages := utils.GetMessagesBetweenUsers(userID, partnerID)
-        
-        // Enhance messages with user and listing information
-        messagesWithInfo := []models.MessageWithUser{}
-        
-        for _, msg := range messages {
-                // Get listing
-                listing, listingExists := utils.GetListing(msg.ListingID)
-                if !listingExists {
-                        continue
+        // Return edits
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, edits)
+}
+
+// DeleteMessage deletes a message for the current user, or for both participants
+// if the sender asks for that with everyone=true. Deleting a message that is
+// already deleted changes nothing.
+func DeleteMessage(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Find the message
+        msg, ok := participantMessage(w, r, userID)
+        if !ok {
+                return
+        }
+
+        // Delete it
+        var err error
+        if r.URL.Query().Get("everyone") == "true" {
+                if msg.FromID != userID {
+                        httpError(w, r, "Only the sender can delete a message for everyone", http.StatusForbidden)
+                        return
                 }
-                
-                // Mark as read if this user is the recipient
-                if msg.ToID == userID && !msg.Read {
-                        utils.MarkMessageAsRead(msg.ID)
-                        msg.Read = true
+                if !msg.IsDeleted() {
+                        err = utils.DeleteMessageForEveryone(r.Context(), msg.ID, userID, time.Now())
                 }
-                
-                // Create message with additional info
-                fromUser, _ := utils.GetUser(msg.FromID)
-                toUser, _ := utils.GetUser(msg.ToID)
-                msgWithInfo := models.MessageWithUser{
-                        Message:  msg,
-                        FromUser: fromUser.ToUserResponse(),
-                        ToUser:   toUser.ToUserResponse(),
-                        Listing:  listing,
+        } else {
+                err = utils.HideMessage(r.Context(), msg.ID, userID)
+        }
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return success
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, successResponse{Success: true})
+}
+
+// participantMessage gets the message in the URL path, writing a not found error
+// unless the user is one of its participants and has not deleted it for themselves
+func participantMessage(w http.ResponseWriter, r *http.Request, userID string) (models.Message, bool) {
+        msg, err := utils.GetMessage(r.Context(), mux.Vars(r)["id"])
+        if err == nil && ((msg.FromID != userID && msg.ToID != userID) || msg.HiddenFor(userID)) {
+                err = utils.NotFoundError("Message not found")
+        }
+        if err != nil {
+                writeError(w, r, err)
+                return models.Message{}, false
+        }
+        return msg, true
+}
+
+// UploadMessageAttachment stores a photo to be sent with a message. Until it is
+// sent, only the uploader can see it; photos never sent are deleted after a day.
+func UploadMessageAttachment(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Parse the multipart upload
+        r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentUploadSize)
+        if err := r.ParseMultipartForm(maxAttachmentUploadSize); err != nil {
+                var sizeErr *http.MaxBytesError
+                if errors.As(err, &sizeErr) {
+                        httpError(w, r, fmt.Sprintf("Attachments must not be larger than %d MB", utils.MaxAttachmentSize>>20), http.StatusRequestEntityTooLarge)
+                        return
                 }
-                
-                messagesWithInfo = append(messagesWithInfo, msgWithInfo)
+                httpError(w, r, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+                return
         }
-        
-        // Create conversation
-        conversation := models.Conversation{
-                UserID:       partnerID,
-                Username:     partner.Username,
-                ProfilePic:   partner.ProfilePic,
-                Messages:     messagesWithInfo,
-                Unread:       0, // All messages marked as read
+
+        file, _, err := r.FormFile("file")
+        if err != nil {
+                httpError(w, r, "Missing attachment file", http.StatusBadRequest)
+                return
         }
-        
-        // Return conversation
+        defer file.Close()
+        content, err := io.ReadAll(file)
+        if err != nil {
+                httpError(w, r, "Cannot read attachment file", http.StatusBadRequest)
+                return
+        }
+
+        // Check the photo and make its thumbnail
+        attachment, thumbnail, err := utils.PrepareAttachment(userID, content)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Save attachment
+        attachment, err = utils.SaveMessageAttachment(r.Context(), attachment, content, thumbnail)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return attachment
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(conversation)
+        w.WriteHeader(http.StatusCreated)
+        writeJSON(w, r, attachment)
+}
+
+// GetMessageAttachment serves an attachment's photo
+func GetMessageAttachment(w http.ResponseWriter, r *http.Request) {
+        serveAttachment(w, r, false)
+}
+
+// GetMessageAttachmentThumbnail serves an attachment's JPEG thumbnail
+func GetMessageAttachmentThumbnail(w http.ResponseWriter, r *http.Request) {
+        serveAttachment(w, r, true)
+}
+
+// serveAttachment serves a photo, or its thumbnail, to its uploader and the
+// participants of the message it was sent with. Anyone else is told it does not exist.
+func serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Find attachment
+        attachmentID := mux.Vars(r)["id"]
+        attachment, err := utils.GetMessageAttachment(r.Context(), attachmentID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Check the user may see it
+        allowed := attachment.UploaderID == userID
+        if !allowed && attachment.MessageID != "" {
+                msg, err := utils.GetMessage(r.Context(), attachment.MessageID)
+                if err != nil {
+                        writeError(w, r, err)
+                        return
+                }
+                allowed = msg.FromID == userID || msg.ToID == userID
+        }
+        if !allowed {
+                httpError(w, r, "Attachment not found", http.StatusNotFound)
+                return
+        }
+
+        content, err := utils.GetMessageAttachmentContent(r.Context(), attachmentID, thumbnail)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Send the image; it never changes, but must not be kept by shared caches
+        contentType := attachment.ContentType
+        if thumbnail {
+                contentType = "image/jpeg"
+        }
+        w.Header().Set("Content-Type", contentType)
+        w.Header().Set("Cache-Control", "private, max-age=86400")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        w.Write(content)
 }
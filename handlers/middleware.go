@@ -0,0 +1,134 @@
+package handlers
+
+import (
+        "errors"
+        "log/slog"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/utils"
+)
+
+// maxRequestIDLength bounds client-supplied request IDs
+const maxRequestIDLength = 64
+
+// RequestID gives every request an ID, reusing a well-formed X-Request-ID header
+// from the client or a proxy, and echoes it in the response. The request context
+// carries the ID and a logger that includes it.
+func RequestID(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                requestID := r.Header.Get("X-Request-ID")
+                if !validRequestID(requestID) {
+                        requestID = utils.NewRequestID()
+                }
+
+                w.Header().Set("X-Request-ID", requestID)
+                ctx := utils.WithRequestID(r.Context(), requestID)
+                ctx = utils.WithLogger(ctx, slog.Default().With("requestId", requestID))
+                next.ServeHTTP(w, r.WithContext(ctx))
+        })
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to reuse
+func validRequestID(requestID string) bool {
+        if requestID == "" || len(requestID) > maxRequestIDLength {
+                return false
+        }
+        for _, c := range requestID {
+                if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+                        return false
+                }
+        }
+        return true
+}
+
+// RevokedSessions ends sessions that are no longer valid: those issued before the
+// user's sessions were revoked by requesting account deletion, and those of purged
+// accounts. The session is emptied before the handler runs, so it sees a logged-out
+// request and authenticated endpoints reply as they would without a session.
+func RevokedSessions(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                session, _ := utils.SessionStore.Get(r, "session")
+                userID, _ := session.Values["userID"].(string)
+                if userID == "" || strings.HasPrefix(r.URL.Path, "/static/") {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+
+                // Sessions from before issue times were recorded count as issued at the epoch
+                issuedAt, _ := session.Values["issuedAt"].(int64)
+                revokedAt, err := utils.GetSessionsRevokedAt(r.Context(), userID)
+                if err != nil && !errors.Is(err, utils.ErrNotFound) {
+                        writeError(w, r, err)
+                        return
+                }
+                if err != nil || time.Unix(0, issuedAt).Before(revokedAt) {
+                        utils.Logger(r.Context()).Info("Ending revoked session", "userId", userID)
+                        session.Values = make(map[interface{}]interface{})
+                        session.Save(r, w)
+                }
+
+                next.ServeHTTP(w, r)
+        })
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+        http.ResponseWriter
+        status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+        rec.status = status
+        rec.ResponseWriter.WriteHeader(status)
+}
+
+// Instrument logs each request and counts requests and their latency by route
+// template, and notes which users are active. Routes are only known inside the
+// router, so it is installed with Router.Use; requests that match no route are
+// labelled "unmatched".
+func Instrument(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                start := time.Now()
+                rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+                // Note the user making the request, if logged in
+                session, _ := utils.SessionStore.Get(r, "session")
+                userID, _ := session.Values["userID"].(string)
+                if userID != "" {
+                        utils.RecordActiveSession(userID, start)
+                }
+
+                next.ServeHTTP(rec, r)
+                duration := time.Since(start)
+
+                route := "unmatched"
+                if current := mux.CurrentRoute(r); current != nil {
+                        if template, err := current.GetPathTemplate(); err == nil {
+                                route = template
+                        }
+                }
+                utils.HTTPRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+                utils.HTTPRequestDuration.Observe(duration.Seconds(), route, r.Method)
+
+                // Probes from the load balancer and scraper are only interesting when debugging
+                level := slog.LevelInfo
+                if route == "/healthz" || route == "/readyz" || route == "/metrics" {
+                        level = slog.LevelDebug
+                }
+                if rec.status >= http.StatusInternalServerError {
+                        level = slog.LevelError
+                }
+                utils.Logger(r.Context()).Log(r.Context(), level, "Request handled",
+                        "method", r.Method,
+                        "route", route,
+                        "status", rec.status,
+                        "durationMs", float64(duration.Microseconds())/1000,
+                        "userId", userID,
+                )
+        })
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+        "net/http"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/utils"
+)
+
+// GetNotifications gets all notifications for the current user
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Get notifications
+        notifications, err := utils.GetNotificationsByUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return notifications
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, notifications)
+}
+
+// MarkNotificationRead marks one of the current user's notifications as read
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+        // Get current session
+        session, _ := utils.SessionStore.Get(r, "session")
+
+        // Check if userID exists in session
+        userID, ok := session.Values["userID"].(string)
+        if !ok {
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
+                return
+        }
+
+        // Get notification ID from URL path
+        vars := mux.Vars(r)
+        notificationID := vars["id"]
+
+        // Mark as read
+        if err := utils.MarkNotificationAsRead(r.Context(), notificationID, userID); err != nil {
+                writeError(w, r, err)
+                return
+        }
+
+        // Return success
+        w.Header().Set("Content-Type", "application/json")
+        writeJSON(w, r, successResponse{Success: true})
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+        "encoding/json"
+        "net/http"
+        "strings"
+        "sync"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/openapi"
+)
+
+// apiInfo describes the API in the OpenAPI document
+var apiInfo = openapi.Info{
+        Title:   "Plant Exchange API",
+        Version: "1.0.0",
+        Description: "JSON API behind the Plant Exchange web app. Authenticated routes use the session " +
+                "cookie set by register or login. Errors are returned as an error envelope with a request ID. " +
+                "Routes are versioned under /api/v1; the unversioned /api routes are deprecated aliases of v1 " +
+                "and send Deprecation and Sunset headers.",
+}
+
+// APIRoutes documents every route registered by the router except HTML pages,
+// static files and the deprecated unversioned aliases, which OpenAPISpec adds.
+// Each entry must match a route in main.go, and its ID must name a method of the
+// Go client; the openapi check command reports any drift.
+var APIRoutes = []openapi.Route{
+        // Health checks and metrics
+        {Method: "GET", Path: "/healthz", ID: "Healthz", Tag: "health", Summary: "Report that the server is alive",
+                Result: healthResponse{}},
+        {Method: "GET", Path: "/readyz", ID: "Readyz", Tag: "health", Summary: "Report whether the database is reachable and migrated",
+                Result: healthResponse{}, Errors: []int{http.StatusServiceUnavailable}},
+        {Method: "GET", Path: "/metrics", ID: "Metrics", Tag: "health", Summary: "Prometheus metrics",
+                Content: openapi.ContentTypes{Response: "text/plain"}},
+        {Method: "GET", Path: "/api/v1/openapi.json", ID: "OpenAPI", Tag: "health", Summary: "This document"},
+
+        // Auth
+        {Method: "POST", Path: "/api/v1/register", ID: "Register", Tag: "auth", Summary: "Create an account and log in",
+                Body: registerRequest{}, Result: models.UserResponse{}, Errors: []int{http.StatusConflict}},
+        {Method: "POST", Path: "/api/v1/login", ID: "Login", Tag: "auth", Summary: "Log in with email and password",
+                Body: loginRequest{}, Result: models.UserResponse{}, Errors: []int{http.StatusUnauthorized}},
+        {Method: "POST", Path: "/api/v1/logout", ID: "Logout", Tag: "auth", Summary: "Log out",
+                Result: successResponse{}},
+        {Method: "GET", Path: "/api/v1/check-auth", ID: "CheckAuth", Tag: "auth", Summary: "Report whether the session is logged in",
+                Result: models.AuthStatus{}},
+
+        // Users
+        {Method: "GET", Path: "/api/v1/users/{id}", ID: "GetUser", Tag: "users", Summary: "Get a user's public profile",
+                Result: models.UserResponse{}, Errors: []int{http.StatusNotFound}},
+        {Method: "PUT", Path: "/api/v1/users/{id}", ID: "UpdateUser", Tag: "users", Summary: "Update your profile; omitted fields are unchanged",
+                Auth: true, Body: profileUpdateRequest{}, Result: models.UserResponse{},
+                Errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge}},
+        {Method: "GET", Path: "/api/v1/users/current", ID: "GetCurrentUser", Tag: "users", Summary: "Get the logged-in user",
+                Auth: true, Result: models.UserResponse{}},
+        {Method: "GET", Path: "/api/v1/users/{id}/listings", ID: "GetUserListings", Tag: "users", Summary: "Get a page of a user's active listings with their public profile and trading stats",
+                Query: paginationParams, Result: models.UserListingsPage{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/users/{id}/follow", ID: "FollowUser", Tag: "users", Summary: "Follow a user; following them again changes nothing",
+                Auth: true, Result: models.FollowStatus{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+        {Method: "DELETE", Path: "/api/v1/users/{id}/follow", ID: "UnfollowUser", Tag: "users", Summary: "Stop following a user",
+                Auth: true, Result: models.FollowStatus{}, Errors: []int{http.StatusNotFound}},
+
+        // Listings
+        {Method: "GET", Path: "/api/v1/listings/search", ID: "SearchListings", Tag: "listings", Summary: "Search listings by title, description and plant type",
+                Query:  []openapi.Param{{Name: "q", Required: true, Description: "Search text"}},
+                Result: []models.ListingWithUser{}},
+        {Method: "GET", Path: "/api/v1/listings/archive", ID: "GetArchivedListings", Tag: "listings", Summary: "List your expired, sold and traded listings",
+                Auth: true, Result: []models.Listing{}},
+        {Method: "GET", Path: "/api/v1/listings/drafts", ID: "GetDraftListings", Tag: "listings", Summary: "List your drafts and scheduled listings",
+                Auth: true, Result: []models.Listing{}},
+        {Method: "GET", Path: "/api/v1/listings", ID: "GetListings", Tag: "listings", Summary: "List published listings",
+                Query: []openapi.Param{
+                        {Name: "userId", Description: "Only listings by this user"},
+                        {Name: "type", Description: "plant, seed or cutting"},
+                        {Name: "plantType", Description: "Exact plant type"},
+                        {Name: "location", Description: "Part of the location, case-insensitive"},
+                },
+                Result: []models.ListingWithUser{}},
+        {Method: "POST", Path: "/api/v1/listings", ID: "CreateListing", Tag: "listings", Summary: "Create a listing or draft; suspected spam is held for moderation with status 202 and held set",
+                Auth: true, Body: listingRequest{}, Result: models.Listing{}, Errors: []int{http.StatusRequestEntityTooLarge}},
+        {Method: "POST", Path: "/api/v1/listings/import", ID: "ImportListings", Tag: "listings", Summary: "Create listings in bulk from a CSV or JSON Lines file",
+                Auth: true, Body: importUpload{}, Content: openapi.ContentTypes{Request: "multipart/form-data"},
+                Result: models.ImportResult{}, Errors: []int{http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity}},
+        {Method: "GET", Path: "/api/v1/listings/{id}", ID: "GetListing", Tag: "listings", Summary: "Get a listing with its seller",
+                Query:  []openapi.Param{{Name: "buyerLocation", Description: "Check regional restrictions for this location"}},
+                Result: models.ListingWithUser{}, Errors: []int{http.StatusNotFound}},
+        {Method: "PUT", Path: "/api/v1/listings/{id}", ID: "UpdateListing", Tag: "listings", Summary: "Update your listing; omitted fields are unchanged",
+                Auth: true, Body: listingUpdateRequest{}, Result: models.Listing{},
+                Errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge}},
+        {Method: "DELETE", Path: "/api/v1/listings/{id}", ID: "DeleteListing", Tag: "listings", Summary: "Delete your listing",
+                Auth: true, Result: successResponse{}, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/listings/{id}/renew", ID: "RenewListing", Tag: "listings", Summary: "Make your available or expired listing available for another lifetime",
+                Auth: true, Result: models.Listing{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/listings/{id}/publish", ID: "PublishListing", Tag: "listings", Summary: "Publish your draft now, or schedule it with publishAt",
+                Auth: true, Body: publishRequest{}, BodyOptional: true, Result: models.Listing{},
+                Errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict}},
+        {Method: "GET", Path: "/api/v1/listings/{id}/revisions", ID: "GetListingRevisions", Tag: "listings", Summary: "List a listing's revisions, oldest first",
+                Result: []models.ListingRevision{}, Errors: []int{http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/listings/{id}/revisions/diff", ID: "GetListingRevisionDiff", Tag: "listings", Summary: "Compare two revisions; defaults to the latest two",
+                Query: []openapi.Param{
+                        {Name: "from", Type: "integer", Description: "Earlier revision"},
+                        {Name: "to", Type: "integer", Description: "Later revision"},
+                },
+                Result: models.RevisionDiff{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/care-sheets/defaults", ID: "GetCareSheetDefaults", Tag: "listings", Summary: "Suggest care information from the species dataset",
+                Query: []openapi.Param{
+                        {Name: "title", Description: "Listing title"},
+                        {Name: "plantType", Description: "Listing plant type"},
+                },
+                Result: models.CareSheet{}, Errors: []int{http.StatusNotFound}},
+
+        // Messages
+        {Method: "GET", Path: "/api/v1/messages", ID: "GetMessages", Tag: "messages", Summary: "List messages you sent or received",
+                Auth: true, Result: []models.MessageWithUser{}},
+        {Method: "POST", Path: "/api/v1/messages", ID: "SendMessage", Tag: "messages", Summary: "Send a message about a listing, optionally with uploaded photos; suspected spam is held for moderation with status 202 and held set",
+                Auth: true, Body: messageRequest{}, Result: models.SentMessage{}, Errors: []int{http.StatusForbidden, http.StatusTooManyRequests}},
+        {Method: "GET", Path: "/api/v1/messages/search", ID: "SearchMessages", Tag: "messages", Summary: "Search the messages of your conversations, best matches first, with highlighted snippets",
+                Auth: true, Query: searchMessagesParams, Result: models.MessageSearchPage{}, Errors: []int{http.StatusBadRequest}},
+        {Method: "POST", Path: "/api/v1/messages/attachments", ID: "UploadMessageAttachment", Tag: "messages", Summary: "Upload a photo to send with a message",
+                Auth: true, Body: attachmentUpload{}, Content: openapi.ContentTypes{Request: "multipart/form-data"},
+                Status: http.StatusCreated, Result: models.MessageAttachment{}, Errors: []int{http.StatusRequestEntityTooLarge}},
+        {Method: "GET", Path: "/api/v1/messages/attachments/{id}", ID: "GetMessageAttachment", Tag: "messages", Summary: "Get a photo you uploaded or that was sent in one of your conversations",
+                Auth: true, Content: openapi.ContentTypes{Response: "image/*"}, Errors: []int{http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/messages/attachments/{id}/thumbnail", ID: "GetMessageAttachmentThumbnail", Tag: "messages", Summary: "Get a JPEG thumbnail of a photo you may see",
+                Auth: true, Content: openapi.ContentTypes{Response: "image/jpeg"}, Errors: []int{http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/messages/{id}", ID: "GetMessage", Tag: "messages", Summary: "Get one of your messages",
+                Auth: true, Result: models.MessageWithUser{}, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+        {Method: "PUT", Path: "/api/v1/messages/{id}", ID: "EditMessage", Tag: "messages", Summary: "Edit a message you sent, shortly after sending it",
+                Auth: true, Body: messageEditRequest{}, Result: models.SentMessage{}, Errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict}},
+        {Method: "DELETE", Path: "/api/v1/messages/{id}", ID: "DeleteMessage", Tag: "messages", Summary: "Delete a message for yourself, or one you sent for everyone",
+                Auth: true, Query: deleteMessageParams, Result: successResponse{}, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/messages/{id}/edits", ID: "GetMessageEdits", Tag: "messages", Summary: "List the earlier versions of an edited message",
+                Auth: true, Result: []models.MessageEdit{}, Errors: []int{http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/conversations", ID: "GetConversations", Tag: "messages", Summary: "List your conversations, most recently active first",
+                Auth: true, Query: conversationsParams, Result: []models.Conversation{}},
+        {Method: "GET", Path: "/api/v1/conversations/{userId}", ID: "GetConversation", Tag: "messages", Summary: "Get all your messages with a user, across listings, and mark them read",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+        {Method: "GET", Path: "/api/v1/conversations/{id}/messages", ID: "GetConversationMessages", Tag: "messages", Summary: "Get one of your conversations with its messages and mark it read",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/conversations/{id}/read", ID: "MarkConversationRead", Tag: "messages", Summary: "Mark every message of a conversation read",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/conversations/{id}/archive", ID: "ArchiveConversation", Tag: "messages", Summary: "Archive a conversation until a new message arrives",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+        {Method: "DELETE", Path: "/api/v1/conversations/{id}/archive", ID: "UnarchiveConversation", Tag: "messages", Summary: "Bring a conversation back from the archive",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/conversations/{id}/mute", ID: "MuteConversation", Tag: "messages", Summary: "Keep a conversation archived when new messages arrive",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+        {Method: "DELETE", Path: "/api/v1/conversations/{id}/mute", ID: "UnmuteConversation", Tag: "messages", Summary: "Let new messages bring a conversation back from the archive",
+                Auth: true, Result: models.Conversation{}, Errors: []int{http.StatusNotFound}},
+
+        // Favorites
+        {Method: "POST", Path: "/api/v1/favorites", ID: "ToggleFavorite", Tag: "favorites", Summary: "Add or remove a favorite",
+                Auth: true, Body: favoriteRequest{}, Result: successResponse{}},
+        {Method: "GET", Path: "/api/v1/favorites", ID: "GetFavorites", Tag: "favorites", Summary: "List your favorite listings",
+                Auth: true, Result: []models.ListingWithUser{}},
+
+        // Feed
+        {Method: "GET", Path: "/api/v1/feed", ID: "GetFeed", Tag: "feed", Summary: "Get your feed of new listings by users you follow and of plant types you favorited, newest first",
+                Auth: true, Query: feedParams, Result: models.FeedPage{}, Errors: []int{http.StatusBadRequest}},
+
+        // Account
+        {Method: "POST", Path: "/api/v1/account/export", ID: "RequestDataExport", Tag: "account", Summary: "Request a zip of your personal data",
+                Auth: true, Status: http.StatusAccepted, Result: models.DataExport{}},
+        {Method: "GET", Path: "/api/v1/account/exports", ID: "GetDataExports", Tag: "account", Summary: "List your data exports",
+                Auth: true, Result: []models.DataExport{}},
+        {Method: "GET", Path: "/api/v1/account/exports/{id}/download", ID: "DownloadDataExport", Tag: "account", Summary: "Download a ready data export",
+                Auth: true, Content: openapi.ContentTypes{Response: "application/zip"},
+                Errors: []int{http.StatusNotFound, http.StatusConflict}},
+        {Method: "GET", Path: "/api/v1/account/deletion", ID: "GetAccountDeletion", Tag: "account", Summary: "Get when your account is scheduled to be deleted",
+                Auth: true, Result: models.AccountDeletion{}, Errors: []int{http.StatusNotFound}},
+        {Method: "POST", Path: "/api/v1/account/deletion", ID: "DeleteAccount", Tag: "account", Summary: "Schedule your account for deletion and log out",
+                Auth: true, Body: passwordRequest{}, Result: models.AccountDeletion{}, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+        {Method: "DELETE", Path: "/api/v1/account/deletion", ID: "CancelAccountDeletion", Tag: "account", Summary: "Cancel a scheduled account deletion",
+                Auth: true, Result: successResponse{}, Errors: []int{http.StatusNotFound}},
+
+        // Notifications
+        {Method: "GET", Path: "/api/v1/notifications", ID: "GetNotifications", Tag: "notifications", Summary: "List your notifications, newest first",
+                Auth: true, Result: []models.Notification{}},
+        {Method: "POST", Path: "/api/v1/notifications/{id}/read", ID: "MarkNotificationRead", Tag: "notifications", Summary: "Mark one of your notifications as read",
+                Auth: true, Result: successResponse{}, Errors: []int{http.StatusNotFound}},
+}
+
+var (
+        specOnce sync.Once
+        specJSON []byte
+)
+
+// OpenAPISpec builds the OpenAPI document for APIRoutes and their unversioned aliases
+func OpenAPISpec() *openapi.Document {
+        return openapi.Build(apiInfo, errorEnvelope{}, withUnversionedAliases(APIRoutes))
+}
+
+// withUnversionedAliases adds a deprecated /api route for every route of the
+// version the unversioned aliases serve
+func withUnversionedAliases(routes []openapi.Route) []openapi.Route {
+        prefix := "/api/" + UnversionedAPIVersion + "/"
+        all := append([]openapi.Route{}, routes...)
+        for _, route := range routes {
+                if !strings.HasPrefix(route.Path, prefix) {
+                        continue
+                }
+                alias := route
+                alias.Path = "/api/" + strings.TrimPrefix(route.Path, prefix)
+                alias.ID = route.ID + "Unversioned"
+                alias.Summary = route.Summary + " (deprecated, use " + route.Path + ")"
+                alias.Deprecated = true
+                all = append(all, alias)
+        }
+        return all
+}
+
+// OpenAPI serves the OpenAPI document
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+        // The document never changes while the server runs, so encode it once
+        specOnce.Do(func() {
+                specJSON, _ = json.MarshalIndent(OpenAPISpec(), "", "  ")
+        })
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(specJSON)
+}
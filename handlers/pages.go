@@ -0,0 +1,595 @@
+package handlers
+
+import (
+        "bytes"
+        "fmt"
+        "html/template"
+        "net/http"
+        "net/url"
+        "path/filepath"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/utils"
+)
+
+// Page templates live in the template directory. Each *.html page there is parsed
+// together with layouts/*.html and partials/*.html and rendered through the "base"
+// layout, which shows the "content", "scripts" and optional "head" blocks the page
+// defines. The pages work without JavaScript; the scripts in static/js enhance them.
+
+// Default images, matching those used by static/js
+const (
+        defaultListingImage = "https://images.unsplash.com/photo-1492282442770-077ea21f0f81"
+        defaultAvatar       = "https://images.unsplash.com/photo-1438109382753-8368e7e1e7cf"
+)
+
+// similarListingsLimit is how many similar listings a listing page shows
+const similarListingsLimit = 4
+
+// pageTemplates holds the parsed templates, keyed by page file name
+var pageTemplates = struct {
+        sync.RWMutex
+        dir       string
+        reload    bool
+        templates map[string]*template.Template
+}{}
+
+// page is the data every page template receives
+type page struct {
+        Title       string               // shown before the site name; empty on the home page
+        Description string               // meta description
+        Nav         string               // navigation link to highlight
+        User        *models.UserResponse // logged-in user, or nil
+        Data        interface{}          // page-specific content
+
+        // Link preview metadata for OpenGraph and Twitter cards. renderPage makes
+        // URL and Image absolute, defaulting to the request path and site image.
+        URL    string
+        Image  string
+        OGType string // website if empty
+}
+
+// indexPage is the content of the home page
+type indexPage struct {
+        Query      string
+        Types      []filterOption
+        PlantTypes []filterOption
+        Locations  []filterOption
+        Listings   []models.ListingWithUser
+}
+
+// filterOption is a choice in one of the home page's listing filters
+type filterOption struct {
+        Value    string
+        Label    string
+        Selected bool
+}
+
+// listingPage is the content of a listing page
+type listingPage struct {
+        Listing models.ListingWithUser
+        Similar []models.ListingWithUser
+}
+
+// profilePage is the content of a profile page
+type profilePage struct {
+        Profile   models.UserResponse
+        Listings  []models.ListingWithUser
+        Public    bool              // a public profile at /u/{username}, rather than the user's own page
+        Stats     *models.UserStats // only shown on public profiles
+        CanFollow bool              // the viewer is logged in and not the profile's user
+        Following bool              // the viewer follows the profile's user
+        PrevPage  string            // links to the neighbouring pages of listings, if any
+        NextPage  string
+}
+
+// errorPage is the content of an error page
+type errorPage struct {
+        Heading string
+        Message string
+}
+
+// Choices offered by the home page filters, as value and label
+var (
+        listingTypeChoices = [][2]string{{"plant", "Plant"}, {"seed", "Seed"}, {"cutting", "Cutting"}}
+        plantTypeChoices   = [][2]string{
+                {"indoor", "Indoor"}, {"outdoor", "Outdoor"}, {"succulent", "Succulent"},
+                {"vegetable", "Vegetable"}, {"herb", "Herb"}, {"flower", "Flower"},
+        }
+        locationChoices = [][2]string{
+                {"Delhi", "Delhi, DL"}, {"Mumbai", "Mumbai, MH"}, {"Chennai", "Chennai, TN"}, {"Hyderabad", "Hyderabad, TG"},
+        }
+)
+
+// pageFuncs are the functions available in page templates
+var pageFuncs = template.FuncMap{
+        "price":        formatPrice,
+        "date":         formatDate,
+        "truncate":     truncate,
+        "listingImage": listingImage,
+        "avatar":       avatar,
+        "percent":      formatPercent,
+        "duration":     formatDuration,
+}
+
+// LoadPageTemplates parses the page templates in dir. With reload set, as in
+// development, they are parsed again for every request so that edits show up
+// without a restart.
+func LoadPageTemplates(dir string, reload bool) error {
+        templates, err := parsePageTemplates(dir)
+        if err != nil {
+                return err
+        }
+
+        pageTemplates.Lock()
+        defer pageTemplates.Unlock()
+        pageTemplates.dir = dir
+        pageTemplates.reload = reload
+        pageTemplates.templates = templates
+        return nil
+}
+
+// parsePageTemplates parses every page in dir with the shared layouts and partials
+func parsePageTemplates(dir string) (map[string]*template.Template, error) {
+        layouts, err := filepath.Glob(filepath.Join(dir, "layouts", "*.html"))
+        if err != nil {
+                return nil, err
+        }
+        partials, err := filepath.Glob(filepath.Join(dir, "partials", "*.html"))
+        if err != nil {
+                return nil, err
+        }
+        pages, err := filepath.Glob(filepath.Join(dir, "*.html"))
+        if err != nil {
+                return nil, err
+        }
+        if len(layouts) == 0 || len(pages) == 0 {
+                return nil, fmt.Errorf("no page templates found in %s", dir)
+        }
+
+        shared := append(layouts, partials...)
+        templates := make(map[string]*template.Template, len(pages))
+        for _, page := range pages {
+                name := filepath.Base(page)
+                tmpl, err := template.New(name).Funcs(pageFuncs).ParseFiles(append(shared, page)...)
+                if err != nil {
+                        return nil, fmt.Errorf("cannot parse page template %s: %w", name, err)
+                }
+                templates[name] = tmpl
+        }
+        return templates, nil
+}
+
+// lookupPageTemplate returns the template of a page, parsing it again in reload mode
+func lookupPageTemplate(name string) (*template.Template, error) {
+        pageTemplates.RLock()
+        dir, reload, templates := pageTemplates.dir, pageTemplates.reload, pageTemplates.templates
+        pageTemplates.RUnlock()
+
+        if reload {
+                var err error
+                if templates, err = parsePageTemplates(dir); err != nil {
+                        return nil, err
+                }
+        }
+        tmpl, ok := templates[name]
+        if !ok {
+                return nil, fmt.Errorf("page template %s not found", name)
+        }
+        return tmpl, nil
+}
+
+// renderPage renders a page through the base layout. The page is rendered to a
+// buffer first so that a template error becomes a clean 500 response.
+func renderPage(w http.ResponseWriter, r *http.Request, name string, status int, data page) {
+        if data.User == nil {
+                data.User = currentUser(r)
+        }
+        if data.URL == "" {
+                data.URL = r.URL.Path
+        }
+        data.URL = absoluteURL(r, data.URL)
+        data.Image = shareableImage(r, data.Image)
+        if data.OGType == "" {
+                data.OGType = "website"
+        }
+
+        var body bytes.Buffer
+        tmpl, err := lookupPageTemplate(name)
+        if err == nil {
+                err = tmpl.ExecuteTemplate(&body, "base", data)
+        }
+        if err != nil {
+                utils.Logger(r.Context()).Error("Cannot render page", "page", name, "error", err)
+                http.Error(w, "Internal server error", http.StatusInternalServerError)
+                return
+        }
+
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.WriteHeader(status)
+        body.WriteTo(w)
+}
+
+// renderErrorPage renders an error returned while loading a page's content
+func renderErrorPage(w http.ResponseWriter, r *http.Request, err error) {
+        status := errorStatus(err)
+        if status == http.StatusNotFound {
+                NotFoundPage(w, r)
+                return
+        }
+
+        logError(r, status, err)
+        content := errorPage{
+                Heading: "Something went wrong",
+                Message: "We couldn't load this page. Please try again in a moment.",
+        }
+        renderPage(w, r, "error.html", status, page{Title: content.Heading, Data: content})
+}
+
+// currentUser returns the logged-in user, or nil
+func currentUser(r *http.Request) *models.UserResponse {
+        session, _ := utils.SessionStore.Get(r, "session")
+        userID, ok := session.Values["userID"].(string)
+        if !ok || userID == "" {
+                return nil
+        }
+
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                return nil
+        }
+        userResponse := user.ToUserResponse()
+        return &userResponse
+}
+
+// IndexPage renders the home page with the listings matching the search form
+func IndexPage(w http.ResponseWriter, r *http.Request) {
+        // Get search and filters from the query string
+        queryParams := r.URL.Query()
+        query := strings.TrimSpace(queryParams.Get("q"))
+        filter := listingFilter{
+                Type:      queryParams.Get("type"),
+                PlantType: queryParams.Get("plantType"),
+                Location:  queryParams.Get("location"),
+        }
+
+        // Find listings; a search is narrowed by the filters too
+        var listings []models.ListingWithUser
+        var err error
+        if query != "" {
+                var results []models.ListingWithUser
+                results, err = matchingListings(r.Context(), query)
+                for _, listing := range results {
+                        if filter.matches(listing.Listing) {
+                                listings = append(listings, listing)
+                        }
+                }
+        } else {
+                listings, err = publishedListings(r.Context(), filter)
+        }
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+
+        renderPage(w, r, "index.html", http.StatusOK, page{
+                Description: "Buy, sell, and trade plants, seeds, and cuttings with enthusiasts near you.",
+                Nav:         "home",
+                Data: indexPage{
+                        Query:      query,
+                        Types:      filterOptions(listingTypeChoices, filter.Type),
+                        PlantTypes: filterOptions(plantTypeChoices, filter.PlantType),
+                        Locations:  filterOptions(locationChoices, filter.Location),
+                        Listings:   listings,
+                },
+        })
+}
+
+// filterOptions lists a filter's choices, marking the selected one
+func filterOptions(choices [][2]string, selected string) []filterOption {
+        options := make([]filterOption, len(choices))
+        for i, choice := range choices {
+                options[i] = filterOption{Value: choice[0], Label: choice[1], Selected: choice[0] == selected}
+        }
+        return options
+}
+
+// ListingPage renders a listing with its seller, care sheet and similar listings
+func ListingPage(w http.ResponseWriter, r *http.Request) {
+        // Get current user, if any
+        user := currentUser(r)
+        currentUserID := ""
+        if user != nil {
+                currentUserID = user.ID
+        }
+
+        // Find listing; drafts are only visible to their owner
+        listing, err := visibleListing(r.Context(), mux.Vars(r)["id"], currentUserID, "")
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+
+        // Find a few other listings of the same plant type
+        sameType, err := publishedListings(r.Context(), listingFilter{PlantType: listing.PlantType})
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+        var similar []models.ListingWithUser
+        for _, other := range sameType {
+                if other.ID != listing.ID && len(similar) < similarListingsLimit {
+                        similar = append(similar, other)
+                }
+        }
+
+        renderPage(w, r, "listing.html", http.StatusOK, page{
+                Title:       listing.Title,
+                Description: truncate(160, listing.Description),
+                User:        user,
+                Data:        listingPage{Listing: listing, Similar: similar},
+                URL:         "/listing/" + listing.ID,
+                Image:       string(listingImage(listing.Images)),
+                OGType:      "product",
+        })
+}
+
+// ProfilePage renders the logged-in user's profile and listings
+func ProfilePage(w http.ResponseWriter, r *http.Request) {
+        // Profiles of other users are public; this page is the user's own
+        user := currentUser(r)
+        if user == nil {
+                http.Redirect(w, r, "/login", http.StatusFound)
+                return
+        }
+
+        // Get the user's published listings
+        listings, err := publishedListings(r.Context(), listingFilter{UserID: user.ID})
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+
+        renderPage(w, r, "profile.html", http.StatusOK, page{
+                Title: "User Profile",
+                User:  user,
+                Data:  profilePage{Profile: *user, Listings: listings},
+        })
+}
+
+// PublicProfilePage renders a user's public profile with their trading stats and a
+// page of their active listings
+func PublicProfilePage(w http.ResponseWriter, r *http.Request) {
+        // Read the requested page of listings
+        pagination, err := readPagination(r)
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+
+        // Find user
+        user, err := utils.GetUserByUsername(r.Context(), mux.Vars(r)["username"])
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+
+        // Get the page of listings and the user's stats
+        profile, err := userListingsPage(r.Context(), user, pagination)
+        if err != nil {
+                renderErrorPage(w, r, err)
+                return
+        }
+        listings := make([]models.ListingWithUser, len(profile.Listings))
+        for i, listing := range profile.Listings {
+                listings[i] = models.ListingWithUser{Listing: listing, User: profile.User}
+        }
+
+        // Logged-in visitors can follow the user
+        viewer := currentUser(r)
+        content := profilePage{Profile: profile.User, Listings: listings, Public: true, Stats: &profile.Stats}
+        if viewer != nil && viewer.ID != user.ID {
+                content.CanFollow = true
+                content.Following, err = utils.IsFollowing(r.Context(), viewer.ID, user.ID)
+                if err != nil {
+                        renderErrorPage(w, r, err)
+                        return
+                }
+        }
+
+        // Link the neighbouring pages, keeping the page size
+        path := "/u/" + url.PathEscape(user.Username)
+        if pagination.Page > 1 {
+                content.PrevPage = profilePageURL(path, pagination.Page-1, r.URL.Query().Get("limit"))
+        }
+        if profile.HasNext() {
+                content.NextPage = profilePageURL(path, pagination.Page+1, r.URL.Query().Get("limit"))
+        }
+
+        name := profile.User.Name
+        if name == "" {
+                name = profile.User.Username
+        }
+        description := profile.User.Bio
+        if description == "" {
+                description = fmt.Sprintf("Plants, seeds and cuttings from %s on Leaf Connect.", name)
+        }
+
+        renderPage(w, r, "profile.html", http.StatusOK, page{
+                Title:       name,
+                Description: truncate(160, description),
+                User:        viewer,
+                Data:        content,
+                URL:         path,
+                Image:       string(avatar(profile.User.ProfilePic)),
+                OGType:      "profile",
+        })
+}
+
+// profilePageURL links a page of a public profile's listings
+func profilePageURL(path string, number int, limit string) string {
+        query := url.Values{}
+        if number > 1 {
+                query.Set("page", strconv.Itoa(number))
+        }
+        if limit != "" {
+                query.Set("limit", limit)
+        }
+        if len(query) == 0 {
+                return path
+        }
+        return path + "?" + query.Encode()
+}
+
+// StaticPage renders a page whose content is loaded by its scripts
+func StaticPage(name, title string) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                renderPage(w, r, name, http.StatusOK, page{Title: title, Nav: strings.TrimSuffix(name, ".html")})
+        }
+}
+
+// MemberPage is a StaticPage for logged-in users; others are sent to the login page
+func MemberPage(name, title string) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                user := currentUser(r)
+                if user == nil {
+                        http.Redirect(w, r, "/login", http.StatusFound)
+                        return
+                }
+                renderPage(w, r, name, http.StatusOK, page{Title: title, Nav: strings.TrimSuffix(name, ".html"), User: user})
+        }
+}
+
+// NotFoundPage renders the 404 page for paths outside the API
+func NotFoundPage(w http.ResponseWriter, r *http.Request) {
+        content := errorPage{
+                Heading: "Page not found",
+                Message: "The page you were looking for doesn't exist. The listing may have been sold, traded or removed.",
+        }
+        renderPage(w, r, "error.html", http.StatusNotFound, page{Title: content.Heading, Data: content})
+}
+
+// absoluteURL turns a path into a URL on the public site address, or on the
+// request's host if none is configured
+func absoluteURL(r *http.Request, path string) string {
+        base := utils.PublicURL()
+        if base == "" {
+                scheme := "http"
+                if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+                        scheme = "https"
+                }
+                base = scheme + "://" + r.Host
+        }
+        return base + path
+}
+
+// shareableImage returns an absolute URL for a preview image. Images uploaded as
+// data URLs cannot be fetched by link preview crawlers, so they fall back to the
+// default image.
+func shareableImage(r *http.Request, image string) string {
+        switch {
+        case strings.HasPrefix(image, "https://") || strings.HasPrefix(image, "http://"):
+                return image
+        case strings.HasPrefix(image, "/"):
+                return absoluteURL(r, image)
+        }
+        return defaultListingImage
+}
+
+// formatPrice formats an amount in rupees with Indian digit grouping, e.g. ₹1,23,456.00,
+// as Intl.NumberFormat does for en-IN in static/js
+func formatPrice(amount float64) string {
+        sign := ""
+        if amount < 0 {
+                sign = "-"
+                amount = -amount
+        }
+        text := fmt.Sprintf("%.2f", amount)
+        whole, fraction := text[:len(text)-3], text[len(text)-2:]
+
+        // The last three digits form a group, then every two before them
+        grouped := whole
+        if len(whole) > 3 {
+                head, tail := whole[:len(whole)-3], whole[len(whole)-3:]
+                var groups []string
+                for len(head) > 2 {
+                        groups = append([]string{head[len(head)-2:]}, groups...)
+                        head = head[:len(head)-2]
+                }
+                groups = append([]string{head}, groups...)
+                grouped = strings.Join(groups, ",") + "," + tail
+        }
+        return sign + "₹" + grouped + "." + fraction
+}
+
+// formatDate formats a date like Jan 2, 2006
+func formatDate(t time.Time) string {
+        return t.Format("Jan 2, 2006")
+}
+
+// formatPercent formats a share between 0 and 1 as a whole percentage, e.g. 85%
+func formatPercent(share float64) string {
+        return fmt.Sprintf("%.0f%%", share*100)
+}
+
+// formatDuration describes a number of seconds roughly, e.g. 3 hours
+func formatDuration(seconds int64) string {
+        plural := func(n int64, unit string) string {
+                if n == 1 {
+                        return "1 " + unit
+                }
+                return fmt.Sprintf("%d %ss", n, unit)
+        }
+
+        duration := time.Duration(seconds) * time.Second
+        switch {
+        case duration < time.Minute:
+                return "under a minute"
+        case duration < time.Hour:
+                return plural(int64(duration/time.Minute), "minute")
+        case duration < 48*time.Hour:
+                return plural(int64(duration/time.Hour), "hour")
+        }
+        return plural(int64(duration/(24*time.Hour)), "day")
+}
+
+// truncate shortens text to at most n characters, adding "..." if anything was cut
+func truncate(n int, text string) string {
+        runes := []rune(text)
+        if len(runes) <= n {
+                return text
+        }
+        return string(runes[:n]) + "..."
+}
+
+// listingImage returns a listing's first image, or a placeholder
+func listingImage(images []string) template.URL {
+        if len(images) > 0 {
+                return imageSrc(images[0], defaultListingImage)
+        }
+        return defaultListingImage
+}
+
+// avatar returns a profile picture, or a placeholder
+func avatar(profilePic string) template.URL {
+        return imageSrc(profilePic, defaultAvatar)
+}
+
+// imageSrc marks an image URL as safe for an img src, or returns the fallback if it
+// is not an image. html/template would otherwise replace the data URLs that uploaded
+// images are stored as with #ZgotmplZ.
+func imageSrc(image string, fallback template.URL) template.URL {
+        switch {
+        case strings.HasPrefix(image, "data:image/"),
+                strings.HasPrefix(image, "https://") || strings.HasPrefix(image, "http://"),
+                strings.HasPrefix(image, "/") && !strings.HasPrefix(image, "//"):
+                return template.URL(image)
+        }
+        return fallback
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+        "fmt"
+        "net/http"
+        "strconv"
+
+        "github.com/plantexchange/app/models"
+        "github.com/plantexchange/app/openapi"
+        "github.com/plantexchange/app/utils"
+)
+
+// Page sizes of paginated lists
+const (
+        defaultPageSize = 20
+        maxPageSize     = 100
+)
+
+// limitParam documents the page size read by readPagination and readLimit
+var limitParam = openapi.Param{
+        Name: "limit", Type: "integer",
+        Description: fmt.Sprintf("Items per page, at most %d; %d if omitted", maxPageSize, defaultPageSize),
+}
+
+// paginationParams documents the query parameters read by readPagination
+var paginationParams = []openapi.Param{
+        {Name: "page", Type: "integer", Description: "Page number, starting at 1"},
+        limitParam,
+}
+
+// readPagination reads the page and limit query parameters, defaulting to the first page
+func readPagination(r *http.Request) (models.Pagination, error) {
+        queryParams := r.URL.Query()
+
+        v := utils.NewValidator()
+        page := models.Pagination{Page: 1, Limit: pageSize(v, queryParams.Get("limit"))}
+        if value := queryParams.Get("page"); value != "" {
+                parsed, err := strconv.Atoi(value)
+                v.Check(err == nil && parsed >= 1, "page", "must be a positive integer")
+                page.Page = parsed
+        }
+
+        return page, v.Err()
+}
+
+// readLimit reads the limit query parameter of a list paged by cursor
+func readLimit(r *http.Request) (int, error) {
+        v := utils.NewValidator()
+        limit := pageSize(v, r.URL.Query().Get("limit"))
+        return limit, v.Err()
+}
+
+// pageSize parses a limit, or returns the default page size if it is empty
+func pageSize(v *utils.Validator, value string) int {
+        if value == "" {
+                return defaultPageSize
+        }
+        parsed, err := strconv.Atoi(value)
+        v.Check(err == nil && parsed >= 1 && parsed <= maxPageSize, "limit", fmt.Sprintf("must be between 1 and %d", maxPageSize))
+        return parsed
+}
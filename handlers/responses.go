@@ -0,0 +1,6 @@
+package handlers
+
+// successResponse is the body of actions that return no resource, such as logging out
+type successResponse struct {
+        Success bool `json:"success"`
+}
@@ -1,13 +1,38 @@
 package handlers
 
 import (
-        "encoding/json"
+        "context"
         "net/http"
 
         "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/models"
         "github.com/plantexchange/app/utils"
 )
 
+// profileUpdateRequest is the body of a profile update; omitted fields are left unchanged
+type profileUpdateRequest struct {
+        Name       *string `json:"name"`
+        Location   *string `json:"location"`
+        Bio        *string `json:"bio"`
+        ProfilePic *string `json:"profilePic"`
+}
+
+// Validate checks the provided fields against the profile rules
+func (req profileUpdateRequest) Validate(v *utils.Validator) {
+        var user models.User
+        if req.Name != nil {
+                user.Name = *req.Name
+        }
+        if req.Location != nil {
+                user.Location = *req.Location
+        }
+        if req.Bio != nil {
+                user.Bio = *req.Bio
+        }
+        utils.ValidateProfile(v, user)
+}
+
 // GetUser gets a user by ID
 func GetUser(w http.ResponseWriter, r *http.Request) {
         // Get user ID from URL path
@@ -15,16 +40,16 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
         userID := vars["id"]
 
         // Find user
-        user, exists := utils.GetUser(userID)
-        if !exists {
-                http.Error(w, "User not found", http.StatusNotFound)
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
         // Return user info (without sensitive data)
         userResponse := user.ToUserResponse()
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(userResponse)
+        writeJSON(w, r, userResponse)
 }
 
 // UpdateUser updates a user's profile
@@ -35,7 +60,7 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
         // Check if userID exists in session
         currentUserID, ok := session.Values["userID"].(string)
         if !ok {
-                http.Error(w, "Not authenticated", http.StatusUnauthorized)
+                httpError(w, r, "Not authenticated", http.StatusUnauthorized)
                 return
         }
 
@@ -45,27 +70,20 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 
         // Check if user is updating their own profile
         if currentUserID != userID {
-                http.Error(w, "Unauthorized", http.StatusForbidden)
+                httpError(w, r, "Unauthorized", http.StatusForbidden)
                 return
         }
 
         // Find user
-        user, exists := utils.GetUser(userID)
-        if !exists {
-                http.Error(w, "User not found", http.StatusNotFound)
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
         // Parse request
-        var updates struct {
-                Name       *string `json:"name"`
-                Location   *string `json:"location"`
-                Bio        *string `json:"bio"`
-                ProfilePic *string `json:"profilePic"`
-        }
-        
-        if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-                http.Error(w, "Invalid request body", http.StatusBadRequest)
+        var updates profileUpdateRequest
+        if !decodeJSON(w, r, &updates, maxProfileBodySize) {
                 return
         }
 
@@ -84,31 +102,70 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
         }
 
         // Save updated user
-        utils.SaveUser(user)
+        if _, err := utils.SaveUser(r.Context(), user); err != nil {
+                writeError(w, r, err)
+                return
+        }
 
         // Return updated user info
         userResponse := user.ToUserResponse()
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(userResponse)
+        writeJSON(w, r, userResponse)
 }
 
-// GetUserListings gets listings by a user
+// GetUserListings gets a page of a user's active listings with their public profile and trading stats
 func GetUserListings(w http.ResponseWriter, r *http.Request) {
         // Get user ID from URL path
         vars := mux.Vars(r)
         userID := vars["id"]
 
+        // Read the requested page
+        page, err := readPagination(r)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
+
         // Find user
-        _, exists := utils.GetUser(userID)
-        if !exists {
-                http.Error(w, "User not found", http.StatusNotFound)
+        user, err := utils.GetUser(r.Context(), userID)
+        if err != nil {
+                writeError(w, r, err)
                 return
         }
 
-        // Get listings by this user
-        listings := utils.GetListingsByUser(userID)
+        // Get the page of listings and the user's stats
+        profile, err := userListingsPage(r.Context(), user, page)
+        if err != nil {
+                writeError(w, r, err)
+                return
+        }
 
-        // Return listings
+        // Return profile with listings
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(listings)
+        writeJSON(w, r, profile)
+}
+
+// userListingsPage loads a page of a user's active listings with their public
+// profile and stats. Purged accounts have no public profile.
+func userListingsPage(ctx context.Context, user models.User, page models.Pagination) (models.UserListingsPage, error) {
+        if user.IsDeleted() {
+                return models.UserListingsPage{}, utils.NotFoundError("User not found")
+        }
+
+        listings, err := utils.GetActiveListingsByUser(ctx, user.ID, &page)
+        if err != nil {
+                return models.UserListingsPage{}, err
+        }
+
+        stats, err := utils.GetUserStats(ctx, user.ID)
+        if err != nil {
+                return models.UserListingsPage{}, err
+        }
+
+        return models.UserListingsPage{
+                User:       user.ToUserResponse(),
+                Stats:      stats,
+                Listings:   listings,
+                Pagination: page,
+        }, nil
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+        "context"
+        "encoding/json"
+        "net/http"
+        "reflect"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/gorilla/mux"
+
+        "github.com/plantexchange/app/utils"
+)
+
+// API versions. Every version serves the same handlers under /api/<version>; they
+// differ only in how responses are serialized.
+const (
+        apiV1 = "v1"
+)
+
+// APIVersions lists the supported API versions, oldest first
+var APIVersions = []string{apiV1}
+
+// UnversionedAPIVersion is the version the deprecated unversioned /api routes serve
+const UnversionedAPIVersion = apiV1
+
+// unversionedDeprecatedAt is when the unversioned routes were deprecated, sent in
+// their Deprecation header
+var unversionedDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// apiVersionKey is the context key of the request's API version
+type apiVersionKey struct{}
+
+// serializers converts a response value to the shape an API version promised,
+// keyed by version and the value's type. Types without an entry are encoded as
+// they are. Before changing the JSON shape of a model such as
+// models.ListingWithUser, register a serializer producing the old shape for every
+// version that has already shipped, then add a new version for the new shape.
+//
+//	serializers[apiV1][reflect.TypeOf(models.ListingWithUser{})] = func(value interface{}) interface{} {
+//	        return listingWithUserV1(value.(models.ListingWithUser))
+//	}
+//
+// Serializers apply to a response value and to the elements of a response slice,
+// not to values nested inside other types.
+var serializers = map[string]map[reflect.Type]func(interface{}) interface{}{
+        apiV1: {},
+}
+
+// WithAPIVersion tags requests with the API version of the router they matched
+func WithAPIVersion(version string) mux.MiddlewareFunc {
+        return func(next http.Handler) http.Handler {
+                return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                        ctx := context.WithValue(r.Context(), apiVersionKey{}, version)
+                        next.ServeHTTP(w, r.WithContext(ctx))
+                })
+        }
+}
+
+// DeprecatedAlias marks responses from the unversioned /api routes as deprecated
+// with Deprecation and Sunset headers (RFC 9745 and RFC 8594) and links to the
+// same route under /api/<version>. Use is counted by route so we know when the
+// aliases can be removed.
+func DeprecatedAlias(version string, sunset time.Time) mux.MiddlewareFunc {
+        return func(next http.Handler) http.Handler {
+                return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                        successor := "/api/" + version + strings.TrimPrefix(r.URL.Path, "/api")
+                        w.Header().Set("Deprecation", "@"+strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10))
+                        w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+                        w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+
+                        route := "unmatched"
+                        if current := mux.CurrentRoute(r); current != nil {
+                                if template, err := current.GetPathTemplate(); err == nil {
+                                        route = template
+                                }
+                        }
+                        utils.DeprecatedAPIRequests.Inc(route)
+
+                        next.ServeHTTP(w, r)
+                })
+        }
+}
+
+// requestAPIVersion returns the API version of a request; requests outside the
+// versioned routers get the oldest version
+func requestAPIVersion(r *http.Request) string {
+        if version, ok := r.Context().Value(apiVersionKey{}).(string); ok {
+                return version
+        }
+        return APIVersions[0]
+}
+
+// writeJSON encodes a response body in the shape promised by the request's API version
+func writeJSON(w http.ResponseWriter, r *http.Request, value interface{}) {
+        json.NewEncoder(w).Encode(serialize(requestAPIVersion(r), value))
+}
+
+// serialize applies the version's serializer to a value, or to each element of a slice
+func serialize(version string, value interface{}) interface{} {
+        convert := serializers[version]
+        if len(convert) == 0 || value == nil {
+                return value
+        }
+
+        v := reflect.ValueOf(value)
+        if fn, ok := convert[v.Type()]; ok {
+                return fn(value)
+        }
+        if v.Kind() == reflect.Slice {
+                if fn, ok := convert[v.Type().Elem()]; ok {
+                        converted := make([]interface{}, v.Len())
+                        for i := range converted {
+                                converted[i] = fn(v.Index(i).Interface())
+                        }
+                        return converted
+                }
+        }
+        return value
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"log/slog"
 	"net/http"
-	"path/filepath"
-
-	// "time"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	"github.com/rs/cors"
 
 	"github.com/joho/godotenv"
+	"github.com/plantexchange/app/config"
 	"github.com/plantexchange/app/handlers"
 
 	// "github.com/plantexchange/app/models"
@@ -23,88 +28,235 @@ func init() {
 	// Load .env file
 	err := godotenv.Load()
 	if err != nil {
-		log.Println("Warning: .env file not found. Using environment variables.")
+		slog.Warn(".env file not found, using environment variables")
 	}
 }
 func main() {
+	// Run a command-line subcommand if one was given; other arguments are server flags
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Load configuration
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("Cannot load configuration", "error", err)
+		os.Exit(1)
+	}
+	utils.Configure(cfg)
+
+	// Parse page templates; in development they are reloaded on every request
+	if err := handlers.LoadPageTemplates(cfg.Server.TemplateDir, !cfg.IsProduction()); err != nil {
+		slog.Error("Cannot load page templates", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize database
 	utils.InitDB()
-	defer utils.CloseDB()
+
+	// Start background jobs
+	jobs := append(utils.ListingExpiryJobs(), utils.ScheduledPublishJobs()...)
+	jobs = append(jobs, utils.AccountJobs()...)
+	jobs = append(jobs, utils.AttachmentJobs()...)
+	jobs = append(jobs, utils.MessageJobs()...)
+	worker := utils.NewWorker(utils.WorkerInterval(), jobs...)
+	worker.Start()
 
 	// Set up router
+	r := newRouter(cfg)
+
+	// CORS setup
+	corsMiddleware := cors.New(cors.Options{
+		AllowedOrigins:   cfg.Server.CORSOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Request-ID"},
+		ExposedHeaders:   []string{"X-Request-ID", "Deprecation", "Sunset", "Link"},
+		AllowCredentials: true,
+	})
+
+	// Start server
+	server := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           handlers.RequestID(corsMiddleware.Handler(r)),
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "addr", cfg.Addr(), "env", cfg.Env)
+		serverErrors <- server.ListenAndServe()
+	}()
+
+	// Serve until a shutdown signal arrives or the server fails
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		slog.Info("Shutting down", "signal", sig.String())
+	case err := <-serverErrors:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	}
+
+	// Stop accepting connections and let in-flight requests and background work finish
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Shutdown deadline passed, closing remaining connections", "error", err)
+		server.Close()
+	}
+	worker.Stop()
+	if err := utils.WaitForBackground(ctx); err != nil {
+		slog.Warn("Shutdown deadline passed with background work still running", "error", err)
+	}
+
+	utils.CloseDB()
+	slog.Info("Server stopped")
+	os.Exit(exitCode)
+}
+
+// pages are the routes for browsers, crawlers and feed readers, and their handlers
+var pages = []struct {
+	path    string
+	handler http.HandlerFunc
+}{
+	{"/", handlers.IndexPage},
+	{"/login", handlers.StaticPage("login.html", "Login")},
+	{"/register", handlers.StaticPage("register.html", "Register")},
+	{"/profile", handlers.ProfilePage},
+	{"/u/{username}", handlers.PublicProfilePage},
+	{"/dashboard", handlers.MemberPage("dashboard.html", "Dashboard")},
+	{"/messages", handlers.MemberPage("messages.html", "Messages")},
+	{"/create-listing", handlers.MemberPage("create-listing.html", "Create Listing")},
+	{"/edit-listing/{id}", handlers.MemberPage("create-listing.html", "Edit Listing")},
+	{"/listing/{id}", handlers.ListingPage},
+	{"/sitemap.xml", handlers.Sitemap},
+	{"/robots.txt", handlers.Robots},
+	{"/feeds/listings.atom", handlers.ListingsAtom},
+	{"/feeds/listings.rss", handlers.ListingsRSS},
+}
+
+// newRouter registers every route. Routes other than pages and static files must
+// also be described in handlers.APIRoutes; the openapi check command compares the two.
+// Each API route is registered under /api/<version> and as a deprecated /api alias.
+func newRouter(cfg *config.Config) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(handlers.Instrument, handlers.RevokedSessions)
+
+	// Health checks and metrics for the load balancer and monitoring
+	r.HandleFunc("/healthz", handlers.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", handlers.Readyz).Methods("GET")
+	r.HandleFunc("/metrics", handlers.Metrics).Methods("GET")
 
 	// Static files
-	fs := http.FileServer(http.Dir("./static"))
+	fs := http.FileServer(http.Dir(cfg.Server.StaticDir))
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
 
-	// API Routes
-	apiRouter := r.PathPrefix("/api").Subrouter()
+	// API routes, once per version under /api/<version>
+	for _, version := range handlers.APIVersions {
+		apiRouter := r.PathPrefix("/api/" + version).Subrouter()
+		apiRouter.Use(handlers.WithAPIVersion(version))
+		registerAPIRoutes(apiRouter)
+	}
+
+	// The unversioned /api routes are deprecated aliases kept for old mobile builds
+	legacyRouter := r.PathPrefix("/api").Subrouter()
+	legacyRouter.Use(handlers.WithAPIVersion(handlers.UnversionedAPIVersion),
+		handlers.DeprecatedAlias(handlers.UnversionedAPIVersion, cfg.API.SunsetTime()))
+	registerAPIRoutes(legacyRouter)
+
+	// HTML pages, the sitemap and feeds
+	for _, page := range pages {
+		r.HandleFunc(page.path, page.handler).Methods("GET")
+	}
+
+	// Anything else gets the 404 page
+	r.NotFoundHandler = handlers.Instrument(http.HandlerFunc(handlers.NotFoundPage))
+
+	return r
+}
+
+// registerAPIRoutes registers the API routes relative to a version's router
+func registerAPIRoutes(api *mux.Router) {
+	api.NotFoundHandler = handlers.Instrument(http.HandlerFunc(handlers.APINotFound))
+	api.MethodNotAllowedHandler = handlers.Instrument(http.HandlerFunc(handlers.APIMethodNotAllowed))
+
+	// API description
+	api.HandleFunc("/openapi.json", handlers.OpenAPI).Methods("GET")
 
 	// Auth routes
-	apiRouter.HandleFunc("/register", handlers.Register).Methods("POST")
-	apiRouter.HandleFunc("/login", handlers.Login).Methods("POST")
-	apiRouter.HandleFunc("/logout", handlers.Logout).Methods("POST")
-	apiRouter.HandleFunc("/check-auth", handlers.CheckAuth).Methods("GET")
+	api.HandleFunc("/register", handlers.Register).Methods("POST")
+	api.HandleFunc("/login", handlers.Login).Methods("POST")
+	api.HandleFunc("/logout", handlers.Logout).Methods("POST")
+	api.HandleFunc("/check-auth", handlers.CheckAuth).Methods("GET")
 
 	// User routes
-	apiRouter.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")
-	apiRouter.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
-	apiRouter.HandleFunc("/users/current", handlers.GetCurrentUser).Methods("GET")
+	api.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")
+	api.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
+	api.HandleFunc("/users/current", handlers.GetCurrentUser).Methods("GET")
+	api.HandleFunc("/users/{id}/listings", handlers.GetUserListings).Methods("GET")
+	api.HandleFunc("/users/{id}/follow", handlers.FollowUser).Methods("POST")
+	api.HandleFunc("/users/{id}/follow", handlers.UnfollowUser).Methods("DELETE")
 
 	// Listing routes
-	apiRouter.HandleFunc("/listings/search", handlers.SearchListings).Methods("GET")
-	apiRouter.HandleFunc("/listings", handlers.GetListings).Methods("GET")
-	apiRouter.HandleFunc("/listings", handlers.CreateListing).Methods("POST")
-	apiRouter.HandleFunc("/listings/{id}", handlers.GetListing).Methods("GET")
-	apiRouter.HandleFunc("/listings/{id}", handlers.UpdateListing).Methods("PUT")
-	apiRouter.HandleFunc("/listings/{id}", handlers.DeleteListing).Methods("DELETE")
+	api.HandleFunc("/listings/search", handlers.SearchListings).Methods("GET")
+	api.HandleFunc("/listings/archive", handlers.GetArchivedListings).Methods("GET")
+	api.HandleFunc("/listings/drafts", handlers.GetDraftListings).Methods("GET")
+	api.HandleFunc("/listings", handlers.GetListings).Methods("GET")
+	api.HandleFunc("/listings", handlers.CreateListing).Methods("POST")
+	api.HandleFunc("/listings/import", handlers.ImportListings).Methods("POST")
+	api.HandleFunc("/listings/{id}", handlers.GetListing).Methods("GET")
+	api.HandleFunc("/listings/{id}", handlers.UpdateListing).Methods("PUT")
+	api.HandleFunc("/listings/{id}", handlers.DeleteListing).Methods("DELETE")
+	api.HandleFunc("/listings/{id}/renew", handlers.RenewListing).Methods("POST")
+	api.HandleFunc("/listings/{id}/publish", handlers.PublishListing).Methods("POST")
+	api.HandleFunc("/listings/{id}/revisions", handlers.GetListingRevisions).Methods("GET")
+	api.HandleFunc("/listings/{id}/revisions/diff", handlers.GetListingRevisionDiff).Methods("GET")
+	api.HandleFunc("/care-sheets/defaults", handlers.GetCareSheetDefaults).Methods("GET")
 
 	// Message routes
-	apiRouter.HandleFunc("/messages", handlers.GetMessages).Methods("GET")
-	apiRouter.HandleFunc("/messages", handlers.SendMessage).Methods("POST")
-	apiRouter.HandleFunc("/messages/{id}", handlers.GetMessage).Methods("GET")
-	apiRouter.HandleFunc("/conversations", handlers.GetConversations).Methods("GET")
-	apiRouter.HandleFunc("/conversations/{userId}", handlers.GetConversation).Methods("GET")
+	api.HandleFunc("/messages", handlers.GetMessages).Methods("GET")
+	api.HandleFunc("/messages", handlers.SendMessage).Methods("POST")
+	api.HandleFunc("/messages/search", handlers.SearchMessages).Methods("GET")
+	api.HandleFunc("/messages/attachments", handlers.UploadMessageAttachment).Methods("POST")
+	api.HandleFunc("/messages/attachments/{id}", handlers.GetMessageAttachment).Methods("GET")
+	api.HandleFunc("/messages/attachments/{id}/thumbnail", handlers.GetMessageAttachmentThumbnail).Methods("GET")
+	api.HandleFunc("/messages/{id}", handlers.GetMessage).Methods("GET")
+	api.HandleFunc("/messages/{id}", handlers.EditMessage).Methods("PUT")
+	api.HandleFunc("/messages/{id}", handlers.DeleteMessage).Methods("DELETE")
+	api.HandleFunc("/messages/{id}/edits", handlers.GetMessageEdits).Methods("GET")
+	api.HandleFunc("/conversations", handlers.GetConversations).Methods("GET")
+	api.HandleFunc("/conversations/{userId}", handlers.GetConversation).Methods("GET")
+	api.HandleFunc("/conversations/{id}/messages", handlers.GetConversationMessages).Methods("GET")
+	api.HandleFunc("/conversations/{id}/read", handlers.MarkConversationRead).Methods("POST")
+	api.HandleFunc("/conversations/{id}/archive", handlers.ArchiveConversation).Methods("POST")
+	api.HandleFunc("/conversations/{id}/archive", handlers.UnarchiveConversation).Methods("DELETE")
+	api.HandleFunc("/conversations/{id}/mute", handlers.MuteConversation).Methods("POST")
+	api.HandleFunc("/conversations/{id}/mute", handlers.UnmuteConversation).Methods("DELETE")
 
 	// Favorites routes
-	apiRouter.HandleFunc("/favorites", handlers.ToggleFavorite).Methods("POST")
-	apiRouter.HandleFunc("/favorites", handlers.GetFavorites).Methods("GET")
-
-	// HTML routes - serve appropriate templates
-	r.HandleFunc("/", serveTemplate("index.html")).Methods("GET")
-	r.HandleFunc("/login", serveTemplate("login.html")).Methods("GET")
-	r.HandleFunc("/register", serveTemplate("register.html")).Methods("GET")
-	r.HandleFunc("/profile", serveTemplate("profile.html")).Methods("GET")
-	r.HandleFunc("/dashboard", serveTemplate("dashboard.html")).Methods("GET")
-	r.HandleFunc("/messages", serveTemplate("messages.html")).Methods("GET")
-	r.HandleFunc("/create-listing", serveTemplate("create-listing.html")).Methods("GET")
-	r.HandleFunc("/listing/{id}", serveTemplate("listing.html")).Methods("GET")
-
-	// Catch-all route to redirect to index
-	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/", http.StatusFound)
-	})
+	api.HandleFunc("/favorites", handlers.ToggleFavorite).Methods("POST")
+	api.HandleFunc("/favorites", handlers.GetFavorites).Methods("GET")
 
-	// CORS setup
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	})
+	// Feed routes
+	api.HandleFunc("/feed", handlers.GetFeed).Methods("GET")
 
-	// Start server
-	port := "8080"
-	log.Printf("Starting server on http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+port, corsMiddleware.Handler(r)))
-}
+	// Account routes
+	api.HandleFunc("/account/export", handlers.RequestDataExport).Methods("POST")
+	api.HandleFunc("/account/exports", handlers.GetDataExports).Methods("GET")
+	api.HandleFunc("/account/exports/{id}/download", handlers.DownloadDataExport).Methods("GET")
+	api.HandleFunc("/account/deletion", handlers.GetAccountDeletion).Methods("GET")
+	api.HandleFunc("/account/deletion", handlers.DeleteAccount).Methods("POST")
+	api.HandleFunc("/account/deletion", handlers.CancelAccountDeletion).Methods("DELETE")
 
-// serveTemplate serves HTML templates
-func serveTemplate(tmpl string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		path := filepath.Join("templates", tmpl)
-		http.ServeFile(w, r, path)
-	}
+	// Notification routes
+	api.HandleFunc("/notifications", handlers.GetNotifications).Methods("GET")
+	api.HandleFunc("/notifications/{id}/read", handlers.MarkNotificationRead).Methods("POST")
 }
-
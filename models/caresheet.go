@@ -0,0 +1,46 @@
+package models
+
+// PetToxicityLevels are the accepted values for CareSheet.PetToxicity
+var PetToxicityLevels = []string{"non-toxic", "mildly toxic", "toxic"}
+
+// CareSheet holds structured care information a seller can attach to a listing
+type CareSheet struct {
+	Species     string `json:"species"`
+	Light       string `json:"light"`
+	Water       string `json:"water"`
+	Humidity    string `json:"humidity"`
+	Temperature string `json:"temperature"`
+	PetToxicity string `json:"petToxicity"` // toxic, non-toxic, mildly toxic
+	Propagation string `json:"propagation"`
+}
+
+// IsEmpty reports whether no care fields have been filled in
+func (c CareSheet) IsEmpty() bool {
+	return c.Light == "" && c.Water == "" && c.Humidity == "" &&
+		c.Temperature == "" && c.PetToxicity == "" && c.Propagation == ""
+}
+
+// FillFrom copies any empty fields from defaults
+func (c *CareSheet) FillFrom(defaults CareSheet) {
+	if c.Species == "" {
+		c.Species = defaults.Species
+	}
+	if c.Light == "" {
+		c.Light = defaults.Light
+	}
+	if c.Water == "" {
+		c.Water = defaults.Water
+	}
+	if c.Humidity == "" {
+		c.Humidity = defaults.Humidity
+	}
+	if c.Temperature == "" {
+		c.Temperature = defaults.Temperature
+	}
+	if c.PetToxicity == "" {
+		c.PetToxicity = defaults.PetToxicity
+	}
+	if c.Propagation == "" {
+		c.Propagation = defaults.Propagation
+	}
+}
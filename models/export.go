@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Data export statuses
+const (
+	DataExportPending    = "pending"
+	DataExportProcessing = "processing"
+	DataExportReady      = "ready"
+	DataExportFailed     = "failed"
+)
+
+// DataExport is a user's request for a zip of all their personal data
+type DataExport struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	Status      string     `json:"status"` // pending, processing, ready, failed
+	Error       string     `json:"error,omitempty"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"` // The file is deleted after this
+}
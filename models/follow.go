@@ -0,0 +1,25 @@
+package models
+
+// FollowStatus reports whether the logged-in user follows another user
+type FollowStatus struct {
+	Following     bool `json:"following"`
+	FollowerCount int  `json:"followerCount"` // the other user's followers
+}
+
+// Reasons a listing is in a personalized feed
+const (
+	FeedReasonFollowing = "following" // listed by a followed user
+	FeedReasonPlantType = "plantType" // same plant type as one of the user's favorites
+)
+
+// FeedItem is a listing in a personalized feed
+type FeedItem struct {
+	ListingWithUser
+	Reason string `json:"reason"` // following or plantType
+}
+
+// FeedPage is a page of a personalized feed, newest first
+type FeedPage struct {
+	Items      []FeedItem `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"` // cursor of the next page; empty on the last page
+}
@@ -0,0 +1,26 @@
+package models
+
+// Import formats
+const (
+	ImportFormatCSV   = "csv"
+	ImportFormatJSONL = "jsonl"
+)
+
+// ImportRowResult reports the outcome of a single row of a bulk listing import
+type ImportRowResult struct {
+	Row       int             `json:"row"` // line number in the uploaded file
+	Title     string          `json:"title"`
+	ListingID string          `json:"listingId,omitempty"` // only set once the listing has been created
+	Held      *ModerationHold `json:"held,omitempty"`      // set instead of ListingID when the listing awaits moderation
+	Errors    []string        `json:"errors,omitempty"`
+}
+
+// ImportResult summarizes a bulk listing import
+type ImportResult struct {
+	DryRun  bool              `json:"dryRun"`
+	Partial bool              `json:"partial"` // whether valid rows are created even if others failed
+	Created int               `json:"created"`
+	Held    int               `json:"held"` // valid rows held for moderation instead of created
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
@@ -1,27 +1,170 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
+// Listing statuses
+const (
+	ListingStatusAvailable = "available"
+	ListingStatusPending   = "pending"
+	ListingStatusSold      = "sold"
+	ListingStatusTraded    = "traded"
+	ListingStatusExpired   = "expired"
+	ListingStatusDraft     = "draft"     // only visible to the owner
+	ListingStatusScheduled = "scheduled" // draft that the background worker will publish at PublishAt
+)
+
+// ListingStatuses are the accepted values for Listing.Status
+var ListingStatuses = []string{
+	ListingStatusAvailable, ListingStatusPending, ListingStatusSold, ListingStatusTraded,
+	ListingStatusExpired, ListingStatusDraft, ListingStatusScheduled,
+}
+
+// ListingTypes are the accepted values for Listing.Type
+var ListingTypes = []string{"plant", "seed", "cutting"}
+
 type Listing struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"userId"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Type        string    `json:"type"` // plant, seed, cutting
-	PlantType   string    `json:"plantType"` // indoor, outdoor, vegetable, herb, etc.
-	Price       float64   `json:"price"`
-	TradeFor    string    `json:"tradeFor"` // What the user is willing to trade for
-	Location    string    `json:"location"`
-	Images      []string  `json:"images"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
-	Status      string    `json:"status"` // available, pending, sold, traded
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Type        string     `json:"type"`      // plant, seed, cutting
+	PlantType   string     `json:"plantType"` // indoor, outdoor, vegetable, herb, etc.
+	Price       float64    `json:"price"`
+	TradeFor    string     `json:"tradeFor"` // What the user is willing to trade for
+	Location    string     `json:"location"`
+	Images      []string   `json:"images"`
+	CareSheet   *CareSheet `json:"careSheet,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	PublishAt   *time.Time `json:"publishAt,omitempty"` // Only set while scheduled
+	Status      string     `json:"status"`              // available, pending, sold, traded, expired, draft, scheduled
+
+	// Edit indicators derived from the revision history; only set by GetListing
+	LastEditedAt  *time.Time `json:"lastEditedAt,omitempty"`  // Last edit after publication
+	PreviousPrice *float64   `json:"previousPrice,omitempty"` // Set when the price has dropped since publication
+
+	// Only set by CreateListing when the listing awaits moderation instead of being created
+	Held *ModerationHold `json:"held,omitempty"`
+}
+
+// IsDraft reports whether the listing is an unpublished draft, scheduled or not
+func (l Listing) IsDraft() bool {
+	return l.Status == ListingStatusDraft || l.Status == ListingStatusScheduled
+}
+
+// PublishProblems lists everything that must be fixed before the listing can be published.
+// Drafts may be saved with any of these missing.
+func (l Listing) PublishProblems() []string {
+	problems := []string{}
+	if strings.TrimSpace(l.Title) == "" {
+		problems = append(problems, "title is required")
+	}
+	if strings.TrimSpace(l.Description) == "" {
+		problems = append(problems, "description is required")
+	}
+	validType := false
+	for _, listingType := range ListingTypes {
+		if l.Type == listingType {
+			validType = true
+		}
+	}
+	if !validType {
+		problems = append(problems, "type must be one of "+strings.Join(ListingTypes, ", "))
+	}
+	if strings.TrimSpace(l.PlantType) == "" {
+		problems = append(problems, "plant type is required")
+	}
+	if l.Price < 0 {
+		problems = append(problems, "price cannot be negative")
+	}
+	if strings.TrimSpace(l.Location) == "" {
+		problems = append(problems, "location is required")
+	}
+	return problems
+}
+
+// ListingEdit is a change to a listing held for moderation. Only the fields the
+// edit changed are set, so approving it keeps changes the owner made since.
+type ListingEdit struct {
+	ListingID   string     `json:"listingId"`
+	Title       *string    `json:"title,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Type        *string    `json:"type,omitempty"`
+	PlantType   *string    `json:"plantType,omitempty"`
+	Price       *float64   `json:"price,omitempty"`
+	TradeFor    *string    `json:"tradeFor,omitempty"`
+	Location    *string    `json:"location,omitempty"`
+	Images      *[]string  `json:"images,omitempty"`
+	CareSheet   *CareSheet `json:"careSheet,omitempty"`
+}
+
+// NewListingEdit records the fields that differ between a listing and its edited version
+func NewListingEdit(from, to Listing) ListingEdit {
+	edit := ListingEdit{ListingID: to.ID}
+	changedString := func(a, b string) *string {
+		if a == b {
+			return nil
+		}
+		return &b
+	}
+
+	edit.Title = changedString(from.Title, to.Title)
+	edit.Description = changedString(from.Description, to.Description)
+	edit.Type = changedString(from.Type, to.Type)
+	edit.PlantType = changedString(from.PlantType, to.PlantType)
+	if priceCents(from.Price) != priceCents(to.Price) {
+		edit.Price = &to.Price
+	}
+	edit.TradeFor = changedString(from.TradeFor, to.TradeFor)
+	edit.Location = changedString(from.Location, to.Location)
+	if !equalStrings(from.Images, to.Images) {
+		images := append([]string{}, to.Images...)
+		edit.Images = &images
+	}
+	if to.CareSheet != nil && (from.CareSheet == nil || *from.CareSheet != *to.CareSheet) {
+		edit.CareSheet = to.CareSheet
+	}
+	return edit
+}
+
+// ApplyTo copies the changed fields onto a listing
+func (e ListingEdit) ApplyTo(listing *Listing) {
+	if e.Title != nil {
+		listing.Title = *e.Title
+	}
+	if e.Description != nil {
+		listing.Description = *e.Description
+	}
+	if e.Type != nil {
+		listing.Type = *e.Type
+	}
+	if e.PlantType != nil {
+		listing.PlantType = *e.PlantType
+	}
+	if e.Price != nil {
+		listing.Price = *e.Price
+	}
+	if e.TradeFor != nil {
+		listing.TradeFor = *e.TradeFor
+	}
+	if e.Location != nil {
+		listing.Location = *e.Location
+	}
+	if e.Images != nil {
+		listing.Images = *e.Images
+	}
+	if e.CareSheet != nil {
+		listing.CareSheet = e.CareSheet
+	}
 }
 
 // ListingWithUser combines listing data with basic user information
 type ListingWithUser struct {
 	Listing
-	User UserResponse `json:"user"`
+	User     UserResponse   `json:"user"`
+	Shipping *ShippingCheck `json:"shipping,omitempty"` // Only set when the buyer's location is known
 }
@@ -5,13 +5,59 @@ import (
 )
 
 type Message struct {
-	ID        string    `json:"id"`
-	FromID    string    `json:"fromId"`
-	ToID      string    `json:"toId"`
-	ListingID string    `json:"listingId"`
-	Content   string    `json:"content"`
-	Read      bool      `json:"read"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversationId"`
+	FromID         string    `json:"fromId"`
+	ToID           string    `json:"toId"`
+	ListingID      string    `json:"listingId"`
+	Content        string    `json:"content"`
+	Read           bool      `json:"read"`
+	CreatedAt      time.Time `json:"createdAt"`
+
+	Attachments []MessageAttachment `json:"attachments,omitempty"`
+
+	// EditedAt is when the sender last edited the message; GetMessageEdits lists
+	// its earlier versions
+	EditedAt *time.Time `json:"editedAt,omitempty"`
+	// DeletedAt is when the sender deleted the message for everyone; it then has
+	// no content or attachments
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// Whether each participant deleted the message for themselves only
+	HiddenForSender    bool `json:"-"`
+	HiddenForRecipient bool `json:"-"`
+}
+
+// IsDeleted reports whether the message was deleted for everyone
+func (m Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// HiddenFor reports whether a participant deleted the message for themselves
+func (m Message) HiddenFor(userID string) bool {
+	return (userID == m.FromID && m.HiddenForSender) || (userID == m.ToID && m.HiddenForRecipient)
+}
+
+// MessageEdit is an earlier version of an edited message: the content it had
+// until the edit made at EditedAt
+type MessageEdit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"editedAt"`
+}
+
+// MessageAttachment is a photo sent with a message. It is uploaded first and then
+// sent by ID; until then only the uploader can see it.
+type MessageAttachment struct {
+	ID           string    `json:"id"`
+	MessageID    string    `json:"messageId,omitempty"` // empty until sent
+	UploaderID   string    `json:"uploaderId"`
+	ContentType  string    `json:"contentType"`
+	Size         int       `json:"size"` // bytes
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`          // the photo, for the uploader and the message's participants only
+	ThumbnailURL string    `json:"thumbnailUrl"` // a small JPEG preview, with the same access
+	CreatedAt    time.Time `json:"createdAt"`
 }
 
 // MessageWithUser includes user information with the message
@@ -22,13 +68,50 @@ type MessageWithUser struct {
 	Listing  Listing      `json:"listing"`
 }
 
-// Conversation represents a conversation between two users
+// Conversation is a thread between two users about a listing, as seen by one of
+// them. The user fields describe the other participant; the unread count, read
+// position and archive and mute settings are the viewer's own.
 type Conversation struct {
-	UserID       string           `json:"userId"`
-	Username     string           `json:"username"`
-	ProfilePic   string           `json:"profilePic"`
-	LastMessage  string           `json:"lastMessage"`
-	LastActivity time.Time        `json:"lastActivity"`
-	Messages     []MessageWithUser `json:"messages"`
-	Unread       int              `json:"unread"`
+	ID                string            `json:"id"`
+	UserID            string            `json:"userId"`
+	Username          string            `json:"username"`
+	ProfilePic        string            `json:"profilePic"`
+	ListingID         string            `json:"listingId"`    // empty if the listing was deleted
+	ListingTitle      string            `json:"listingTitle"` // empty if the listing was deleted
+	LastMessage       string            `json:"lastMessage"`
+	LastActivity      time.Time         `json:"lastActivity"`
+	Messages          []MessageWithUser `json:"messages,omitempty"` // only when getting one conversation
+	Unread            int               `json:"unread"`
+	LastReadMessageID string            `json:"lastReadMessageId,omitempty"`
+	Archived          bool              `json:"archived"` // hidden from the conversation list until a new message arrives
+	Muted             bool              `json:"muted"`    // new messages don't bring it back from the archive
+}
+
+// MessageSearchResult is a message matching a search, with where to find it.
+// The user fields describe the other participant.
+type MessageSearchResult struct {
+	MessageID      string    `json:"messageId"`
+	ConversationID string    `json:"conversationId"`
+	FromID         string    `json:"fromId"`
+	UserID         string    `json:"userId"`
+	Username       string    `json:"username"`
+	ListingID      string    `json:"listingId"`    // empty if the listing was deleted
+	ListingTitle   string    `json:"listingTitle"` // empty if the listing was deleted
+	Snippet        string    `json:"snippet"`      // HTML: the escaped content around the matches, which are in <mark>
+	CreatedAt      time.Time `json:"createdAt"`
+	Link           string    `json:"link"` // the messages page opened at the message
+}
+
+// MessageSearchPage is a page of message search results, best matches first
+type MessageSearchPage struct {
+	Results []MessageSearchResult `json:"results"`
+	Pagination
+}
+
+// SentMessage is a newly sent or edited message with any regional warnings about its
+// listing. A message or edit held for moderation is returned as submitted, with Held set.
+type SentMessage struct {
+	Message
+	Warnings []RegionRestriction `json:"warnings,omitempty"`
+	Held     *ModerationHold     `json:"held,omitempty"` // set instead of an ID when the message awaits moderation
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// Notification kinds
+const (
+	NotificationListingExpiring  = "listing_expiring"
+	NotificationListingExpired   = "listing_expired"
+	NotificationListingPublished = "listing_published"
+	NotificationPublishFailed    = "listing_publish_failed"
+	NotificationDataExportReady  = "data_export_ready"
+	NotificationContentApproved  = "content_approved" // a held message or listing was delivered
+	NotificationContentRejected  = "content_rejected" // a held message or listing was not delivered
+)
+
+// Notification is a system message shown to a user in their dashboard
+type Notification struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	ListingID string    `json:"listingId"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// Pagination describes one page of a list
+type Pagination struct {
+	Page  int `json:"page"`  // starting at 1
+	Limit int `json:"limit"` // items per page
+	Total int `json:"total"` // items across all pages
+}
+
+// Offset is the number of items before the page
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// HasNext reports whether there are items after the page
+func (p Pagination) HasNext() bool {
+	return p.Page*p.Limit < p.Total
+}
@@ -0,0 +1,37 @@
+package models
+
+// Restriction severities
+const (
+	RestrictionWarning = "warning"
+	RestrictionBlock   = "block"
+)
+
+// Region is a location resolved to a state and hardiness zone
+type Region struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Zone  string `json:"zone"` // USDA-style hardiness zone, e.g. "10b"
+}
+
+// RegionRestriction describes why a listing may be unsuitable for, or cannot be sent to, a buyer's region
+type RegionRestriction struct {
+	RuleID   string `json:"ruleId"`
+	Severity string `json:"severity"` // warning, block
+	Reason   string `json:"reason"`
+}
+
+// ShippingCheck is the result of checking a listing against a buyer's location
+type ShippingCheck struct {
+	BuyerRegion  *Region             `json:"buyerRegion,omitempty"`
+	Restrictions []RegionRestriction `json:"restrictions"`
+}
+
+// Blocked reports whether any restriction forbids the trade
+func (c ShippingCheck) Blocked() bool {
+	for _, restriction := range c.Restrictions {
+		if restriction.Severity == RestrictionBlock {
+			return true
+		}
+	}
+	return false
+}
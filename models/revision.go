@@ -0,0 +1,102 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// ListingRevision is an immutable snapshot of a listing taken whenever a save changes
+// what buyers see; a change of status alone does not start a new revision
+type ListingRevision struct {
+	ListingID   string    `json:"listingId"`
+	Revision    int       `json:"revision"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Type        string    `json:"type"`
+	PlantType   string    `json:"plantType"`
+	Price       float64   `json:"price"`
+	TradeFor    string    `json:"tradeFor"`
+	Location    string    `json:"location"`
+	Status      string    `json:"status"`
+	Images      []string  `json:"images"` // sha256 references to the image URLs, not the images themselves
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// FieldChange is a single field that differs between two revisions
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// RevisionDiff lists the field-level changes between two revisions of a listing
+type RevisionDiff struct {
+	ListingID string        `json:"listingId"`
+	From      int           `json:"from"`
+	To        int           `json:"to"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// DiffRevisions compares two revisions field by field
+func DiffRevisions(from, to ListingRevision) RevisionDiff {
+	diff := RevisionDiff{
+		ListingID: to.ListingID,
+		From:      from.Revision,
+		To:        to.Revision,
+		Changes:   []FieldChange{},
+	}
+
+	addString := func(field, a, b string) {
+		if a != b {
+			diff.Changes = append(diff.Changes, FieldChange{Field: field, From: a, To: b})
+		}
+	}
+
+	addString("title", from.Title, to.Title)
+	addString("description", from.Description, to.Description)
+	addString("type", from.Type, to.Type)
+	addString("plantType", from.PlantType, to.PlantType)
+	if priceCents(from.Price) != priceCents(to.Price) {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "price", From: from.Price, To: to.Price})
+	}
+	addString("tradeFor", from.TradeFor, to.TradeFor)
+	addString("location", from.Location, to.Location)
+	addString("status", from.Status, to.Status)
+	if !equalStrings(from.Images, to.Images) {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "images", From: from.Images, To: to.Images})
+	}
+
+	return diff
+}
+
+// SameContent reports whether two revisions show buyers the same listing,
+// ignoring status and the revision's own bookkeeping fields
+func (r ListingRevision) SameContent(other ListingRevision) bool {
+	return r.Title == other.Title &&
+		r.Description == other.Description &&
+		r.Type == other.Type &&
+		r.PlantType == other.PlantType &&
+		priceCents(r.Price) == priceCents(other.Price) &&
+		r.TradeFor == other.TradeFor &&
+		r.Location == other.Location &&
+		equalStrings(r.Images, other.Images)
+}
+
+// priceCents converts a price to whole cents, as the database stores it, so a
+// price read back compares equal to the one that was saved
+func priceCents(price float64) int64 {
+	return int64(math.Round(price * 100))
+}
+
+// equalStrings reports whether two string slices hold the same values in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
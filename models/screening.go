@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Kinds of content screened before they are delivered
+const (
+	ContentMessage     = "message"
+	ContentListing     = "listing"
+	ContentMessageEdit = "message_edit" // new text for a sent message
+	ContentListingEdit = "listing_edit" // changes to an existing listing
+)
+
+// Moderation statuses of held content
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved" // delivered as if it had just been submitted
+	ModerationRejected = "rejected"
+)
+
+// ScreeningFlag is something a screening check found in new content
+type ScreeningFlag struct {
+	Check  string `json:"check"`
+	Reason string `json:"reason"`
+	Score  int    `json:"score"`
+}
+
+// Screening is the outcome of screening new content
+type Screening struct {
+	Score int             `json:"score"`
+	Flags []ScreeningFlag `json:"flags"`
+	Held  bool            `json:"held"` // the score reached the hold threshold
+}
+
+// HeldContent is a new or edited message or listing held for moderation instead of delivered
+type HeldContent struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"` // message, listing, message_edit or listing_edit
+	UserID     string          `json:"userId"`
+	Content    json.RawMessage `json:"content"` // the Message or Listing as submitted, the edited Message, or a ListingEdit
+	Screening  Screening       `json:"screening"`
+	Status     string          `json:"status"` // pending, approved or rejected
+	CreatedAt  time.Time       `json:"createdAt"`
+	ReviewedAt *time.Time      `json:"reviewedAt,omitempty"`
+}
+
+// ModerationHold tells the author that their content is waiting for moderation;
+// how it was scored is not shown to them
+type ModerationHold struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
@@ -16,6 +16,18 @@ type User struct {
         CreatedAt   time.Time `json:"createdAt"`
         LastLoginAt time.Time `json:"lastLoginAt"`
         Favorites   []string  `json:"favorites"` // Array of listing IDs
+
+        FollowerCount  int `json:"followerCount"`
+        FollowingCount int `json:"followingCount"`
+
+        // Account deletion; the account can still be used, and deletion cancelled, until DeletionScheduledAt
+        DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
+        DeletedAt           *time.Time `json:"-"` // Set once the account has been purged and anonymized
+}
+
+// IsDeleted reports whether the account has been purged
+func (u *User) IsDeleted() bool {
+        return u.DeletedAt != nil
 }
 
 // UserResponse is a struct to return user data without sensitive information
@@ -27,6 +39,9 @@ type UserResponse struct {
         Bio        string    `json:"bio"`
         ProfilePic string    `json:"profilePic"`
         CreatedAt  time.Time `json:"createdAt"`
+
+        FollowerCount  int `json:"followerCount"`
+        FollowingCount int `json:"followingCount"`
 }
 
 // ToUserResponse converts a User to a UserResponse
@@ -39,5 +54,34 @@ func (u *User) ToUserResponse() UserResponse {
                 Bio:        u.Bio,
                 ProfilePic: u.ProfilePic,
                 CreatedAt:  u.CreatedAt,
+
+                FollowerCount:  u.FollowerCount,
+                FollowingCount: u.FollowingCount,
         }
 }
+
+// UserStats summarizes a user's trading record on their public profile
+type UserStats struct {
+        CompletedTrades        int      `json:"completedTrades"`        // Listings sold or traded
+        ResponseRate           *float64 `json:"responseRate"`           // Share of conversations started by others that were answered, 0 to 1; null before any
+        AverageResponseSeconds *int64   `json:"averageResponseSeconds"` // Mean time to the first reply; null before any reply
+}
+
+// UserListingsPage is a page of a user's active listings with their public profile
+type UserListingsPage struct {
+        User     UserResponse `json:"user"`
+        Stats    UserStats    `json:"stats"`
+        Listings []Listing    `json:"listings"`
+        Pagination
+}
+
+// AuthStatus reports whether the request carries a valid session, and for whom
+type AuthStatus struct {
+        Authenticated bool          `json:"authenticated"`
+        User          *UserResponse `json:"user,omitempty"`
+}
+
+// AccountDeletion reports when the account will be purged; nil when no deletion is scheduled
+type AccountDeletion struct {
+        DeletionScheduledAt *time.Time `json:"deletionScheduledAt"`
+}
@@ -0,0 +1,254 @@
+// Package openapi builds an OpenAPI 3 document from a list of routes.
+//
+// Request and response schemas are derived by reflection from the Go types the
+// handlers decode and encode, following their json tags, so the document stays
+// in step with the models. Validation rules are not expressed in the schemas;
+// requests that break them are answered with a validation_failed error.
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the OpenAPI version of the documents built here
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations on a path, keyed by lower-case HTTP method
+type PathItem map[string]*Operation
+
+// Operation describes a single route
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path or query
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response for one status code
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType gives the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the named schemas and security schemes referenced from operations
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how requests authenticate
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Route describes one registered route for Build
+type Route struct {
+	Method       string
+	Path         string // mux path template, e.g. /api/listings/{id}
+	ID           string // operationId; the client method of the same name calls it
+	Summary      string
+	Tag          string
+	Auth         bool        // requires a logged-in session
+	Query        []Param     // query string or form parameters
+	Body         interface{} // value of the request body type, or nil
+	BodyOptional bool
+	Status       int          // success status; http.StatusOK if zero
+	Result       interface{}  // value of the response body type, or nil for no JSON body
+	Errors       []int        // statuses of error responses besides those implied by the route
+	Content      ContentTypes // non-JSON request or response bodies
+	Deprecated   bool
+}
+
+// ContentTypes overrides the JSON default for a route's bodies
+type ContentTypes struct {
+	Request  string // e.g. multipart/form-data
+	Response string // e.g. application/zip
+}
+
+// Param is a query string or form parameter
+type Param struct {
+	Name        string
+	Description string
+	Type        string // string, integer, number or boolean; string if empty
+	Required    bool
+}
+
+// SessionScheme is the name of the cookie security scheme
+const SessionScheme = "session"
+
+// pathParam matches a mux path variable such as {id} or {id:[0-9]+}
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Build creates a document for the given routes. errorBody is the type of every
+// error response body.
+func Build(info Info, errorBody interface{}, routes []Route) *Document {
+	schemas := newSchemaSet()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas: schemas.named,
+			SecuritySchemes: map[string]SecurityScheme{
+				SessionScheme: {
+					Type:        "apiKey",
+					In:          "cookie",
+					Name:        "session",
+					Description: "Session cookie set by /api/v1/login and /api/v1/register",
+				},
+			},
+		},
+	}
+	errorSchema := schemas.of(errorBody)
+
+	for _, route := range routes {
+		op := &Operation{
+			OperationID: route.ID,
+			Summary:     route.Summary,
+			Responses:   map[string]Response{},
+			Deprecated:  route.Deprecated,
+		}
+		if route.Tag != "" {
+			op.Tags = []string{route.Tag}
+		}
+		if route.Auth {
+			op.Security = []map[string][]string{{SessionScheme: {}}}
+		}
+
+		// Path parameters come from the template; their names are used verbatim
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		for _, param := range route.Query {
+			paramType := param.Type
+			if paramType == "" {
+				paramType = "string"
+			}
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:        param.Name,
+				In:          "query",
+				Description: param.Description,
+				Required:    param.Required,
+				Schema:      &Schema{Type: paramType},
+			})
+		}
+
+		// Request body
+		if route.Body != nil {
+			contentType := route.Content.Request
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			op.RequestBody = &RequestBody{
+				Required: !route.BodyOptional,
+				Content:  map[string]MediaType{contentType: {Schema: schemas.of(route.Body)}},
+			}
+		}
+
+		// Success response
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := Response{Description: http.StatusText(status)}
+		switch {
+		case route.Content.Response != "":
+			success.Content = map[string]MediaType{route.Content.Response: {Schema: &Schema{Type: "string", Format: "binary"}}}
+		case route.Result != nil:
+			success.Content = map[string]MediaType{"application/json": {Schema: schemas.of(route.Result)}}
+		}
+		op.Responses[strconv.Itoa(status)] = success
+
+		// Error responses: those implied by the route plus any listed
+		errors := append([]int{}, route.Errors...)
+		if route.Body != nil {
+			errors = append(errors, http.StatusBadRequest)
+		}
+		if route.Auth {
+			errors = append(errors, http.StatusUnauthorized)
+		}
+		errors = append(errors, http.StatusInternalServerError)
+		for _, errorStatus := range errors {
+			op.Responses[strconv.Itoa(errorStatus)] = Response{
+				Description: http.StatusText(errorStatus),
+				Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+			}
+		}
+
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = PathItem{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return doc
+}
+
+// Routes lists the documented routes as "METHOD /path", sorted
+func (doc *Document) Routes() []string {
+	var routes []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Operation finds the operation for a method and path, or nil if none is documented
+func (doc *Document) Operation(method, path string) *Operation {
+	return doc.Paths[path][strings.ToLower(method)]
+}
@@ -26,24 +26,16 @@ function handleRegister(event) {
   // Debug the JSON being sent
   console.log('JSON to send:', JSON.stringify(data));
   
-  fetch('/api/register', {
+  fetch('/api/v1/register', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json'
     },
     body: JSON.stringify(data)
   })
-  .then(response => {
+  .then(async response => {
     if (!response.ok) {
-      return response.text().then(text => {
-        try {
-          const err = JSON.parse(text);
-          throw new Error(err.message || 'Registration failed');
-        } catch (e) {
-          console.error('Error parsing response:', text);
-          throw new Error(text || 'Registration failed');
-        }
-      });
+      throw new Error(await readErrorMessage(response, 'Registration failed'));
     }
     return response.json();
   })
@@ -67,24 +59,16 @@ function handleLogin(event) {
   const formData = new FormData(form);
   const data = Object.fromEntries(formData.entries());
   
-  fetch('/api/login', {
+  fetch('/api/v1/login', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json'
     },
     body: JSON.stringify(data)
   })
-  .then(response => {
+  .then(async response => {
     if (!response.ok) {
-      return response.text().then(text => {
-        try {
-          const err = JSON.parse(text);
-          throw new Error(err.message || 'Invalid email or password');
-        } catch (e) {
-          console.error('Error parsing response:', text);
-          throw new Error(text || 'Login failed');
-        }
-      });
+      throw new Error(await readErrorMessage(response, 'Invalid email or password'));
     }
     return response.json();
   })
@@ -101,7 +85,7 @@ function handleLogin(event) {
  * Handle user logout
  */
 function handleLogout() {
-  fetch('/api/logout', {
+  fetch('/api/v1/logout', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json'
@@ -18,7 +18,7 @@ async function fetchListings(filters = {}) {
     if (filters.plantType) queryParams.append('plantType', filters.plantType);
     if (filters.location) queryParams.append('location', filters.location);
     
-    const url = `/api/listings${queryParams.toString() ? '?' + queryParams.toString() : ''}`;
+    const url = `/api/v1/listings${queryParams.toString() ? '?' + queryParams.toString() : ''}`;
     
     const response = await fetch(url);
     if (!response.ok) {
@@ -89,7 +89,7 @@ async function handleSearch(event) {
   }
   
   try {
-    const response = await fetch(`/api/listings/search?q=${encodeURIComponent(query)}`);
+    const response = await fetch(`/api/v1/listings/search?q=${encodeURIComponent(query)}`);
     if (!response.ok) {
       throw new Error('Search failed');
     }
@@ -132,7 +132,7 @@ function handleFilterChange() {
 }
 
 /**
- * Create a new listing
+ * Create a new listing, save it as a draft, or save edits to an existing one
  * @param {Event} event - Form submit event
  */
 async function createListing(event) {
@@ -141,12 +141,30 @@ async function createListing(event) {
   const form = event.target;
   const formData = new FormData(form);
   
+  // "publish" or "draft", depending on which submit button was used
+  const action = event.submitter && event.submitter.value ? event.submitter.value : 'publish';
+  const editingId = form.dataset.listingId;
+  
   // Convert form data to JSON
   const listingData = {};
+  const careSheet = {};
+  let publishAt = null;
   formData.forEach((value, key) => {
+    // Collect care sheet fields into a nested object
+    if (key.startsWith('care.')) {
+      if (value) careSheet[key.substring(5)] = value;
+      return;
+    }
+    
+    // Scheduling is sent to the publish endpoint, not stored with the listing
+    if (key === 'publishAt') {
+      if (value) publishAt = new Date(value).toISOString();
+      return;
+    }
+    
     // Handle numeric values
     if (key === 'price') {
-      listingData[key] = parseFloat(value);
+      listingData[key] = value === '' ? 0 : parseFloat(value);
     } else if (key === 'images') {
       // For demo, use a placeholder image if none provided
       listingData[key] = value ? [value] : [];
@@ -155,31 +173,83 @@ async function createListing(event) {
     }
   });
   
+  // Only attach a care sheet if the seller kept some care information
+  if (Object.keys(careSheet).length > 0) {
+    listingData.careSheet = careSheet;
+  }
+  
   try {
-    const response = await fetch('/api/listings', {
-      method: 'POST',
-      headers: {
-        'Content-Type': 'application/json'
-      },
-      body: JSON.stringify(listingData)
-    });
+    let response;
+    if (editingId) {
+      response = await fetch(`/api/v1/listings/${editingId}`, {
+        method: 'PUT',
+        headers: {
+          'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(listingData)
+      });
+    } else {
+      // Scheduled listings start as drafts and are scheduled through the publish endpoint
+      listingData.status = action === 'draft' || publishAt ? 'draft' : 'available';
+      response = await fetch('/api/v1/listings', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(listingData)
+      });
+    }
     
     if (!response.ok) {
-      const errorData = await response.json();
-      throw new Error(errorData.message || 'Failed to create listing');
+      throw new Error(await readErrorMessage(response, 'Failed to save listing'));
     }
     
-    const listing = await response.json();
+    let listing = await response.json();
+    
+    // Listings held for moderation are created once reviewed
+    if (listing.held) {
+      form.reset();
+      const errorElement = form.querySelector('.form-error');
+      if (errorElement) {
+        errorElement.remove();
+      }
+      const noticeElement = form.querySelector('.form-notice') || createElement('div', { className: 'form-notice' });
+      noticeElement.textContent = listing.held.message;
+      form.appendChild(noticeElement);
+      return;
+    }
+    
+    // Publish or schedule drafts when the seller asked to publish
+    const isDraft = listing.status === 'draft' || listing.status === 'scheduled';
+    if (isDraft && action === 'publish') {
+      const publishResponse = await fetch(`/api/v1/listings/${listing.id}/publish`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(publishAt ? { publishAt } : {})
+      });
+      
+      if (!publishResponse.ok) {
+        throw new Error(await readErrorMessage(publishResponse, 'Saved as a draft, but it could not be published'));
+      }
+      
+      listing = await publishResponse.json();
+    }
     
-    // Redirect to the new listing
-    window.location.href = `/listing/${listing.id}`;
+    // Drafts go back to the dashboard, published listings to their page
+    if (listing.status === 'draft' || listing.status === 'scheduled') {
+      window.location.href = '/dashboard#drafts';
+    } else {
+      window.location.href = `/listing/${listing.id}`;
+    }
   } catch (error) {
-    console.error('Error creating listing:', error);
+    console.error('Error saving listing:', error);
     
     // Display error message
     const errorElement = form.querySelector('.form-error') || document.createElement('div');
     errorElement.className = 'form-error';
-    errorElement.textContent = error.message || 'Failed to create listing. Please try again.';
+    errorElement.textContent = error.message || 'Failed to save listing. Please try again.';
     
     if (!form.querySelector('.form-error')) {
       form.appendChild(errorElement);
@@ -187,6 +257,150 @@ async function createListing(event) {
   }
 }
 
+/**
+ * Load an existing listing into the create listing form for editing
+ * @param {string} listingId - ID of the listing to edit
+ */
+async function loadListingForEdit(listingId) {
+  const form = document.getElementById('create-listing-form');
+  if (!form) return;
+  
+  try {
+    const response = await fetch(`/api/v1/listings/${listingId}`);
+    if (!response.ok) {
+      throw new Error('Failed to fetch listing');
+    }
+    
+    const listing = await response.json();
+    form.dataset.listingId = listing.id;
+    
+    // Fill the basic fields
+    ['title', 'description', 'type', 'plantType', 'price', 'location', 'tradeFor'].forEach(key => {
+      const input = document.getElementById(key);
+      if (input && listing[key] !== undefined && listing[key] !== null) {
+        input.value = listing[key];
+      }
+    });
+    
+    // Fill the care sheet
+    if (listing.careSheet) {
+      Object.entries(listing.careSheet).forEach(([key, value]) => {
+        const input = document.getElementById(`care-${key}`);
+        if (input) input.value = value;
+      });
+    }
+    
+    // Drafts can be kept as drafts or published; published listings are just saved
+    const heading = document.querySelector('main h1');
+    const isDraft = listing.status === 'draft' || listing.status === 'scheduled';
+    if (heading) heading.textContent = isDraft ? 'Edit Draft' : 'Edit Listing';
+    
+    const publishButton = form.querySelector('button[value="publish"]');
+    const draftButton = form.querySelector('button[value="draft"]');
+    const schedule = document.getElementById('schedule-group');
+    if (isDraft) {
+      if (publishButton) publishButton.textContent = 'Publish';
+      if (draftButton) draftButton.textContent = 'Save Draft';
+      if (listing.publishAt) {
+        const publishAtInput = document.getElementById('publishAt');
+        const local = new Date(listing.publishAt);
+        local.setMinutes(local.getMinutes() - local.getTimezoneOffset());
+        if (publishAtInput) publishAtInput.value = local.toISOString().slice(0, 16);
+      }
+    } else {
+      if (publishButton) publishButton.textContent = 'Save Changes';
+      if (draftButton) draftButton.style.display = 'none';
+      if (schedule) schedule.style.display = 'none';
+    }
+  } catch (error) {
+    console.error('Error loading listing for edit:', error);
+    displayError('Failed to load listing. It may have been removed.');
+  }
+}
+
+/**
+ * Fetch and display the current user's draft listings
+ */
+async function fetchDraftListings() {
+  const container = document.getElementById('draft-listings');
+  if (!container) return;
+  
+  try {
+    const response = await fetch('/api/v1/listings/drafts');
+    if (!response.ok) {
+      throw new Error('Failed to fetch drafts');
+    }
+    
+    const listings = await response.json();
+    
+    container.innerHTML = '';
+    
+    if (listings.length === 0) {
+      container.innerHTML = '<p class="text-center">You have no drafts.</p>';
+      return;
+    }
+    
+    listings.forEach(listing => {
+      const status = listing.status === 'scheduled' && listing.publishAt
+        ? `Scheduled for ${formatDate(listing.publishAt)} ${formatTime(listing.publishAt)}`
+        : 'Draft';
+      
+      container.appendChild(createElement('div', { className: 'archived-listing card-meta mb-2' }, [
+        createElement('a', { href: `/edit-listing/${listing.id}` }, listing.title || 'Untitled draft'),
+        createElement('span', { className: 'card-badge' }, status),
+        createElement('div', {}, [
+          createElement('a', { className: 'btn btn-sm btn-outline', href: `/edit-listing/${listing.id}` }, 'Edit'),
+          createElement('button', {
+            className: 'btn btn-sm btn-primary ml-3',
+            onclick: async () => {
+              const response = await fetch(`/api/v1/listings/${listing.id}/publish`, { method: 'POST' });
+              if (!response.ok) {
+                displayError(await readErrorMessage(response, 'Failed to publish listing'));
+                return;
+              }
+              window.location.href = `/listing/${listing.id}`;
+            }
+          }, 'Publish now')
+        ])
+      ]));
+    });
+  } catch (error) {
+    console.error('Error fetching drafts:', error);
+    container.innerHTML = '<p class="text-center text-error">Failed to load your drafts. Please try again later.</p>';
+  }
+}
+
+/**
+ * Pre-fill empty care sheet fields with defaults for the entered plant
+ */
+async function prefillCareSheet() {
+  const title = document.getElementById('title');
+  const plantType = document.getElementById('plantType');
+  if (!title || !title.value) return;
+  
+  try {
+    const queryParams = new URLSearchParams({
+      title: title.value,
+      plantType: plantType ? plantType.value : ''
+    });
+    
+    const response = await fetch(`/api/v1/care-sheets/defaults?${queryParams.toString()}`);
+    if (!response.ok) return;
+    
+    const careSheet = await response.json();
+    
+    // Only fill fields the seller hasn't typed into yet
+    Object.entries(careSheet).forEach(([key, value]) => {
+      const input = document.getElementById(`care-${key}`);
+      if (input && !input.value) {
+        input.value = value;
+      }
+    });
+  } catch (error) {
+    console.error('Error fetching care sheet defaults:', error);
+  }
+}
+
 /**
  * Handle image upload
  * @param {Event} event - Change event from file input
@@ -231,7 +445,7 @@ function handleImageUpload(event) {
  */
 async function fetchListing(listingId) {
   try {
-    const response = await fetch(`/api/listings/${listingId}`);
+    const response = await fetch(`/api/v1/listings/${listingId}`);
     if (!response.ok) {
       throw new Error('Failed to fetch listing');
     }
@@ -279,12 +493,20 @@ function displayListingDetails(listing) {
     // Info section
     createElement('div', { className: 'listing-info' }, [
       createElement('h1', { className: 'mb-2' }, listing.title),
-      createElement('div', { className: 'listing-price' }, formatCurrency(listing.price)),
+      createElement('div', { className: 'listing-price' }, [
+        createElement('span', {}, formatCurrency(listing.price)),
+        listing.previousPrice ? createElement('span', { className: 'listing-previous-price' }, formatCurrency(listing.previousPrice)) : null
+      ].filter(Boolean)),
       
       createElement('div', { className: 'listing-meta' }, [
         createElement('span', { className: 'card-badge' }, listing.type),
-        createElement('span', { className: 'card-badge' }, listing.plantType)
-      ]),
+        createElement('span', { className: 'card-badge' }, listing.plantType),
+        listing.previousPrice ? createElement('span', { className: 'card-badge badge-price-drop' }, 'Price dropped') : null,
+        listing.lastEditedAt ? createElement('span', {
+          className: 'card-badge badge-edited',
+          title: `Edited ${formatDate(listing.lastEditedAt)}`
+        }, 'Edited') : null
+      ].filter(Boolean)),
       
       createElement('p', { className: 'mb-3' }, listing.description),
       
@@ -298,6 +520,8 @@ function displayListingDetails(listing) {
         createElement('span', {}, listing.tradeFor || 'N/A')
       ]),
       
+      createShippingNotice(listing.shipping),
+      
       createElement('div', { className: 'listing-seller' }, [
         createElement('img', {
           className: 'seller-avatar',
@@ -323,13 +547,7 @@ function displayListingDetails(listing) {
         createElement('button', {
           className: 'btn btn-outline btn-lg ml-3',
           id: 'favorite-btn',
-          onclick: async (event) => {
-            const isFavorite = event.target.dataset.favorite === 'true';
-            const newStatus = await toggleFavorite(listing.id, isFavorite);
-            event.target.dataset.favorite = newStatus.toString();
-            event.target.innerHTML = newStatus ? '★ Favorited' : '☆ Add to Favorites';
-            event.target.className = newStatus ? 'btn btn-accent btn-lg ml-3' : 'btn btn-outline btn-lg ml-3';
-          },
+          onclick: (event) => handleFavoriteClick(event, listing.id),
           dataset: { favorite: 'false' }
         }, '☆ Add to Favorites')
       ])
@@ -338,10 +556,160 @@ function displayListingDetails(listing) {
   
   container.appendChild(detailElement);
   
+  // Show the care sheet if the seller attached one
+  displayCareSheet(listing.careSheet);
+  
   // Check if listing is in favorites
   checkFavoriteStatus(listing.id);
 }
 
+/**
+ * Toggle the favorite status of the listing shown on the page
+ * @param {Event} event - Click event on the favorite button
+ * @param {string} listingId - ID of the listing
+ */
+async function handleFavoriteClick(event, listingId) {
+  const isFavorite = event.target.dataset.favorite === 'true';
+  const newStatus = await toggleFavorite(listingId, isFavorite);
+  event.target.dataset.favorite = newStatus.toString();
+  event.target.innerHTML = newStatus ? '★ Favorited' : '☆ Add to Favorites';
+  event.target.className = newStatus ? 'btn btn-accent btn-lg ml-3' : 'btn btn-outline btn-lg ml-3';
+}
+
+/**
+ * Add the favorite toggle to a listing rendered by the server
+ * @param {string} listingId - ID of the listing
+ */
+function setupFavoriteButton(listingId) {
+  const favoriteBtn = document.getElementById('favorite-btn');
+  if (!favoriteBtn) return;
+  
+  favoriteBtn.addEventListener('click', event => handleFavoriteClick(event, listingId));
+}
+
+/**
+ * Create a notice listing regional restrictions for the buyer
+ * @param {Object} shipping - Shipping check result, may be undefined
+ * @returns {HTMLElement|null} Notice element, or null if there is nothing to show
+ */
+function createShippingNotice(shipping) {
+  if (!shipping || !shipping.restrictions || shipping.restrictions.length === 0) {
+    return null;
+  }
+  
+  const blocked = shipping.restrictions.some(restriction => restriction.severity === 'block');
+  const region = shipping.buyerRegion;
+  
+  return createElement('div', { className: blocked ? 'shipping-notice shipping-blocked' : 'shipping-notice' }, [
+    createElement('strong', {}, blocked
+      ? `Cannot be sent to ${region.name} (zone ${region.zone})`
+      : `Notes for ${region.name} (zone ${region.zone})`),
+    createElement('ul', {}, shipping.restrictions.map(restriction =>
+      createElement('li', {}, restriction.reason)
+    ))
+  ]);
+}
+
+/**
+ * Renew a listing so it stays visible for another lifetime
+ * @param {string} listingId - ID of the listing to renew
+ * @returns {Promise<Object|null>} The renewed listing, or null on failure
+ */
+async function renewListing(listingId) {
+  try {
+    const response = await fetch(`/api/v1/listings/${listingId}/renew`, {
+      method: 'POST'
+    });
+    
+    if (!response.ok) {
+      throw new Error('Failed to renew listing');
+    }
+    
+    return await response.json();
+  } catch (error) {
+    console.error('Error renewing listing:', error);
+    displayError('Failed to renew listing. Please try again.');
+    return null;
+  }
+}
+
+/**
+ * Fetch and display the current user's archived listings
+ */
+async function fetchArchivedListings() {
+  const container = document.getElementById('archived-listings');
+  if (!container) return;
+  
+  try {
+    const response = await fetch('/api/v1/listings/archive');
+    if (!response.ok) {
+      throw new Error('Failed to fetch archived listings');
+    }
+    
+    const listings = await response.json();
+    
+    container.innerHTML = '';
+    
+    if (listings.length === 0) {
+      container.innerHTML = '<p class="text-center">Your archive is empty.</p>';
+      return;
+    }
+    
+    listings.forEach(listing => {
+      const actions = [];
+      if (listing.status === 'expired') {
+        actions.push(createElement('button', {
+          className: 'btn btn-sm btn-primary',
+          onclick: async () => {
+            const renewed = await renewListing(listing.id);
+            if (renewed) {
+              window.location.reload();
+            }
+          }
+        }, 'Renew'));
+      }
+      
+      container.appendChild(createElement('div', { className: 'archived-listing card-meta mb-2' }, [
+        createElement('a', { href: `/listing/${listing.id}` }, listing.title),
+        createElement('span', { className: 'card-badge' }, listing.status),
+        createElement('div', {}, actions)
+      ]));
+    });
+  } catch (error) {
+    console.error('Error fetching archived listings:', error);
+    container.innerHTML = '<p class="text-center text-error">Failed to load your archive. Please try again later.</p>';
+  }
+}
+
+/**
+ * Display a listing's care sheet
+ * @param {Object} careSheet - Care sheet data, may be undefined
+ */
+function displayCareSheet(careSheet) {
+  const section = document.getElementById('care-sheet');
+  const details = document.getElementById('care-sheet-details');
+  if (!section || !details || !careSheet) return;
+  
+  const fields = [
+    ['species', 'Species'],
+    ['light', 'Light'],
+    ['water', 'Water'],
+    ['humidity', 'Humidity'],
+    ['temperature', 'Temperature'],
+    ['petToxicity', 'Toxicity to pets'],
+    ['propagation', 'Propagation']
+  ];
+  
+  details.innerHTML = '';
+  fields.forEach(([key, label]) => {
+    if (!careSheet[key]) return;
+    details.appendChild(createElement('dt', {}, label));
+    details.appendChild(createElement('dd', {}, careSheet[key]));
+  });
+  
+  section.style.display = details.children.length > 0 ? '' : 'none';
+}
+
 /**
  * Fetch user's favorite listings
  */
@@ -350,7 +718,7 @@ async function fetchFavorites() {
     const user = await checkAuth();
     if (!user) return;
     
-    const response = await fetch('/api/favorites');
+    const response = await fetch('/api/v1/favorites');
     if (!response.ok) {
       throw new Error('Failed to fetch favorites');
     }
@@ -420,9 +788,9 @@ function displayError(message) {
 
 // Set up event listeners when DOM is loaded
 document.addEventListener('DOMContentLoaded', () => {
-  // Fetch listings for homepage
+  // Fetch listings for homepage, unless the server already rendered them
   const listingsContainer = document.getElementById('listings-container');
-  if (listingsContainer) {
+  if (listingsContainer && !listingsContainer.dataset.rendered) {
     fetchListings();
   }
   
@@ -454,10 +822,16 @@ document.addEventListener('DOMContentLoaded', () => {
   const listingContainer = document.getElementById('listing-container');
   const listingId = window.location.pathname.split('/').pop();
   
-  if (listingContainer && listingId && listingId !== 'listing') {
+  if (listingContainer && listingContainer.dataset.rendered) {
+    setupFavoriteButton(listingContainer.dataset.listingId);
+  } else if (listingContainer && listingId && listingId !== 'listing') {
     fetchListing(listingId);
   }
   
-  // Load user's favorites
-  fetchFavorites();
+  // Load user's favorites, then mark a listing rendered by the server
+  fetchFavorites().then(() => {
+    if (listingContainer && listingContainer.dataset.rendered) {
+      checkFavoriteStatus(listingContainer.dataset.listingId);
+    }
+  });
 });
@@ -155,7 +155,7 @@ function createListingCard(listing) {
  */
 async function checkAuth() {
   try {
-    const response = await fetch('/api/check-auth');
+    const response = await fetch('/api/v1/check-auth');
     const data = await response.json();
     
     // Update UI based on authentication status
@@ -201,7 +201,7 @@ function updateAuthUI(isAuthenticated, user = null) {
  */
 async function toggleFavorite(listingId, isFavorite) {
   try {
-    const response = await fetch('/api/favorites', {
+    const response = await fetch('/api/v1/favorites', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json'
@@ -224,6 +224,26 @@ async function toggleFavorite(listingId, isFavorite) {
   }
 }
 
+/**
+ * Read an error message from a failed response
+ * @param {Response} response - Failed fetch response; API errors carry { error: { message } }
+ * @param {string} fallback - Message to use if the body has none
+ * @returns {Promise<string>} Error message
+ */
+async function readErrorMessage(response, fallback) {
+  try {
+    const text = await response.text();
+    try {
+      const data = JSON.parse(text);
+      return (data.error && data.error.message) || data.message || fallback;
+    } catch (parseError) {
+      return text.trim() || fallback;
+    }
+  } catch (error) {
+    return fallback;
+  }
+}
+
 /**
  * Handle form submission with fetch API
  * @param {Event} event - Form submit event
@@ -243,9 +263,9 @@ function handleFormSubmit(event, successCallback) {
     },
     body: JSON.stringify(data)
   })
-  .then(response => {
+  .then(async response => {
     if (!response.ok) {
-      return response.json().then(err => { throw err; });
+      throw new Error(await readErrorMessage(response, 'An error occurred. Please try again.'));
     }
     return response.json();
   })
@@ -4,9 +4,12 @@
 let currentConversations = [];
 let currentConversation = null;
 let currentUser = null;
+let showArchived = false;
+let searchQuery = '';
 
 /**
- * Fetch all conversations
+ * Fetch the conversations list, or the archived conversations if showArchived is set
+ * @returns {Promise<Array>} The conversations
  */
 async function fetchConversations() {
   try {
@@ -26,7 +29,7 @@ async function fetchConversations() {
     console.log('Current user set:', currentUser);
     
     console.log('Fetching conversations from API');
-    const response = await fetch('/api/conversations');
+    const response = await fetch(`/api/v1/conversations${showArchived ? '?archived=true' : ''}`);
     console.log('API response status:', response.status);
     
     if (!response.ok) {
@@ -41,25 +44,6 @@ async function fetchConversations() {
     console.log('Displaying conversations');
     displayConversations(conversations);
     
-    // Check URL for specific conversation
-    const urlParams = new URLSearchParams(window.location.search);
-    const userId = urlParams.get('userId');
-    console.log('URL userId parameter:', userId);
-    
-    if (userId) {
-      // Open conversation with specified user
-      console.log('Opening conversation with specified user:', userId);
-      fetchConversation(userId);
-    } else if (conversations.length > 0) {
-      // Open first conversation by default
-      console.log('Opening first conversation by default:', conversations[0].userId);
-      fetchConversation(conversations[0].userId);
-    } else {
-      // Show empty state if no conversations
-      console.log('No conversations, showing empty state');
-      displayEmptyConversation();
-    }
-    
     return conversations;
   } catch (error) {
     console.error('Error fetching conversations:', error);
@@ -68,6 +52,63 @@ async function fetchConversations() {
   }
 }
 
+/**
+ * Open the conversation given in the URL, or the most recent one. Links from
+ * listings and profiles give the other user and listing, which open their
+ * conversation or start a new one.
+ * @param {Array} conversations - Conversations in the list
+ */
+function openInitialConversation(conversations) {
+  const urlParams = new URLSearchParams(window.location.search);
+  const conversationId = urlParams.get('conversationId');
+  const userId = urlParams.get('userId');
+  const listingId = urlParams.get('listingId');
+  
+  if (conversationId) {
+    fetchConversation(conversationId, urlParams.get('messageId'));
+  } else if (userId) {
+    const existing = conversations.find(conversation =>
+      conversation.userId === userId && (!listingId || conversation.listingId === listingId));
+    if (existing) {
+      fetchConversation(existing.id);
+    } else {
+      startConversation(userId, listingId);
+    }
+  } else if (conversations.length > 0) {
+    fetchConversation(conversations[0].id);
+  } else {
+    displayEmptyConversation();
+  }
+}
+
+/**
+ * Show a new conversation with a user about a listing; it is created when the
+ * first message is sent
+ * @param {string} userId - ID of the other user
+ * @param {string|null} listingId - ID of the listing, if known
+ */
+async function startConversation(userId, listingId) {
+  try {
+    const response = await fetch(`/api/v1/users/${userId}`);
+    if (!response.ok) {
+      throw new Error('Failed to fetch user');
+    }
+    
+    const user = await response.json();
+    currentConversation = {
+      userId: user.id,
+      username: user.username,
+      profilePic: user.profilePic,
+      listingId: listingId || '',
+      messages: []
+    };
+    displayConversation(currentConversation);
+  } catch (error) {
+    console.error('Error starting conversation:', error);
+    displayError('Failed to start the conversation. Please try again later.');
+  }
+}
+
 /**
  * Display conversations list
  * @param {Array} conversations - Array of conversation data
@@ -84,10 +125,21 @@ function displayConversations(conversations) {
   // Clear existing content
   container.innerHTML = '';
   console.log('Cleared existing content in conversations list');
+  container.appendChild(createMessageSearchForm());
+  
+  // Switch between the inbox and the archive
+  container.appendChild(createElement('button', {
+    className: 'btn btn-outline btn-sm conversations-toggle',
+    onclick: async () => {
+      showArchived = !showArchived;
+      await fetchConversations();
+    }
+  }, showArchived ? 'Back to conversations' : 'Archived conversations'));
   
   if (conversations.length === 0) {
     console.log('No conversations to display, showing empty state');
-    container.innerHTML = '<p class="text-center p-3">No conversations yet.</p>';
+    container.appendChild(createElement('p', { className: 'text-center p-3' },
+      showArchived ? 'No archived conversations.' : 'No conversations yet.'));
     return;
   }
   
@@ -95,7 +147,7 @@ function displayConversations(conversations) {
   console.log('Adding conversation items to list');
   conversations.forEach((conversation, index) => {
     try {
-      console.log(`Creating conversation item ${index + 1}/${conversations.length}:`, conversation.userId);
+      console.log(`Creating conversation item ${index + 1}/${conversations.length}:`, conversation.id);
       
       // Fix potential issues with lastActivity not being a valid date
       let formattedDate = '';
@@ -117,6 +169,7 @@ function displayConversations(conversations) {
         }),
         createElement('div', { className: 'conversation-info' }, [
           createElement('div', { className: 'conversation-username' }, conversation.username || 'Unknown User'),
+          createElement('div', { className: 'conversation-listing' }, conversation.listingTitle || 'Listing removed'),
           createElement('div', { className: 'conversation-time' }, formattedDate)
         ])
       ]);
@@ -125,10 +178,12 @@ function displayConversations(conversations) {
         conversation.lastMessage || 'Start a conversation'
       );
       
-      // Only create badge if unread > 0
+      // Only create badge if unread > 0; muted conversations don't stand out
       let badge = null;
       if (conversation.unread && conversation.unread > 0) {
-        badge = createElement('div', { className: 'conversation-badge' }, String(conversation.unread));
+        badge = createElement('div', {
+          className: `conversation-badge${conversation.muted ? ' conversation-badge-muted' : ''}`
+        }, String(conversation.unread));
       }
       
       // Create children array without null elements
@@ -138,8 +193,8 @@ function displayConversations(conversations) {
       // Create the item with the valid children
       const item = createElement('div', {
         className: 'conversation-item',
-        dataset: { userId: conversation.userId },
-        onclick: () => fetchConversation(conversation.userId)
+        dataset: { conversationId: conversation.id },
+        onclick: () => fetchConversation(conversation.id)
       }, childrenArray);
       
       console.log(`Appending conversation item ${index + 1} to container`);
@@ -153,15 +208,104 @@ function displayConversations(conversations) {
 }
 
 /**
- * Fetch messages for a specific conversation
- * @param {string} userId - ID of the user to fetch conversation with
+ * Create the form searching the user's messages
+ * @returns {HTMLElement} The search form
+ */
+function createMessageSearchForm() {
+  return createElement('form', {
+    className: 'message-search-form',
+    onsubmit: (event) => {
+      event.preventDefault();
+      const query = event.target.elements.q.value.trim();
+      if (query) {
+        searchMessages(query);
+      }
+    }
+  }, [
+    createElement('input', {
+      type: 'search',
+      name: 'q',
+      className: 'form-control',
+      placeholder: 'Search messages',
+      value: searchQuery
+    })
+  ]);
+}
+
+/**
+ * Search the user's messages and list the matches in place of the conversations
+ * @param {string} query - Search text
+ */
+async function searchMessages(query) {
+  try {
+    searchQuery = query;
+    const response = await fetch(`/api/v1/messages/search?q=${encodeURIComponent(query)}`);
+    if (!response.ok) {
+      throw new Error('Failed to search messages');
+    }
+    
+    const page = await response.json();
+    displaySearchResults(page);
+  } catch (error) {
+    console.error('Error searching messages:', error);
+    displayError('Failed to search messages. Please try again later.');
+  }
+}
+
+/**
+ * Display message search results; each opens its conversation at the message
+ * @param {Object} page - Page of search results
+ */
+function displaySearchResults(page) {
+  const container = document.getElementById('conversations-list');
+  container.innerHTML = '';
+  container.appendChild(createMessageSearchForm());
+  container.appendChild(createElement('button', {
+    className: 'btn btn-outline btn-sm conversations-toggle',
+    onclick: async () => {
+      searchQuery = '';
+      await fetchConversations();
+    }
+  }, 'Back to conversations'));
+  
+  if (page.results.length === 0) {
+    container.appendChild(createElement('p', { className: 'text-center p-3' }, 'No messages found.'));
+    return;
+  }
+  
+  if (page.total > page.results.length) {
+    container.appendChild(createElement('p', { className: 'text-center message-search-count' },
+      `Showing the best ${page.results.length} of ${page.total} matches.`));
+  }
+  
+  page.results.forEach(result => {
+    container.appendChild(createElement('div', {
+      className: 'conversation-item',
+      dataset: { conversationId: result.conversationId },
+      onclick: () => fetchConversation(result.conversationId, result.messageId)
+    }, [
+      createElement('div', { className: 'conversation-info' }, [
+        createElement('div', { className: 'conversation-username' }, result.username || 'Unknown User'),
+        createElement('div', { className: 'conversation-listing' }, result.listingTitle || 'Listing removed'),
+        createElement('div', { className: 'conversation-time' }, formatDate(result.createdAt))
+      ]),
+      // The snippet is escaped by the server, with the matches in <mark>
+      createElement('div', { className: 'conversation-preview message-search-snippet', innerHTML: result.snippet })
+    ]));
+  });
+}
+
+/**
+ * Fetch messages for a specific conversation, marking it read
+ * @param {string} conversationId - ID of the conversation
+ * @param {string} [messageId] - ID of a message to scroll to and highlight
  */
-async function fetchConversation(userId) {
+async function fetchConversation(conversationId, messageId) {
   try {
-    console.log('Starting fetchConversation for userId:', userId);
+    console.log('Starting fetchConversation for conversationId:', conversationId);
     
     console.log('Fetching conversation from API');
-    const response = await fetch(`/api/conversations/${userId}`);
+    const response = await fetch(`/api/v1/conversations/${conversationId}/messages`);
     console.log('API response status:', response.status);
     
     if (!response.ok) {
@@ -175,10 +319,13 @@ async function fetchConversation(userId) {
     // Display the messages
     console.log('Displaying conversation');
     displayConversation(conversation);
+    if (messageId) {
+      highlightMessage(messageId);
+    }
     
     // Update active state in conversation list
     console.log('Updating active conversation in list');
-    updateActiveConversation(userId);
+    updateActiveConversation(conversationId);
     
     return conversation;
   } catch (error) {
@@ -221,8 +368,13 @@ function displayConversation(conversation) {
         alt: conversation.username
       }),
       createElement('div', { className: 'message-user-info' }, [
-        createElement('div', { className: 'conversation-username' }, conversation.username)
-      ])
+        createElement('div', { className: 'conversation-username' }, conversation.username),
+        conversation.listingTitle ? createElement('a', {
+          className: 'conversation-listing',
+          href: `/listing/${conversation.listingId}`
+        }, conversation.listingTitle) : null
+      ]),
+      conversation.id ? createConversationActions(conversation) : null
     ]);
     headerContainer.appendChild(headerContent);
     
@@ -236,16 +388,49 @@ function displayConversation(conversation) {
     } else {
       // Display messages
       console.log(`Displaying ${conversation.messages.length} messages`);
+      
+      // Track when each listing was first discussed, so edits made since can be flagged
+      const editNoticeShown = {};
+      const firstMessageAt = {};
+      
       conversation.messages.forEach((message, index) => {
         console.log(`Processing message ${index + 1}/${conversation.messages.length}`);
         try {
           const isSentByCurrentUser = message.fromUser.id === currentUser.id;
           
+          // Show an edit notice where the listing changed mid-conversation
+          const listing = message.listing;
+          if (listing && listing.id) {
+            if (firstMessageAt[listing.id] && listing.lastEditedAt && !editNoticeShown[listing.id] &&
+                new Date(listing.lastEditedAt) > firstMessageAt[listing.id] &&
+                new Date(message.createdAt) > new Date(listing.lastEditedAt)) {
+              bodyContainer.appendChild(createListingEditNotice(listing));
+              editNoticeShown[listing.id] = true;
+            }
+            if (!firstMessageAt[listing.id]) {
+              firstMessageAt[listing.id] = new Date(message.createdAt);
+            }
+          }
+          
           const messageElement = createElement('div', {
-            className: `message-bubble ${isSentByCurrentUser ? 'message-sent' : 'message-received'}`
+            className: `message-bubble ${isSentByCurrentUser ? 'message-sent' : 'message-received'}`,
+            dataset: { messageId: message.id }
           }, [
-            createElement('div', { className: 'message-content' }, message.content),
-            createElement('div', { className: 'message-time' }, formatTime(message.createdAt))
+            message.deletedAt
+              ? createElement('div', { className: 'message-content message-deleted' }, 'This message was deleted')
+              : null,
+            message.content ? createElement('div', { className: 'message-content' }, message.content) : null,
+            createMessageAttachments(message.attachments),
+            createElement('div', { className: 'message-time' }, [
+              formatTime(message.createdAt),
+              message.editedAt ? createElement('a', {
+                href: '#',
+                className: 'message-edited',
+                title: 'Show earlier versions',
+                onclick: (event) => toggleMessageEdits(event, message)
+              }, ' (edited)') : null
+            ]),
+            createMessageActions(message, isSentByCurrentUser)
           ]);
           
           bodyContainer.appendChild(messageElement);
@@ -254,6 +439,16 @@ function displayConversation(conversation) {
         }
       });
       
+      // Listings edited after the last message get their notice at the end
+      conversation.messages.forEach(message => {
+        const listing = message.listing;
+        if (listing && listing.id && listing.lastEditedAt && !editNoticeShown[listing.id] &&
+            new Date(listing.lastEditedAt) > firstMessageAt[listing.id]) {
+          bodyContainer.appendChild(createListingEditNotice(listing));
+          editNoticeShown[listing.id] = true;
+        }
+      });
+      
       // Scroll to bottom
       bodyContainer.scrollTop = bodyContainer.scrollHeight;
     }
@@ -263,15 +458,24 @@ function displayConversation(conversation) {
     formContainer.innerHTML = '';
     const formElement = createElement('form', {
       id: 'send-message-form',
-      onsubmit: (event) => sendMessage(event, conversation.userId)
+      onsubmit: (event) => sendMessage(event, conversation)
     }, [
       createElement('input', {
         type: 'text',
         name: 'message',
         className: 'form-control message-input',
-        placeholder: 'Type your message...',
-        required: true
+        placeholder: 'Type your message...'
       }),
+      createElement('label', { className: 'btn btn-outline message-photo-btn', title: 'Attach photos' }, [
+        'Photo',
+        createElement('input', {
+          type: 'file',
+          name: 'photos',
+          accept: 'image/jpeg,image/png,image/gif',
+          multiple: true,
+          style: { display: 'none' }
+        })
+      ]),
       createElement('button', {
         type: 'submit',
         className: 'btn btn-primary'
@@ -280,13 +484,10 @@ function displayConversation(conversation) {
     formContainer.appendChild(formElement);
     
     // Check if this is a new conversation from listing
-    const urlParams = new URLSearchParams(window.location.search);
-    const listingId = urlParams.get('listingId');
-    
-    if (listingId && conversation.messages.length === 0) {
+    if (conversation.listingId && (!conversation.messages || conversation.messages.length === 0)) {
       console.log('New conversation with listing ID, fetching listing details');
       // Fetch listing details to show in message area
-      fetchListingForMessage(listingId);
+      fetchListingForMessage(conversation.listingId);
     }
   } catch (error) {
     console.error('Error in displayConversation:', error);
@@ -294,6 +495,20 @@ function displayConversation(conversation) {
   }
 }
 
+/**
+ * Scroll a message of the displayed conversation into view and highlight it
+ * @param {string} messageId - ID of the message
+ */
+function highlightMessage(messageId) {
+  const element = document.querySelector(`#message-body [data-message-id="${CSS.escape(messageId)}"]`);
+  if (!element) {
+    return;
+  }
+  
+  element.scrollIntoView({ block: 'center' });
+  element.classList.add('message-highlight');
+}
+
 /**
  * Display empty conversation state
  */
@@ -319,15 +534,63 @@ function displayEmptyConversation() {
 }
 
 /**
- * Update active conversation in the list
- * @param {string} userId - ID of the active conversation user
+ * Create the archive and mute buttons of a conversation
+ * @param {Object} conversation - Conversation data
+ * @returns {HTMLElement} Actions element
+ */
+function createConversationActions(conversation) {
+  return createElement('div', { className: 'conversation-actions' }, [
+    createElement('button', {
+      className: 'btn btn-outline btn-sm',
+      onclick: () => updateConversation(conversation.id, 'archive', conversation.archived ? 'DELETE' : 'POST')
+    }, conversation.archived ? 'Unarchive' : 'Archive'),
+    createElement('button', {
+      className: 'btn btn-outline btn-sm',
+      title: 'Muted conversations stay archived when new messages arrive',
+      onclick: () => updateConversation(conversation.id, 'mute', conversation.muted ? 'DELETE' : 'POST')
+    }, conversation.muted ? 'Unmute' : 'Mute')
+  ]);
+}
+
+/**
+ * Archive, unarchive, mute or unmute the open conversation
+ * @param {string} conversationId - ID of the conversation
+ * @param {string} action - archive or mute
+ * @param {string} method - POST to set, DELETE to clear
  */
-function updateActiveConversation(userId) {
+async function updateConversation(conversationId, action, method) {
+  try {
+    const response = await fetch(`/api/v1/conversations/${conversationId}/${action}`, { method });
+    if (!response.ok) {
+      throw new Error(await readErrorMessage(response, 'Failed to update conversation'));
+    }
+    
+    const updated = await response.json();
+    currentConversation = { ...updated, messages: currentConversation.messages };
+    displayConversation(currentConversation);
+    
+    await fetchConversations();
+    updateActiveConversation(conversationId);
+  } catch (error) {
+    console.error('Error updating conversation:', error);
+    displayError(error.message || 'Failed to update conversation. Please try again.');
+  }
+}
+
+/**
+ * Update active conversation in the list; it has just been read
+ * @param {string} conversationId - ID of the active conversation
+ */
+function updateActiveConversation(conversationId) {
   const items = document.querySelectorAll('.conversation-item');
   
   items.forEach(item => {
-    if (item.dataset.userId === userId) {
+    if (item.dataset.conversationId === conversationId) {
       item.classList.add('active');
+      const badge = item.querySelector('.conversation-badge');
+      if (badge) {
+        badge.remove();
+      }
     } else {
       item.classList.remove('active');
     }
@@ -337,28 +600,24 @@ function updateActiveConversation(userId) {
 /**
  * Send a message
  * @param {Event} event - Form submit event
- * @param {string} recipientId - ID of the recipient
+ * @param {Object} conversation - Conversation the message is sent in
  */
-async function sendMessage(event, recipientId) {
+async function sendMessage(event, conversation) {
   event.preventDefault();
   
   const form = event.target;
   const messageInput = form.querySelector('input[name="message"]');
+  const photoInput = form.querySelector('input[name="photos"]');
+  const photos = photoInput ? Array.from(photoInput.files) : [];
   
-  if (!messageInput || !messageInput.value.trim()) {
+  if (!messageInput || (!messageInput.value.trim() && photos.length === 0)) {
     return;
   }
   
   const message = messageInput.value.trim();
   
-  // Get listing ID from URL or use the most recent one
-  const urlParams = new URLSearchParams(window.location.search);
-  let listingId = urlParams.get('listingId');
-  
-  if (!listingId && currentConversation && currentConversation.messages && currentConversation.messages.length > 0) {
-    // Use listing ID from most recent message
-    listingId = currentConversation.messages[0].listing.id;
-  }
+  // Messages go to the conversation's listing
+  const listingId = conversation.listingId;
   
   if (!listingId) {
     displayError('Cannot send message: No listing selected.');
@@ -366,43 +625,229 @@ async function sendMessage(event, recipientId) {
   }
   
   try {
-    const response = await fetch('/api/messages', {
+    // Upload photos first, then send them by ID
+    const attachmentIds = [];
+    for (const photo of photos) {
+      const attachment = await uploadAttachment(photo);
+      attachmentIds.push(attachment.id);
+    }
+    
+    const response = await fetch('/api/v1/messages', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json'
       },
       body: JSON.stringify({
-        toID: recipientId,
+        toID: conversation.userId,
         listingID: listingId,
-        content: message
+        content: message,
+        attachmentIds: attachmentIds
       })
     });
     
     if (!response.ok) {
-      throw new Error('Failed to send message');
+      throw new Error(await readErrorMessage(response, 'Failed to send message'));
+    }
+    
+    // Show any regional warnings returned with the message
+    const sent = await response.json();
+    if (sent.warnings && sent.warnings.length > 0) {
+      displayError(sent.warnings.map(warning => warning.reason).join(' '));
     }
     
-    // Clear input
+    // Clear inputs
     messageInput.value = '';
+    if (photoInput) {
+      photoInput.value = '';
+    }
     
-    // Reload conversation to show new message
-    await fetchConversation(recipientId);
+    // Messages held for moderation are not in the conversation yet
+    if (sent.held) {
+      displayError(sent.held.message);
+      return;
+    }
     
-    // Also reload conversations list to update last message
+    // Reload conversations list to update last message, then the conversation
+    // to show the new message
     await fetchConversations();
+    await fetchConversation(sent.conversationId);
   } catch (error) {
     console.error('Error sending message:', error);
-    displayError('Failed to send message. Please try again.');
+    displayError(error.message || 'Failed to send message. Please try again.');
+  }
+}
+
+/**
+ * Create the edit and delete links of a message
+ * @param {Object} message - Message data
+ * @param {boolean} isSentByCurrentUser - Whether the current user sent it
+ * @returns {HTMLElement|null} Actions element, or null for deleted messages
+ */
+function createMessageActions(message, isSentByCurrentUser) {
+  if (message.deletedAt) {
+    return null;
+  }
+  
+  const action = (label, onclick) => createElement('a', {
+    href: '#',
+    onclick: (event) => {
+      event.preventDefault();
+      onclick();
+    }
+  }, label);
+  
+  const actions = [action('Delete for me', () => deleteMessage(message, false))];
+  if (isSentByCurrentUser) {
+    actions.unshift(action('Edit', () => editMessage(message)));
+    actions.push(action('Delete for everyone', () => deleteMessage(message, true)));
+  }
+  return createElement('div', { className: 'message-actions' }, actions);
+}
+
+/**
+ * Edit a message the current user sent
+ * @param {Object} message - Message data
+ */
+async function editMessage(message) {
+  const content = prompt('Edit your message', message.content);
+  if (content === null || content.trim() === message.content) {
+    return;
+  }
+  
+  try {
+    const response = await fetch(`/api/v1/messages/${message.id}`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json'
+      },
+      body: JSON.stringify({ content: content.trim() })
+    });
+    if (!response.ok) {
+      throw new Error(await readErrorMessage(response, 'Failed to edit message'));
+    }
+    
+    // Edits held for moderation are saved once reviewed
+    const edited = await response.json();
+    if (edited.held) {
+      displayError(edited.held.message);
+      return;
+    }
+    
+    await fetchConversation(message.conversationId);
+  } catch (error) {
+    console.error('Error editing message:', error);
+    displayError(error.message || 'Failed to edit message. Please try again.');
   }
 }
 
+/**
+ * Delete a message for the current user, or for both participants
+ * @param {Object} message - Message data
+ * @param {boolean} everyone - Delete it for both participants
+ */
+async function deleteMessage(message, everyone) {
+  const question = everyone
+    ? 'Delete this message for everyone? It will be removed for both of you.'
+    : 'Delete this message for you? The other person will still see it.';
+  if (!confirm(question)) {
+    return;
+  }
+  
+  try {
+    const response = await fetch(`/api/v1/messages/${message.id}${everyone ? '?everyone=true' : ''}`, {
+      method: 'DELETE'
+    });
+    if (!response.ok) {
+      throw new Error(await readErrorMessage(response, 'Failed to delete message'));
+    }
+    
+    await fetchConversations();
+    await fetchConversation(message.conversationId);
+  } catch (error) {
+    console.error('Error deleting message:', error);
+    displayError(error.message || 'Failed to delete message. Please try again.');
+  }
+}
+
+/**
+ * Show or hide the earlier versions of an edited message below it
+ * @param {Event} event - Click event on the edited label
+ * @param {Object} message - Message data
+ */
+async function toggleMessageEdits(event, message) {
+  event.preventDefault();
+  
+  const bubble = event.target.closest('.message-bubble');
+  const shown = bubble.querySelector('.message-edits');
+  if (shown) {
+    shown.remove();
+    return;
+  }
+  
+  try {
+    const response = await fetch(`/api/v1/messages/${message.id}/edits`);
+    if (!response.ok) {
+      throw new Error(await readErrorMessage(response, 'Failed to load earlier versions'));
+    }
+    
+    const edits = await response.json();
+    bubble.appendChild(createElement('ul', { className: 'message-edits' }, edits.map(edit =>
+      createElement('li', {}, `${edit.content} (until ${formatTime(edit.editedAt)})`)
+    )));
+  } catch (error) {
+    console.error('Error loading message edits:', error);
+    displayError(error.message || 'Failed to load earlier versions.');
+  }
+}
+
+/**
+ * Upload a photo to attach to a message
+ * @param {File} photo - JPEG, PNG or GIF file
+ * @returns {Promise<Object>} The uploaded attachment
+ */
+async function uploadAttachment(photo) {
+  const formData = new FormData();
+  formData.append('file', photo);
+  
+  const response = await fetch('/api/v1/messages/attachments', {
+    method: 'POST',
+    body: formData
+  });
+  if (!response.ok) {
+    throw new Error(await readErrorMessage(response, `Failed to upload ${photo.name}`));
+  }
+  return response.json();
+}
+
+/**
+ * Create thumbnails linking to a message's photos
+ * @param {Array} attachments - Message attachments, if any
+ * @returns {HTMLElement|null} Thumbnails element, or null without attachments
+ */
+function createMessageAttachments(attachments) {
+  if (!attachments || attachments.length === 0) {
+    return null;
+  }
+  
+  return createElement('div', { className: 'message-attachments' }, attachments.map(attachment =>
+    createElement('a', { href: attachment.url, target: '_blank', rel: 'noopener' }, [
+      createElement('img', {
+        src: attachment.thumbnailUrl,
+        alt: 'Photo',
+        className: 'message-attachment',
+        loading: 'lazy'
+      })
+    ])
+  ));
+}
+
 /**
  * Fetch listing details for new conversation
  * @param {string} listingId - ID of the listing
  */
 async function fetchListingForMessage(listingId) {
   try {
-    const response = await fetch(`/api/listings/${listingId}`);
+    const response = await fetch(`/api/v1/listings/${listingId}`);
     if (!response.ok) {
       throw new Error('Failed to fetch listing');
     }
@@ -465,6 +910,27 @@ document.addEventListener('DOMContentLoaded', () => {
   // Load conversations
   const messagesContainer = document.querySelector('.messages-container');
   if (messagesContainer) {
-    fetchConversations();
+    fetchConversations().then(conversations => {
+      if (currentUser) {
+        openInitialConversation(conversations);
+      }
+    });
   }
 });
+
+/**
+ * Create a notice that a listing was edited during a conversation
+ * @param {Object} listing - Listing data with edit indicators
+ * @returns {HTMLElement} Notice element
+ */
+function createListingEditNotice(listing) {
+  let text = `"${listing.title}" was edited on ${formatDate(listing.lastEditedAt)}.`;
+  if (listing.previousPrice) {
+    text += ` Price dropped from ${formatCurrency(listing.previousPrice)} to ${formatCurrency(listing.price)}.`;
+  }
+  
+  return createElement('div', { className: 'listing-edit-notice' }, [
+    createElement('span', {}, text + ' '),
+    createElement('a', { href: `/listing/${listing.id}` }, 'View listing')
+  ]);
+}
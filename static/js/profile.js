@@ -14,7 +14,7 @@ async function fetchUserProfile(userId = null) {
     }
 
     // Determine which endpoint to use
-    const url = userId ? `/api/users/${userId}` : '/api/users/current';
+    const url = userId ? `/api/v1/users/${userId}` : '/api/v1/users/current';
     
     const response = await fetch(url);
     if (!response.ok) {
@@ -26,8 +26,11 @@ async function fetchUserProfile(userId = null) {
     // Display the profile information
     displayProfile(profile);
     
-    // Load user's listings
-    fetchUserListings(profile.id);
+    // Load user's listings, unless the server already rendered a page of them
+    const listingsContainer = document.getElementById('user-listings');
+    if (!listingsContainer || !listingsContainer.dataset.rendered) {
+      fetchUserListings(profile.id);
+    }
     
     return profile;
   } catch (error) {
@@ -73,6 +76,14 @@ function displayProfile(profile) {
     el.alt = profile.name || profile.username;
   });
   
+  // Follow counts
+  document.querySelectorAll('.profile-follower-count').forEach(el => {
+    el.textContent = profile.followerCount;
+  });
+  document.querySelectorAll('.profile-following-count').forEach(el => {
+    el.textContent = profile.followingCount;
+  });
+  
   // Join date
   const joinDateElements = document.querySelectorAll('.profile-join-date');
   joinDateElements.forEach(el => {
@@ -87,6 +98,9 @@ function displayProfile(profile) {
       editButtons.forEach(btn => {
         btn.style.display = 'block';
       });
+      
+      // Show data export and account deletion
+      setupAccountData();
     }
   });
 }
@@ -97,7 +111,7 @@ function displayProfile(profile) {
  */
 async function fetchUserListings(userId) {
   try {
-    const response = await fetch(`/api/listings?userId=${userId}`);
+    const response = await fetch(`/api/v1/listings?userId=${userId}`);
     if (!response.ok) {
       throw new Error('Failed to fetch user listings');
     }
@@ -135,6 +149,35 @@ async function fetchUserListings(userId) {
   }
 }
 
+/**
+ * Follow or unfollow the user whose profile is shown
+ * @param {HTMLButtonElement} button - Follow button
+ */
+async function toggleFollow(button) {
+  const userId = document.getElementById('profile-container').dataset.userId;
+  const following = button.dataset.following === 'true';
+  
+  button.disabled = true;
+  try {
+    const response = await fetch(`/api/v1/users/${userId}/follow`, { method: following ? 'DELETE' : 'POST' });
+    if (!response.ok) {
+      throw new Error('Failed to update follow');
+    }
+    
+    const status = await response.json();
+    button.dataset.following = String(status.following);
+    button.textContent = status.following ? 'Unfollow' : 'Follow';
+    document.querySelectorAll('.profile-follower-count').forEach(el => {
+      el.textContent = status.followerCount;
+    });
+  } catch (error) {
+    console.error('Error updating follow:', error);
+    displayError('Failed to update follow. Please try again later.');
+  } finally {
+    button.disabled = false;
+  }
+}
+
 /**
  * Handle profile update form submission
  * @param {Event} event - Form submit event
@@ -159,7 +202,7 @@ async function updateProfile(event) {
   });
   
   try {
-    const response = await fetch(`/api/users/${currentUser.id}`, {
+    const response = await fetch(`/api/v1/users/${currentUser.id}`, {
       method: 'PUT',
       headers: {
         'Content-Type': 'application/json'
@@ -168,8 +211,7 @@ async function updateProfile(event) {
     });
     
     if (!response.ok) {
-      const errorData = await response.json();
-      throw new Error(errorData.message || 'Failed to update profile');
+      throw new Error(await readErrorMessage(response, 'Failed to update profile'));
     }
     
     const updatedProfile = await response.json();
@@ -266,6 +308,153 @@ function toggleEditProfile() {
   }
 }
 
+/**
+ * Show the data export and account deletion section on the user's own profile
+ */
+function setupAccountData() {
+  const section = document.getElementById('account-data');
+  if (!section) return;
+  section.style.display = 'block';
+  
+  document.getElementById('request-export-btn').addEventListener('click', requestDataExport);
+  document.getElementById('delete-account-btn').addEventListener('click', deleteAccount);
+  
+  fetchDataExports();
+  fetchAccountDeletion();
+}
+
+/**
+ * Fetch and display the user's data exports
+ */
+async function fetchDataExports() {
+  const container = document.getElementById('data-exports');
+  if (!container) return;
+  
+  try {
+    const response = await fetch('/api/v1/account/exports');
+    if (!response.ok) {
+      throw new Error('Failed to fetch exports');
+    }
+    
+    const exports = await response.json();
+    container.innerHTML = '';
+    
+    exports.forEach(dataExport => {
+      let status;
+      if (dataExport.status === 'ready') {
+        status = createElement('a', { href: `/api/v1/account/exports/${dataExport.id}/download` },
+          `Download (available until ${formatDate(dataExport.expiresAt)})`);
+      } else if (dataExport.status === 'failed') {
+        status = createElement('span', { className: 'text-error' }, dataExport.error || 'Export failed');
+      } else {
+        status = createElement('span', {}, 'Being prepared...');
+      }
+      
+      container.appendChild(createElement('div', { className: 'mb-2' }, [
+        createElement('span', {}, `Requested ${formatDate(dataExport.requestedAt)}: `),
+        status
+      ]));
+    });
+    
+    // Check again while an export is being prepared
+    if (exports.some(dataExport => dataExport.status === 'pending' || dataExport.status === 'processing')) {
+      setTimeout(fetchDataExports, 5000);
+    }
+  } catch (error) {
+    console.error('Error fetching data exports:', error);
+  }
+}
+
+/**
+ * Request a new data export
+ */
+async function requestDataExport() {
+  try {
+    const response = await fetch('/api/v1/account/export', { method: 'POST' });
+    if (!response.ok) {
+      throw new Error('Failed to request export');
+    }
+    
+    fetchDataExports();
+  } catch (error) {
+    console.error('Error requesting data export:', error);
+    displayError('Failed to request data export. Please try again later.');
+  }
+}
+
+/**
+ * Show whether the account is scheduled for deletion, with an option to cancel
+ */
+async function fetchAccountDeletion() {
+  const container = document.getElementById('account-deletion-notice');
+  if (!container) return;
+  
+  try {
+    const response = await fetch('/api/v1/account/deletion');
+    if (!response.ok) {
+      throw new Error('Failed to fetch account deletion status');
+    }
+    
+    const deletion = await response.json();
+    container.innerHTML = '';
+    if (!deletion.deletionScheduledAt) return;
+    
+    container.appendChild(createElement('div', { className: 'notification' }, [
+      createElement('span', {}, `Your account will be deleted on ${formatDate(deletion.deletionScheduledAt)}. `),
+      createElement('button', {
+        type: 'button',
+        className: 'btn btn-outline btn-sm',
+        onclick: cancelAccountDeletion
+      }, 'Keep my account')
+    ]));
+  } catch (error) {
+    console.error('Error fetching account deletion status:', error);
+  }
+}
+
+/**
+ * Schedule the account for deletion after confirming the password
+ */
+async function deleteAccount() {
+  const password = prompt('This will delete your account and listings. Enter your password to confirm.');
+  if (!password) return;
+  
+  try {
+    const response = await fetch('/api/v1/account/deletion', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ password })
+    });
+    if (!response.ok) {
+      throw new Error(await readErrorMessage(response, 'Failed to delete account'));
+    }
+    
+    const deletion = await response.json();
+    alert(`Your account will be deleted on ${formatDate(deletion.deletionScheduledAt)}. Log in before then to cancel.`);
+    window.location.href = '/';
+  } catch (error) {
+    console.error('Error deleting account:', error);
+    displayError(error.message);
+  }
+}
+
+/**
+ * Cancel a scheduled account deletion
+ */
+async function cancelAccountDeletion() {
+  try {
+    const response = await fetch('/api/v1/account/deletion', { method: 'DELETE' });
+    if (!response.ok) {
+      throw new Error('Failed to cancel account deletion');
+    }
+    
+    fetchAccountDeletion();
+  } catch (error) {
+    console.error('Error cancelling account deletion:', error);
+    displayError('Failed to cancel account deletion. Please try again later.');
+  }
+}
+
 /**
  * Display an error message
  * @param {string} message - Error message to display
@@ -291,14 +480,19 @@ document.addEventListener('DOMContentLoaded', () => {
   // Check if on profile page
   const profileContainer = document.getElementById('profile-container');
   if (profileContainer) {
-    // Extract user ID from URL if present
-    const pathParts = window.location.pathname.split('/');
-    const userId = pathParts[pathParts.length - 1] !== 'profile' ? pathParts[pathParts.length - 1] : null;
+    // Public profiles at /u/{username} carry the user's ID; /profile is the current user's
+    const userId = window.location.pathname === '/profile' ? null : profileContainer.dataset.userId;
     
     // Load profile
     fetchUserProfile(userId);
   }
   
+  // Follow button on public profiles
+  const followBtn = document.getElementById('follow-btn');
+  if (followBtn) {
+    followBtn.addEventListener('click', () => toggleFollow(followBtn));
+  }
+  
   // Edit profile form
   const editProfileForm = document.getElementById('edit-profile-form');
   if (editProfileForm) {
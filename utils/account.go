@@ -0,0 +1,369 @@
+package utils
+
+import (
+        "archive/zip"
+        "bytes"
+        "context"
+        "encoding/base64"
+        "encoding/json"
+        "fmt"
+        "mime"
+        "strings"
+        "time"
+
+        "github.com/plantexchange/app/config"
+        "github.com/plantexchange/app/models"
+)
+
+// AccountDeletionGrace is how long a deletion request can be cancelled before the account is purged
+// (accounts.deletionGraceDays, ACCOUNT_DELETION_GRACE_DAYS)
+func AccountDeletionGrace() time.Duration {
+        return config.Days(settings.Accounts.DeletionGraceDays)
+}
+
+// DataExportLifetime is how long a generated data export can be downloaded
+// (accounts.dataExportLifetimeDays, DATA_EXPORT_LIFETIME_DAYS)
+func DataExportLifetime() time.Duration {
+        return config.Days(settings.Accounts.DataExportLifetimeDays)
+}
+
+// dataExportTimeout is how long an export may be processing before another attempt is made
+const dataExportTimeout = time.Hour
+
+// AccountJobs returns the background jobs that generate data exports and purge deleted accounts
+func AccountJobs() []Job {
+        return []Job{
+                {Name: "generate-data-exports", Run: generatePendingDataExports},
+                {Name: "delete-expired-data-exports", Run: deleteExpiredDataExports},
+                {Name: "purge-deleted-accounts", Run: purgeDeletedAccounts},
+        }
+}
+
+// generatePendingDataExports builds any exports that were not generated when requested,
+// e.g. because the server restarted
+func generatePendingDataExports(ctx context.Context, now time.Time) error {
+        exports, err := GetUnfinishedDataExports(ctx, now.Add(-dataExportTimeout))
+        if err != nil {
+                return err
+        }
+
+        for _, export := range exports {
+                if err := GenerateDataExport(ctx, export.ID); err != nil {
+                        return err
+                }
+        }
+
+        return nil
+}
+
+// deleteExpiredDataExports removes exports that can no longer be downloaded
+func deleteExpiredDataExports(ctx context.Context, now time.Time) error {
+        deleted, err := DeleteExpiredDataExports(ctx, now)
+        if err != nil {
+                return err
+        }
+
+        if deleted > 0 {
+                Logger(ctx).Info("Deleted expired data exports", "count", deleted)
+        }
+        return nil
+}
+
+// purgeDeletedAccounts purges accounts whose deletion grace period has ended
+func purgeDeletedAccounts(ctx context.Context, now time.Time) error {
+        userIDs, err := GetAccountsDueForPurge(ctx, now)
+        if err != nil {
+                return err
+        }
+
+        for _, userID := range userIDs {
+                if err := PurgeAccount(ctx, userID, now); err != nil {
+                        return err
+                }
+                Logger(ctx).Info("Purged deleted account", "userId", userID)
+        }
+
+        return nil
+}
+
+// GenerateDataExport builds the zip for an export request and stores it. It is safe to
+// call from several goroutines; only the first caller to claim the export builds it.
+// A failure to build the zip is recorded on the export rather than returned.
+func GenerateDataExport(ctx context.Context, exportID string) error {
+        now := time.Now()
+        claimed, err := ClaimDataExport(ctx, exportID, now, now.Add(-dataExportTimeout))
+        if err != nil || !claimed {
+                return err
+        }
+
+        export, err := GetDataExport(ctx, exportID)
+        if err != nil {
+                return err
+        }
+
+        file, err := buildDataExport(ctx, export.UserID)
+        if err != nil {
+                Logger(ctx).Error("Cannot build data export", "exportId", exportID, "error", err)
+                return FailDataExport(ctx, exportID, "Could not generate export", time.Now())
+        }
+
+        completedAt := time.Now()
+        expiresAt := completedAt.Add(DataExportLifetime())
+        if err := CompleteDataExport(ctx, exportID, file, completedAt, expiresAt); err != nil {
+                return err
+        }
+
+        _, err = SaveNotification(ctx, models.Notification{
+                UserID:    export.UserID,
+                Kind:      models.NotificationDataExportReady,
+                Message:   fmt.Sprintf("Your data export is ready to download until %s.", expiresAt.Format("2 Jan 2006")),
+                CreatedAt: completedAt,
+        })
+        return err
+}
+
+// exportProfile is the profile file of a data export; unlike UserResponse it includes private fields
+type exportProfile struct {
+        models.UserResponse
+        Email               string     `json:"email"`
+        LastLoginAt         time.Time  `json:"lastLoginAt"`
+        DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
+}
+
+// exportMessage is a message in a data export with the other participant's name
+type exportMessage struct {
+        models.Message
+        Direction string `json:"direction"` // sent, received
+        OtherUser string `json:"otherUser"`
+}
+
+// exportHeldContent is a message or listing held for moderation in a data export;
+// how screening scored it is left out
+type exportHeldContent struct {
+        Kind       string          `json:"kind"`
+        Content    json.RawMessage `json:"content"`
+        Status     string          `json:"status"`
+        CreatedAt  time.Time       `json:"createdAt"`
+        ReviewedAt *time.Time      `json:"reviewedAt,omitempty"`
+}
+
+// buildDataExport builds a zip of JSON files holding all of a user's personal data.
+// Uploaded images are written as files and referenced by path from listings.json.
+func buildDataExport(ctx context.Context, userID string) ([]byte, error) {
+        user, err := GetUser(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+
+        var buf bytes.Buffer
+        archive := zip.NewWriter(&buf)
+
+        writeJSON := func(name string, value interface{}) error {
+                file, err := archive.Create(name)
+                if err != nil {
+                        return err
+                }
+                encoder := json.NewEncoder(file)
+                encoder.SetIndent("", "  ")
+                return encoder.Encode(value)
+        }
+
+        // Profile
+        err = writeJSON("profile.json", exportProfile{
+                UserResponse:        user.ToUserResponse(),
+                Email:               user.Email,
+                LastLoginAt:         user.LastLoginAt,
+                DeletionScheduledAt: user.DeletionScheduledAt,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        // Listings with their care sheets, images and edit history
+        listings := []models.Listing{}
+        revisions := []models.ListingRevision{}
+        summaries, err := GetListingsByUser(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+        for _, summary := range summaries {
+                listing, err := GetListing(ctx, summary.ID)
+                if err != nil {
+                        return nil, err
+                }
+
+                for i, image := range listing.Images {
+                        content, extension, ok := decodeDataURL(image)
+                        if !ok {
+                                continue
+                        }
+                        name := fmt.Sprintf("images/listing-%s-%d%s", listing.ID, i+1, extension)
+                        file, err := archive.Create(name)
+                        if err != nil {
+                                return nil, err
+                        }
+                        if _, err := file.Write(content); err != nil {
+                                return nil, err
+                        }
+                        listing.Images[i] = name
+                }
+
+                listingRevisions, err := GetListingRevisions(ctx, listing.ID)
+                if err != nil {
+                        return nil, err
+                }
+
+                listings = append(listings, listing)
+                revisions = append(revisions, listingRevisions...)
+        }
+        if err := writeJSON("listings.json", listings); err != nil {
+                return nil, err
+        }
+        if err := writeJSON("listing_revisions.json", revisions); err != nil {
+                return nil, err
+        }
+
+        // Messages sent and received
+        usernames := map[string]string{}
+        messages := []exportMessage{}
+        userMessages, err := GetMessagesByUser(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+        for _, message := range userMessages {
+                exported := exportMessage{Message: message, Direction: "sent", OtherUser: message.ToID}
+                if message.ToID == userID {
+                        exported.Direction = "received"
+                        exported.OtherUser = message.FromID
+                }
+                if _, ok := usernames[exported.OtherUser]; !ok {
+                        if other, err := GetUser(ctx, exported.OtherUser); err == nil {
+                                usernames[exported.OtherUser] = other.Username
+                        }
+                }
+                if username, ok := usernames[exported.OtherUser]; ok {
+                        exported.OtherUser = username
+                }
+
+                // Photos the user sent are included in the zip; those received keep their API URL
+                for i, attachment := range exported.Attachments {
+                        exported.Attachments[i].ThumbnailURL = ""
+                        if attachment.UploaderID != userID {
+                                continue
+                        }
+                        content, err := GetMessageAttachmentContent(ctx, attachment.ID, false)
+                        if err != nil {
+                                return nil, err
+                        }
+                        extension := ""
+                        if extensions, err := mime.ExtensionsByType(attachment.ContentType); err == nil && len(extensions) > 0 {
+                                extension = extensions[0]
+                        }
+                        name := fmt.Sprintf("attachments/attachment-%s%s", attachment.ID, extension)
+                        file, err := archive.Create(name)
+                        if err != nil {
+                                return nil, err
+                        }
+                        if _, err := file.Write(content); err != nil {
+                                return nil, err
+                        }
+                        exported.Attachments[i].URL = name
+                }
+                messages = append(messages, exported)
+        }
+        if err := writeJSON("messages.json", messages); err != nil {
+                return nil, err
+        }
+
+        // Conversations with the user's archive and mute settings; their messages are in messages.json
+        conversations := []models.Conversation{}
+        for _, archived := range []bool{false, true} {
+                listed, err := GetConversations(ctx, userID, archived)
+                if err != nil {
+                        return nil, err
+                }
+                conversations = append(conversations, listed...)
+        }
+        if err := writeJSON("conversations.json", conversations); err != nil {
+                return nil, err
+        }
+
+        // Messages and listings held for moderation, as submitted
+        held, err := GetHeldContentByUser(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+        exportedHeld := []exportHeldContent{}
+        for _, item := range held {
+                exportedHeld = append(exportedHeld, exportHeldContent{
+                        Kind:       item.Kind,
+                        Content:    item.Content,
+                        Status:     item.Status,
+                        CreatedAt:  item.CreatedAt,
+                        ReviewedAt: item.ReviewedAt,
+                })
+        }
+        if err := writeJSON("held.json", exportedHeld); err != nil {
+                return nil, err
+        }
+
+        // Favorites, follows and notifications
+        favorites, err := GetFavorites(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+        if err := writeJSON("favorites.json", favorites); err != nil {
+                return nil, err
+        }
+        following, err := GetFollowing(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+        followedUsernames := []string{} // exported by username, as in messages.json
+        for _, id := range following {
+                if followed, err := GetUser(ctx, id); err == nil {
+                        followedUsernames = append(followedUsernames, followed.Username)
+                }
+        }
+        if err := writeJSON("following.json", followedUsernames); err != nil {
+                return nil, err
+        }
+        notifications, err := GetNotificationsByUser(ctx, userID)
+        if err != nil {
+                return nil, err
+        }
+        if err := writeJSON("notifications.json", notifications); err != nil {
+                return nil, err
+        }
+
+        if err := archive.Close(); err != nil {
+                return nil, err
+        }
+
+        return buf.Bytes(), nil
+}
+
+// decodeDataURL decodes a base64 data URL as stored for uploaded images and
+// returns its content and a file extension for its type
+func decodeDataURL(dataURL string) ([]byte, string, bool) {
+        if !strings.HasPrefix(dataURL, "data:") {
+                return nil, "", false
+        }
+
+        header, data, found := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+        if !found || !strings.HasSuffix(header, ";base64") {
+                return nil, "", false
+        }
+
+        content, err := base64.StdEncoding.DecodeString(data)
+        if err != nil {
+                return nil, "", false
+        }
+
+        extension := ""
+        if extensions, err := mime.ExtensionsByType(strings.TrimSuffix(header, ";base64")); err == nil && len(extensions) > 0 {
+                extension = extensions[0]
+        }
+
+        return content, extension, true
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+        "bytes"
+        "context"
+        "fmt"
+        "image"
+        "image/color"
+        "image/jpeg"
+        "net/http"
+        "strings"
+        "time"
+
+        // Decoders for the accepted attachment types
+        _ "image/gif"
+        _ "image/png"
+
+        "github.com/plantexchange/app/models"
+)
+
+// Limits for message attachments
+const (
+        MaxAttachmentSize     = 5 << 20 // 5 MB per photo
+        MaxMessageAttachments = 4
+        maxAttachmentPixels   = 40_000_000 // larger images are refused before being decoded
+        thumbnailSize         = 320        // longest side of a thumbnail, in pixels
+        thumbnailQuality      = 80
+)
+
+// unsentAttachmentLifetime is how long an uploaded photo is kept if it is never sent
+const unsentAttachmentLifetime = 24 * time.Hour
+
+// AttachmentTypes are the accepted content types of message attachments
+var AttachmentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// AttachmentJobs returns the background jobs that delete photos uploaded but never sent
+func AttachmentJobs() []Job {
+        return []Job{
+                {Name: "delete-unsent-attachments", Run: deleteUnsentAttachments},
+        }
+}
+
+// deleteUnsentAttachments removes uploads that were not sent with a message in time
+func deleteUnsentAttachments(ctx context.Context, now time.Time) error {
+        deleted, err := DeleteUnsentAttachments(ctx, now.Add(-unsentAttachmentLifetime))
+        if err != nil {
+                return err
+        }
+
+        if deleted > 0 {
+                Logger(ctx).Info("Deleted unsent attachments", "count", deleted)
+        }
+        return nil
+}
+
+// PrepareAttachment checks an uploaded photo and makes its thumbnail. The content
+// type is detected from the content rather than trusted from the upload.
+func PrepareAttachment(uploaderID string, content []byte) (models.MessageAttachment, []byte, error) {
+        if len(content) == 0 {
+                return models.MessageAttachment{}, nil, ValidationError("Attachment is empty", map[string]string{"file": "is required"})
+        }
+        if len(content) > MaxAttachmentSize {
+                return models.MessageAttachment{}, nil, ValidationError(fmt.Sprintf("Attachments must not be larger than %d MB", MaxAttachmentSize>>20),
+                        map[string]string{"file": "is too large"})
+        }
+
+        contentType := http.DetectContentType(content)
+        accepted := false
+        for _, attachmentType := range AttachmentTypes {
+                accepted = accepted || contentType == attachmentType
+        }
+        if !accepted {
+                return models.MessageAttachment{}, nil, ValidationError("Attachments must be JPEG, PNG or GIF photos",
+                        map[string]string{"file": "must be one of " + strings.Join(AttachmentTypes, ", ")})
+        }
+
+        // Check the dimensions before decoding, so a small file cannot claim a huge image
+        config, _, err := image.DecodeConfig(bytes.NewReader(content))
+        if err != nil || config.Width <= 0 || config.Height <= 0 {
+                return models.MessageAttachment{}, nil, ValidationError("Attachment is not a valid image", map[string]string{"file": "cannot be read"})
+        }
+        if config.Width*config.Height > maxAttachmentPixels {
+                return models.MessageAttachment{}, nil, ValidationError("Attachment has too many pixels", map[string]string{"file": "is too large"})
+        }
+
+        img, _, err := image.Decode(bytes.NewReader(content))
+        if err != nil {
+                return models.MessageAttachment{}, nil, ValidationError("Attachment is not a valid image", map[string]string{"file": "cannot be read"})
+        }
+
+        thumbnail, err := makeThumbnail(img)
+        if err != nil {
+                return models.MessageAttachment{}, nil, InternalError(err)
+        }
+
+        attachment := models.MessageAttachment{
+                UploaderID:  uploaderID,
+                ContentType: contentType,
+                Size:        len(content),
+                Width:       config.Width,
+                Height:      config.Height,
+        }
+        return attachment, thumbnail, nil
+}
+
+// makeThumbnail scales an image to fit thumbnailSize and encodes it as a JPEG.
+// Each thumbnail pixel averages a grid of samples from the area it covers, and
+// transparent areas are shown on white.
+func makeThumbnail(img image.Image) ([]byte, error) {
+        bounds := img.Bounds()
+        width, height := bounds.Dx(), bounds.Dy()
+        scale := 1.0
+        if width > thumbnailSize || height > thumbnailSize {
+                scale = float64(thumbnailSize) / float64(max(width, height))
+        }
+        thumbWidth, thumbHeight := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+
+        thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+
+        const samples = 4 // per axis
+        for y := 0; y < thumbHeight; y++ {
+                for x := 0; x < thumbWidth; x++ {
+                        var r, g, b, a uint32
+                        for sy := 0; sy < samples; sy++ {
+                                for sx := 0; sx < samples; sx++ {
+                                        srcX := bounds.Min.X + int((float64(x)+(float64(sx)+0.5)/samples)/scale)
+                                        srcY := bounds.Min.Y + int((float64(y)+(float64(sy)+0.5)/samples)/scale)
+                                        pr, pg, pb, pa := img.At(min(srcX, bounds.Max.X-1), min(srcY, bounds.Max.Y-1)).RGBA()
+                                        r, g, b, a = r+pr, g+pg, b+pb, a+pa
+                                }
+                        }
+                        // Colors are alpha-premultiplied, so white shows through by what is missing of alpha
+                        n := uint32(samples * samples)
+                        background := 0xffff - a/n
+                        thumb.Set(x, y, color.RGBA64{R: uint16(r/n + background), G: uint16(g/n + background), B: uint16(b/n + background), A: 0xffff})
+                }
+        }
+
+        var buf bytes.Buffer
+        if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+                return nil, err
+        }
+        return buf.Bytes(), nil
+}
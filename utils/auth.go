@@ -3,25 +3,29 @@ package utils
 import (
         "crypto/rand"
         "encoding/base64"
-        "log"
+        "log/slog"
 
         "github.com/gorilla/sessions"
         "golang.org/x/crypto/bcrypt"
+
+        "github.com/plantexchange/app/config"
 )
 
 var (
-        // Store for sessions
-        SessionStore = sessions.NewCookieStore([]byte("plant-exchange-secret-key"))
+        // Store for sessions; Configure replaces it with one using the configured secret
+        SessionStore = newSessionStore(config.Default().Session)
 )
 
-func init() {
-        // Configure the session store
-        SessionStore.Options = &sessions.Options{
+// newSessionStore creates a cookie session store from the session settings
+func newSessionStore(cfg config.SessionConfig) *sessions.CookieStore {
+        store := sessions.NewCookieStore([]byte(cfg.Secret))
+        store.Options = &sessions.Options{
                 Path:     "/",
-                MaxAge:   3600 * 24 * 30, // 30 days
+                MaxAge:   int(config.Days(cfg.MaxAgeDays).Seconds()),
                 HttpOnly: true,
+                Secure:   cfg.Secure,
         }
-        log.Println("Session store initialized with cookie options")
+        return store
 }
 
 // GenerateToken generates a random token
@@ -38,7 +42,7 @@ func GenerateToken() (string, error) {
 func HashPassword(password string) string {
         hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
         if err != nil {
-                log.Printf("Error hashing password: %v", err)
+                slog.Error("Cannot hash password", "error", err)
                 return ""
         }
         return string(hash)
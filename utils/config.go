@@ -0,0 +1,25 @@
+package utils
+
+import (
+        "log/slog"
+        "strings"
+
+        "github.com/plantexchange/app/config"
+)
+
+// settings is the configuration the package runs with. It holds the defaults until Configure is called.
+var settings = config.Default()
+
+// Configure applies the loaded configuration to logging, the database connection, session store and background jobs.
+// It must be called before InitDB.
+func Configure(cfg *config.Config) {
+        settings = cfg
+        SetupLogging(cfg.Log)
+        SessionStore = newSessionStore(cfg.Session)
+        slog.Info("Configured", "env", cfg.Env)
+}
+
+// PublicURL returns the configured public address of the site without a trailing slash, or "" if unset
+func PublicURL() string {
+        return strings.TrimSuffix(settings.Server.PublicURL, "/")
+}
@@ -1,37 +1,57 @@
 package utils
 
 import (
+        "context"
         "database/sql"
-        "log"
+        "errors"
+        "fmt"
+        "log/slog"
         "os"
         "time"
 
-        _ "github.com/lib/pq"
+        "github.com/lib/pq"
 )
 
 var db *sql.DB
 
+// schemaTables are the tables created by createTables; readiness checks that they all exist
+var schemaTables = []string{
+        "users", "listings", "listing_images", "listing_care_sheets", "listing_revisions",
+        "messages", "message_edits", "message_attachments", "conversations", "conversation_participants", "favorites", "follows", "notifications", "data_exports",
+        "moderation_queue",
+}
+
 // InitDB initializes the database connection
 func InitDB() {
         var err error
-        connStr := os.Getenv("DATABASE_URL")
+        connStr := settings.Database.URL
         if connStr == "" {
-                log.Fatal("DATABASE_URL environment variable not set")
+                slog.Error("Database URL not configured; set DATABASE_URL")
+                os.Exit(1)
         }
 
         // Connect to the database
         db, err = sql.Open("postgres", connStr)
         if err != nil {
-                log.Fatalf("Failed to open database: %v", err)
+                fatal("Failed to open database", err)
         }
 
+        // Size the connection pool
+        pool := settings.Database
+        db.SetMaxOpenConns(pool.MaxOpenConns)
+        db.SetMaxIdleConns(pool.MaxIdleConns)
+        db.SetConnMaxLifetime(time.Duration(pool.ConnMaxLifetime))
+        db.SetConnMaxIdleTime(time.Duration(pool.ConnMaxIdleTime))
+
         // Check the connection
-        err = db.Ping()
+        ctx, cancel := withQueryTimeout(context.Background())
+        defer cancel()
+        err = db.PingContext(ctx)
         if err != nil {
-                log.Fatalf("Failed to ping database: %v", err)
+                fatal("Failed to ping database", err)
         }
 
-        log.Println("Successfully connected to the database")
+        slog.Info("Connected to the database", "maxOpenConns", pool.MaxOpenConns, "maxIdleConns", pool.MaxIdleConns)
 
         // Create the required tables if they don't exist
         createTables()
@@ -55,7 +75,19 @@ func createTables() {
                 )
         `)
         if err != nil {
-                log.Fatalf("Failed to create users table: %v", err)
+                fatal("Failed to create users table", err)
+        }
+
+        // Track account deletion requests and purged accounts, and when the user's
+        // sessions were last revoked; sessions issued before then are no longer accepted
+        _, err = db.Exec(`
+                ALTER TABLE users
+                        ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP WITH TIME ZONE,
+                        ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE,
+                        ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMP WITH TIME ZONE
+        `)
+        if err != nil {
+                fatal("Failed to add deletion columns to users", err)
         }
 
         // Create listings table
@@ -76,7 +108,7 @@ func createTables() {
                 )
         `)
         if err != nil {
-                log.Fatalf("Failed to create listings table: %v", err)
+                fatal("Failed to create listings table", err)
         }
 
         // Create images table (for listing images)
@@ -89,7 +121,82 @@ func createTables() {
                 )
         `)
         if err != nil {
-                log.Fatalf("Failed to create listing_images table: %v", err)
+                fatal("Failed to create listing_images table", err)
+        }
+
+        // Listing expiry columns, added after the original schema
+        _, err = db.Exec(`
+                ALTER TABLE listings
+                        ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE,
+                        ADD COLUMN IF NOT EXISTS expiry_warned_at TIMESTAMP WITH TIME ZONE
+        `)
+        if err != nil {
+                fatal("Failed to add listing expiry columns", err)
+        }
+
+        // Scheduled publication time for draft listings
+        _, err = db.Exec(`ALTER TABLE listings ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP WITH TIME ZONE`)
+        if err != nil {
+                fatal("Failed to add listing publish_at column", err)
+        }
+
+        // Latest available listings, for the feeds and sitemap
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS listings_status_created_at ON listings (status, created_at DESC)`)
+        if err != nil {
+                fatal("Failed to create listings status index", err)
+        }
+
+        // Create care sheets table (one optional care sheet per listing)
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS listing_care_sheets (
+                        listing_id INTEGER PRIMARY KEY REFERENCES listings(id) ON DELETE CASCADE,
+                        species VARCHAR(100),
+                        light VARCHAR(200),
+                        water VARCHAR(200),
+                        humidity VARCHAR(200),
+                        temperature VARCHAR(100),
+                        pet_toxicity VARCHAR(50),
+                        propagation VARCHAR(200),
+                        updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create listing_care_sheets table", err)
+        }
+
+        // Create listing revisions table; revisions are append-only
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS listing_revisions (
+                        id SERIAL PRIMARY KEY,
+                        listing_id INTEGER REFERENCES listings(id) ON DELETE CASCADE,
+                        revision INTEGER NOT NULL,
+                        title VARCHAR(200) NOT NULL,
+                        description TEXT NOT NULL,
+                        type VARCHAR(20) NOT NULL,
+                        plant_type VARCHAR(50) NOT NULL,
+                        price NUMERIC(10, 2) NOT NULL,
+                        trade_for TEXT,
+                        location VARCHAR(100) NOT NULL,
+                        status VARCHAR(20),
+                        images JSONB NOT NULL DEFAULT '[]',
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        UNIQUE(listing_id, revision)
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create listing_revisions table", err)
+        }
+
+        if err := compactRevisionImages(); err != nil {
+                fatal("Failed to replace images in listing_revisions with references", err)
+        }
+
+        _, err = db.Exec(`
+                CREATE OR REPLACE RULE listing_revisions_immutable AS
+                ON UPDATE TO listing_revisions DO INSTEAD NOTHING
+        `)
+        if err != nil {
+                fatal("Failed to make listing_revisions immutable", err)
         }
 
         // Create messages table
@@ -105,7 +212,122 @@ func createTables() {
                 )
         `)
         if err != nil {
-                log.Fatalf("Failed to create messages table: %v", err)
+                fatal("Failed to create messages table", err)
+        }
+
+        // Message edits and deletions, added after the original schema. A message deleted
+        // for everyone keeps its row, without content, so the thread shows where it was;
+        // one deleted by a participant only for themselves is hidden from them.
+        _, err = db.Exec(`
+                ALTER TABLE messages
+                        ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE,
+                        ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE,
+                        ADD COLUMN IF NOT EXISTS hidden_for_sender BOOLEAN NOT NULL DEFAULT FALSE,
+                        ADD COLUMN IF NOT EXISTS hidden_for_recipient BOOLEAN NOT NULL DEFAULT FALSE
+        `)
+        if err != nil {
+                fatal("Failed to add message edit columns", err)
+        }
+
+        // Create message edits table; each row is the content a message had before the
+        // edit made at edited_at
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS message_edits (
+                        id SERIAL PRIMARY KEY,
+                        message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+                        content TEXT NOT NULL,
+                        edited_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create message_edits table", err)
+        }
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS message_edits_message_id ON message_edits (message_id)`)
+        if err != nil {
+                fatal("Failed to create message edits index", err)
+        }
+
+        // Create message attachments table; photos are kept in the database like listing
+        // images, and belong to their uploader until sent with a message
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS message_attachments (
+                        id SERIAL PRIMARY KEY,
+                        message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+                        uploader_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        content_type VARCHAR(50) NOT NULL,
+                        size INTEGER NOT NULL,
+                        width INTEGER NOT NULL,
+                        height INTEGER NOT NULL,
+                        content BYTEA NOT NULL,
+                        thumbnail BYTEA NOT NULL,
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create message_attachments table", err)
+        }
+
+        // Create conversations tables. A conversation is between two users about a
+        // listing, user1_id being the lower ID; each participant keeps their own unread
+        // count, read position and archive and mute settings.
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS conversations (
+                        id SERIAL PRIMARY KEY,
+                        user1_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        user2_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        listing_id INTEGER REFERENCES listings(id) ON DELETE SET NULL,
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        last_message_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        UNIQUE(user1_id, user2_id, listing_id),
+                        CHECK(user1_id <= user2_id)
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create conversations table", err)
+        }
+
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS conversation_participants (
+                        conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+                        user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        unread_count INTEGER NOT NULL DEFAULT 0,
+                        last_read_message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL,
+                        archived BOOLEAN NOT NULL DEFAULT FALSE,
+                        muted BOOLEAN NOT NULL DEFAULT FALSE,
+                        PRIMARY KEY(conversation_id, user_id)
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create conversation_participants table", err)
+        }
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS conversation_participants_user_id ON conversation_participants (user_id)`)
+        if err != nil {
+                fatal("Failed to create conversation participants index", err)
+        }
+
+        // Messages belong to a conversation, added after the original schema
+        _, err = db.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE`)
+        if err != nil {
+                fatal("Failed to add messages conversation_id column", err)
+        }
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_conversation_id ON messages (conversation_id, created_at)`)
+        if err != nil {
+                fatal("Failed to create messages conversation index", err)
+        }
+
+        // Full-text index for message search; queries must use the same expression
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_content_search ON messages USING GIN (` + messageSearchVector + `)`)
+        if err != nil {
+                fatal("Failed to create messages search index", err)
+        }
+
+        // Recent messages by sender, for the send-rate and duplicate screening checks
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS messages_from_id ON messages (from_id, created_at)`)
+        if err != nil {
+                fatal("Failed to create messages sender index", err)
+        }
+        if err := backfillConversations(); err != nil {
+                fatal("Failed to backfill conversations", err)
         }
 
         // Create favorites table
@@ -119,10 +341,258 @@ func createTables() {
                 )
         `)
         if err != nil {
-                log.Fatalf("Failed to create favorites table: %v", err)
+                fatal("Failed to create favorites table", err)
+        }
+
+        // Create follows table; a user's feed shows new listings by the users they follow
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS follows (
+                        follower_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        followed_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        PRIMARY KEY(follower_id, followed_id),
+                        CHECK(follower_id <> followed_id)
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create follows table", err)
+        }
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS follows_followed_id ON follows (followed_id)`)
+        if err != nil {
+                fatal("Failed to create follows index", err)
+        }
+
+        // Create notifications table
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS notifications (
+                        id SERIAL PRIMARY KEY,
+                        user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        listing_id INTEGER REFERENCES listings(id) ON DELETE CASCADE,
+                        kind VARCHAR(50) NOT NULL,
+                        message TEXT NOT NULL,
+                        read BOOLEAN DEFAULT FALSE,
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create notifications table", err)
+        }
+
+        // Create data exports table; the generated zip is kept until expires_at
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS data_exports (
+                        id SERIAL PRIMARY KEY,
+                        user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        status VARCHAR(20) NOT NULL DEFAULT 'pending',
+                        file BYTEA,
+                        error TEXT,
+                        requested_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        started_at TIMESTAMP WITH TIME ZONE,
+                        completed_at TIMESTAMP WITH TIME ZONE,
+                        expires_at TIMESTAMP WITH TIME ZONE
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create data_exports table", err)
+        }
+
+        // Create moderation queue table; new messages and listings that screening held
+        // are kept here as submitted until they are approved and delivered, or rejected.
+        // Photos of held messages are listed so the unsent attachment cleanup keeps them.
+        _, err = db.Exec(`
+                CREATE TABLE IF NOT EXISTS moderation_queue (
+                        id SERIAL PRIMARY KEY,
+                        kind VARCHAR(20) NOT NULL,
+                        user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+                        content JSONB NOT NULL,
+                        attachment_ids INTEGER[] NOT NULL DEFAULT '{}',
+                        score INTEGER NOT NULL,
+                        flags JSONB NOT NULL DEFAULT '[]',
+                        status VARCHAR(20) NOT NULL DEFAULT 'pending',
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        reviewed_at TIMESTAMP WITH TIME ZONE
+                )
+        `)
+        if err != nil {
+                fatal("Failed to create moderation_queue table", err)
+        }
+        _, err = db.Exec(`CREATE INDEX IF NOT EXISTS moderation_queue_user_id ON moderation_queue (user_id, created_at)`)
+        if err != nil {
+                fatal("Failed to create moderation_queue index", err)
+        }
+
+        slog.Info("Database tables created successfully")
+}
+
+// compactRevisionImages replaces the image copies kept by revisions saved before
+// revisions stored image references. Revisions are otherwise never updated, so the
+// immutability rule is lifted for the rewrite and restored before it commits.
+func compactRevisionImages() error {
+        ctx, cancel := withTransactionTimeout(context.Background())
+        defer cancel()
+
+        var pending bool
+        err := db.QueryRowContext(ctx, `
+                SELECT EXISTS (
+                        SELECT 1 FROM listing_revisions r, jsonb_array_elements_text(r.images) AS image(image_url)
+                        WHERE image_url NOT LIKE 'sha256:%'
+                )
+        `).Scan(&pending)
+        if err != nil || !pending {
+                return err
+        }
+
+        tx, err := db.BeginTx(ctx, nil)
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback()
+
+        _, err = tx.ExecContext(ctx, `DROP RULE IF EXISTS listing_revisions_immutable ON listing_revisions`)
+        if err != nil {
+                return err
+        }
+        result, err := tx.ExecContext(ctx, `
+                UPDATE listing_revisions r
+                SET images = (
+                        SELECT COALESCE(jsonb_agg(CASE WHEN image_url LIKE 'sha256:%' THEN image_url
+                                                       ELSE `+imageReferenceSQL+` END ORDER BY position), '[]')
+                        FROM jsonb_array_elements_text(r.images) WITH ORDINALITY AS image(image_url, position)
+                )
+                WHERE EXISTS (
+                        SELECT 1 FROM jsonb_array_elements_text(r.images) AS image(image_url)
+                        WHERE image_url NOT LIKE 'sha256:%'
+                )
+        `)
+        if err != nil {
+                return err
+        }
+        _, err = tx.ExecContext(ctx, `
+                CREATE RULE listing_revisions_immutable AS
+                ON UPDATE TO listing_revisions DO INSTEAD NOTHING
+        `)
+        if err != nil {
+                return err
+        }
+
+        compacted, err := result.RowsAffected()
+        if err != nil {
+                return err
+        }
+        if err := tx.Commit(); err != nil {
+                return err
+        }
+        slog.Info("Replaced images in listing revisions with references", "revisions", compacted)
+        return nil
+}
+
+// backfillConversations puts messages sent before conversations existed into
+// conversations, one per pair of users and listing, and gives their participants
+// unread counts and read positions from the messages' read flags
+func backfillConversations() error {
+        ctx, cancel := withTransactionTimeout(context.Background())
+        defer cancel()
+
+        tx, err := db.BeginTx(ctx, nil)
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback()
+
+        // Create the conversations and move the messages into them
+        _, err = tx.ExecContext(ctx, `
+                INSERT INTO conversations (user1_id, user2_id, listing_id, created_at, last_message_at)
+                SELECT LEAST(from_id, to_id), GREATEST(from_id, to_id), listing_id, MIN(created_at), MAX(created_at)
+                FROM messages
+                WHERE conversation_id IS NULL AND from_id IS NOT NULL AND to_id IS NOT NULL
+                GROUP BY 1, 2, 3
+                ON CONFLICT (user1_id, user2_id, listing_id)
+                DO UPDATE SET last_message_at = GREATEST(conversations.last_message_at, EXCLUDED.last_message_at)
+        `)
+        if err != nil {
+                return err
         }
+        result, err := tx.ExecContext(ctx, `
+                UPDATE messages m
+                SET conversation_id = c.id
+                FROM conversations c
+                WHERE m.conversation_id IS NULL
+                  AND c.user1_id = LEAST(m.from_id, m.to_id) AND c.user2_id = GREATEST(m.from_id, m.to_id)
+                  AND c.listing_id IS NOT DISTINCT FROM m.listing_id
+        `)
+        if err != nil {
+                return err
+        }
+        backfilled, err := result.RowsAffected()
+        if err != nil || backfilled == 0 {
+                return err
+        }
+
+        // Count the participants' unread messages; the read flags are kept in step
+        // with the counts, so recounting conversations already backfilled is harmless
+        _, err = tx.ExecContext(ctx, `
+                INSERT INTO conversation_participants (conversation_id, user_id, unread_count, last_read_message_id)
+                SELECT c.id, p.user_id,
+                       (SELECT COUNT(*) FROM messages m
+                        WHERE m.conversation_id = c.id AND m.to_id = p.user_id AND m.from_id <> p.user_id AND NOT m.read),
+                       (SELECT MAX(m.id) FROM messages m
+                        WHERE m.conversation_id = c.id AND (m.from_id = p.user_id OR m.read))
+                FROM conversations c
+                CROSS JOIN LATERAL (SELECT DISTINCT user_id FROM (VALUES (c.user1_id), (c.user2_id)) AS ids(user_id)) p
+                ON CONFLICT (conversation_id, user_id) DO UPDATE
+                SET unread_count = EXCLUDED.unread_count, last_read_message_id = EXCLUDED.last_read_message_id
+        `)
+        if err != nil {
+                return err
+        }
+
+        if err := tx.Commit(); err != nil {
+                return err
+        }
+        slog.Info("Moved messages into conversations", "messages", backfilled)
+        return nil
+}
+
+// fatal logs a failure to set up the database and exits, as the server cannot run without it
+func fatal(msg string, err error) {
+        slog.Error(msg, "error", err)
+        os.Exit(1)
+}
+
+// CheckReady reports whether the database is reachable and the schema is in place
+func CheckReady(ctx context.Context) error {
+        if db == nil {
+                return errors.New("database not initialized")
+        }
+        if err := db.PingContext(ctx); err != nil {
+                return fmt.Errorf("database unreachable: %w", err)
+        }
+
+        var found int
+        err := db.QueryRowContext(ctx, `
+                SELECT COUNT(*)
+                FROM information_schema.tables
+                WHERE table_schema = current_schema() AND table_name = ANY($1)
+        `, pq.Array(schemaTables)).Scan(&found)
+        if err != nil {
+                return fmt.Errorf("cannot check schema: %w", err)
+        }
+        if found != len(schemaTables) {
+                return fmt.Errorf("schema incomplete: %d of %d tables present", found, len(schemaTables))
+        }
+
+        return nil
+}
+
+// withQueryTimeout bounds a single storage operation by database.queryTimeout. The
+// caller's context still applies, so a client disconnect cancels the query.
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+        return context.WithTimeout(ctx, time.Duration(settings.Database.QueryTimeout))
+}
 
-        log.Println("Database tables created successfully")
+// withTransactionTimeout bounds a multi-statement transaction by database.transactionTimeout
+func withTransactionTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+        return context.WithTimeout(ctx, time.Duration(settings.Database.TransactionTimeout))
 }
 
 // GetDB returns the database connection
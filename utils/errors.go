@@ -0,0 +1,171 @@
+package utils
+
+import (
+        "context"
+        "database/sql"
+        "errors"
+        "fmt"
+        "strconv"
+        "strings"
+
+        "github.com/lib/pq"
+)
+
+// Error kinds returned by storage functions. Check them with errors.Is.
+var (
+        ErrNotFound    = errors.New("not found")
+        ErrConflict    = errors.New("conflict")
+        ErrValidation  = errors.New("validation failed")
+        ErrInternal    = errors.New("internal error")
+        ErrTimeout     = errors.New("timed out")
+        ErrRateLimited = errors.New("rate limited")
+)
+
+// Error is a typed error returned by storage functions. Message and Fields are
+// safe to show to users; the underlying cause in Err is only logged.
+type Error struct {
+        Kind    error             // ErrNotFound, ErrConflict, ErrValidation, ErrInternal, ErrTimeout or ErrRateLimited
+        Message string            // user-facing description
+        Fields  map[string]string // per-field problems, keyed by JSON field name
+        Err     error             // underlying cause, if any
+}
+
+func (e *Error) Error() string {
+        if e.Err != nil {
+                return e.Message + ": " + e.Err.Error()
+        }
+        return e.Message
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+        return e.Err
+}
+
+// Is makes errors.Is(err, ErrNotFound) and friends match on the kind
+func (e *Error) Is(target error) bool {
+        return target == e.Kind
+}
+
+// NotFoundError reports that a record does not exist
+func NotFoundError(format string, args ...interface{}) error {
+        return &Error{Kind: ErrNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// ConflictError reports that a change clashes with existing data, e.g. a duplicate email
+func ConflictError(message string, fields map[string]string) error {
+        return &Error{Kind: ErrConflict, Message: message, Fields: fields}
+}
+
+// ValidationError reports invalid input, optionally with per-field problems
+func ValidationError(message string, fields map[string]string) error {
+        return &Error{Kind: ErrValidation, Message: message, Fields: fields}
+}
+
+// RateLimitError reports that a user is doing something too often
+func RateLimitError(format string, args ...interface{}) error {
+        return &Error{Kind: ErrRateLimited, Message: fmt.Sprintf(format, args...)}
+}
+
+// InternalError wraps an unexpected failure
+func InternalError(err error) error {
+        return &Error{Kind: ErrInternal, Message: "Internal server error", Err: err}
+}
+
+// TimeoutError reports that an operation ran out of time or its caller went away
+func TimeoutError(err error) error {
+        return &Error{Kind: ErrTimeout, Message: "The server took too long to respond; please try again", Err: err}
+}
+
+// UserMessage returns the part of an error that is safe to show to users
+func UserMessage(err error) string {
+        var typed *Error
+        if errors.As(err, &typed) {
+                return typed.Message
+        }
+        return "Internal server error"
+}
+
+// dbError converts a database error to a typed error. what names the record for messages, e.g. "listing".
+// Timeouts and unexpected errors are logged with the request's logger from ctx.
+func dbError(ctx context.Context, err error, what string) error {
+        if err == nil {
+                return nil
+        }
+
+        // Already typed, e.g. returned by a helper
+        var typed *Error
+        if errors.As(err, &typed) {
+                return err
+        }
+
+        // The query timeout passed or the client disconnected
+        if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+                Logger(ctx).Warn("Storage query timed out", "record", what, "error", err)
+                return TimeoutError(fmt.Errorf("%s: %w", what, err))
+        }
+
+        if errors.Is(err, sql.ErrNoRows) {
+                return NotFoundError("%s not found", capitalize(what))
+        }
+
+        var pqErr *pq.Error
+        if errors.As(err, &pqErr) {
+                switch pqErr.Code.Name() {
+                case "query_canceled":
+                        Logger(ctx).Warn("Storage query timed out", "record", what, "error", err)
+                        return TimeoutError(fmt.Errorf("%s: %w", what, err))
+                case "unique_violation":
+                        field := uniqueViolationField(pqErr)
+                        return &Error{
+                                Kind:    ErrConflict,
+                                Message: fmt.Sprintf("A %s with this %s already exists", what, field),
+                                Fields:  map[string]string{field: "already in use"},
+                                Err:     err,
+                        }
+                case "foreign_key_violation":
+                        return &Error{Kind: ErrValidation, Message: fmt.Sprintf("The %s refers to a record that does not exist", what), Err: err}
+                case "string_data_right_truncation":
+                        return &Error{Kind: ErrValidation, Message: fmt.Sprintf("A %s field is too long", what), Err: err}
+                case "numeric_value_out_of_range", "check_violation", "not_null_violation", "invalid_text_representation":
+                        return &Error{Kind: ErrValidation, Message: fmt.Sprintf("The %s contains an invalid value", what), Err: err}
+                }
+        }
+
+        Logger(ctx).Error("Storage query failed", "record", what, "error", err)
+        return InternalError(fmt.Errorf("%s: %w", what, err))
+}
+
+// uniqueViolationField guesses the JSON field name from a unique constraint name like users_email_key
+func uniqueViolationField(pqErr *pq.Error) string {
+        name := strings.TrimSuffix(pqErr.Constraint, "_key")
+        name = strings.TrimPrefix(name, pqErr.Table+"_")
+        if name == "" {
+                return "value"
+        }
+
+        // Convert snake_case to camelCase to match the JSON field names
+        parts := strings.Split(name, "_")
+        for i := 1; i < len(parts); i++ {
+                parts[i] = capitalize(parts[i])
+        }
+        return strings.Join(parts, "")
+}
+
+// parseID converts a string ID to the database integer ID. An ID that cannot be
+// a database ID refers to a record that does not exist.
+func parseID(id, what string) (int, error) {
+        value, err := strconv.Atoi(id)
+        if err != nil || value <= 0 {
+                return 0, NotFoundError("%s not found", capitalize(what))
+        }
+        return value, nil
+}
+
+// capitalize upper-cases the first letter of s
+func capitalize(s string) string {
+        if s == "" {
+                return s
+        }
+        return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+        "context"
+        "fmt"
+        "time"
+
+        "github.com/plantexchange/app/config"
+        "github.com/plantexchange/app/models"
+)
+
+// ListingLifetime is how long a listing stays available before it expires
+// (listings.lifetimeDays, LISTING_LIFETIME_DAYS)
+func ListingLifetime() time.Duration {
+        return config.Days(settings.Listings.LifetimeDays)
+}
+
+// ListingExpiryWarning is how long before expiry owners are warned
+// (listings.expiryWarningDays, LISTING_EXPIRY_WARNING_DAYS)
+func ListingExpiryWarning() time.Duration {
+        return config.Days(settings.Listings.ExpiryWarningDays)
+}
+
+// WorkerInterval is how often background jobs run (worker.interval, WORKER_INTERVAL)
+func WorkerInterval() time.Duration {
+        return time.Duration(settings.Worker.Interval)
+}
+
+// ListingExpiryJobs returns the background jobs that warn owners and expire old listings
+func ListingExpiryJobs() []Job {
+        return []Job{
+                {Name: "warn-expiring-listings", Run: warnExpiringListings},
+                {Name: "expire-listings", Run: expireListings},
+        }
+}
+
+// warnExpiringListings notifies owners of listings that will expire soon
+func warnExpiringListings(ctx context.Context, now time.Time) error {
+        // Listings created before expiry existed get one now, leaving time for a warning
+        if err := BackfillListingExpiry(ctx, ListingLifetime(), now.Add(ListingExpiryWarning())); err != nil {
+                return err
+        }
+
+        listings, err := MarkExpiringListingsWarned(ctx, now.Add(ListingExpiryWarning()))
+        if err != nil {
+                return err
+        }
+
+        for _, listing := range listings {
+                _, err := SaveNotification(ctx, models.Notification{
+                        UserID:    listing.UserID,
+                        ListingID: listing.ID,
+                        Kind:      models.NotificationListingExpiring,
+                        Message: fmt.Sprintf("Your listing %q expires on %s. Renew it to keep it visible.",
+                                listing.Title, listing.ExpiresAt.Format("2 Jan 2006")),
+                        CreatedAt: now,
+                })
+                if err != nil {
+                        return err
+                }
+        }
+
+        return nil
+}
+
+// expireListings marks listings past their expiry as expired and notifies their owners
+func expireListings(ctx context.Context, now time.Time) error {
+        listings, err := ExpireListings(ctx, now)
+        if err != nil {
+                return err
+        }
+
+        for _, listing := range listings {
+                _, err := SaveNotification(ctx, models.Notification{
+                        UserID:    listing.UserID,
+                        ListingID: listing.ID,
+                        Kind:      models.NotificationListingExpired,
+                        Message: fmt.Sprintf("Your listing %q has expired and moved to your archive. Renew it to list it again.",
+                                listing.Title),
+                        CreatedAt: now,
+                })
+                if err != nil {
+                        return err
+                }
+        }
+
+        return nil
+}
@@ -0,0 +1,472 @@
+package utils
+
+import (
+        "archive/zip"
+        "bufio"
+        "bytes"
+        "context"
+        "encoding/base64"
+        "encoding/csv"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "mime"
+        "net/http"
+        "path"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/plantexchange/app/models"
+)
+
+// Limits for bulk imports
+const (
+        MaxImportRows       = 500
+        MaxImportImageSize  = 5 << 20   // 5 MB per image once extracted
+        MaxImportImagesSize = 200 << 20 // 200 MB for all images once extracted
+        MaxImportImageFiles = 1000      // files in the images zip
+)
+
+// ImportOptions controls how a bulk listing import is run
+type ImportOptions struct {
+        Format  string // csv or jsonl
+        DryRun  bool   // validate only, create nothing
+        Partial bool   // create valid rows even if others failed
+}
+
+// importCSVColumns maps CSV header names to setters on the listing being imported
+var importCSVColumns = map[string]func(listing *models.Listing, value string) error{
+        "title":       func(l *models.Listing, v string) error { l.Title = v; return nil },
+        "description": func(l *models.Listing, v string) error { l.Description = v; return nil },
+        "type":        func(l *models.Listing, v string) error { l.Type = strings.ToLower(v); return nil },
+        "planttype":   func(l *models.Listing, v string) error { l.PlantType = strings.ToLower(v); return nil },
+        "tradefor":    func(l *models.Listing, v string) error { l.TradeFor = v; return nil },
+        "location":    func(l *models.Listing, v string) error { l.Location = v; return nil },
+        "status":      func(l *models.Listing, v string) error { l.Status = strings.ToLower(v); return nil },
+        "price": func(l *models.Listing, v string) error {
+                if v == "" {
+                        return nil
+                }
+                price, err := strconv.ParseFloat(strings.TrimPrefix(v, "₹"), 64)
+                if err != nil {
+                        return fmt.Errorf("price %q is not a number", v)
+                }
+                l.Price = price
+                return nil
+        },
+        // Image filenames or URLs separated by semicolons
+        "images": func(l *models.Listing, v string) error {
+                for _, image := range strings.Split(v, ";") {
+                        if image = strings.TrimSpace(image); image != "" {
+                                l.Images = append(l.Images, image)
+                        }
+                }
+                return nil
+        },
+        "species":     careSheetSetter(func(c *models.CareSheet, v string) { c.Species = v }),
+        "light":       careSheetSetter(func(c *models.CareSheet, v string) { c.Light = v }),
+        "water":       careSheetSetter(func(c *models.CareSheet, v string) { c.Water = v }),
+        "humidity":    careSheetSetter(func(c *models.CareSheet, v string) { c.Humidity = v }),
+        "temperature": careSheetSetter(func(c *models.CareSheet, v string) { c.Temperature = v }),
+        "pettoxicity": careSheetSetter(func(c *models.CareSheet, v string) { c.PetToxicity = v }),
+        "propagation": careSheetSetter(func(c *models.CareSheet, v string) { c.Propagation = v }),
+}
+
+// careSheetSetter wraps a care sheet field setter as a CSV column setter
+func careSheetSetter(set func(careSheet *models.CareSheet, value string)) func(*models.Listing, string) error {
+        return func(l *models.Listing, v string) error {
+                if v == "" {
+                        return nil
+                }
+                if l.CareSheet == nil {
+                        l.CareSheet = &models.CareSheet{}
+                }
+                set(l.CareSheet, v)
+                return nil
+        }
+}
+
+// importRow is a parsed row before validation
+type importRow struct {
+        line       int
+        listing    models.Listing
+        errors     []string
+        unreadable bool                   // the row could not be parsed at all, so is not validated
+        held       *models.ModerationHold // set when the listing was held for moderation
+}
+
+// ImportFormatFromFilename guesses the import format from a file extension
+func ImportFormatFromFilename(filename string) string {
+        switch strings.ToLower(path.Ext(filename)) {
+        case ".jsonl", ".ndjson", ".json":
+                return models.ImportFormatJSONL
+        default:
+                return models.ImportFormatCSV
+        }
+}
+
+// RunListingImport parses, validates and creates listings for a user from a CSV or
+// JSON Lines file. Images are referenced by filename in the optional zip archive, or
+// by URL. Unless opts.Partial is set nothing is created if any row is invalid.
+// Valid rows are screened like new listings, and suspicious ones are held for
+// moderation instead of created.
+// The returned error is only set when the file as a whole cannot be read.
+func RunListingImport(ctx context.Context, userID string, data []byte, imagesZip []byte, opts ImportOptions) (models.ImportResult, error) {
+        result := models.ImportResult{DryRun: opts.DryRun, Partial: opts.Partial, Rows: []models.ImportRowResult{}}
+
+        // Parse rows
+        var rows []importRow
+        var err error
+        switch opts.Format {
+        case models.ImportFormatCSV:
+                rows, err = parseImportCSV(data)
+        case models.ImportFormatJSONL:
+                rows, err = parseImportJSONL(data)
+        default:
+                return result, fmt.Errorf("unsupported import format %q", opts.Format)
+        }
+        if err != nil {
+                return result, err
+        }
+        if len(rows) == 0 {
+                return result, fmt.Errorf("import file contains no listings")
+        }
+        if len(rows) > MaxImportRows {
+                return result, fmt.Errorf("import file contains %d listings, the limit is %d", len(rows), MaxImportRows)
+        }
+
+        // Read the images the rows refer to
+        images := map[string]string{}
+        if len(imagesZip) > 0 {
+                images, err = readImportImages(imagesZip, importImageNames(rows))
+                if err != nil {
+                        return result, err
+                }
+        }
+
+        // Validate each row
+        now := time.Now()
+        for i := range rows {
+                if !rows[i].unreadable {
+                        rows[i].errors = append(rows[i].errors, prepareImportedListing(&rows[i].listing, userID, images, now)...)
+                }
+        }
+
+        // Collect valid rows
+        valid := []int{}
+        for i, row := range rows {
+                if len(row.errors) == 0 {
+                        valid = append(valid, i)
+                } else {
+                        result.Failed++
+                }
+        }
+
+        // Create listings in a single transaction unless this is a dry run or an invalid row blocks the import
+        if !opts.DryRun && len(valid) > 0 && (opts.Partial || result.Failed == 0) {
+                // Screen for spam and scams; suspicious rows are held once the others are created
+                var create []int
+                held := map[int]models.Screening{}
+                for _, index := range valid {
+                        screening, err := Screen(ctx, ScreenedContent{
+                                Kind:   models.ContentListing,
+                                UserID: userID,
+                                Text:   strings.Join([]string{rows[index].listing.Title, rows[index].listing.Description, rows[index].listing.TradeFor}, "\n"),
+                        }, now)
+                        if err != nil {
+                                return result, err
+                        }
+                        if screening.Held {
+                                held[index] = screening
+                        } else {
+                                create = append(create, index)
+                        }
+                }
+
+                if len(create) > 0 {
+                        listings := make([]models.Listing, len(create))
+                        for i, index := range create {
+                                listings[i] = rows[index].listing
+                        }
+
+                        ids, err := ImportListings(ctx, listings)
+                        if err != nil {
+                                // A problem with the data is reported on every row created with it; anything else fails the import
+                                if errors.Is(err, ErrInternal) {
+                                        return result, err
+                                }
+                                for _, index := range create {
+                                        rows[index].errors = append(rows[index].errors, UserMessage(err))
+                                }
+                                result.Failed += len(create)
+                        } else {
+                                for i, index := range create {
+                                        rows[index].listing.ID = ids[i]
+                                }
+                                result.Created = len(ids)
+                        }
+                }
+
+                // Held rows were not part of the transaction, so they are queued either way
+                for _, index := range valid {
+                        screening, ok := held[index]
+                        if !ok {
+                                continue
+                        }
+                        heldID, err := HoldContent(ctx, models.ContentListing, userID, rows[index].listing, nil, screening, now)
+                        if err != nil {
+                                return result, err
+                        }
+                        rows[index].held = &models.ModerationHold{ID: heldID, Message: "This listing will be created once it has been reviewed"}
+                        result.Held++
+                }
+        }
+
+        for _, row := range rows {
+                result.Rows = append(result.Rows, models.ImportRowResult{
+                        Row:       row.line,
+                        Title:     row.listing.Title,
+                        ListingID: row.listing.ID,
+                        Held:      row.held,
+                        Errors:    row.errors,
+                })
+        }
+
+        return result, nil
+}
+
+// parseImportCSV parses a CSV file with a header row naming the listing fields
+func parseImportCSV(data []byte) ([]importRow, error) {
+        reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+        reader.FieldsPerRecord = -1
+        reader.TrimLeadingSpace = true
+
+        header, err := reader.Read()
+        if err != nil {
+                return nil, fmt.Errorf("cannot read CSV header: %v", err)
+        }
+
+        // Map header columns to setters
+        setters := make([]func(*models.Listing, string) error, len(header))
+        for i, name := range header {
+                key := strings.ToLower(strings.TrimSpace(name))
+                key = strings.TrimPrefix(key, "care.")
+                key = strings.ReplaceAll(strings.ReplaceAll(key, "_", ""), " ", "")
+                setter, ok := importCSVColumns[key]
+                if !ok {
+                        return nil, fmt.Errorf("unknown CSV column %q", name)
+                }
+                setters[i] = setter
+        }
+
+        rows := []importRow{}
+        for {
+                record, err := reader.Read()
+                if err == io.EOF {
+                        break
+                }
+
+                row := importRow{}
+                if err != nil {
+                        if parseErr, ok := err.(*csv.ParseError); ok {
+                                row.line = parseErr.StartLine
+                        }
+                        row.errors = []string{err.Error()}
+                        row.unreadable = true
+                        rows = append(rows, row)
+                        continue
+                }
+                row.line, _ = reader.FieldPos(0)
+
+                // Skip blank lines
+                if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+                        continue
+                }
+                if len(record) != len(header) {
+                        row.errors = []string{fmt.Sprintf("expected %d columns, got %d", len(header), len(record))}
+                        row.unreadable = true
+                        rows = append(rows, row)
+                        continue
+                }
+
+                for i, value := range record {
+                        if err := setters[i](&row.listing, strings.TrimSpace(value)); err != nil {
+                                row.errors = append(row.errors, err.Error())
+                        }
+                }
+                rows = append(rows, row)
+        }
+
+        return rows, nil
+}
+
+// parseImportJSONL parses a JSON Lines file with one listing object per line
+func parseImportJSONL(data []byte) ([]importRow, error) {
+        scanner := bufio.NewScanner(bytes.NewReader(data))
+        scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+        rows := []importRow{}
+        line := 0
+        for scanner.Scan() {
+                line++
+                text := strings.TrimSpace(scanner.Text())
+                if text == "" {
+                        continue
+                }
+
+                row := importRow{line: line}
+                decoder := json.NewDecoder(strings.NewReader(text))
+                decoder.DisallowUnknownFields()
+                if err := decoder.Decode(&row.listing); err != nil {
+                        row.listing = models.Listing{}
+                        row.errors = []string{"invalid JSON: " + err.Error()}
+                        row.unreadable = true
+                }
+                rows = append(rows, row)
+        }
+        if err := scanner.Err(); err != nil {
+                return nil, fmt.Errorf("cannot read JSON Lines file: %v", err)
+        }
+
+        return rows, nil
+}
+
+// importImageNames collects the base filenames of the zip images referred to by readable rows
+func importImageNames(rows []importRow) map[string]bool {
+        names := map[string]bool{}
+        for _, row := range rows {
+                if row.unreadable {
+                        continue
+                }
+                for _, image := range row.listing.Images {
+                        if !isImageURL(image) {
+                                names[path.Base(image)] = true
+                        }
+                }
+        }
+        return names
+}
+
+// isImageURL reports whether an imported image is given as a URL rather than a zip filename
+func isImageURL(image string) bool {
+        return strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") || strings.HasPrefix(image, "data:image/")
+}
+
+// readImportImages extracts the named images in a zip archive as data URLs keyed by base
+// filename. Other files are skipped without being decompressed.
+func readImportImages(zipData []byte, names map[string]bool) (map[string]string, error) {
+        archive, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+        if err != nil {
+                return nil, fmt.Errorf("cannot read images zip: %v", err)
+        }
+        if len(archive.File) > MaxImportImageFiles {
+                return nil, fmt.Errorf("images zip contains %d files, the limit is %d", len(archive.File), MaxImportImageFiles)
+        }
+
+        images := map[string]string{}
+        total := 0
+        for _, file := range archive.File {
+                if file.FileInfo().IsDir() {
+                        continue
+                }
+                name := path.Base(file.Name)
+                if strings.HasPrefix(name, ".") || !names[name] {
+                        continue
+                }
+                if _, found := images[name]; found {
+                        continue
+                }
+                if file.UncompressedSize64 > MaxImportImageSize {
+                        return nil, fmt.Errorf("image %q is larger than %d MB", name, MaxImportImageSize>>20)
+                }
+
+                reader, err := file.Open()
+                if err != nil {
+                        return nil, fmt.Errorf("cannot read image %q: %v", name, err)
+                }
+                content, err := io.ReadAll(io.LimitReader(reader, MaxImportImageSize+1))
+                reader.Close()
+                if err != nil {
+                        return nil, fmt.Errorf("cannot read image %q: %v", name, err)
+                }
+                if len(content) > MaxImportImageSize {
+                        return nil, fmt.Errorf("image %q is larger than %d MB", name, MaxImportImageSize>>20)
+                }
+                total += len(content)
+                if total > MaxImportImagesSize {
+                        return nil, fmt.Errorf("images in the zip are larger than %d MB in total", MaxImportImagesSize>>20)
+                }
+
+                // Store images the same way the listing form does, as data URLs
+                contentType := mime.TypeByExtension(strings.ToLower(path.Ext(name)))
+                if contentType == "" {
+                        contentType = http.DetectContentType(content)
+                }
+                if !strings.HasPrefix(contentType, "image/") {
+                        continue
+                }
+                images[name] = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(content)
+        }
+
+        return images, nil
+}
+
+// prepareImportedListing validates an imported listing the same way CreateListing
+// does and fills in its owner, timestamps, images and care sheet defaults
+func prepareImportedListing(listing *models.Listing, userID string, images map[string]string, now time.Time) []string {
+        problems := []string{}
+
+        listing.ID = ""
+        listing.UserID = userID
+        listing.CreatedAt = now
+        listing.UpdatedAt = now
+        listing.PublishAt = nil
+        listing.LastEditedAt = nil
+        listing.PreviousPrice = nil
+
+        // Imported listings are published straight away unless marked as drafts
+        if listing.Status == "" {
+                listing.Status = models.ListingStatusAvailable
+        }
+        switch listing.Status {
+        case models.ListingStatusAvailable:
+                problems = append(problems, listing.PublishProblems()...)
+                listing.ExpiresAt = now.Add(ListingLifetime())
+        case models.ListingStatusDraft:
+                listing.ExpiresAt = time.Time{}
+        default:
+                problems = append(problems, "status must be available or draft")
+        }
+
+        // Apply the same field rules as the API
+        v := NewValidator()
+        ValidateListing(v, *listing)
+        problems = append(problems, v.Problems()...)
+
+        // Resolve image filenames against the zip; URLs are kept as they are
+        resolved := make([]string, 0, len(listing.Images))
+        for _, image := range listing.Images {
+                if isImageURL(image) {
+                        resolved = append(resolved, image)
+                        continue
+                }
+                dataURL, ok := images[path.Base(image)]
+                if !ok {
+                        problems = append(problems, fmt.Sprintf("image %q not found in images zip", image))
+                        continue
+                }
+                resolved = append(resolved, dataURL)
+        }
+        listing.Images = resolved
+
+        // Fill any care fields left blank from the species dataset
+        if listing.CareSheet != nil {
+                if defaults, found := DefaultCareSheet(listing.Title, listing.PlantType); found {
+                        listing.CareSheet.FillFrom(defaults)
+                }
+        }
+
+        return problems
+}
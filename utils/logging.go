@@ -0,0 +1,84 @@
+package utils
+
+import (
+        "context"
+        "log/slog"
+        "os"
+        "regexp"
+        "strings"
+
+        "github.com/plantexchange/app/config"
+)
+
+// redactedValue replaces sensitive values in logs
+const redactedValue = "[redacted]"
+
+// sensitiveLogKeys are attribute keys whose values are never logged, matched case-insensitively
+var sensitiveLogKeys = map[string]bool{
+        "password":      true,
+        "token":         true,
+        "secret":        true,
+        "authorization": true,
+        "cookie":        true,
+        "set-cookie":    true,
+        "session":       true,
+        "email":         true,
+}
+
+// emailPattern finds email addresses inside logged text
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// loggerKey is the context key for the request-scoped logger
+type loggerKey struct{}
+
+// SetupLogging makes slog the default logger, writing JSON or text at the configured level.
+// Output from the standard log package goes through the same handler, so it is redacted too.
+func SetupLogging(cfg config.LogConfig) {
+        var level slog.Level
+        if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+                level = slog.LevelInfo
+        }
+
+        options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+        var handler slog.Handler
+        if cfg.Format == "text" {
+                handler = slog.NewTextHandler(os.Stderr, options)
+        } else {
+                handler = slog.NewJSONHandler(os.Stderr, options)
+        }
+        slog.SetDefault(slog.New(handler))
+}
+
+// redactAttr hides the values of sensitive attributes and masks email addresses in any text
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+        if sensitiveLogKeys[strings.ToLower(attr.Key)] {
+                return slog.String(attr.Key, redactedValue)
+        }
+        if attr.Value.Kind() == slog.KindString {
+                return slog.String(attr.Key, RedactText(attr.Value.String()))
+        }
+        if attr.Value.Kind() == slog.KindAny {
+                if err, ok := attr.Value.Any().(error); ok {
+                        return slog.String(attr.Key, RedactText(err.Error()))
+                }
+        }
+        return attr
+}
+
+// RedactText masks email addresses in free text
+func RedactText(text string) string {
+        return emailPattern.ReplaceAllString(text, "[email]")
+}
+
+// WithLogger returns a copy of ctx carrying a request-scoped logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+        return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger carried by ctx, or the default logger if there is none
+func Logger(ctx context.Context) *slog.Logger {
+        if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+                return logger
+        }
+        return slog.Default()
+}
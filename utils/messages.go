@@ -0,0 +1,45 @@
+package utils
+
+import (
+        "context"
+        "time"
+)
+
+// MessageEditWindow is how long after sending a message its sender may edit it
+// (messages.editWindow, MESSAGE_EDIT_WINDOW)
+func MessageEditWindow() time.Duration {
+        return time.Duration(settings.Messages.EditWindow)
+}
+
+// MessageRetentionMonths is how many months messages about sold, traded or deleted
+// listings are kept, or 0 to keep them forever
+// (messages.retentionMonths, MESSAGE_RETENTION_MONTHS)
+func MessageRetentionMonths() int {
+        return settings.Messages.RetentionMonths
+}
+
+// MessageJobs returns the background jobs that apply the message retention policy
+func MessageJobs() []Job {
+        return []Job{
+                {Name: "purge-old-messages", Run: purgeOldMessages},
+        }
+}
+
+// purgeOldMessages deletes messages past the retention period in conversations
+// whose listing was sold, traded or deleted
+func purgeOldMessages(ctx context.Context, now time.Time) error {
+        months := MessageRetentionMonths()
+        if months == 0 {
+                return nil
+        }
+
+        purged, err := PurgeOldMessages(ctx, now.AddDate(0, -months, 0))
+        if err != nil {
+                return err
+        }
+
+        if purged > 0 {
+                Logger(ctx).Info("Purged old messages", "count", purged, "retentionMonths", months)
+        }
+        return nil
+}
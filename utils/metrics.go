@@ -0,0 +1,269 @@
+package utils
+
+import (
+        "fmt"
+        "io"
+        "math"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+// metricsNamespace prefixes every metric name
+const metricsNamespace = "plantexchange_"
+
+// Request metrics, labelled by mux route template, method and status code
+var (
+        HTTPRequests = NewCounter("http_requests_total",
+                "HTTP requests handled, by route template, method and status code.", "route", "method", "status")
+        HTTPRequestDuration = NewHistogram("http_request_duration_seconds",
+                "Time taken to handle HTTP requests, by route template and method.",
+                []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "route", "method")
+        DeprecatedAPIRequests = NewCounter("deprecated_api_requests_total",
+                "Requests to deprecated unversioned API routes, by route template.", "route")
+)
+
+// Domain metrics
+var (
+        UsersRegistered = NewCounter("users_registered_total", "Accounts registered.")
+        ListingsCreated = NewCounter("listings_created_total", "Listings created, by source (api or import).", "source")
+        MessagesSent    = NewCounter("messages_sent_total", "Messages sent between users.")
+)
+
+// activeSessionWindow is how recently a user must have made a request to count as an active session
+const activeSessionWindow = 15 * time.Minute
+
+// activeSessions records when each logged-in user last made a request
+var activeSessions = struct {
+        sync.Mutex
+        lastSeen map[string]time.Time
+}{lastSeen: make(map[string]time.Time)}
+
+// collectors are written out by WriteMetrics in registration order
+var collectors []collector
+
+// collector is a metric that can write itself in the Prometheus text format
+type collector interface {
+        write(w io.Writer)
+}
+
+// Counter is a monotonically increasing metric with optional labels
+type Counter struct {
+        name   string
+        help   string
+        labels []string
+
+        mu     sync.Mutex
+        values map[string]float64 // keyed by label values joined with labelSeparator
+}
+
+// Histogram counts observations into cumulative buckets, with optional labels
+type Histogram struct {
+        name    string
+        help    string
+        labels  []string
+        buckets []float64
+
+        mu     sync.Mutex
+        series map[string]*histogramSeries
+}
+
+// histogramSeries is one labelled series of a histogram
+type histogramSeries struct {
+        counts []uint64 // per bucket, not cumulative
+        count  uint64
+        sum    float64
+}
+
+// labelSeparator joins label values into map keys; it cannot appear in UTF-8 text
+const labelSeparator = "\xff"
+
+// NewCounter creates and registers a counter
+func NewCounter(name, help string, labels ...string) *Counter {
+        counter := &Counter{name: metricsNamespace + name, help: help, labels: labels, values: make(map[string]float64)}
+        collectors = append(collectors, counter)
+        return counter
+}
+
+// Inc adds one to the series with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+        c.Add(1, labelValues...)
+}
+
+// Add adds n to the series with the given label values
+func (c *Counter) Add(n float64, labelValues ...string) {
+        key := strings.Join(labelValues, labelSeparator)
+        c.mu.Lock()
+        c.values[key] += n
+        c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+
+        writeHeader(w, c.name, c.help, "counter")
+        if len(c.labels) == 0 && len(c.values) == 0 {
+                fmt.Fprintf(w, "%s 0\n", c.name)
+        }
+        for _, key := range sortedKeys(c.values) {
+                fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatValue(c.values[key]))
+        }
+}
+
+// NewHistogram creates and registers a histogram with the given upper bucket bounds
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+        histogram := &Histogram{name: metricsNamespace + name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+        collectors = append(collectors, histogram)
+        return histogram
+}
+
+// Observe records a value in the series with the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+        key := strings.Join(labelValues, labelSeparator)
+        h.mu.Lock()
+        defer h.mu.Unlock()
+
+        series, exists := h.series[key]
+        if !exists {
+                series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+                h.series[key] = series
+        }
+        for i, bound := range h.buckets {
+                if value <= bound {
+                        series.counts[i]++
+                        break
+                }
+        }
+        series.count++
+        series.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+
+        writeHeader(w, h.name, h.help, "histogram")
+        keys := make([]string, 0, len(h.series))
+        for key := range h.series {
+                keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        for _, key := range keys {
+                series := h.series[key]
+                var cumulative uint64
+                for i, bound := range h.buckets {
+                        cumulative += series.counts[i]
+                        fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatValue(bound)), cumulative)
+                }
+                fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), series.count)
+                fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatValue(series.sum))
+                fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), series.count)
+        }
+}
+
+// RecordActiveSession notes that a logged-in user made a request
+func RecordActiveSession(userID string, now time.Time) {
+        activeSessions.Lock()
+        activeSessions.lastSeen[userID] = now
+        activeSessions.Unlock()
+}
+
+// countActiveSessions counts users seen within activeSessionWindow, forgetting older ones
+func countActiveSessions(now time.Time) int {
+        activeSessions.Lock()
+        defer activeSessions.Unlock()
+
+        for userID, lastSeen := range activeSessions.lastSeen {
+                if now.Sub(lastSeen) > activeSessionWindow {
+                        delete(activeSessions.lastSeen, userID)
+                }
+        }
+        return len(activeSessions.lastSeen)
+}
+
+// WriteMetrics writes every metric in the Prometheus text exposition format
+func WriteMetrics(w io.Writer) {
+        for _, c := range collectors {
+                c.write(w)
+        }
+
+        // Gauges read at scrape time
+        writeGauge(w, "active_sessions", "Users who made a logged-in request in the last 15 minutes.", float64(countActiveSessions(time.Now())))
+
+        if db != nil {
+                stats := db.Stats()
+                writeGauge(w, "db_open_connections", "Open database connections, in use or idle.", float64(stats.OpenConnections))
+                writeGauge(w, "db_in_use_connections", "Database connections currently in use.", float64(stats.InUse))
+                writeGauge(w, "db_idle_connections", "Idle database connections.", float64(stats.Idle))
+                writeGauge(w, "db_max_open_connections", "Maximum number of open database connections.", float64(stats.MaxOpenConnections))
+                writeCounterValue(w, "db_wait_count_total", "Connections waited for because the pool was exhausted.", float64(stats.WaitCount))
+                writeCounterValue(w, "db_wait_duration_seconds_total", "Time spent waiting for a connection.", stats.WaitDuration.Seconds())
+        }
+}
+
+// writeGauge writes a single unlabelled gauge
+func writeGauge(w io.Writer, name, help string, value float64) {
+        writeHeader(w, metricsNamespace+name, help, "gauge")
+        fmt.Fprintf(w, "%s%s %s\n", metricsNamespace, name, formatValue(value))
+}
+
+// writeCounterValue writes a single unlabelled counter whose value is tracked elsewhere
+func writeCounterValue(w io.Writer, name, help string, value float64) {
+        writeHeader(w, metricsNamespace+name, help, "counter")
+        fmt.Fprintf(w, "%s%s %s\n", metricsNamespace, name, formatValue(value))
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w io.Writer, name, help, kind string) {
+        fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+        fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// formatLabels renders label names and values joined in key, plus an le label for histogram buckets
+func formatLabels(names []string, key string, le string) string {
+        var pairs []string
+        if len(names) > 0 {
+                values := strings.Split(key, labelSeparator)
+                for i, name := range names {
+                        value := ""
+                        if i < len(values) {
+                                value = values[i]
+                        }
+                        pairs = append(pairs, name+`="`+escapeLabelValue(value)+`"`)
+                }
+        }
+        if le != "" {
+                pairs = append(pairs, `le="`+le+`"`)
+        }
+        if len(pairs) == 0 {
+                return ""
+        }
+        return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue escapes a label value for the text format
+func escapeLabelValue(value string) string {
+        return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue renders a sample value
+func formatValue(value float64) string {
+        if math.IsInf(value, 1) {
+                return "+Inf"
+        }
+        return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a counter's series in a stable order
+func sortedKeys(values map[string]float64) []string {
+        keys := make([]string, 0, len(values))
+        for key := range values {
+                keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        return keys
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "strings"
+        "time"
+
+        "github.com/plantexchange/app/models"
+)
+
+// ScheduledPublishJobs returns the background jobs that publish scheduled draft listings
+func ScheduledPublishJobs() []Job {
+        return []Job{
+                {Name: "publish-scheduled-listings", Run: publishScheduledListings},
+        }
+}
+
+// publishScheduledListings publishes scheduled listings that are due. A listing that
+// was edited into an incomplete state after scheduling goes back to being a draft.
+func publishScheduledListings(ctx context.Context, now time.Time) error {
+        listings, err := GetDueScheduledListings(ctx, now)
+        if err != nil {
+                return err
+        }
+
+        for _, listing := range listings {
+                if problems := listing.PublishProblems(); len(problems) > 0 {
+                        // A listing the owner changed in the meantime is checked again on the next run
+                        err := UnscheduleListing(ctx, listing.ID, listing.UpdatedAt, now)
+                        if errors.Is(err, ErrConflict) {
+                                continue
+                        }
+                        if err != nil {
+                                return err
+                        }
+
+                        _, err = SaveNotification(ctx, models.Notification{
+                                UserID:    listing.UserID,
+                                ListingID: listing.ID,
+                                Kind:      models.NotificationPublishFailed,
+                                Message: fmt.Sprintf("Your scheduled listing %q could not be published: %s. It has been kept as a draft.",
+                                        listing.Title, strings.Join(problems, ", ")),
+                                CreatedAt: now,
+                        })
+                        if err != nil {
+                                return err
+                        }
+                        continue
+                }
+
+                // A listing the owner unscheduled in the meantime is left alone
+                err := PublishScheduledListing(ctx, listing.ID, now, now.Add(ListingLifetime()))
+                if errors.Is(err, ErrConflict) {
+                        continue
+                }
+                if err != nil {
+                        return err
+                }
+
+                _, err = SaveNotification(ctx, models.Notification{
+                        UserID:    listing.UserID,
+                        ListingID: listing.ID,
+                        Kind:      models.NotificationListingPublished,
+                        Message:   fmt.Sprintf("Your scheduled listing %q is now live.", listing.Title),
+                        CreatedAt: now,
+                })
+                if err != nil {
+                        return err
+                }
+        }
+
+        return nil
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+        _ "embed"
+        "encoding/json"
+        "log/slog"
+        "regexp"
+        "strconv"
+        "strings"
+        "sync"
+
+        "github.com/plantexchange/app/models"
+)
+
+//go:embed data/regions.json
+var regionsJSON []byte
+
+//go:embed data/restrictions.json
+var restrictionsJSON []byte
+
+// regionEntry is a city or area in the bundled hardiness zone dataset
+type regionEntry struct {
+        Name    string   `json:"name"`
+        Aliases []string `json:"aliases"`
+        State   string   `json:"state"`
+        Zone    string   `json:"zone"`
+}
+
+// stateEntry is the fallback zone for a whole state
+type stateEntry struct {
+        Name string `json:"name"`
+        Zone string `json:"zone"`
+}
+
+// restrictionRule is a single entry in the bundled restrictions table
+type restrictionRule struct {
+        ID          string   `json:"id"`
+        Match       []string `json:"match"`       // species names or title keywords, "*" for any
+        Types       []string `json:"types"`       // listing types the rule applies to, empty for all
+        FromRegions []string `json:"fromRegions"` // seller states the rule applies to, empty for all
+        ToRegions   []string `json:"toRegions"`   // buyer states the rule applies to, empty for all
+        Severity    string   `json:"severity"`
+        Reason      string   `json:"reason"`
+
+        pattern *regexp.Regexp // the match terms as whole words, compiled when the table loads
+}
+
+var (
+        regionData struct {
+                Regions []regionEntry `json:"regions"`
+                States  []stateEntry  `json:"states"`
+        }
+        restrictionData struct {
+                Rules []restrictionRule `json:"rules"`
+        }
+        regionsOnce sync.Once
+)
+
+// loadRegions parses the bundled region and restriction datasets once
+func loadRegions() {
+        regionsOnce.Do(func() {
+                if err := json.Unmarshal(regionsJSON, &regionData); err != nil {
+                        slog.Error("Cannot parse regions dataset", "error", err)
+                }
+                if err := json.Unmarshal(restrictionsJSON, &restrictionData); err != nil {
+                        slog.Error("Cannot parse restrictions dataset", "error", err)
+                }
+                for i := range restrictionData.Rules {
+                        restrictionData.Rules[i].pattern = matchPattern(restrictionData.Rules[i].Match)
+                }
+        })
+}
+
+// LookupRegion resolves a free-text location to a region and hardiness zone
+// without any network calls. Cities are matched first, then whole states.
+func LookupRegion(location string) (models.Region, bool) {
+        loadRegions()
+
+        locationLower := strings.ToLower(location)
+        if strings.TrimSpace(locationLower) == "" {
+                return models.Region{}, false
+        }
+
+        // Prefer the longest matching city name or alias
+        var best *regionEntry
+        bestLen := 0
+        for i := range regionData.Regions {
+                entry := &regionData.Regions[i]
+                candidates := append([]string{entry.Name}, entry.Aliases...)
+                for _, candidate := range candidates {
+                        candidate = strings.ToLower(candidate)
+                        if len(candidate) > bestLen && strings.Contains(locationLower, candidate) {
+                                best = entry
+                                bestLen = len(candidate)
+                        }
+                }
+        }
+        if best != nil {
+                return models.Region{Name: best.Name, State: best.State, Zone: best.Zone}, true
+        }
+
+        // Fall back to state-level zones
+        for _, state := range regionData.States {
+                if strings.Contains(locationLower, strings.ToLower(state.Name)) {
+                        return models.Region{Name: state.Name, State: state.Name, Zone: state.Zone}, true
+                }
+        }
+
+        return models.Region{}, false
+}
+
+// CheckShipping checks a listing against the buyer's location. It reports
+// hardiness warnings for outdoor growing and any matching rules from the
+// restrictions table. The seller's region is taken from the listing location.
+// If the buyer's location cannot be resolved no restrictions are reported.
+func CheckShipping(listing models.Listing, buyerLocation string) models.ShippingCheck {
+        loadRegions()
+
+        check := models.ShippingCheck{Restrictions: []models.RegionRestriction{}}
+
+        // Nothing can be checked until we know where the buyer is
+        buyerRegion, buyerFound := LookupRegion(buyerLocation)
+        if !buyerFound {
+                return check
+        }
+        check.BuyerRegion = &buyerRegion
+        sellerRegion, sellerFound := LookupRegion(listing.Location)
+
+        // Identify the species from the care sheet first, then the title
+        speciesText := listing.Title
+        if listing.CareSheet != nil && listing.CareSheet.Species != "" {
+                speciesText = listing.CareSheet.Species + " " + listing.Title
+        }
+
+        for _, rule := range restrictionData.Rules {
+                if !ruleMatchesText(rule, speciesText) {
+                        continue
+                }
+                if len(rule.Types) > 0 && !containsFold(rule.Types, listing.Type) {
+                        continue
+                }
+                if len(rule.FromRegions) > 0 && (!sellerFound || !containsFold(rule.FromRegions, sellerRegion.State)) {
+                        continue
+                }
+                // Sending within the same state is not restricted by inter-state quarantine rules
+                if len(rule.FromRegions) > 0 && buyerRegion.State == sellerRegion.State {
+                        continue
+                }
+                if len(rule.ToRegions) > 0 && !containsFold(rule.ToRegions, buyerRegion.State) {
+                        continue
+                }
+
+                check.Restrictions = append(check.Restrictions, models.RegionRestriction{
+                        RuleID:   rule.ID,
+                        Severity: rule.Severity,
+                        Reason:   rule.Reason,
+                })
+        }
+
+        // Warn when an outdoor plant or seed is unlikely to survive the buyer's winters
+        if !strings.EqualFold(listing.PlantType, "indoor") {
+                if entry := matchSpecies(speciesText); entry != nil && entry.MinZone != "" {
+                        if zoneValue(buyerRegion.Zone) < zoneValue(entry.MinZone) {
+                                check.Restrictions = append(check.Restrictions, models.RegionRestriction{
+                                        RuleID:   "hardiness-zone",
+                                        Severity: models.RestrictionWarning,
+                                        Reason: entry.Name + " is hardy to zone " + entry.MinZone + " but " + buyerRegion.Name +
+                                                " is zone " + buyerRegion.Zone + ". It will need protection or to be grown indoors in winter.",
+                                })
+                        }
+                }
+        }
+
+        return check
+}
+
+// matchPattern compiles match terms into a pattern matching any of them as whole
+// words, in the singular or with a plain plural ending, with any spacing between words
+func matchPattern(match []string) *regexp.Regexp {
+        var terms []string
+        for _, term := range match {
+                if term != "*" {
+                        terms = append(terms, strings.Join(strings.Fields(regexp.QuoteMeta(term)), `\s+`))
+                }
+        }
+        if len(terms) == 0 {
+                return nil
+        }
+        return regexp.MustCompile(`(?i)\b(?:` + strings.Join(terms, "|") + `)(?:e?s)?\b`)
+}
+
+// ruleMatchesText reports whether any of the rule's match terms appear in text as whole words
+func ruleMatchesText(rule restrictionRule, text string) bool {
+        for _, term := range rule.Match {
+                if term == "*" {
+                        return true
+                }
+        }
+        return rule.pattern != nil && rule.pattern.MatchString(text)
+}
+
+// containsFold reports whether values contains s, ignoring case
+func containsFold(values []string, s string) bool {
+        for _, value := range values {
+                if strings.EqualFold(value, s) {
+                        return true
+                }
+        }
+        return false
+}
+
+// zoneValue converts a zone like "10b" to a comparable number (10b -> 21)
+func zoneValue(zone string) int {
+        zone = strings.ToLower(strings.TrimSpace(zone))
+        if zone == "" {
+                return 0
+        }
+
+        half := 0
+        switch zone[len(zone)-1] {
+        case 'a':
+                zone = zone[:len(zone)-1]
+        case 'b':
+                zone = zone[:len(zone)-1]
+                half = 1
+        }
+
+        number, err := strconv.Atoi(zone)
+        if err != nil {
+                return 0
+        }
+        return number*2 + half
+}
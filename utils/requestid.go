@@ -0,0 +1,30 @@
+package utils
+
+import (
+        "context"
+        "crypto/rand"
+        "encoding/hex"
+)
+
+// requestIDKey is the context key for the request ID
+type requestIDKey struct{}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+        b := make([]byte, 8)
+        if _, err := rand.Read(b); err != nil {
+                return "unknown"
+        }
+        return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+        return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or "" if there is none
+func RequestIDFromContext(ctx context.Context) string {
+        requestID, _ := ctx.Value(requestIDKey{}).(string)
+        return requestID
+}
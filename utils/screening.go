@@ -0,0 +1,392 @@
+package utils
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "log/slog"
+        "net/url"
+        "regexp"
+        "strings"
+        "sync"
+        "time"
+        "unicode/utf8"
+
+        "github.com/plantexchange/app/config"
+        "github.com/plantexchange/app/models"
+)
+
+// Content screening. New messages and listings, and edits to them, go through every
+// screening check before they are saved; each check flags what it finds with a score, and content
+// whose total reaches screening.holdScore is held in the moderation queue until
+// it is approved or rejected with the moderation command.
+
+// minDuplicateLength is the shortest message the duplicate check looks at, so that
+// short greetings and questions sent to several sellers are not flagged
+const minDuplicateLength = 40
+
+// ScreenedContent is a new or edited message or listing to screen
+type ScreenedContent struct {
+        Kind        string // models.ContentMessage, ContentListing, ContentMessageEdit or ContentListingEdit
+        UserID      string // the author
+        RecipientID string // messages only
+        Text        string // a message's content, or a listing's title, description and trade wishes
+}
+
+// ScreeningCheck looks for one kind of spam or scam in new content. It returns what
+// it found, or an error to refuse the content outright, such as a RateLimitError.
+// Flags without a check name get the check's name.
+type ScreeningCheck struct {
+        Name string
+        Run  func(ctx context.Context, content ScreenedContent, now time.Time) ([]models.ScreeningFlag, error)
+}
+
+// screeningChecks run on every new message and listing, in order
+var screeningChecks = []ScreeningCheck{
+        {Name: "send-rate", Run: checkSendRate},
+        {Name: "rules", Run: checkScreeningRules},
+        {Name: "off-platform-payment", Run: checkOffPlatformPayment},
+        {Name: "external-links", Run: checkExternalLinks},
+        {Name: "duplicate-messages", Run: checkDuplicateMessages},
+}
+
+// AddScreeningCheck adds a check to run after the built-in ones. It must be called
+// before the server starts handling requests.
+func AddScreeningCheck(check ScreeningCheck) {
+        screeningChecks = append(screeningChecks, check)
+}
+
+// Screen runs every screening check on new content and totals the scores of what
+// they found. The content should be held if the result is marked held.
+func Screen(ctx context.Context, content ScreenedContent, now time.Time) (models.Screening, error) {
+        screening := models.Screening{Flags: []models.ScreeningFlag{}}
+        for _, check := range screeningChecks {
+                flags, err := check.Run(ctx, content, now)
+                if err != nil {
+                        return models.Screening{}, err
+                }
+                for _, flag := range flags {
+                        if flag.Check == "" {
+                                flag.Check = check.Name
+                        }
+                        screening.Score += flag.Score
+                        screening.Flags = append(screening.Flags, flag)
+                }
+        }
+
+        holdScore := settings.Screening.HoldScore
+        screening.Held = holdScore > 0 && screening.Score >= holdScore
+        if len(screening.Flags) > 0 {
+                Logger(ctx).Info("Content flagged by screening", "kind", content.Kind, "userId", content.UserID,
+                        "score", screening.Score, "held", screening.Held)
+        }
+        return screening, nil
+}
+
+// checkSendRate refuses a message once its author has sent screening.messagesPerHour
+// in the past hour
+func checkSendRate(ctx context.Context, content ScreenedContent, now time.Time) ([]models.ScreeningFlag, error) {
+        limit := settings.Screening.MessagesPerHour
+        if content.Kind != models.ContentMessage || limit == 0 {
+                return nil, nil
+        }
+
+        sent, err := CountMessagesSentSince(ctx, content.UserID, now.Add(-time.Hour))
+        if err != nil {
+                return nil, err
+        }
+        if sent >= limit {
+                return nil, RateLimitError("You can send up to %d messages an hour; please wait before sending more", limit)
+        }
+        return nil, nil
+}
+
+// screeningRule is a configured screening rule with its pattern compiled
+type screeningRule struct {
+        name    string
+        pattern *regexp.Regexp
+        score   int
+}
+
+// compiledRules caches the configured rules, compiled for the settings they came from
+var compiledRules struct {
+        sync.Mutex
+        settings *config.Config
+        rules    []screeningRule
+}
+
+// screeningRules returns the configured screening rules, compiling them the first
+// time they are used with the current settings
+func screeningRules() []screeningRule {
+        compiledRules.Lock()
+        defer compiledRules.Unlock()
+
+        if compiledRules.settings == settings {
+                return compiledRules.rules
+        }
+
+        var rules []screeningRule
+        for _, rule := range settings.Screening.Rules {
+                pattern := rule.Pattern
+                if pattern == "" {
+                        // Keywords match as whole words with any spacing between them
+                        var keywords []string
+                        for _, keyword := range rule.Keywords {
+                                keywords = append(keywords, strings.Join(strings.Fields(regexp.QuoteMeta(keyword)), `\s+`))
+                        }
+                        pattern = `\b(?:` + strings.Join(keywords, "|") + `)\b`
+                }
+                compiled, err := regexp.Compile("(?i)" + pattern)
+                if err != nil {
+                        // Rules are validated when the configuration loads
+                        slog.Error("Invalid screening rule", "rule", rule.Name, "error", err)
+                        continue
+                }
+                rules = append(rules, screeningRule{name: rule.Name, pattern: compiled, score: rule.Score})
+        }
+
+        compiledRules.settings = settings
+        compiledRules.rules = rules
+        return rules
+}
+
+// checkScreeningRules flags content matching the configured rules (screening.rules)
+func checkScreeningRules(ctx context.Context, content ScreenedContent, now time.Time) ([]models.ScreeningFlag, error) {
+        var flags []models.ScreeningFlag
+        for _, rule := range screeningRules() {
+                if match := rule.pattern.FindString(content.Text); match != "" {
+                        flags = append(flags, models.ScreeningFlag{
+                                Reason: fmt.Sprintf("Matches rule %s: %q", rule.name, match),
+                                Score:  rule.score,
+                        })
+                }
+        }
+        return flags, nil
+}
+
+// offPlatformPayment matches payment methods favored by scams because they cannot be
+// reversed or offer no buyer protection
+var offPlatformPayment = regexp.MustCompile(`(?i)\b(?:` +
+        `wire\s+transfer|bank\s+transfer|western\s+union|moneygram|zelle|cash\s*app|venmo|` +
+        `gift\s*cards?|itunes\s+cards?|steam\s+cards?|bitcoin|btc|crypto(?:currency)?|usdt|` +
+        `friends\s+(?:and|&)\s+family|f\s*&\s*f|pay\s+(?:me\s+)?outside|deposit\s+(?:first|upfront|up\s+front))\b`)
+
+// checkOffPlatformPayment flags requests to pay by methods that scams favor
+// (screening.paymentScore)
+func checkOffPlatformPayment(ctx context.Context, content ScreenedContent, now time.Time) ([]models.ScreeningFlag, error) {
+        score := settings.Screening.PaymentScore
+        if score == 0 {
+                return nil, nil
+        }
+
+        if match := offPlatformPayment.FindString(content.Text); match != "" {
+                return []models.ScreeningFlag{{Reason: fmt.Sprintf("Asks for payment by %q", match), Score: score}}, nil
+        }
+        return nil, nil
+}
+
+// externalLink matches web addresses, with or without a scheme
+var externalLink = regexp.MustCompile(`(?i)\b(?:https?://[^\s<>"']+|www\.[^\s<>"']+|` +
+        `[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|net|org|info|biz|io|co|me|ly|xyz|top|site|online|shop|store|app|link|click)\b(?:/[^\s<>"']*)?)`)
+
+// checkExternalLinks flags links to sites other than this one (screening.linkScore)
+func checkExternalLinks(ctx context.Context, content ScreenedContent, now time.Time) ([]models.ScreeningFlag, error) {
+        score := settings.Screening.LinkScore
+        if score == 0 {
+                return nil, nil
+        }
+
+        ownHost := ""
+        if parsed, err := url.Parse(PublicURL()); err == nil {
+                ownHost = strings.ToLower(parsed.Hostname())
+        }
+
+        var hosts []string
+        seen := map[string]bool{}
+        for _, link := range externalLink.FindAllString(content.Text, -1) {
+                if !strings.Contains(link, "://") {
+                        link = "http://" + link
+                }
+                parsed, err := url.Parse(link)
+                if err != nil {
+                        continue
+                }
+                host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+                if host == "" || host == strings.TrimPrefix(ownHost, "www.") || seen[host] {
+                        continue
+                }
+                seen[host] = true
+                hosts = append(hosts, host)
+        }
+
+        if len(hosts) == 0 {
+                return nil, nil
+        }
+        return []models.ScreeningFlag{{Reason: "Links to " + strings.Join(hosts, ", "), Score: score}}, nil
+}
+
+// checkDuplicateMessages flags a message its author already sent to more than
+// screening.duplicateRecipients other users within screening.duplicateWindow
+// (screening.duplicateScore)
+func checkDuplicateMessages(ctx context.Context, content ScreenedContent, now time.Time) ([]models.ScreeningFlag, error) {
+        cfg := settings.Screening
+        if content.Kind != models.ContentMessage || cfg.DuplicateScore == 0 ||
+                utf8.RuneCountInString(strings.TrimSpace(content.Text)) < minDuplicateLength {
+                return nil, nil
+        }
+
+        recipients, err := CountDuplicateRecipients(ctx, content.UserID, content.RecipientID, content.Text,
+                now.Add(-time.Duration(cfg.DuplicateWindow)))
+        if err != nil {
+                return nil, err
+        }
+        if recipients < cfg.DuplicateRecipients {
+                return nil, nil
+        }
+        return []models.ScreeningFlag{{
+                Reason: fmt.Sprintf("Same message already sent to %d other users", recipients),
+                Score:  cfg.DuplicateScore,
+        }}, nil
+}
+
+// ApproveHeldContent delivers content held for moderation as if it had just been
+// submitted, sending the message, creating the listing or saving the edit, and
+// tells the author. It returns the ID of the new or edited message or listing.
+func ApproveHeldContent(ctx context.Context, id string, now time.Time) (string, error) {
+        held, err := GetHeldContent(ctx, id)
+        if err != nil {
+                return "", err
+        }
+
+        // Claim the content first so that it is delivered only once
+        if err := SetHeldContentStatus(ctx, id, models.ModerationPending, models.ModerationApproved, &now); err != nil {
+                return "", err
+        }
+        deliveredID, notification, err := deliverHeldContent(ctx, held, now)
+        if err != nil {
+                if revertErr := SetHeldContentStatus(ctx, id, models.ModerationApproved, models.ModerationPending, nil); revertErr != nil {
+                        Logger(ctx).Error("Cannot return undelivered content to the moderation queue", "heldId", id, "error", revertErr)
+                }
+                return "", err
+        }
+
+        notification.UserID = held.UserID
+        notification.Kind = models.NotificationContentApproved
+        notification.CreatedAt = now
+        if _, err := SaveNotification(ctx, notification); err != nil {
+                Logger(ctx).Error("Cannot notify author of approved content", "heldId", id, "error", err)
+        }
+        return deliveredID, nil
+}
+
+// deliverHeldContent saves held content, returning its new ID and the notification
+// telling the author
+func deliverHeldContent(ctx context.Context, held models.HeldContent, now time.Time) (string, models.Notification, error) {
+        switch held.Kind {
+        case models.ContentMessage:
+                var msg models.Message
+                if err := json.Unmarshal(held.Content, &msg); err != nil {
+                        return "", models.Notification{}, InternalError(err)
+                }
+                msg.CreatedAt = now
+                messageID, err := SaveMessage(ctx, msg)
+                if err != nil {
+                        return "", models.Notification{}, err
+                }
+                MessagesSent.Inc()
+                return messageID, models.Notification{
+                        ListingID: msg.ListingID,
+                        Message:   "Your message has been reviewed and delivered.",
+                }, nil
+
+        case models.ContentListing:
+                var listing models.Listing
+                if err := json.Unmarshal(held.Content, &listing); err != nil {
+                        return "", models.Notification{}, InternalError(err)
+                }
+                listing.CreatedAt = now
+                listing.UpdatedAt = now
+                if listing.Status == models.ListingStatusAvailable {
+                        listing.ExpiresAt = now.Add(ListingLifetime())
+                }
+                listingID, err := SaveListing(ctx, listing)
+                if err != nil {
+                        return "", models.Notification{}, err
+                }
+                ListingsCreated.Inc("api")
+                return listingID, models.Notification{
+                        ListingID: listingID,
+                        Message:   fmt.Sprintf("Your listing %q has been reviewed and created.", listing.Title),
+                }, nil
+
+        case models.ContentMessageEdit:
+                var msg models.Message
+                if err := json.Unmarshal(held.Content, &msg); err != nil {
+                        return "", models.Notification{}, InternalError(err)
+                }
+                // The edit window was checked when the edit was submitted
+                if err := EditMessage(ctx, msg.ID, msg.FromID, msg.Content, now, time.Time{}); err != nil {
+                        return "", models.Notification{}, err
+                }
+                return msg.ID, models.Notification{
+                        ListingID: msg.ListingID,
+                        Message:   "Your message edit has been reviewed and saved.",
+                }, nil
+
+        case models.ContentListingEdit:
+                var edit models.ListingEdit
+                if err := json.Unmarshal(held.Content, &edit); err != nil {
+                        return "", models.Notification{}, InternalError(err)
+                }
+                listing, err := GetListing(ctx, edit.ListingID)
+                if err != nil {
+                        return "", models.Notification{}, err
+                }
+
+                // Apply the held changes to the listing as it is now, keeping anything the owner changed since
+                edit.ApplyTo(&listing)
+                if !listing.IsDraft() {
+                        if problems := listing.PublishProblems(); len(problems) > 0 {
+                                return "", models.Notification{}, ValidationError("Cannot update listing: "+strings.Join(problems, ", "), nil)
+                        }
+                }
+                listing.UpdatedAt = now
+                if _, err := SaveListing(ctx, listing); err != nil {
+                        return "", models.Notification{}, err
+                }
+                return listing.ID, models.Notification{
+                        ListingID: listing.ID,
+                        Message:   fmt.Sprintf("Your changes to listing %q have been reviewed and saved.", listing.Title),
+                }, nil
+        }
+
+        return "", models.Notification{}, InternalError(fmt.Errorf("unknown held content kind %q", held.Kind))
+}
+
+// RejectHeldContent discards content held for moderation and tells the author.
+// Photos of a rejected message are deleted with other unsent uploads.
+func RejectHeldContent(ctx context.Context, id string, now time.Time) error {
+        held, err := GetHeldContent(ctx, id)
+        if err != nil {
+                return err
+        }
+
+        if err := SetHeldContentStatus(ctx, id, models.ModerationPending, models.ModerationRejected, &now); err != nil {
+                return err
+        }
+
+        message := fmt.Sprintf("Your %s was not delivered because it looks like spam or a scam.", held.Kind)
+        if kind, edit := strings.CutSuffix(held.Kind, "_edit"); edit {
+                message = fmt.Sprintf("Your changes to a %s were not saved because they look like spam or a scam.", kind)
+        }
+        _, err = SaveNotification(ctx, models.Notification{
+                UserID:    held.UserID,
+                Kind:      models.NotificationContentRejected,
+                Message:   message,
+                CreatedAt: now,
+        })
+        if err != nil {
+                Logger(ctx).Error("Cannot notify author of rejected content", "heldId", id, "error", err)
+        }
+        return nil
+}
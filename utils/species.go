@@ -0,0 +1,91 @@
+package utils
+
+import (
+        _ "embed"
+        "encoding/json"
+        "log/slog"
+        "strings"
+        "sync"
+
+        "github.com/plantexchange/app/models"
+)
+
+//go:embed data/species.json
+var speciesJSON []byte
+
+// speciesEntry is a single species in the bundled dataset
+type speciesEntry struct {
+        Name    string           `json:"name"`
+        Aliases []string         `json:"aliases"`
+        MinZone string           `json:"minZone"` // coldest hardiness zone it survives outdoors
+        Care    models.CareSheet `json:"care"`
+}
+
+// speciesDataset is the parsed form of data/species.json
+type speciesDataset struct {
+        Species  []speciesEntry              `json:"species"`
+        Defaults map[string]models.CareSheet `json:"defaults"`
+}
+
+var (
+        species     speciesDataset
+        speciesOnce sync.Once
+)
+
+// loadSpecies parses the bundled species dataset once
+func loadSpecies() {
+        speciesOnce.Do(func() {
+                if err := json.Unmarshal(speciesJSON, &species); err != nil {
+                        slog.Error("Cannot parse species dataset", "error", err)
+                }
+        })
+}
+
+// DefaultCareSheet builds a care sheet for a listing from the bundled species
+// dataset. The species is matched against the listing title; if none matches,
+// generic defaults for the plant type are used. The second return value is
+// false when nothing at all could be suggested.
+func DefaultCareSheet(title, plantType string) (models.CareSheet, bool) {
+        best := matchSpecies(title)
+
+        var careSheet models.CareSheet
+        if best != nil {
+                careSheet = best.Care
+                careSheet.Species = best.Name
+        }
+
+        // Fill anything the species entry left out from the plant type defaults
+        if defaults, ok := species.Defaults[strings.ToLower(plantType)]; ok {
+                careSheet.FillFrom(defaults)
+        }
+
+        if careSheet.IsEmpty() {
+                return models.CareSheet{}, false
+        }
+
+        return careSheet, true
+}
+
+// matchSpecies finds the species whose name or alias appears in text, or nil
+func matchSpecies(text string) *speciesEntry {
+        loadSpecies()
+
+        textLower := strings.ToLower(text)
+
+        // Prefer the longest matching name or alias so "rubber plant" beats "plant"
+        var best *speciesEntry
+        bestLen := 0
+        for i := range species.Species {
+                entry := &species.Species[i]
+                candidates := append([]string{entry.Name}, entry.Aliases...)
+                for _, candidate := range candidates {
+                        candidate = strings.ToLower(candidate)
+                        if len(candidate) > bestLen && strings.Contains(textLower, candidate) {
+                                best = entry
+                                bestLen = len(candidate)
+                        }
+                }
+        }
+
+        return best
+}
@@ -1,227 +1,357 @@
 package utils
 
 import (
+        "context"
+        "crypto/sha256"
         "database/sql"
-        "log"
+        "encoding/base64"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
         "strconv"
+        "strings"
         "time"
 
+        "github.com/lib/pq"
+
         "github.com/plantexchange/app/models"
 )
 
-// PostgreSQL storage implementation
+// PostgreSQL storage implementation. Functions return typed errors (see errors.go):
+// ErrNotFound when a record does not exist, ErrConflict for duplicates,
+// ErrValidation for invalid data and ErrInternal for anything else.
+//
+// Every function takes the caller's context, so a client disconnect cancels its
+// queries. Each call is also bounded by database.queryTimeout, or
+// database.transactionTimeout for multi-statement transactions; running out of
+// time returns ErrTimeout.
+
+// userColumns are the users columns read by scanUser, with the user's follow counts
+const userColumns = `id, email, username, password, name, location, bio, profile_pic, created_at, last_login_at,
+                       deletion_scheduled_at, deleted_at,
+                       (SELECT COUNT(*) FROM follows WHERE followed_id = users.id),
+                       (SELECT COUNT(*) FROM follows WHERE follower_id = users.id)`
+
+// scanUser scans a row selected with userColumns
+func scanUser(row rowScanner) (models.User, error) {
+        var user models.User
+        var id int
+        var deletionScheduledAt, deletedAt sql.NullTime
+
+        err := row.Scan(&id, &user.Email, &user.Username, &user.Password, &user.Name, &user.Location, &user.Bio,
+                &user.ProfilePic, &user.CreatedAt, &user.LastLoginAt, &deletionScheduledAt, &deletedAt,
+                &user.FollowerCount, &user.FollowingCount)
+        if err != nil {
+                return models.User{}, err
+        }
+
+        user.ID = strconv.Itoa(id)
+        if deletionScheduledAt.Valid {
+                user.DeletionScheduledAt = &deletionScheduledAt.Time
+        }
+        if deletedAt.Valid {
+                user.DeletedAt = &deletedAt.Time
+        }
+
+        return user, nil
+}
 
 // GetUsers retrieves all users from the database
-func GetUsers() []models.User {
-        rows, err := GetDB().Query(`
-                SELECT id, email, username, password, name, location, bio, profile_pic, created_at, last_login_at
+func GetUsers(ctx context.Context) ([]models.User, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT ` + userColumns + `
                 FROM users
         `)
         if err != nil {
-                log.Printf("Error getting users: %v", err)
-                return []models.User{}
+                return nil, dbError(ctx, err, "user")
         }
         defer rows.Close()
 
         users := []models.User{}
         for rows.Next() {
-                var user models.User
-                var id int
-                err := rows.Scan(&id, &user.Email, &user.Username, &user.Password, &user.Name, &user.Location, &user.Bio, &user.ProfilePic, &user.CreatedAt, &user.LastLoginAt)
+                user, err := scanUser(rows)
                 if err != nil {
-                        log.Printf("Error scanning user row: %v", err)
-                        continue
+                        return nil, dbError(ctx, err, "user")
                 }
-                user.ID = strconv.Itoa(id)
 
                 // Get user favorites
-                user.Favorites = GetFavorites(user.ID)
+                user.Favorites, err = GetFavorites(ctx, user.ID)
+                if err != nil {
+                        return nil, err
+                }
 
                 users = append(users, user)
         }
 
         if err = rows.Err(); err != nil {
-                log.Printf("Error iterating user rows: %v", err)
+                return nil, dbError(ctx, err, "user")
         }
 
-        return users
+        return users, nil
 }
 
 // GetUser retrieves a user by ID from the database
-func GetUser(id string) (models.User, bool) {
-        var user models.User
-        var dbID int
-
-        userID, err := strconv.Atoi(id)
-        if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return models.User{}, false
-        }
-
-        err = GetDB().QueryRow(`
-                SELECT id, email, username, password, name, location, bio, profile_pic, created_at, last_login_at
-                FROM users
-                WHERE id = $1
-        `, userID).Scan(&dbID, &user.Email, &user.Username, &user.Password, &user.Name, &user.Location, &user.Bio, &user.ProfilePic, &user.CreatedAt, &user.LastLoginAt)
+func GetUser(ctx context.Context, id string) (models.User, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
+        userID, err := parseID(id, "user")
         if err != nil {
-                if err == sql.ErrNoRows {
-                        return models.User{}, false
-                }
-                log.Printf("Error getting user by ID: %v", err)
-                return models.User{}, false
+                return models.User{}, err
         }
 
-        user.ID = strconv.Itoa(dbID)
-        
-        // Get user favorites
-        user.Favorites = GetFavorites(user.ID)
-
-        return user, true
+        return getUserWhere(ctx, `id = $1`, userID)
 }
 
 // GetUserByEmail retrieves a user by email from the database
-func GetUserByEmail(email string) (models.User, bool) {
-        var user models.User
-        var id int
-
-        err := GetDB().QueryRow(`
-                SELECT id, email, username, password, name, location, bio, profile_pic, created_at, last_login_at
-                FROM users
-                WHERE email = $1
-        `, email).Scan(&id, &user.Email, &user.Username, &user.Password, &user.Name, &user.Location, &user.Bio, &user.ProfilePic, &user.CreatedAt, &user.LastLoginAt)
+func GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
-        if err != nil {
-                if err == sql.ErrNoRows {
-                        return models.User{}, false
-                }
-                log.Printf("Error getting user by email: %v", err)
-                return models.User{}, false
-        }
-
-        user.ID = strconv.Itoa(id)
-        
-        // Get user favorites
-        user.Favorites = GetFavorites(user.ID)
-
-        return user, true
+        return getUserWhere(ctx, `email = $1`, email)
 }
 
 // GetUserByUsername retrieves a user by username from the database
-func GetUserByUsername(username string) (models.User, bool) {
-        var user models.User
-        var id int
+func GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
-        err := GetDB().QueryRow(`
-                SELECT id, email, username, password, name, location, bio, profile_pic, created_at, last_login_at
-                FROM users
-                WHERE username = $1
-        `, username).Scan(&id, &user.Email, &user.Username, &user.Password, &user.Name, &user.Location, &user.Bio, &user.ProfilePic, &user.CreatedAt, &user.LastLoginAt)
+        return getUserWhere(ctx, `username = $1`, username)
+}
 
+// getUserWhere retrieves the single user matching a condition, with their favorites
+func getUserWhere(ctx context.Context, condition string, arg interface{}) (models.User, error) {
+        user, err := scanUser(GetDB().QueryRowContext(ctx, `
+                SELECT `+userColumns+`
+                FROM users
+                WHERE `+condition, arg))
         if err != nil {
-                if err == sql.ErrNoRows {
-                        return models.User{}, false
-                }
-                log.Printf("Error getting user by username: %v", err)
-                return models.User{}, false
+                return models.User{}, dbError(ctx, err, "user")
         }
 
-        user.ID = strconv.Itoa(id)
-        
         // Get user favorites
-        user.Favorites = GetFavorites(user.ID)
+        user.Favorites, err = GetFavorites(ctx, user.ID)
+        if err != nil {
+                return models.User{}, err
+        }
 
-        return user, true
+        return user, nil
 }
 
-// SaveUser saves a user to the database
-func SaveUser(user models.User) string {
+// SaveUser saves a user to the database. A duplicate email or username is a conflict.
+func SaveUser(ctx context.Context, user models.User) (string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
         // If the user has no ID, insert a new user
         if user.ID == "" {
                 var id int
-                err := GetDB().QueryRow(`
+                err := GetDB().QueryRowContext(ctx, `
                         INSERT INTO users (email, username, password, name, location, bio, profile_pic, created_at, last_login_at)
                         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
                         RETURNING id
                 `, user.Email, user.Username, user.Password, user.Name, user.Location, user.Bio, user.ProfilePic, user.CreatedAt, user.LastLoginAt).Scan(&id)
 
                 if err != nil {
-                        log.Printf("Error creating user: %v", err)
-                        return ""
+                        return "", dbError(ctx, err, "user")
                 }
 
-                return strconv.Itoa(id)
+                return strconv.Itoa(id), nil
         }
 
         // User has an ID, update existing user
-        userID, err := strconv.Atoi(user.ID)
+        userID, err := parseID(user.ID, "user")
         if err != nil {
-                log.Printf("Invalid user ID for update: %v", err)
-                return ""
+                return "", err
         }
 
-        _, err = GetDB().Exec(`
+        result, err := GetDB().ExecContext(ctx, `
                 UPDATE users
                 SET email = $1, username = $2, password = $3, name = $4, location = $5, bio = $6, profile_pic = $7, last_login_at = $8
                 WHERE id = $9
         `, user.Email, user.Username, user.Password, user.Name, user.Location, user.Bio, user.ProfilePic, user.LastLoginAt, userID)
 
         if err != nil {
-                log.Printf("Error updating user: %v", err)
-                return ""
+                return "", dbError(ctx, err, "user")
+        }
+        if err := requireRowsAffected(ctx, result, "user"); err != nil {
+                return "", err
         }
 
-        return user.ID
+        return user.ID, nil
+}
+
+// requireRowsAffected returns a not found error if an update or delete matched nothing
+func requireRowsAffected(ctx context.Context, result sql.Result, what string) error {
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return dbError(ctx, err, what)
+        }
+        if rowsAffected == 0 {
+                return NotFoundError("%s not found", capitalize(what))
+        }
+        return nil
 }
 
 // GetListings retrieves all listings from the database
-func GetListings() []models.Listing {
-        rows, err := GetDB().Query(`
-                SELECT l.id, l.user_id, l.title, l.description, l.type, l.plant_type, l.price,
-                           l.trade_for, l.location, l.created_at, l.updated_at, l.status
+func GetListings(ctx context.Context) ([]models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        return queryListings(ctx, `
+                SELECT ` + listingColumns + `
                 FROM listings l
                 ORDER BY l.created_at DESC
         `)
+}
+
+// ListingFilter narrows the listings returned by GetAvailableListings; empty fields match anything
+type ListingFilter struct {
+        UserID    string
+        Type      string
+        PlantType string
+        Location  string // part of the location, case-insensitive
+}
+
+// GetAvailableListings retrieves up to limit available listings matching a filter with
+// their sellers, newest first, for the feeds and sitemap. Images are not loaded, and
+// sellers only have their ID, username, name and location.
+func GetAvailableListings(ctx context.Context, filter ListingFilter, limit int) ([]models.ListingWithUser, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT `+listingColumns+`, u.username, u.name, u.location
+                FROM listings l
+                JOIN users u ON u.id = l.user_id
+                WHERE l.status = $1
+                  AND ($2 = '' OR l.user_id::text = $2)
+                  AND ($3 = '' OR l.type = $3)
+                  AND ($4 = '' OR l.plant_type = $4)
+                  AND ($5 = '' OR strpos(lower(l.location), lower($5)) > 0)
+                ORDER BY l.created_at DESC
+                LIMIT $6
+        `, models.ListingStatusAvailable, filter.UserID, filter.Type, filter.PlantType, filter.Location, limit)
         if err != nil {
-                log.Printf("Error getting listings: %v", err)
-                return []models.Listing{}
+                return nil, dbError(ctx, err, "listing")
         }
         defer rows.Close()
 
-        listings := []models.Listing{}
+        listings := []models.ListingWithUser{}
         for rows.Next() {
-                var listing models.Listing
-                var id, userID int
-                err := rows.Scan(&id, &userID, &listing.Title, &listing.Description, &listing.Type, &listing.PlantType, &listing.Price,
-                        &listing.TradeFor, &listing.Location, &listing.CreatedAt, &listing.UpdatedAt, &listing.Status)
+                var listing models.ListingWithUser
+                listing.Listing, _, err = scanListing(withColumns(rows, &listing.User.Username, &listing.User.Name, &listing.User.Location))
                 if err != nil {
-                        log.Printf("Error scanning listing row: %v", err)
-                        continue
+                        return nil, dbError(ctx, err, "listing")
                 }
-                listing.ID = strconv.Itoa(id)
-                listing.UserID = strconv.Itoa(userID)
+                listing.User.ID = listing.UserID
+
+                listings = append(listings, listing)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "listing")
+        }
+
+        return listings, nil
+}
+
+// listingColumns is the column list read by scanListing, for queries aliasing listings as l
+const listingColumns = `l.id, l.user_id, l.title, l.description, l.type, l.plant_type, l.price,
+                           l.trade_for, l.location, l.created_at, l.updated_at, l.status, l.expires_at, l.publish_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+        Scan(dest ...interface{}) error
+}
+
+// extraColumns scans columns selected after those a scan function reads
+type extraColumns struct {
+        row   rowScanner
+        extra []interface{}
+}
+
+// withColumns lets a scan function such as scanListing read a row that has more columns,
+// scanning those into extra
+func withColumns(row rowScanner, extra ...interface{}) rowScanner {
+        return extraColumns{row: row, extra: extra}
+}
+
+func (e extraColumns) Scan(dest ...interface{}) error {
+        return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+        QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// scanListing scans a row selected with listingColumns, returning the listing and its numeric ID
+func scanListing(row rowScanner) (models.Listing, int, error) {
+        var listing models.Listing
+        var id, userID int
+        var expiresAt, publishAt sql.NullTime
+
+        err := row.Scan(&id, &userID, &listing.Title, &listing.Description, &listing.Type, &listing.PlantType, &listing.Price,
+                &listing.TradeFor, &listing.Location, &listing.CreatedAt, &listing.UpdatedAt, &listing.Status, &expiresAt, &publishAt)
+        if err != nil {
+                return models.Listing{}, 0, err
+        }
+
+        listing.ID = strconv.Itoa(id)
+        listing.UserID = strconv.Itoa(userID)
+        if expiresAt.Valid {
+                listing.ExpiresAt = expiresAt.Time
+        }
+        if publishAt.Valid {
+                listing.PublishAt = &publishAt.Time
+        }
+
+        return listing, id, nil
+}
+
+// queryListings runs a query selecting listingColumns and loads each listing's images
+func queryListings(ctx context.Context, query string, args ...interface{}) ([]models.Listing, error) {
+        rows, err := GetDB().QueryContext(ctx, query, args...)
+        if err != nil {
+                return nil, dbError(ctx, err, "listing")
+        }
+        defer rows.Close()
 
-                // Get images for the listing
-                images, err := getListingImages(id)
+        listings := []models.Listing{}
+        ids := []int{}
+        for rows.Next() {
+                listing, id, err := scanListing(rows)
                 if err != nil {
-                        log.Printf("Error getting images for listing %d: %v", id, err)
-                } else {
-                        listing.Images = images
+                        return nil, dbError(ctx, err, "listing")
                 }
 
                 listings = append(listings, listing)
+                ids = append(ids, id)
         }
 
         if err = rows.Err(); err != nil {
-                log.Printf("Error iterating listing rows: %v", err)
+                return nil, dbError(ctx, err, "listing")
+        }
+
+        // Get images for the listings
+        for i, id := range ids {
+                images, err := getListingImages(ctx, GetDB(), id)
+                if err != nil {
+                        return nil, dbError(ctx, err, "listing image")
+                }
+                listings[i].Images = images
         }
 
-        return listings
+        return listings, nil
 }
 
 // getListingImages retrieves all images for a listing
-func getListingImages(listingID int) ([]string, error) {
-        rows, err := GetDB().Query(`
+func getListingImages(ctx context.Context, q queryer, listingID int) ([]string, error) {
+        rows, err := q.QueryContext(ctx, `
                 SELECT image_url FROM listing_images
                 WHERE listing_id = $1
                 ORDER BY id
@@ -247,105 +377,212 @@ func getListingImages(listingID int) ([]string, error) {
         return images, nil
 }
 
-// GetListing retrieves a listing by ID from the database
-func GetListing(id string) (models.Listing, bool) {
-        var listing models.Listing
-        var dbID, userID int
+// GetListing retrieves a listing by ID from the database, with its images, care sheet and edit summary
+func GetListing(ctx context.Context, id string) (models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
-        listingID, err := strconv.Atoi(id)
+        listingID, err := parseID(id, "listing")
         if err != nil {
-                log.Printf("Invalid listing ID: %v", err)
-                return models.Listing{}, false
+                return models.Listing{}, err
         }
 
-        err = GetDB().QueryRow(`
-                SELECT id, user_id, title, description, type, plant_type, price,
-                           trade_for, location, created_at, updated_at, status
-                FROM listings
-                WHERE id = $1
-        `, listingID).Scan(&dbID, &userID, &listing.Title, &listing.Description, &listing.Type, &listing.PlantType, &listing.Price,
-                &listing.TradeFor, &listing.Location, &listing.CreatedAt, &listing.UpdatedAt, &listing.Status)
+        listing, dbID, err := scanListing(GetDB().QueryRowContext(ctx, `
+                SELECT `+listingColumns+`
+                FROM listings l
+                WHERE l.id = $1
+        `, listingID))
+        if err != nil {
+                return models.Listing{}, dbError(ctx, err, "listing")
+        }
 
+        // Get images for the listing
+        listing.Images, err = getListingImages(ctx, GetDB(), dbID)
         if err != nil {
-                if err == sql.ErrNoRows {
-                        return models.Listing{}, false
-                }
-                log.Printf("Error getting listing by ID: %v", err)
-                return models.Listing{}, false
+                return models.Listing{}, dbError(ctx, err, "listing image")
         }
 
-        listing.ID = strconv.Itoa(dbID)
-        listing.UserID = strconv.Itoa(userID)
+        // Get the care sheet for the listing
+        listing.CareSheet, err = getListingCareSheet(ctx, dbID)
+        if err != nil {
+                return models.Listing{}, dbError(ctx, err, "care sheet")
+        }
+
+        // Flag edits and price drops made since the listing was published
+        err = setListingEditSummary(ctx, &listing, dbID)
+        if err != nil {
+                return models.Listing{}, dbError(ctx, err, "listing revision")
+        }
+
+        return listing, nil
+}
+
+// getListingCareSheet retrieves the care sheet for a listing, or nil if none is attached
+func getListingCareSheet(ctx context.Context, listingID int) (*models.CareSheet, error) {
+        var careSheet models.CareSheet
+        var species, light, water, humidity, temperature, petToxicity, propagation sql.NullString
+
+        err := GetDB().QueryRowContext(ctx, `
+                SELECT species, light, water, humidity, temperature, pet_toxicity, propagation
+                FROM listing_care_sheets
+                WHERE listing_id = $1
+        `, listingID).Scan(&species, &light, &water, &humidity, &temperature, &petToxicity, &propagation)
 
-        // Get images for the listing
-        images, err := getListingImages(dbID)
         if err != nil {
-                log.Printf("Error getting images for listing %d: %v", dbID, err)
-        } else {
-                listing.Images = images
+                if err == sql.ErrNoRows {
+                        return nil, nil
+                }
+                return nil, err
         }
 
-        return listing, true
+        careSheet.Species = species.String
+        careSheet.Light = light.String
+        careSheet.Water = water.String
+        careSheet.Humidity = humidity.String
+        careSheet.Temperature = temperature.String
+        careSheet.PetToxicity = petToxicity.String
+        careSheet.Propagation = propagation.String
+
+        return &careSheet, nil
+}
+
+// saveListingCareSheet inserts or replaces the care sheet for a listing within a transaction
+func saveListingCareSheet(ctx context.Context, tx *sql.Tx, listingID int, careSheet models.CareSheet) error {
+        _, err := tx.ExecContext(ctx, `
+                INSERT INTO listing_care_sheets (listing_id, species, light, water, humidity, temperature,
+                                                 pet_toxicity, propagation, updated_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+                ON CONFLICT (listing_id) DO UPDATE
+                SET species = EXCLUDED.species, light = EXCLUDED.light, water = EXCLUDED.water,
+                    humidity = EXCLUDED.humidity, temperature = EXCLUDED.temperature,
+                    pet_toxicity = EXCLUDED.pet_toxicity, propagation = EXCLUDED.propagation,
+                    updated_at = EXCLUDED.updated_at
+        `, listingID, StringToNullString(careSheet.Species), StringToNullString(careSheet.Light),
+                StringToNullString(careSheet.Water), StringToNullString(careSheet.Humidity),
+                StringToNullString(careSheet.Temperature), StringToNullString(careSheet.PetToxicity),
+                StringToNullString(careSheet.Propagation))
+        return err
 }
 
 // GetListingsByUser retrieves all listings by a user from the database
-func GetListingsByUser(userID string) []models.Listing {
-        userIDInt, err := strconv.Atoi(userID)
+func GetListingsByUser(ctx context.Context, userID string) ([]models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return []models.Listing{}
+                return nil, err
         }
 
-        rows, err := GetDB().Query(`
-                SELECT l.id, l.user_id, l.title, l.description, l.type, l.plant_type, l.price,
-                           l.trade_for, l.location, l.created_at, l.updated_at, l.status
+        return queryListings(ctx, `
+                SELECT `+listingColumns+`
                 FROM listings l
                 WHERE l.user_id = $1
                 ORDER BY l.created_at DESC
         `, userIDInt)
+}
+
+// GetActiveListingsByUser retrieves a page of a user's available and pending listings,
+// newest first, and fills in the total number of them
+func GetActiveListingsByUser(ctx context.Context, userID string, page *models.Pagination) ([]models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Error getting listings by user: %v", err)
-                return []models.Listing{}
+                return nil, err
         }
-        defer rows.Close()
 
-        listings := []models.Listing{}
-        for rows.Next() {
-                var listing models.Listing
-                var id, dbUserID int
-                err := rows.Scan(&id, &dbUserID, &listing.Title, &listing.Description, &listing.Type, &listing.PlantType, &listing.Price,
-                        &listing.TradeFor, &listing.Location, &listing.CreatedAt, &listing.UpdatedAt, &listing.Status)
-                if err != nil {
-                        log.Printf("Error scanning listing row: %v", err)
-                        continue
-                }
-                listing.ID = strconv.Itoa(id)
-                listing.UserID = strconv.Itoa(dbUserID)
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT COUNT(*) FROM listings
+                WHERE user_id = $1 AND status IN ($2, $3)
+        `, userIDInt, models.ListingStatusAvailable, models.ListingStatusPending).Scan(&page.Total)
+        if err != nil {
+                return nil, dbError(ctx, err, "listing")
+        }
 
-                // Get images for the listing
-                images, err := getListingImages(id)
-                if err != nil {
-                        log.Printf("Error getting images for listing %d: %v", id, err)
-                } else {
-                        listing.Images = images
-                }
+        return queryListings(ctx, `
+                SELECT `+listingColumns+`
+                FROM listings l
+                WHERE l.user_id = $1 AND l.status IN ($2, $3)
+                ORDER BY l.created_at DESC
+                LIMIT $4 OFFSET $5
+        `, userIDInt, models.ListingStatusAvailable, models.ListingStatusPending, page.Limit, page.Offset())
+}
 
-                listings = append(listings, listing)
+// GetUserStats computes a user's trading record. Completed trades are the user's
+// sold and traded listings. The response stats cover conversations other users
+// started with the user, one per sender and listing: the share the user replied
+// to, and the average time from the first message to the user's first reply.
+func GetUserStats(ctx context.Context, userID string) (models.UserStats, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return models.UserStats{}, err
         }
 
-        if err = rows.Err(); err != nil {
-                log.Printf("Error iterating listing rows: %v", err)
+        var stats models.UserStats
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT COUNT(*) FROM listings
+                WHERE user_id = $1 AND status IN ($2, $3)
+        `, userIDInt, models.ListingStatusSold, models.ListingStatusTraded).Scan(&stats.CompletedTrades)
+        if err != nil {
+                return models.UserStats{}, dbError(ctx, err, "listing")
+        }
+
+        // Conversations the user started themselves are not inquiries
+        var inquiries, answered int
+        var averageSeconds sql.NullFloat64
+        err = GetDB().QueryRowContext(ctx, `
+                WITH inquiries AS (
+                        SELECT from_id, listing_id, MIN(created_at) AS asked_at
+                        FROM messages
+                        WHERE to_id = $1 AND from_id IS NOT NULL
+                        GROUP BY from_id, listing_id
+                ), replies AS (
+                        SELECT i.asked_at, (
+                                SELECT MIN(r.created_at) FROM messages r
+                                WHERE r.from_id = $1 AND r.to_id = i.from_id
+                                        AND r.listing_id IS NOT DISTINCT FROM i.listing_id
+                                        AND r.created_at >= i.asked_at
+                        ) AS replied_at
+                        FROM inquiries i
+                        WHERE NOT EXISTS (
+                                SELECT 1 FROM messages s
+                                WHERE s.from_id = $1 AND s.to_id = i.from_id
+                                        AND s.listing_id IS NOT DISTINCT FROM i.listing_id
+                                        AND s.created_at < i.asked_at
+                        )
+                )
+                SELECT COUNT(*), COUNT(replied_at), EXTRACT(EPOCH FROM AVG(replied_at - asked_at))
+                FROM replies
+        `, userIDInt).Scan(&inquiries, &answered, &averageSeconds)
+        if err != nil {
+                return models.UserStats{}, dbError(ctx, err, "message")
         }
 
-        return listings
+        if inquiries > 0 {
+                rate := float64(answered) / float64(inquiries)
+                stats.ResponseRate = &rate
+        }
+        if averageSeconds.Valid {
+                seconds := int64(averageSeconds.Float64)
+                stats.AverageResponseSeconds = &seconds
+        }
+
+        return stats, nil
 }
 
-// SaveListing saves a listing to the database
-func SaveListing(listing models.Listing) string {
-        tx, err := GetDB().Begin()
+// SaveListing saves a listing to the database, returning its ID
+func SaveListing(ctx context.Context, listing models.Listing) (string, error) {
+        ctx, cancel := withTransactionTimeout(ctx)
+        defer cancel()
+
+        tx, err := GetDB().BeginTx(ctx, nil)
         if err != nil {
-                log.Printf("Error starting transaction: %v", err)
-                return ""
+                return "", dbError(ctx, err, "listing")
         }
         defer func() {
                 if err != nil {
@@ -355,115 +592,162 @@ func SaveListing(listing models.Listing) string {
 
         // If the listing has no ID, insert a new listing
         if listing.ID == "" {
-                userID, err := strconv.Atoi(listing.UserID)
-                if err != nil {
-                        log.Printf("Invalid user ID: %v", err)
-                        return ""
-                }
-
                 var id int
-                err = tx.QueryRow(`
-                        INSERT INTO listings (user_id, title, description, type, plant_type, price,
-                                                                 trade_for, location, created_at, updated_at, status)
-                        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-                        RETURNING id
-                `, userID, listing.Title, listing.Description, listing.Type, listing.PlantType, listing.Price,
-                        listing.TradeFor, listing.Location, listing.CreatedAt, listing.UpdatedAt, listing.Status).Scan(&id)
-
+                id, err = insertListing(ctx, tx, listing)
                 if err != nil {
-                        log.Printf("Error creating listing: %v", err)
-                        return ""
-                }
-
-                // Save images
-                for _, imageURL := range listing.Images {
-                        _, err = tx.Exec(`
-                                INSERT INTO listing_images (listing_id, image_url)
-                                VALUES ($1, $2)
-                        `, id, imageURL)
-                        if err != nil {
-                                log.Printf("Error saving listing image: %v", err)
-                                return ""
-                        }
+                        return "", dbError(ctx, err, "listing")
                 }
 
                 err = tx.Commit()
                 if err != nil {
-                        log.Printf("Error committing transaction: %v", err)
-                        return ""
+                        return "", dbError(ctx, err, "listing")
                 }
 
-                return strconv.Itoa(id)
+                return strconv.Itoa(id), nil
         }
 
         // Listing has an ID, update existing listing
-        listingID, err := strconv.Atoi(listing.ID)
+        err = updateListing(ctx, tx, listing)
+        if err != nil {
+                return "", dbError(ctx, err, "listing")
+        }
+
+        err = tx.Commit()
         if err != nil {
-                log.Printf("Invalid listing ID for update: %v", err)
-                return ""
+                return "", dbError(ctx, err, "listing")
         }
 
-        userID, err := strconv.Atoi(listing.UserID)
+        return listing.ID, nil
+}
+
+// updateListing updates an existing listing with its images, care sheet and a new revision within a transaction
+func updateListing(ctx context.Context, tx *sql.Tx, listing models.Listing) error {
+        listingID, err := parseID(listing.ID, "listing")
+        if err != nil {
+                return err
+        }
+
+        userID, err := parseID(listing.UserID, "user")
         if err != nil {
-                log.Printf("Invalid user ID for update: %v", err)
-                return ""
+                return err
         }
 
         listing.UpdatedAt = time.Now()
 
-        _, err = tx.Exec(`
+        // Listings saved before revisions existed get their stored state recorded first
+        err = saveBaselineRevision(ctx, tx, listingID)
+        if err != nil {
+                return err
+        }
+
+        result, err := tx.ExecContext(ctx, `
                 UPDATE listings
                 SET user_id = $1, title = $2, description = $3, type = $4, plant_type = $5,
-                        price = $6, trade_for = $7, location = $8, updated_at = $9, status = $10
-                WHERE id = $11
+                        price = $6, trade_for = $7, location = $8, updated_at = $9, status = $10, expires_at = $11,
+                        publish_at = $12
+                WHERE id = $13
         `, userID, listing.Title, listing.Description, listing.Type, listing.PlantType,
-                listing.Price, listing.TradeFor, listing.Location, listing.UpdatedAt, listing.Status, listingID)
+                listing.Price, listing.TradeFor, listing.Location, listing.UpdatedAt, listing.Status,
+                TimeToNullTime(listing.ExpiresAt), listing.PublishAt, listingID)
+        if err != nil {
+                return err
+        }
+        if err := requireRowsAffected(ctx, result, "listing"); err != nil {
+                return err
+        }
+
+        // Replace images only if they changed, so unchanged image rows are left alone
+        currentImages, err := getListingImages(ctx, tx, listingID)
+        if err != nil {
+                return err
+        }
+
+        if !sameImages(currentImages, listing.Images) {
+                _, err = tx.ExecContext(ctx, `DELETE FROM listing_images WHERE listing_id = $1`, listingID)
+                if err != nil {
+                        return err
+                }
+
+                for _, imageURL := range listing.Images {
+                        _, err = tx.ExecContext(ctx, `
+                                INSERT INTO listing_images (listing_id, image_url)
+                                VALUES ($1, $2)
+                        `, listingID, imageURL)
+                        if err != nil {
+                                return err
+                        }
+                }
+        }
+
+        // Replace the care sheet if one was provided; a nil care sheet leaves the stored one untouched
+        if listing.CareSheet != nil {
+                err = saveListingCareSheet(ctx, tx, listingID, *listing.CareSheet)
+                if err != nil {
+                        return err
+                }
+        }
+
+        // Record the new state as the next revision if buyers would see a difference
+        return saveListingRevision(ctx, tx, listingID, listing, listing.UpdatedAt)
+}
 
+// insertListing inserts a new listing with its images, care sheet and first revision within a transaction
+func insertListing(ctx context.Context, tx *sql.Tx, listing models.Listing) (int, error) {
+        userID, err := parseID(listing.UserID, "user")
         if err != nil {
-                log.Printf("Error updating listing: %v", err)
-                return ""
+                return 0, err
         }
 
-        // Delete existing images and add new ones
-        _, err = tx.Exec(`DELETE FROM listing_images WHERE listing_id = $1`, listingID)
+        var id int
+        err = tx.QueryRowContext(ctx, `
+                INSERT INTO listings (user_id, title, description, type, plant_type, price,
+                                      trade_for, location, created_at, updated_at, status, expires_at, publish_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+                RETURNING id
+        `, userID, listing.Title, listing.Description, listing.Type, listing.PlantType, listing.Price,
+                listing.TradeFor, listing.Location, listing.CreatedAt, listing.UpdatedAt, listing.Status,
+                TimeToNullTime(listing.ExpiresAt), listing.PublishAt).Scan(&id)
         if err != nil {
-                log.Printf("Error deleting listing images: %v", err)
-                return ""
+                return 0, err
         }
 
+        // Save images
         for _, imageURL := range listing.Images {
-                _, err = tx.Exec(`
+                _, err = tx.ExecContext(ctx, `
                         INSERT INTO listing_images (listing_id, image_url)
                         VALUES ($1, $2)
-                `, listingID, imageURL)
+                `, id, imageURL)
                 if err != nil {
-                        log.Printf("Error saving listing image: %v", err)
-                        return ""
+                        return 0, err
                 }
         }
 
-        err = tx.Commit()
+        // Save care sheet
+        if listing.CareSheet != nil {
+                err = saveListingCareSheet(ctx, tx, id, *listing.CareSheet)
+                if err != nil {
+                        return 0, err
+                }
+        }
+
+        // Record the first revision
+        err = saveListingRevision(ctx, tx, id, listing, listing.CreatedAt)
         if err != nil {
-                log.Printf("Error committing transaction: %v", err)
-                return ""
+                return 0, err
         }
 
-        return listing.ID
+        return id, nil
 }
 
-// DeleteListing deletes a listing from the database
-func DeleteListing(id string) bool {
-        listingID, err := strconv.Atoi(id)
-        if err != nil {
-                log.Printf("Invalid listing ID: %v", err)
-                return false
-        }
+// ImportListings creates several new listings in a single transaction. Either all
+// listings are created and their IDs returned in order, or none are.
+func ImportListings(ctx context.Context, listings []models.Listing) ([]string, error) {
+        ctx, cancel := withTransactionTimeout(ctx)
+        defer cancel()
 
-        // Start a transaction
-        tx, err := GetDB().Begin()
+        tx, err := GetDB().BeginTx(ctx, nil)
         if err != nil {
-                log.Printf("Error starting transaction: %v", err)
-                return false
+                return nil, dbError(ctx, err, "listing")
         }
         defer func() {
                 if err != nil {
@@ -471,452 +755,2731 @@ func DeleteListing(id string) bool {
                 }
         }()
 
-        // Delete images first (cascade will handle this, but being explicit)
-        _, err = tx.Exec(`DELETE FROM listing_images WHERE listing_id = $1`, listingID)
-        if err != nil {
-                log.Printf("Error deleting listing images: %v", err)
-                return false
+        ids := make([]string, 0, len(listings))
+        for _, listing := range listings {
+                var id int
+                id, err = insertListing(ctx, tx, listing)
+                if err != nil {
+                        return nil, dbError(ctx, err, "listing")
+                }
+                ids = append(ids, strconv.Itoa(id))
         }
 
-        // Delete the listing
-        result, err := tx.Exec(`DELETE FROM listings WHERE id = $1`, listingID)
+        err = tx.Commit()
         if err != nil {
-                log.Printf("Error deleting listing: %v", err)
-                return false
+                return nil, dbError(ctx, err, "listing")
         }
 
-        // Check if the listing was actually deleted
-        rowsAffected, err := result.RowsAffected()
+        return ids, nil
+}
+
+// DeleteListing deletes a listing from the database
+func DeleteListing(ctx context.Context, id string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        listingID, err := parseID(id, "listing")
         if err != nil {
-                log.Printf("Error getting rows affected: %v", err)
-                return false
+                return err
         }
 
-        // Commit the transaction
-        err = tx.Commit()
+        // Images, care sheets, revisions and favorites are removed by cascade
+        result, err := GetDB().ExecContext(ctx, `DELETE FROM listings WHERE id = $1`, listingID)
         if err != nil {
-                log.Printf("Error committing transaction: %v", err)
-                return false
+                return dbError(ctx, err, "listing")
         }
 
-        return rowsAffected > 0
+        return requireRowsAffected(ctx, result, "listing")
 }
 
-// GetMessages retrieves all messages from the database
-func GetMessages() []models.Message {
-        rows, err := GetDB().Query(`
-                SELECT id, from_id, to_id, listing_id, content, read, created_at
-                FROM messages
-                ORDER BY created_at
-        `)
+// messageColumns are the messages columns read by scanMessage
+const messageColumns = `id, conversation_id, from_id, to_id, listing_id, content, read, created_at,
+        edited_at, deleted_at, hidden_for_sender, hidden_for_recipient`
+
+// scanMessage scans a row selected with messageColumns
+func scanMessage(row rowScanner) (models.Message, error) {
+        var message models.Message
+        var id, fromID, toID int
+        var conversationID, listingID sql.NullInt64
+        var editedAt, deletedAt sql.NullTime
+
+        err := row.Scan(&id, &conversationID, &fromID, &toID, &listingID, &message.Content, &message.Read, &message.CreatedAt,
+                &editedAt, &deletedAt, &message.HiddenForSender, &message.HiddenForRecipient)
         if err != nil {
-                log.Printf("Error getting messages: %v", err)
-                return []models.Message{}
+                return models.Message{}, err
+        }
+        if editedAt.Valid {
+                message.EditedAt = &editedAt.Time
+        }
+        if deletedAt.Valid {
+                message.DeletedAt = &deletedAt.Time
         }
-        defer rows.Close()
 
-        messages := []models.Message{}
-        for rows.Next() {
-                var message models.Message
-                var id, fromID, toID int
-                var listingID sql.NullInt64
-                err := rows.Scan(&id, &fromID, &toID, &listingID, &message.Content, &message.Read, &message.CreatedAt)
-                if err != nil {
-                        log.Printf("Error scanning message row: %v", err)
-                        continue
-                }
-                message.ID = strconv.Itoa(id)
-                message.FromID = strconv.Itoa(fromID)
-                message.ToID = strconv.Itoa(toID)
+        message.ID = strconv.Itoa(id)
+        if conversationID.Valid {
+                message.ConversationID = strconv.FormatInt(conversationID.Int64, 10)
+        }
+        message.FromID = strconv.Itoa(fromID)
+        message.ToID = strconv.Itoa(toID)
+        if listingID.Valid {
+                message.ListingID = strconv.FormatInt(listingID.Int64, 10)
+        }
+
+        return message, nil
+}
+
+// queryMessages runs a query selecting messageColumns
+func queryMessages(ctx context.Context, query string, args ...interface{}) ([]models.Message, error) {
+        rows, err := GetDB().QueryContext(ctx, query, args...)
+        if err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+        defer rows.Close()
+
+        messages := []models.Message{}
+        for rows.Next() {
+                message, err := scanMessage(rows)
+                if err != nil {
+                        return nil, dbError(ctx, err, "message")
+                }
+
+                messages = append(messages, message)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+
+        if err := loadMessageAttachments(ctx, messages); err != nil {
+                return nil, err
+        }
+
+        return messages, nil
+}
+
+// GetMessages retrieves all messages from the database
+func GetMessages(ctx context.Context) ([]models.Message, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        return queryMessages(ctx, `
+                SELECT ` + messageColumns + `
+                FROM messages
+                ORDER BY created_at
+        `)
+}
+
+// GetMessage retrieves a message by ID from the database
+func GetMessage(ctx context.Context, id string) (models.Message, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        messageID, err := parseID(id, "message")
+        if err != nil {
+                return models.Message{}, err
+        }
+
+        message, err := scanMessage(GetDB().QueryRowContext(ctx, `
+                SELECT `+messageColumns+`
+                FROM messages
+                WHERE id = $1
+        `, messageID))
+        if err != nil {
+                return models.Message{}, dbError(ctx, err, "message")
+        }
+
+        messages := []models.Message{message}
+        if err := loadMessageAttachments(ctx, messages); err != nil {
+                return models.Message{}, err
+        }
+
+        return messages[0], nil
+}
+
+// visibleTo is the condition on messages that the user $1 has not deleted for themselves
+const visibleTo = `NOT ((from_id = $1 AND hidden_for_sender) OR (to_id = $1 AND hidden_for_recipient))`
+
+// GetMessagesByUser retrieves all messages for a user from the database, except
+// those they deleted for themselves
+func GetMessagesByUser(ctx context.Context, userID string) ([]models.Message, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        return queryMessages(ctx, `
+                SELECT `+messageColumns+`
+                FROM messages
+                WHERE (from_id = $1 OR to_id = $1) AND `+visibleTo+`
+                ORDER BY created_at
+        `, userIDInt)
+}
+
+// GetConversationMessages retrieves the messages of a conversation as one of its
+// participants sees them, oldest first
+func GetConversationMessages(ctx context.Context, conversationID, userID string) ([]models.Message, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        conversationIDInt, err := parseID(conversationID, "conversation")
+        if err != nil {
+                return nil, err
+        }
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        return queryMessages(ctx, `
+                SELECT `+messageColumns+`
+                FROM messages
+                WHERE conversation_id = $2 AND `+visibleTo+`
+                ORDER BY created_at, id
+        `, userIDInt, conversationIDInt)
+}
+
+// SaveMessage saves a message to the database, returning its ID
+func SaveMessage(ctx context.Context, msg models.Message) (string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        fromID, err := parseID(msg.FromID, "sender")
+        if err != nil {
+                return "", err
+        }
+
+        toID, err := parseID(msg.ToID, "recipient")
+        if err != nil {
+                return "", err
+        }
+        if toID == fromID {
+                return "", ValidationError("You cannot send a message to yourself", map[string]string{"toId": "must be another user"})
+        }
+
+        var listingIDParam interface{} = nil
+        if msg.ListingID != "" {
+                listingIDInt, err := parseID(msg.ListingID, "listing")
+                if err != nil {
+                        return "", err
+                }
+                listingIDParam = listingIDInt
+        }
+
+        // If the message has no ID, insert a new message with its attachments
+        if msg.ID == "" {
+                return insertMessage(ctx, msg, fromID, toID, listingIDParam)
+        }
+
+        // Message has an ID, update existing message
+        messageID, err := parseID(msg.ID, "message")
+        if err != nil {
+                return "", err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE messages
+                SET from_id = $1, to_id = $2, listing_id = $3, content = $4, read = $5
+                WHERE id = $6
+        `, fromID, toID, listingIDParam, msg.Content, msg.Read, messageID)
+        if err != nil {
+                return "", dbError(ctx, err, "message")
+        }
+        if err := requireRowsAffected(ctx, result, "message"); err != nil {
+                return "", err
+        }
+
+        return msg.ID, nil
+}
+
+// insertMessage inserts a new message into the conversation between the sender
+// and recipient about its listing, starting one if needed, and attaches the photos
+// listed in its attachments, which must have been uploaded by the sender and not
+// yet sent
+func insertMessage(ctx context.Context, msg models.Message, fromID, toID int, listingID interface{}) (string, error) {
+        attachmentIDs := make([]int, 0, len(msg.Attachments))
+        for _, attachment := range msg.Attachments {
+                attachmentID, err := parseID(attachment.ID, "attachment")
+                if err != nil {
+                        return "", err
+                }
+                attachmentIDs = append(attachmentIDs, attachmentID)
+        }
+
+        tx, err := GetDB().BeginTx(ctx, nil)
+        if err != nil {
+                return "", dbError(ctx, err, "message")
+        }
+        defer func() {
+                if err != nil {
+                        tx.Rollback()
+                }
+        }()
+
+        var conversationID int
+        conversationID, err = startConversation(ctx, tx, fromID, toID, listingID, msg.CreatedAt)
+        if err != nil {
+                return "", err
+        }
+
+        var id int
+        err = tx.QueryRowContext(ctx, `
+                INSERT INTO messages (conversation_id, from_id, to_id, listing_id, content, read, created_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7)
+                RETURNING id
+        `, conversationID, fromID, toID, listingID, msg.Content, msg.Read, msg.CreatedAt).Scan(&id)
+        if err != nil {
+                return "", dbError(ctx, err, "message")
+        }
+
+        // The sender has read up to their own message and the recipient has one more
+        // to read. Sending brings the conversation back from the sender's archive, and
+        // from the recipient's unless they muted it.
+        _, err = tx.ExecContext(ctx, `
+                INSERT INTO conversation_participants (conversation_id, user_id, unread_count, last_read_message_id)
+                VALUES ($1, $2, 0, $4), ($1, $3, 1, NULL)
+                ON CONFLICT (conversation_id, user_id) DO UPDATE
+                SET unread_count = conversation_participants.unread_count + EXCLUDED.unread_count,
+                    last_read_message_id = COALESCE(EXCLUDED.last_read_message_id, conversation_participants.last_read_message_id),
+                    archived = conversation_participants.archived AND conversation_participants.muted AND EXCLUDED.unread_count > 0
+        `, conversationID, fromID, toID, id)
+        if err != nil {
+                return "", dbError(ctx, err, "conversation")
+        }
+
+        if len(attachmentIDs) > 0 {
+                var result sql.Result
+                result, err = tx.ExecContext(ctx, `
+                        UPDATE message_attachments
+                        SET message_id = $1
+                        WHERE id = ANY($2) AND uploader_id = $3 AND message_id IS NULL
+                `, id, pq.Array(attachmentIDs), fromID)
+                if err != nil {
+                        return "", dbError(ctx, err, "attachment")
+                }
+
+                var attached int64
+                attached, err = result.RowsAffected()
+                if err != nil {
+                        return "", dbError(ctx, err, "attachment")
+                }
+                if attached != int64(len(attachmentIDs)) {
+                        err = ValidationError("Attachments must be photos you uploaded and have not sent yet",
+                                map[string]string{"attachmentIds": "must refer to your unsent uploads"})
+                        return "", err
+                }
+        }
+
+        err = tx.Commit()
+        if err != nil {
+                return "", dbError(ctx, err, "message")
+        }
+
+        return strconv.Itoa(id), nil
+}
+
+// startConversation returns the ID of the conversation between two users about a
+// listing, creating it if there is none, and records a message sent at sentAt
+func startConversation(ctx context.Context, tx *sql.Tx, fromID, toID int, listingID interface{}, sentAt time.Time) (int, error) {
+        user1ID, user2ID := fromID, toID
+        if user2ID < user1ID {
+                user1ID, user2ID = user2ID, user1ID
+        }
+
+        // Conversations whose listing was deleted are matched too; the unique
+        // constraint doesn't cover them as their listing is NULL
+        var id int
+        err := tx.QueryRowContext(ctx, `
+                WITH existing AS (
+                        SELECT id FROM conversations
+                        WHERE user1_id = $1 AND user2_id = $2 AND listing_id IS NOT DISTINCT FROM $3
+                        ORDER BY id
+                        LIMIT 1
+                ), created AS (
+                        INSERT INTO conversations (user1_id, user2_id, listing_id, created_at, last_message_at)
+                        SELECT $1, $2, $3::integer, $4::timestamptz, $4::timestamptz
+                        WHERE NOT EXISTS (SELECT 1 FROM existing)
+                        ON CONFLICT (user1_id, user2_id, listing_id) DO UPDATE SET last_message_at = EXCLUDED.last_message_at
+                        RETURNING id
+                )
+                SELECT id FROM existing UNION ALL SELECT id FROM created
+        `, user1ID, user2ID, listingID, sentAt).Scan(&id)
+        if err != nil {
+                return 0, dbError(ctx, err, "conversation")
+        }
+
+        _, err = tx.ExecContext(ctx, `
+                UPDATE conversations SET last_message_at = GREATEST(last_message_at, $2) WHERE id = $1
+        `, id, sentAt)
+        if err != nil {
+                return 0, dbError(ctx, err, "conversation")
+        }
+
+        return id, nil
+}
+
+// attachmentColumns are the message_attachments columns read by scanAttachment
+const attachmentColumns = `id, message_id, uploader_id, content_type, size, width, height, created_at`
+
+// attachmentPath is where the API serves attachments; thumbnails are under /thumbnail
+const attachmentPath = "/api/v1/messages/attachments/"
+
+// scanAttachment scans a row selected with attachmentColumns
+func scanAttachment(row rowScanner) (models.MessageAttachment, error) {
+        var attachment models.MessageAttachment
+        var id, uploaderID int
+        var messageID sql.NullInt64
+
+        err := row.Scan(&id, &messageID, &uploaderID, &attachment.ContentType, &attachment.Size,
+                &attachment.Width, &attachment.Height, &attachment.CreatedAt)
+        if err != nil {
+                return models.MessageAttachment{}, err
+        }
+
+        attachment.ID = strconv.Itoa(id)
+        attachment.UploaderID = strconv.Itoa(uploaderID)
+        if messageID.Valid {
+                attachment.MessageID = strconv.FormatInt(messageID.Int64, 10)
+        }
+        attachment.URL = attachmentPath + attachment.ID
+        attachment.ThumbnailURL = attachmentPath + attachment.ID + "/thumbnail"
+
+        return attachment, nil
+}
+
+// loadMessageAttachments fills in the attachments of messages
+func loadMessageAttachments(ctx context.Context, messages []models.Message) error {
+        if len(messages) == 0 {
+                return nil
+        }
+
+        ids := make([]string, len(messages))
+        byID := map[string]*models.Message{}
+        for i := range messages {
+                ids[i] = messages[i].ID
+                byID[messages[i].ID] = &messages[i]
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT `+attachmentColumns+`
+                FROM message_attachments
+                WHERE message_id = ANY($1::int[])
+                ORDER BY id
+        `, pq.Array(ids))
+        if err != nil {
+                return dbError(ctx, err, "attachment")
+        }
+        defer rows.Close()
+
+        for rows.Next() {
+                attachment, err := scanAttachment(rows)
+                if err != nil {
+                        return dbError(ctx, err, "attachment")
+                }
+                message := byID[attachment.MessageID]
+                message.Attachments = append(message.Attachments, attachment)
+        }
+
+        if err = rows.Err(); err != nil {
+                return dbError(ctx, err, "attachment")
+        }
+
+        return nil
+}
+
+// SaveMessageAttachment stores an uploaded photo and its thumbnail, returning the attachment
+func SaveMessageAttachment(ctx context.Context, attachment models.MessageAttachment, content, thumbnail []byte) (models.MessageAttachment, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        uploaderID, err := parseID(attachment.UploaderID, "uploader")
+        if err != nil {
+                return models.MessageAttachment{}, err
+        }
+
+        saved, err := scanAttachment(GetDB().QueryRowContext(ctx, `
+                INSERT INTO message_attachments (uploader_id, content_type, size, width, height, content, thumbnail)
+                VALUES ($1, $2, $3, $4, $5, $6, $7)
+                RETURNING `+attachmentColumns,
+                uploaderID, attachment.ContentType, attachment.Size, attachment.Width, attachment.Height, content, thumbnail))
+        if err != nil {
+                return models.MessageAttachment{}, dbError(ctx, err, "attachment")
+        }
+
+        return saved, nil
+}
+
+// GetMessageAttachment retrieves an attachment's details by ID
+func GetMessageAttachment(ctx context.Context, id string) (models.MessageAttachment, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        attachmentID, err := parseID(id, "attachment")
+        if err != nil {
+                return models.MessageAttachment{}, err
+        }
+
+        attachment, err := scanAttachment(GetDB().QueryRowContext(ctx, `
+                SELECT `+attachmentColumns+`
+                FROM message_attachments
+                WHERE id = $1
+        `, attachmentID))
+        if err != nil {
+                return models.MessageAttachment{}, dbError(ctx, err, "attachment")
+        }
+
+        return attachment, nil
+}
+
+// GetMessageAttachmentContent retrieves an attachment's photo, or its JPEG thumbnail
+func GetMessageAttachmentContent(ctx context.Context, id string, thumbnail bool) ([]byte, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        attachmentID, err := parseID(id, "attachment")
+        if err != nil {
+                return nil, err
+        }
+
+        column := "content"
+        if thumbnail {
+                column = "thumbnail"
+        }
+
+        var content []byte
+        err = GetDB().QueryRowContext(ctx, `SELECT `+column+` FROM message_attachments WHERE id = $1`, attachmentID).Scan(&content)
+        if err != nil {
+                return nil, dbError(ctx, err, "attachment")
+        }
+
+        return content, nil
+}
+
+// DeleteUnsentAttachments deletes photos uploaded before a time that were never sent,
+// returning how many were deleted. Photos of messages awaiting moderation are kept.
+func DeleteUnsentAttachments(ctx context.Context, uploadedBefore time.Time) (int64, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        result, err := GetDB().ExecContext(ctx, `
+                DELETE FROM message_attachments a
+                WHERE a.message_id IS NULL AND a.created_at < $1
+                  AND NOT EXISTS (
+                        SELECT 1 FROM moderation_queue q
+                        WHERE q.status = $2 AND a.id = ANY(q.attachment_ids)
+                  )
+        `, uploadedBefore, models.ModerationPending)
+        if err != nil {
+                return 0, dbError(ctx, err, "attachment")
+        }
+
+        deleted, err := result.RowsAffected()
+        if err != nil {
+                return 0, dbError(ctx, err, "attachment")
+        }
+
+        return deleted, nil
+}
+
+// MarkMessageAsRead marks a message as read in the database, taking it off its
+// recipient's unread count
+func MarkMessageAsRead(ctx context.Context, id string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        messageID, err := parseID(id, "message")
+        if err != nil {
+                return err
+        }
+
+        _, err = GetDB().ExecContext(ctx, `
+                WITH marked AS (
+                        UPDATE messages
+                        SET read = true
+                        WHERE id = $1 AND NOT read
+                        RETURNING conversation_id, to_id
+                )
+                UPDATE conversation_participants p
+                SET unread_count = GREATEST(p.unread_count - 1, 0)
+                FROM marked
+                WHERE p.conversation_id = marked.conversation_id AND p.user_id = marked.to_id
+        `, messageID)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        return nil
+}
+
+// Full-text message search. The index on messageSearchVector serves queries that
+// match it against messageSearchQuery.
+const (
+        messageSearchVector = `to_tsvector('english', content)`
+        messageSearchQuery  = `websearch_to_tsquery('english', $2)`
+
+        // messageSearchHeadline highlights the matches in a message's HTML-escaped content
+        messageSearchHeadline = `ts_headline('english',
+                replace(replace(replace(content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+                ` + messageSearchQuery + `,
+                'StartSel=<mark>, StopSel=</mark>, MinWords=8, MaxWords=24, MaxFragments=2, FragmentDelimiter=" ... "')`
+)
+
+// MessageSearch is a full-text search of a user's messages; empty filters match everything
+type MessageSearch struct {
+        Query     string // web search syntax: words, "quoted phrases", or and -excluded words
+        PartnerID string // only messages with this user
+        ListingID string // only conversations about this listing
+}
+
+// SearchMessages finds the messages of a user's conversations matching a search,
+// best matches first, leaving out those deleted for everyone and those the user
+// deleted for themselves. The page's total is filled in.
+func SearchMessages(ctx context.Context, userID string, search MessageSearch, page *models.Pagination) ([]models.MessageSearchResult, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        var partnerID, listingID interface{}
+        if search.PartnerID != "" {
+                if partnerID, err = parseID(search.PartnerID, "user"); err != nil {
+                        return nil, err
+                }
+        }
+        if search.ListingID != "" {
+                if listingID, err = parseID(search.ListingID, "listing"); err != nil {
+                        return nil, err
+                }
+        }
+
+        // Messages are matched as the user sees them
+        const matching = `
+                FROM messages m
+                JOIN conversations c ON c.id = m.conversation_id
+                WHERE (m.from_id = $1 OR m.to_id = $1) AND ` + visibleTo + ` AND m.deleted_at IS NULL
+                  AND ` + messageSearchVector + ` @@ ` + messageSearchQuery + `
+                  AND ($3::integer IS NULL OR m.from_id = $3 OR m.to_id = $3)
+                  AND ($4::integer IS NULL OR c.listing_id = $4)`
+        args := []interface{}{userIDInt, search.Query, partnerID, listingID}
+
+        err = GetDB().QueryRowContext(ctx, `SELECT COUNT(*) `+matching, args...).Scan(&page.Total)
+        if err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT m.id, m.conversation_id, m.from_id,
+                       CASE WHEN m.from_id = $1 THEN m.to_id ELSE m.from_id END,
+                       COALESCE(o.username, ''), c.listing_id, COALESCE(l.title, ''),
+                       `+messageSearchHeadline+`, m.created_at
+                FROM (SELECT m.*, ts_rank(`+messageSearchVector+`, `+messageSearchQuery+`) AS rank `+matching+`) m
+                JOIN conversations c ON c.id = m.conversation_id
+                LEFT JOIN users o ON o.id = CASE WHEN m.from_id = $1 THEN m.to_id ELSE m.from_id END
+                LEFT JOIN listings l ON l.id = c.listing_id
+                ORDER BY m.rank DESC, m.created_at DESC, m.id DESC
+                LIMIT $5 OFFSET $6
+        `, append(args, page.Limit, page.Offset())...)
+        if err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+        defer rows.Close()
+
+        results := []models.MessageSearchResult{}
+        for rows.Next() {
+                var result models.MessageSearchResult
+                var id, conversationID, fromID, otherID int
+                var resultListingID sql.NullInt64
+
+                err := rows.Scan(&id, &conversationID, &fromID, &otherID, &result.Username, &resultListingID,
+                        &result.ListingTitle, &result.Snippet, &result.CreatedAt)
+                if err != nil {
+                        return nil, dbError(ctx, err, "message")
+                }
+
+                result.MessageID = strconv.Itoa(id)
+                result.ConversationID = strconv.Itoa(conversationID)
+                result.FromID = strconv.Itoa(fromID)
+                result.UserID = strconv.Itoa(otherID)
+                if resultListingID.Valid {
+                        result.ListingID = strconv.FormatInt(resultListingID.Int64, 10)
+                }
+                result.Link = fmt.Sprintf("/messages?conversationId=%s&messageId=%s", result.ConversationID, result.MessageID)
+
+                results = append(results, result)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+
+        return results, nil
+}
+
+// EditMessage replaces the content of a message sent by senderID after sentAfter,
+// keeping the previous content in its edit history. Messages deleted for everyone
+// or sent before sentAfter cannot be edited.
+func EditMessage(ctx context.Context, id, senderID, content string, editedAt, sentAfter time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        messageID, err := parseID(id, "message")
+        if err != nil {
+                return err
+        }
+
+        senderIDInt, err := parseID(senderID, "sender")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                WITH previous AS (
+                        SELECT id, content FROM messages WHERE id = $1 FOR UPDATE
+                ), edited AS (
+                        UPDATE messages m
+                        SET content = $3, edited_at = $4
+                        FROM previous
+                        WHERE m.id = previous.id AND m.from_id = $2 AND m.deleted_at IS NULL AND m.created_at >= $5
+                        RETURNING previous.content
+                )
+                INSERT INTO message_edits (message_id, content, edited_at)
+                SELECT $1, content, $4 FROM edited
+        `, messageID, senderIDInt, content, editedAt, sentAfter)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        affected, err := result.RowsAffected()
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+        if affected == 0 {
+                return ConflictError("This message can no longer be edited", nil)
+        }
+
+        return nil
+}
+
+// GetMessageEdits retrieves the earlier versions of a message, oldest first
+func GetMessageEdits(ctx context.Context, id string) ([]models.MessageEdit, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        messageID, err := parseID(id, "message")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT content, edited_at
+                FROM message_edits
+                WHERE message_id = $1
+                ORDER BY edited_at, id
+        `, messageID)
+        if err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+        defer rows.Close()
+
+        edits := []models.MessageEdit{}
+        for rows.Next() {
+                var edit models.MessageEdit
+                if err := rows.Scan(&edit.Content, &edit.EditedAt); err != nil {
+                        return nil, dbError(ctx, err, "message")
+                }
+
+                edits = append(edits, edit)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "message")
+        }
+
+        return edits, nil
+}
+
+// DeleteMessageForEveryone deletes the content, photos and edit history of a
+// message sent by senderID. The message itself is kept, marked deleted, so the
+// conversation shows where it was.
+func DeleteMessageForEveryone(ctx context.Context, id, senderID string, deletedAt time.Time) error {
+        ctx, cancel := withTransactionTimeout(ctx)
+        defer cancel()
+
+        messageID, err := parseID(id, "message")
+        if err != nil {
+                return err
+        }
+
+        senderIDInt, err := parseID(senderID, "sender")
+        if err != nil {
+                return err
+        }
+
+        tx, err := GetDB().BeginTx(ctx, nil)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+        defer func() {
+                if err != nil {
+                        tx.Rollback()
+                }
+        }()
+
+        // Lock the message and see whether the recipient still counts it as unread
+        var read bool
+        err = tx.QueryRowContext(ctx, `
+                SELECT read FROM messages
+                WHERE id = $1 AND from_id = $2 AND deleted_at IS NULL
+                FOR UPDATE
+        `, messageID, senderIDInt).Scan(&read)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        _, err = tx.ExecContext(ctx, `
+                UPDATE messages
+                SET content = '', edited_at = NULL, deleted_at = $2, read = true
+                WHERE id = $1
+        `, messageID, deletedAt)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+        for _, statement := range []string{
+                `DELETE FROM message_attachments WHERE message_id = $1`,
+                `DELETE FROM message_edits WHERE message_id = $1`,
+        } {
+                _, err = tx.ExecContext(ctx, statement, messageID)
+                if err != nil {
+                        return dbError(ctx, err, "message")
+                }
+        }
+        if !read {
+                err = unreadOneLess(ctx, tx, messageID)
+                if err != nil {
+                        return err
+                }
+        }
+
+        err = tx.Commit()
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        return nil
+}
+
+// HideMessage deletes a message for one of its participants only. It is marked
+// read for them, and removed altogether once both participants have deleted it.
+func HideMessage(ctx context.Context, id, userID string) error {
+        ctx, cancel := withTransactionTimeout(ctx)
+        defer cancel()
+
+        messageID, err := parseID(id, "message")
+        if err != nil {
+                return err
+        }
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        tx, err := GetDB().BeginTx(ctx, nil)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+        defer func() {
+                if err != nil {
+                        tx.Rollback()
+                }
+        }()
+
+        // Lock the message and see whether it is an unread one sent to the user
+        var unread bool
+        err = tx.QueryRowContext(ctx, `
+                SELECT to_id = $2 AND NOT read FROM messages
+                WHERE id = $1 AND (from_id = $2 OR to_id = $2)
+                FOR UPDATE
+        `, messageID, userIDInt).Scan(&unread)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        _, err = tx.ExecContext(ctx, `
+                UPDATE messages
+                SET hidden_for_sender = hidden_for_sender OR from_id = $2,
+                    hidden_for_recipient = hidden_for_recipient OR to_id = $2,
+                    read = read OR to_id = $2
+                WHERE id = $1
+        `, messageID, userIDInt)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+        if unread {
+                err = unreadOneLess(ctx, tx, messageID)
+                if err != nil {
+                        return err
+                }
+        }
+
+        _, err = tx.ExecContext(ctx, `DELETE FROM messages WHERE id = $1 AND hidden_for_sender AND hidden_for_recipient`, messageID)
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        err = tx.Commit()
+        if err != nil {
+                return dbError(ctx, err, "message")
+        }
+
+        return nil
+}
+
+// unreadOneLess takes a message that is no longer unread off its recipient's unread count
+func unreadOneLess(ctx context.Context, tx *sql.Tx, messageID int) error {
+        _, err := tx.ExecContext(ctx, `
+                UPDATE conversation_participants p
+                SET unread_count = GREATEST(p.unread_count - 1, 0)
+                FROM messages m
+                WHERE m.id = $1 AND p.conversation_id = m.conversation_id AND p.user_id = m.to_id
+        `, messageID)
+        if err != nil {
+                return dbError(ctx, err, "conversation")
+        }
+
+        return nil
+}
+
+// PurgeOldMessages deletes messages sent before a time in conversations about
+// listings that were sold, traded or deleted, and the conversations left empty.
+// It returns the number of messages deleted.
+func PurgeOldMessages(ctx context.Context, sentBefore time.Time) (int, error) {
+        ctx, cancel := withTransactionTimeout(ctx)
+        defer cancel()
+
+        tx, err := GetDB().BeginTx(ctx, nil)
+        if err != nil {
+                return 0, dbError(ctx, err, "message")
+        }
+        defer func() {
+                if err != nil {
+                        tx.Rollback()
+                }
+        }()
+
+        // Delete the messages, counting them by conversation
+        rows, err := tx.QueryContext(ctx, `
+                WITH purged AS (
+                        DELETE FROM messages m
+                        USING conversations c
+                        LEFT JOIN listings l ON l.id = c.listing_id
+                        WHERE m.conversation_id = c.id AND m.created_at < $1
+                          AND (c.listing_id IS NULL OR l.status IN ($2, $3))
+                        RETURNING m.conversation_id
+                )
+                SELECT conversation_id, COUNT(*) FROM purged GROUP BY conversation_id
+        `, sentBefore, models.ListingStatusSold, models.ListingStatusTraded)
+        if err != nil {
+                return 0, dbError(ctx, err, "message")
+        }
+        var conversationIDs []int
+        purged := 0
+        for rows.Next() {
+                var conversationID, count int
+                if err = rows.Scan(&conversationID, &count); err != nil {
+                        rows.Close()
+                        return 0, dbError(ctx, err, "message")
+                }
+                conversationIDs = append(conversationIDs, conversationID)
+                purged += count
+        }
+        rows.Close()
+        if err = rows.Err(); err != nil {
+                return 0, dbError(ctx, err, "message")
+        }
+        if len(conversationIDs) == 0 {
+                err = tx.Commit()
+                return 0, dbError(ctx, err, "message")
+        }
+
+        // Recount the unread messages left and drop the conversations left empty
+        statements := []string{
+                `UPDATE conversation_participants p
+                 SET unread_count = (SELECT COUNT(*) FROM messages m
+                                     WHERE m.conversation_id = p.conversation_id AND m.to_id = p.user_id AND NOT m.read)
+                 WHERE p.conversation_id = ANY($1)`,
+                `DELETE FROM conversations c
+                 WHERE c.id = ANY($1) AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.conversation_id = c.id)`,
+        }
+        for _, statement := range statements {
+                _, err = tx.ExecContext(ctx, statement, pq.Array(conversationIDs))
+                if err != nil {
+                        return 0, dbError(ctx, err, "conversation")
+                }
+        }
+
+        err = tx.Commit()
+        if err != nil {
+                return 0, dbError(ctx, err, "message")
+        }
+
+        return purged, nil
+}
+
+// conversationQuery selects conversations as seen by the participant $1, with the
+// other participant, the listing and the last message; scanConversation reads its rows
+const conversationQuery = `
+        SELECT c.id, c.listing_id, COALESCE(l.title, ''), c.last_message_at,
+               p.unread_count, p.last_read_message_id, p.archived, p.muted,
+               o.id, o.username, COALESCE(o.profile_pic, ''),
+               COALESCE(last.content, ''), COALESCE(last.photos, 0), COALESCE(last.deleted, false)
+        FROM conversation_participants p
+        JOIN conversations c ON c.id = p.conversation_id
+        JOIN users o ON o.id = CASE WHEN c.user1_id = p.user_id THEN c.user2_id ELSE c.user1_id END
+        LEFT JOIN listings l ON l.id = c.listing_id
+        LEFT JOIN LATERAL (
+                SELECT m.content, (SELECT COUNT(*) FROM message_attachments a WHERE a.message_id = m.id) AS photos,
+                       m.deleted_at IS NOT NULL AS deleted
+                FROM messages m
+                WHERE m.conversation_id = c.id
+                  AND NOT ((m.from_id = p.user_id AND m.hidden_for_sender) OR (m.to_id = p.user_id AND m.hidden_for_recipient))
+                ORDER BY m.created_at DESC, m.id DESC
+                LIMIT 1
+        ) last ON true
+        WHERE p.user_id = $1`
+
+// scanConversation scans a row selected with conversationQuery
+func scanConversation(row rowScanner) (models.Conversation, error) {
+        var conversation models.Conversation
+        var id, otherID, photos int
+        var listingID, lastReadID sql.NullInt64
+        var lastContent string
+        var lastDeleted bool
+
+        err := row.Scan(&id, &listingID, &conversation.ListingTitle, &conversation.LastActivity,
+                &conversation.Unread, &lastReadID, &conversation.Archived, &conversation.Muted,
+                &otherID, &conversation.Username, &conversation.ProfilePic, &lastContent, &photos, &lastDeleted)
+        if err != nil {
+                return models.Conversation{}, err
+        }
+
+        conversation.ID = strconv.Itoa(id)
+        conversation.UserID = strconv.Itoa(otherID)
+        if listingID.Valid {
+                conversation.ListingID = strconv.FormatInt(listingID.Int64, 10)
+        }
+        if lastReadID.Valid {
+                conversation.LastReadMessageID = strconv.FormatInt(lastReadID.Int64, 10)
+        }
+        conversation.LastMessage = messagePreview(lastContent, photos, lastDeleted)
+
+        return conversation, nil
+}
+
+// messagePreview summarizes a message for the conversation list; photos sent
+// without text and deleted messages are described
+func messagePreview(content string, photos int, deleted bool) string {
+        switch {
+        case deleted:
+                return "Message deleted"
+        case content != "":
+                return content
+        case photos == 1:
+                return "Sent a photo"
+        case photos > 1:
+                return fmt.Sprintf("Sent %d photos", photos)
+        }
+        return ""
+}
+
+// GetConversations retrieves a user's conversations, most recently active first.
+// Archived conversations are listed instead if archived is true.
+func GetConversations(ctx context.Context, userID string, archived bool) ([]models.Conversation, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, conversationQuery+`
+                AND p.archived = $2
+                ORDER BY c.last_message_at DESC, c.id DESC
+        `, userIDInt, archived)
+        if err != nil {
+                return nil, dbError(ctx, err, "conversation")
+        }
+        defer rows.Close()
+
+        conversations := []models.Conversation{}
+        for rows.Next() {
+                conversation, err := scanConversation(rows)
+                if err != nil {
+                        return nil, dbError(ctx, err, "conversation")
+                }
+
+                conversations = append(conversations, conversation)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "conversation")
+        }
+
+        return conversations, nil
+}
+
+// GetConversation retrieves one of a user's conversations, without its messages.
+// Conversations the user is not part of are not found.
+func GetConversation(ctx context.Context, id, userID string) (models.Conversation, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        conversationID, err := parseID(id, "conversation")
+        if err != nil {
+                return models.Conversation{}, err
+        }
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return models.Conversation{}, err
+        }
+
+        conversation, err := scanConversation(GetDB().QueryRowContext(ctx, conversationQuery+`
+                AND c.id = $2
+        `, userIDInt, conversationID))
+        if err != nil {
+                return models.Conversation{}, dbError(ctx, err, "conversation")
+        }
+
+        return conversation, nil
+}
+
+// GetConversationsWithUser retrieves a user's conversations with another user, one per
+// listing, archived or not, without their messages
+func GetConversationsWithUser(ctx context.Context, userID, otherUserID string) ([]models.Conversation, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        otherUserIDInt, err := parseID(otherUserID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, conversationQuery+`
+                AND o.id = $2
+                ORDER BY c.last_message_at, c.id
+        `, userIDInt, otherUserIDInt)
+        if err != nil {
+                return nil, dbError(ctx, err, "conversation")
+        }
+        defer rows.Close()
+
+        conversations := []models.Conversation{}
+        for rows.Next() {
+                conversation, err := scanConversation(rows)
+                if err != nil {
+                        return nil, dbError(ctx, err, "conversation")
+                }
+
+                conversations = append(conversations, conversation)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "conversation")
+        }
+
+        return conversations, nil
+}
+
+// MarkConversationRead marks every message of a conversation that was sent to the
+// user as read, and moves their read position to its last message
+func MarkConversationRead(ctx context.Context, id, userID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        conversationID, err := parseID(id, "conversation")
+        if err != nil {
+                return err
+        }
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        // Messages arriving meanwhile are after the read position and stay unread
+        _, err = GetDB().ExecContext(ctx, `
+                WITH participant AS (
+                        UPDATE conversation_participants
+                        SET unread_count = 0,
+                            last_read_message_id = (SELECT MAX(id) FROM messages WHERE conversation_id = $1)
+                        WHERE conversation_id = $1 AND user_id = $2
+                        RETURNING last_read_message_id
+                )
+                UPDATE messages
+                SET read = true
+                FROM participant
+                WHERE conversation_id = $1 AND to_id = $2 AND NOT read AND id <= participant.last_read_message_id
+        `, conversationID, userIDInt)
+        if err != nil {
+                return dbError(ctx, err, "conversation")
+        }
+
+        return nil
+}
+
+// SetConversationArchived archives or unarchives a conversation for one of its participants
+func SetConversationArchived(ctx context.Context, id, userID string, archived bool) error {
+        return setConversationFlag(ctx, id, userID, "archived", archived)
+}
+
+// SetConversationMuted mutes or unmutes a conversation for one of its participants
+func SetConversationMuted(ctx context.Context, id, userID string, muted bool) error {
+        return setConversationFlag(ctx, id, userID, "muted", muted)
+}
+
+// setConversationFlag sets a participant's archived or muted column
+func setConversationFlag(ctx context.Context, id, userID, column string, value bool) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        conversationID, err := parseID(id, "conversation")
+        if err != nil {
+                return err
+        }
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE conversation_participants
+                SET `+column+` = $3
+                WHERE conversation_id = $1 AND user_id = $2
+        `, conversationID, userIDInt, value)
+        if err != nil {
+                return dbError(ctx, err, "conversation")
+        }
+
+        return requireRowsAffected(ctx, result, "conversation")
+}
+
+// GetFavorites retrieves all favorite listing IDs for a user from the database
+func GetFavorites(ctx context.Context, userID string) ([]string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT listing_id
+                FROM favorites
+                WHERE user_id = $1
+        `, userIDInt)
+        if err != nil {
+                return nil, dbError(ctx, err, "favorite")
+        }
+        defer rows.Close()
+
+        favoriteIDs := []string{}
+        for rows.Next() {
+                var listingID int
+                if err := rows.Scan(&listingID); err != nil {
+                        return nil, dbError(ctx, err, "favorite")
+                }
+                favoriteIDs = append(favoriteIDs, strconv.Itoa(listingID))
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "favorite")
+        }
+
+        return favoriteIDs, nil
+}
+
+// AddFavorite adds a listing to a user's favorites. Adding it twice is a conflict.
+func AddFavorite(ctx context.Context, userID, listingID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        listingIDInt, err := parseID(listingID, "listing")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                INSERT INTO favorites (user_id, listing_id)
+                VALUES ($1, $2)
+                ON CONFLICT (user_id, listing_id) DO NOTHING
+        `, userIDInt, listingIDInt)
+        if err != nil {
+                return dbError(ctx, err, "favorite")
+        }
+
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return dbError(ctx, err, "favorite")
+        }
+        if rowsAffected == 0 {
+                return ConflictError("Listing is already a favorite", nil)
+        }
+
+        return nil
+}
+
+// RemoveFavorite removes a listing from a user's favorites
+func RemoveFavorite(ctx context.Context, userID, listingID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        listingIDInt, err := parseID(listingID, "listing")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                DELETE FROM favorites
+                WHERE user_id = $1 AND listing_id = $2
+        `, userIDInt, listingIDInt)
+        if err != nil {
+                return dbError(ctx, err, "favorite")
+        }
+
+        return requireRowsAffected(ctx, result, "favorite")
+}
+
+// IsFavorite checks if a listing is in a user's favorites
+func IsFavorite(ctx context.Context, userID, listingID string) (bool, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return false, err
+        }
+
+        listingIDInt, err := parseID(listingID, "listing")
+        if err != nil {
+                return false, err
+        }
+
+        var count int
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT COUNT(*)
+                FROM favorites
+                WHERE user_id = $1 AND listing_id = $2
+        `, userIDInt, listingIDInt).Scan(&count)
+        if err != nil {
+                return false, dbError(ctx, err, "favorite")
+        }
+
+        return count > 0, nil
+}
+
+// FollowUser makes one user follow another. Following a user twice is a conflict.
+func FollowUser(ctx context.Context, followerID, followedID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        followerIDInt, err := parseID(followerID, "user")
+        if err != nil {
+                return err
+        }
+
+        followedIDInt, err := parseID(followedID, "user")
+        if err != nil {
+                return err
+        }
+
+        if followerIDInt == followedIDInt {
+                return ValidationError("You cannot follow yourself", nil)
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                INSERT INTO follows (follower_id, followed_id)
+                VALUES ($1, $2)
+                ON CONFLICT (follower_id, followed_id) DO NOTHING
+        `, followerIDInt, followedIDInt)
+        if err != nil {
+                return dbError(ctx, err, "follow")
+        }
+
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return dbError(ctx, err, "follow")
+        }
+        if rowsAffected == 0 {
+                return ConflictError("Already following this user", nil)
+        }
+
+        return nil
+}
+
+// UnfollowUser stops one user following another
+func UnfollowUser(ctx context.Context, followerID, followedID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        followerIDInt, err := parseID(followerID, "user")
+        if err != nil {
+                return err
+        }
+
+        followedIDInt, err := parseID(followedID, "user")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                DELETE FROM follows
+                WHERE follower_id = $1 AND followed_id = $2
+        `, followerIDInt, followedIDInt)
+        if err != nil {
+                return dbError(ctx, err, "follow")
+        }
+
+        return requireRowsAffected(ctx, result, "follow")
+}
+
+// GetFollowing retrieves the IDs of the users a user follows, most recently followed first
+func GetFollowing(ctx context.Context, userID string) ([]string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT followed_id FROM follows
+                WHERE follower_id = $1
+                ORDER BY created_at DESC
+        `, userIDInt)
+        if err != nil {
+                return nil, dbError(ctx, err, "follow")
+        }
+        defer rows.Close()
+
+        following := []string{}
+        for rows.Next() {
+                var id int
+                if err := rows.Scan(&id); err != nil {
+                        return nil, dbError(ctx, err, "follow")
+                }
+                following = append(following, strconv.Itoa(id))
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "follow")
+        }
+
+        return following, nil
+}
+
+// IsFollowing reports whether one user follows another
+func IsFollowing(ctx context.Context, followerID, followedID string) (bool, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        followerIDInt, err := parseID(followerID, "user")
+        if err != nil {
+                return false, err
+        }
+
+        followedIDInt, err := parseID(followedID, "user")
+        if err != nil {
+                return false, err
+        }
+
+        var following bool
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followed_id = $2)
+        `, followerIDInt, followedIDInt).Scan(&following)
+        if err != nil {
+                return false, dbError(ctx, err, "follow")
+        }
+
+        return following, nil
+}
+
+// GetFeedListings retrieves up to limit listings for a user's personalized feed:
+// available and pending listings by the users they follow or of the plant types
+// of their favorites, excluding their own, newest first. cursor continues from
+// the cursor returned with a previous page; the returned cursor is empty on the
+// last page.
+func GetFeedListings(ctx context.Context, userID, cursor string, limit int) ([]models.Listing, string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, "", err
+        }
+
+        // Listings are ordered by creation time, then ID for listings created together
+        var before sql.NullTime
+        var beforeID int
+        if cursor != "" {
+                before.Time, beforeID, err = decodeFeedCursor(cursor)
+                if err != nil {
+                        return nil, "", err
+                }
+                before.Valid = true
+        }
+
+        // Read one listing past the page to know whether there is another page
+        listings, err := queryListings(ctx, `
+                SELECT `+listingColumns+`
+                FROM listings l
+                WHERE l.user_id <> $1
+                        AND l.status IN ($2, $3)
+                        AND (l.user_id IN (SELECT followed_id FROM follows WHERE follower_id = $1)
+                                OR l.plant_type IN (
+                                        SELECT fl.plant_type FROM favorites f
+                                        JOIN listings fl ON fl.id = f.listing_id
+                                        WHERE f.user_id = $1
+                                ))
+                        AND ($4::timestamptz IS NULL OR (l.created_at, l.id) < ($4, $5))
+                ORDER BY l.created_at DESC, l.id DESC
+                LIMIT $6
+        `, userIDInt, models.ListingStatusAvailable, models.ListingStatusPending, before, beforeID, limit+1)
+        if err != nil {
+                return nil, "", err
+        }
+
+        next := ""
+        if len(listings) > limit {
+                listings = listings[:limit]
+                last := listings[limit-1]
+                next = encodeFeedCursor(last.CreatedAt, last.ID)
+        }
+
+        return listings, next, nil
+}
+
+// encodeFeedCursor encodes the position of a listing in a feed as an opaque cursor
+func encodeFeedCursor(createdAt time.Time, listingID string) string {
+        return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "," + listingID))
+}
+
+// decodeFeedCursor decodes a cursor made by encodeFeedCursor
+func decodeFeedCursor(cursor string) (time.Time, int, error) {
+        invalid := ValidationError("Invalid feed cursor", map[string]string{"cursor": "is not a cursor returned by the feed"})
+
+        data, err := base64.RawURLEncoding.DecodeString(cursor)
+        if err != nil {
+                return time.Time{}, 0, invalid
+        }
+        createdAt, listingID, ok := strings.Cut(string(data), ",")
+        if !ok {
+                return time.Time{}, 0, invalid
+        }
+        at, err := time.Parse(time.RFC3339Nano, createdAt)
+        if err != nil {
+                return time.Time{}, 0, invalid
+        }
+        id, err := strconv.Atoi(listingID)
+        if err != nil {
+                return time.Time{}, 0, invalid
+        }
+
+        return at, id, nil
+}
+
+// RenewListing makes a listing available again with a new expiry time
+func RenewListing(ctx context.Context, id string, expiresAt time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        listingID, err := parseID(id, "listing")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE listings
+                SET status = $1, expires_at = $2, expiry_warned_at = NULL, updated_at = $3
+                WHERE id = $4
+        `, models.ListingStatusAvailable, expiresAt, time.Now(), listingID)
+        if err != nil {
+                return dbError(ctx, err, "listing")
+        }
+
+        return requireRowsAffected(ctx, result, "listing")
+}
+
+// PublishListing makes a draft listing available. The listing is treated as newly
+// listed, so its creation time is reset to the publication time. Publishing a
+// listing that is no longer a draft is a conflict.
+func PublishListing(ctx context.Context, id string, publishedAt, expiresAt time.Time) error {
+        return publishListing(ctx, id, publishedAt, expiresAt, models.ListingStatusDraft, models.ListingStatusScheduled)
+}
+
+// PublishScheduledListing makes a scheduled listing available like PublishListing.
+// Publishing a listing that is no longer scheduled, because its owner moved it back
+// to draft, is a conflict.
+func PublishScheduledListing(ctx context.Context, id string, publishedAt, expiresAt time.Time) error {
+        return publishListing(ctx, id, publishedAt, expiresAt, models.ListingStatusScheduled)
+}
+
+// UnscheduleListing moves a scheduled listing back to draft. The listing must be
+// unchanged since it was read with the given update time; if its owner edited or
+// unscheduled it in the meantime, this is a conflict.
+func UnscheduleListing(ctx context.Context, id string, updatedAt, now time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        listingID, err := parseID(id, "listing")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE listings
+                SET status = $1, publish_at = NULL, updated_at = $2
+                WHERE id = $3 AND status = $4 AND updated_at = $5
+        `, models.ListingStatusDraft, now, listingID, models.ListingStatusScheduled, updatedAt)
+        if err != nil {
+                return dbError(ctx, err, "listing")
+        }
+
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return dbError(ctx, err, "listing")
+        }
+        if rowsAffected == 0 {
+                return ConflictError("Listing has changed since it was read", nil)
+        }
+
+        return nil
+}
+
+// publishListing makes a listing available if it has one of the given statuses
+func publishListing(ctx context.Context, id string, publishedAt, expiresAt time.Time, statuses ...string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        listingID, err := parseID(id, "listing")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE listings
+                SET status = $1, publish_at = NULL, created_at = $2, updated_at = $2,
+                    expires_at = $3, expiry_warned_at = NULL
+                WHERE id = $4 AND status = ANY($5)
+        `, models.ListingStatusAvailable, publishedAt, expiresAt, listingID, pq.Array(statuses))
+        if err != nil {
+                return dbError(ctx, err, "listing")
+        }
+
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return dbError(ctx, err, "listing")
+        }
+        if rowsAffected == 0 {
+                return ConflictError("Listing is not a draft", nil)
+        }
+
+        return nil
+}
+
+// GetDueScheduledListings retrieves scheduled listings whose publication time has passed.
+// Images are loaded so the listing can be saved back without losing them.
+func GetDueScheduledListings(ctx context.Context, now time.Time) ([]models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        return queryListings(ctx, `
+                SELECT `+listingColumns+`
+                FROM listings l
+                WHERE l.status = $1 AND l.publish_at <= $2
+                ORDER BY l.publish_at
+        `, models.ListingStatusScheduled, now)
+}
+
+// BackfillListingExpiry sets an expiry on available listings created before expiry existed.
+// Listings get their normal lifetime but never expire before notBefore, so owners are still warned.
+func BackfillListingExpiry(ctx context.Context, lifetime time.Duration, notBefore time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        _, err := GetDB().ExecContext(ctx, `
+                UPDATE listings
+                SET expires_at = GREATEST(created_at + $1 * INTERVAL '1 second', $2)
+                WHERE expires_at IS NULL AND status = $3
+        `, int64(lifetime.Seconds()), notBefore, models.ListingStatusAvailable)
+
+        return dbError(ctx, err, "listing")
+}
+
+// MarkExpiringListingsWarned flags available listings expiring before the given time whose
+// owners have not been warned yet, and returns them
+func MarkExpiringListingsWarned(ctx context.Context, before time.Time) ([]models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        rows, err := GetDB().QueryContext(ctx, `
+                UPDATE listings
+                SET expiry_warned_at = CURRENT_TIMESTAMP
+                WHERE status = $1 AND expires_at <= $2 AND expiry_warned_at IS NULL
+                RETURNING id, user_id, title, expires_at
+        `, models.ListingStatusAvailable, before)
+        if err != nil {
+                return nil, dbError(ctx, err, "listing")
+        }
+        defer rows.Close()
+
+        return scanExpiryRows(ctx, rows)
+}
+
+// ExpireListings marks available listings past their expiry time as expired and returns them
+func ExpireListings(ctx context.Context, now time.Time) ([]models.Listing, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        rows, err := GetDB().QueryContext(ctx, `
+                UPDATE listings
+                SET status = $1, updated_at = $2
+                WHERE status = $3 AND expires_at <= $2
+                RETURNING id, user_id, title, expires_at
+        `, models.ListingStatusExpired, now, models.ListingStatusAvailable)
+        if err != nil {
+                return nil, dbError(ctx, err, "listing")
+        }
+        defer rows.Close()
+
+        return scanExpiryRows(ctx, rows)
+}
+
+// scanExpiryRows scans the id, user_id, title and expires_at rows returned by the expiry updates
+func scanExpiryRows(ctx context.Context, rows *sql.Rows) ([]models.Listing, error) {
+        listings := []models.Listing{}
+        for rows.Next() {
+                var listing models.Listing
+                var id, userID int
+                if err := rows.Scan(&id, &userID, &listing.Title, &listing.ExpiresAt); err != nil {
+                        return nil, dbError(ctx, err, "listing")
+                }
+                listing.ID = strconv.Itoa(id)
+                listing.UserID = strconv.Itoa(userID)
+
+                listings = append(listings, listing)
+        }
+
+        if err := rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "listing")
+        }
+
+        return listings, nil
+}
+
+// SaveNotification saves a notification to the database, returning its ID
+func SaveNotification(ctx context.Context, notification models.Notification) (string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userID, err := parseID(notification.UserID, "user")
+        if err != nil {
+                return "", err
+        }
+
+        var listingIDParam interface{} = nil
+        if notification.ListingID != "" {
+                listingIDInt, err := parseID(notification.ListingID, "listing")
+                if err != nil {
+                        return "", err
+                }
+                listingIDParam = listingIDInt
+        }
+
+        var id int
+        err = GetDB().QueryRowContext(ctx, `
+                INSERT INTO notifications (user_id, listing_id, kind, message, read, created_at)
+                VALUES ($1, $2, $3, $4, $5, $6)
+                RETURNING id
+        `, userID, listingIDParam, notification.Kind, notification.Message, notification.Read, notification.CreatedAt).Scan(&id)
+        if err != nil {
+                return "", dbError(ctx, err, "notification")
+        }
+
+        return strconv.Itoa(id), nil
+}
+
+// GetNotificationsByUser retrieves a user's notifications, newest first
+func GetNotificationsByUser(ctx context.Context, userID string) ([]models.Notification, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT id, user_id, listing_id, kind, message, read, created_at
+                FROM notifications
+                WHERE user_id = $1
+                ORDER BY created_at DESC
+        `, userIDInt)
+        if err != nil {
+                return nil, dbError(ctx, err, "notification")
+        }
+        defer rows.Close()
+
+        notifications := []models.Notification{}
+        for rows.Next() {
+                var notification models.Notification
+                var id, dbUserID int
+                var listingID sql.NullInt64
+                err := rows.Scan(&id, &dbUserID, &listingID, &notification.Kind, &notification.Message, &notification.Read, &notification.CreatedAt)
+                if err != nil {
+                        return nil, dbError(ctx, err, "notification")
+                }
+                notification.ID = strconv.Itoa(id)
+                notification.UserID = strconv.Itoa(dbUserID)
                 if listingID.Valid {
-                        message.ListingID = strconv.FormatInt(listingID.Int64, 10)
-                } else {
-                        message.ListingID = ""
+                        notification.ListingID = strconv.FormatInt(listingID.Int64, 10)
+                }
+
+                notifications = append(notifications, notification)
+        }
+
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "notification")
+        }
+
+        return notifications, nil
+}
+
+// MarkNotificationAsRead marks one of a user's notifications as read
+func MarkNotificationAsRead(ctx context.Context, id, userID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        notificationID, err := parseID(id, "notification")
+        if err != nil {
+                return err
+        }
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE notifications
+                SET read = true
+                WHERE id = $1 AND user_id = $2
+        `, notificationID, userIDInt)
+        if err != nil {
+                return dbError(ctx, err, "notification")
+        }
+
+        return requireRowsAffected(ctx, result, "notification")
+}
+
+// sameImages reports whether two image lists hold the same URLs in the same order
+func sameImages(a, b []string) bool {
+        if len(a) != len(b) {
+                return false
+        }
+        for i := range a {
+                if a[i] != b[i] {
+                        return false
+                }
+        }
+        return true
+}
+
+// imageReference identifies an image URL in a listing revision by its SHA-256 hash,
+// so revisions do not keep their own copies of uploaded images
+func imageReference(imageURL string) string {
+        sum := sha256.Sum256([]byte(imageURL))
+        return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// imageReferenceSQL computes imageReference for the SQL expression image_url
+const imageReferenceSQL = `'sha256:' || encode(sha256(convert_to(image_url, 'UTF8')), 'hex')`
+
+// saveListingRevision appends a snapshot of the listing as its next revision within a transaction.
+// Nothing is written if the snapshot shows buyers the same listing as the latest revision, so
+// no-op saves and status changes do not mark the listing as edited.
+func saveListingRevision(ctx context.Context, tx *sql.Tx, listingID int, listing models.Listing, createdAt time.Time) error {
+        revision := models.ListingRevision{
+                Title:       listing.Title,
+                Description: listing.Description,
+                Type:        listing.Type,
+                PlantType:   listing.PlantType,
+                Price:       listing.Price,
+                TradeFor:    listing.TradeFor,
+                Location:    listing.Location,
+                Status:      listing.Status,
+                Images:      []string{},
+        }
+        for _, imageURL := range listing.Images {
+                revision.Images = append(revision.Images, imageReference(imageURL))
+        }
+
+        latest, err := scanListingRevision(tx.QueryRowContext(ctx, `
+                SELECT `+listingRevisionColumns+`
+                FROM listing_revisions
+                WHERE listing_id = $1
+                ORDER BY revision DESC
+                LIMIT 1
+        `, listingID))
+        if err == nil && latest.SameContent(revision) {
+                return nil
+        }
+        if err != nil && err != sql.ErrNoRows {
+                return err
+        }
+
+        imagesJSON, err := json.Marshal(revision.Images)
+        if err != nil {
+                return err
+        }
+
+        _, err = tx.ExecContext(ctx, `
+                INSERT INTO listing_revisions (listing_id, revision, title, description, type, plant_type, price,
+                                               trade_for, location, status, images, created_at)
+                SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+                FROM listing_revisions
+                WHERE listing_id = $1
+        `, listingID, revision.Title, revision.Description, revision.Type, revision.PlantType, revision.Price,
+                revision.TradeFor, revision.Location, revision.Status, string(imagesJSON), createdAt)
+        return err
+}
+
+// saveBaselineRevision records the currently stored state of a listing as its first
+// revision if it has none yet, i.e. for listings created before revisions existed
+func saveBaselineRevision(ctx context.Context, tx *sql.Tx, listingID int) error {
+        _, err := tx.ExecContext(ctx, `
+                INSERT INTO listing_revisions (listing_id, revision, title, description, type, plant_type, price,
+                                               trade_for, location, status, images, created_at)
+                SELECT l.id, 1, l.title, l.description, l.type, l.plant_type, l.price,
+                       l.trade_for, l.location, l.status,
+                       COALESCE((SELECT json_agg(`+imageReferenceSQL+` ORDER BY id) FROM listing_images WHERE listing_id = l.id), '[]')::jsonb,
+                       l.updated_at
+                FROM listings l
+                WHERE l.id = $1 AND NOT EXISTS (SELECT 1 FROM listing_revisions WHERE listing_id = $1)
+        `, listingID)
+        return err
+}
+
+// setListingEditSummary fills in LastEditedAt and PreviousPrice from the listing's revisions.
+// Only revisions after publication count, so edits made while drafting are not shown to buyers.
+func setListingEditSummary(ctx context.Context, listing *models.Listing, listingID int) error {
+        var lastEditedAt sql.NullTime
+        err := GetDB().QueryRowContext(ctx, `
+                SELECT MAX(created_at)
+                FROM listing_revisions
+                WHERE listing_id = $1 AND created_at > $2
+        `, listingID, listing.CreatedAt).Scan(&lastEditedAt)
+        if err != nil {
+                return err
+        }
+        if !lastEditedAt.Valid {
+                return nil
+        }
+        listing.LastEditedAt = &lastEditedAt.Time
+
+        // Find the most recent price in effect since publication that differs from the current one
+        var previousPrice float64
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT r.price
+                FROM listing_revisions r
+                WHERE r.listing_id = $1 AND r.price <> $3
+                  AND r.revision >= COALESCE((
+                        SELECT MAX(revision) FROM listing_revisions
+                        WHERE listing_id = $1 AND created_at <= $2
+                  ), 1)
+                ORDER BY r.revision DESC
+                LIMIT 1
+        `, listingID, listing.CreatedAt, listing.Price).Scan(&previousPrice)
+        if err != nil {
+                if err == sql.ErrNoRows {
+                        return nil
+                }
+                return err
+        }
+
+        if previousPrice > listing.Price {
+                listing.PreviousPrice = &previousPrice
+        }
+
+        return nil
+}
+
+// listingRevisionColumns are the listing_revisions columns read by scanListingRevision
+const listingRevisionColumns = `listing_id, revision, title, description, type, plant_type, price,
+                                   trade_for, location, status, images, created_at`
+
+// GetListingRevisions retrieves all revisions of a listing, oldest first
+func GetListingRevisions(ctx context.Context, listingID string) ([]models.ListingRevision, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        listingIDInt, err := parseID(listingID, "listing")
+        if err != nil {
+                return nil, err
+        }
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT `+listingRevisionColumns+`
+                FROM listing_revisions
+                WHERE listing_id = $1
+                ORDER BY revision
+        `, listingIDInt)
+        if err != nil {
+                return nil, dbError(ctx, err, "listing revision")
+        }
+        defer rows.Close()
+
+        revisions := []models.ListingRevision{}
+        for rows.Next() {
+                revision, err := scanListingRevision(rows)
+                if err != nil {
+                        return nil, dbError(ctx, err, "listing revision")
                 }
 
-                messages = append(messages, message)
+                revisions = append(revisions, revision)
         }
 
         if err = rows.Err(); err != nil {
-                log.Printf("Error iterating message rows: %v", err)
+                return nil, dbError(ctx, err, "listing revision")
         }
 
-        return messages
+        return revisions, nil
 }
 
-// GetMessage retrieves a message by ID from the database
-func GetMessage(id string) (models.Message, bool) {
-        var message models.Message
-        var dbID, fromID, toID int
-        var listingID sql.NullInt64
+// GetListingRevision retrieves a single revision of a listing
+func GetListingRevision(ctx context.Context, listingID string, revisionNumber int) (models.ListingRevision, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
-        messageID, err := strconv.Atoi(id)
+        listingIDInt, err := parseID(listingID, "listing")
         if err != nil {
-                log.Printf("Invalid message ID: %v", err)
-                return models.Message{}, false
+                return models.ListingRevision{}, err
         }
 
-        err = GetDB().QueryRow(`
-                SELECT id, from_id, to_id, listing_id, content, read, created_at
-                FROM messages
-                WHERE id = $1
-        `, messageID).Scan(&dbID, &fromID, &toID, &listingID, &message.Content, &message.Read, &message.CreatedAt)
+        revision, err := scanListingRevision(GetDB().QueryRowContext(ctx, `
+                SELECT `+listingRevisionColumns+`
+                FROM listing_revisions
+                WHERE listing_id = $1 AND revision = $2
+        `, listingIDInt, revisionNumber))
+        if err != nil {
+                return models.ListingRevision{}, dbError(ctx, err, "listing revision")
+        }
+
+        return revision, nil
+}
+
+// scanListingRevision scans a row selected with listingRevisionColumns
+func scanListingRevision(row rowScanner) (models.ListingRevision, error) {
+        var revision models.ListingRevision
+        var listingID int
+        var tradeFor, status sql.NullString
+        var imagesJSON []byte
 
+        err := row.Scan(&listingID, &revision.Revision, &revision.Title, &revision.Description, &revision.Type,
+                &revision.PlantType, &revision.Price, &tradeFor, &revision.Location, &status, &imagesJSON, &revision.CreatedAt)
         if err != nil {
-                if err == sql.ErrNoRows {
-                        return models.Message{}, false
-                }
-                log.Printf("Error getting message by ID: %v", err)
-                return models.Message{}, false
+                return models.ListingRevision{}, err
         }
 
-        message.ID = strconv.Itoa(dbID)
-        message.FromID = strconv.Itoa(fromID)
-        message.ToID = strconv.Itoa(toID)
-        if listingID.Valid {
-                message.ListingID = strconv.FormatInt(listingID.Int64, 10)
-        } else {
-                message.ListingID = ""
+        revision.ListingID = strconv.Itoa(listingID)
+        revision.TradeFor = tradeFor.String
+        revision.Status = status.String
+        if err := json.Unmarshal(imagesJSON, &revision.Images); err != nil {
+                return models.ListingRevision{}, err
         }
 
-        return message, true
+        return revision, nil
 }
 
-// GetMessagesByUser retrieves all messages for a user from the database
-func GetMessagesByUser(userID string) []models.Message {
-        userIDInt, err := strconv.Atoi(userID)
+// CreateDataExport records a pending data export request for a user, returning its ID
+func CreateDataExport(ctx context.Context, userID string, requestedAt time.Time) (string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return []models.Message{}
+                return "", err
         }
 
-        rows, err := GetDB().Query(`
-                SELECT id, from_id, to_id, listing_id, content, read, created_at
-                FROM messages
-                WHERE from_id = $1 OR to_id = $1
-                ORDER BY created_at
-        `, userIDInt)
+        var id int
+        err = GetDB().QueryRowContext(ctx, `
+                INSERT INTO data_exports (user_id, status, requested_at)
+                VALUES ($1, $2, $3)
+                RETURNING id
+        `, userIDInt, models.DataExportPending, requestedAt).Scan(&id)
         if err != nil {
-                log.Printf("Error getting messages by user: %v", err)
-                return []models.Message{}
+                return "", dbError(ctx, err, "data export")
         }
-        defer rows.Close()
 
-        messages := []models.Message{}
-        for rows.Next() {
-                var message models.Message
-                var id, fromID, toID int
-                var listingID sql.NullInt64
-                err := rows.Scan(&id, &fromID, &toID, &listingID, &message.Content, &message.Read, &message.CreatedAt)
-                if err != nil {
-                        log.Printf("Error scanning message row: %v", err)
-                        continue
-                }
-                message.ID = strconv.Itoa(id)
-                message.FromID = strconv.Itoa(fromID)
-                message.ToID = strconv.Itoa(toID)
-                if listingID.Valid {
-                        message.ListingID = strconv.FormatInt(listingID.Int64, 10)
-                } else {
-                        message.ListingID = ""
-                }
+        return strconv.Itoa(id), nil
+}
 
-                messages = append(messages, message)
+// dataExportColumns are the data_exports columns read by scanDataExport; the file is loaded separately
+const dataExportColumns = `id, user_id, status, error, requested_at, completed_at, expires_at`
+
+// scanDataExport scans a row selected with dataExportColumns
+func scanDataExport(row rowScanner) (models.DataExport, error) {
+        var export models.DataExport
+        var id, userID int
+        var exportError sql.NullString
+        var completedAt, expiresAt sql.NullTime
+
+        err := row.Scan(&id, &userID, &export.Status, &exportError, &export.RequestedAt, &completedAt, &expiresAt)
+        if err != nil {
+                return models.DataExport{}, err
         }
 
-        if err = rows.Err(); err != nil {
-                log.Printf("Error iterating message rows: %v", err)
+        export.ID = strconv.Itoa(id)
+        export.UserID = strconv.Itoa(userID)
+        export.Error = exportError.String
+        if completedAt.Valid {
+                export.CompletedAt = &completedAt.Time
+        }
+        if expiresAt.Valid {
+                export.ExpiresAt = &expiresAt.Time
         }
 
-        return messages
+        return export, nil
 }
 
-// GetMessagesBetweenUsers retrieves all messages between two users from the database
-func GetMessagesBetweenUsers(user1ID, user2ID string) []models.Message {
-        user1IDInt, err := strconv.Atoi(user1ID)
+// GetDataExport retrieves a data export by ID, without its file
+func GetDataExport(ctx context.Context, id string) (models.DataExport, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        exportID, err := parseID(id, "data export")
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return []models.Message{}
+                return models.DataExport{}, err
         }
 
-        user2IDInt, err := strconv.Atoi(user2ID)
+        export, err := scanDataExport(GetDB().QueryRowContext(ctx, `
+                SELECT `+dataExportColumns+`
+                FROM data_exports
+                WHERE id = $1
+        `, exportID))
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return []models.Message{}
+                return models.DataExport{}, dbError(ctx, err, "data export")
         }
 
-        rows, err := GetDB().Query(`
-                SELECT id, from_id, to_id, listing_id, content, read, created_at
-                FROM messages
-                WHERE (from_id = $1 AND to_id = $2) OR (from_id = $2 AND to_id = $1)
-                ORDER BY created_at
-        `, user1IDInt, user2IDInt)
+        return export, nil
+}
+
+// GetDataExportsByUser retrieves a user's data exports, newest first, without their files
+func GetDataExportsByUser(ctx context.Context, userID string) ([]models.DataExport, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return nil, err
+        }
+
+        return queryDataExports(ctx, `
+                SELECT `+dataExportColumns+`
+                FROM data_exports
+                WHERE user_id = $1
+                ORDER BY requested_at DESC
+        `, userIDInt)
+}
+
+// GetUnfinishedDataExports retrieves exports that are waiting to be generated, including
+// ones whose generation started before staleBefore and never finished
+func GetUnfinishedDataExports(ctx context.Context, staleBefore time.Time) ([]models.DataExport, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        return queryDataExports(ctx, `
+                SELECT `+dataExportColumns+`
+                FROM data_exports
+                WHERE status = $1 OR (status = $2 AND started_at < $3)
+                ORDER BY requested_at
+        `, models.DataExportPending, models.DataExportProcessing, staleBefore)
+}
+
+// queryDataExports runs a query selecting dataExportColumns
+func queryDataExports(ctx context.Context, query string, args ...interface{}) ([]models.DataExport, error) {
+        rows, err := GetDB().QueryContext(ctx, query, args...)
         if err != nil {
-                log.Printf("Error getting messages between users: %v", err)
-                return []models.Message{}
+                return nil, dbError(ctx, err, "data export")
         }
         defer rows.Close()
 
-        messages := []models.Message{}
+        exports := []models.DataExport{}
         for rows.Next() {
-                var message models.Message
-                var id, fromID, toID int
-                var listingID sql.NullInt64
-                err := rows.Scan(&id, &fromID, &toID, &listingID, &message.Content, &message.Read, &message.CreatedAt)
+                export, err := scanDataExport(rows)
                 if err != nil {
-                        log.Printf("Error scanning message row: %v", err)
-                        continue
-                }
-                message.ID = strconv.Itoa(id)
-                message.FromID = strconv.Itoa(fromID)
-                message.ToID = strconv.Itoa(toID)
-                if listingID.Valid {
-                        message.ListingID = strconv.FormatInt(listingID.Int64, 10)
-                } else {
-                        message.ListingID = ""
+                        return nil, dbError(ctx, err, "data export")
                 }
-
-                messages = append(messages, message)
+                exports = append(exports, export)
         }
 
         if err = rows.Err(); err != nil {
-                log.Printf("Error iterating message rows: %v", err)
+                return nil, dbError(ctx, err, "data export")
         }
 
-        return messages
+        return exports, nil
 }
 
-// SaveMessage saves a message to the database
-func SaveMessage(msg models.Message) string {
-        fromID, err := strconv.Atoi(msg.FromID)
+// ClaimDataExport marks an export as being generated. It returns false if the export
+// is already being generated elsewhere, so each export is only built once.
+func ClaimDataExport(ctx context.Context, id string, now, staleBefore time.Time) (bool, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        exportID, err := parseID(id, "data export")
         if err != nil {
-                log.Printf("Invalid from user ID: %v", err)
-                return ""
+                return false, err
         }
 
-        toID, err := strconv.Atoi(msg.ToID)
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE data_exports
+                SET status = $2, started_at = $3
+                WHERE id = $1 AND (status = $4 OR (status = $2 AND started_at < $5))
+        `, exportID, models.DataExportProcessing, now, models.DataExportPending, staleBefore)
         if err != nil {
-                log.Printf("Invalid to user ID: %v", err)
-                return ""
+                return false, dbError(ctx, err, "data export")
         }
 
-        var listingIDParam interface{} = nil
-        if msg.ListingID != "" {
-                listingIDInt, err := strconv.Atoi(msg.ListingID)
-                if err != nil {
-                        log.Printf("Invalid listing ID: %v", err)
-                        return ""
-                }
-                listingIDParam = listingIDInt
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return false, dbError(ctx, err, "data export")
         }
 
-        // If the message has no ID, insert a new message
-        if msg.ID == "" {
-                var id int
-                err := GetDB().QueryRow(`
-                        INSERT INTO messages (from_id, to_id, listing_id, content, read, created_at)
-                        VALUES ($1, $2, $3, $4, $5, $6)
-                        RETURNING id
-                `, fromID, toID, listingIDParam, msg.Content, msg.Read, msg.CreatedAt).Scan(&id)
+        return rowsAffected > 0, nil
+}
 
-                if err != nil {
-                        log.Printf("Error creating message: %v", err)
-                        return ""
-                }
+// CompleteDataExport stores the generated zip for an export
+func CompleteDataExport(ctx context.Context, id string, file []byte, completedAt, expiresAt time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
-                return strconv.Itoa(id)
+        exportID, err := parseID(id, "data export")
+        if err != nil {
+                return err
         }
 
-        // Message has an ID, update existing message
-        messageID, err := strconv.Atoi(msg.ID)
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE data_exports
+                SET status = $2, file = $3, error = NULL, completed_at = $4, expires_at = $5
+                WHERE id = $1
+        `, exportID, models.DataExportReady, file, completedAt, expiresAt)
         if err != nil {
-                log.Printf("Invalid message ID for update: %v", err)
-                return ""
+                return dbError(ctx, err, "data export")
         }
 
-        _, err = GetDB().Exec(`
-                UPDATE messages
-                SET from_id = $1, to_id = $2, listing_id = $3, content = $4, read = $5
-                WHERE id = $6
-        `, fromID, toID, listingIDParam, msg.Content, msg.Read, messageID)
+        return requireRowsAffected(ctx, result, "data export")
+}
+
+// FailDataExport records why an export could not be generated
+func FailDataExport(ctx context.Context, id string, message string, completedAt time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
+        exportID, err := parseID(id, "data export")
         if err != nil {
-                log.Printf("Error updating message: %v", err)
-                return ""
+                return err
         }
 
-        return msg.ID
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE data_exports
+                SET status = $2, error = $3, completed_at = $4
+                WHERE id = $1
+        `, exportID, models.DataExportFailed, message, completedAt)
+        if err != nil {
+                return dbError(ctx, err, "data export")
+        }
+
+        return requireRowsAffected(ctx, result, "data export")
 }
 
-// MarkMessageAsRead marks a message as read in the database
-func MarkMessageAsRead(id string) bool {
-        messageID, err := strconv.Atoi(id)
+// GetDataExportFile retrieves the zip of a ready export
+func GetDataExportFile(ctx context.Context, id string) ([]byte, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        exportID, err := parseID(id, "data export")
         if err != nil {
-                log.Printf("Invalid message ID: %v", err)
-                return false
+                return nil, err
         }
 
-        result, err := GetDB().Exec(`
-                UPDATE messages
-                SET read = true
-                WHERE id = $1
-        `, messageID)
+        var file []byte
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT file
+                FROM data_exports
+                WHERE id = $1 AND status = $2 AND file IS NOT NULL
+        `, exportID, models.DataExportReady).Scan(&file)
+        if err != nil {
+                return nil, dbError(ctx, err, "data export")
+        }
+
+        return file, nil
+}
+
+// DeleteExpiredDataExports removes exports whose download window has passed, returning how many were removed
+func DeleteExpiredDataExports(ctx context.Context, now time.Time) (int64, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
+        result, err := GetDB().ExecContext(ctx, `DELETE FROM data_exports WHERE expires_at <= $1`, now)
         if err != nil {
-                log.Printf("Error marking message as read: %v", err)
-                return false
+                return 0, dbError(ctx, err, "data export")
         }
 
         rowsAffected, err := result.RowsAffected()
         if err != nil {
-                log.Printf("Error getting rows affected: %v", err)
-                return false
+                return 0, dbError(ctx, err, "data export")
         }
 
-        return rowsAffected > 0
+        return rowsAffected, nil
 }
 
-// GetFavorites retrieves all favorite listing IDs for a user from the database
-func GetFavorites(userID string) []string {
-        userIDInt, err := strconv.Atoi(userID)
+// ScheduleAccountDeletion schedules a user's account to be purged at the given time and
+// revokes the sessions issued before requestedAt, so every device is logged out
+func ScheduleAccountDeletion(ctx context.Context, userID string, requestedAt, at time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return []string{}
+                return err
         }
 
-        rows, err := GetDB().Query(`
-                SELECT listing_id
-                FROM favorites
-                WHERE user_id = $1
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE users
+                SET deletion_scheduled_at = $2, sessions_revoked_at = $3
+                WHERE id = $1 AND deleted_at IS NULL
+        `, userIDInt, at, requestedAt)
+        if err != nil {
+                return dbError(ctx, err, "user")
+        }
+
+        return requireRowsAffected(ctx, result, "user")
+}
+
+// GetSessionsRevokedAt returns when a user's sessions were last revoked, or the zero
+// time if they never were. Purged accounts are reported as not found.
+func GetSessionsRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return time.Time{}, err
+        }
+
+        var revokedAt sql.NullTime
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT sessions_revoked_at
+                FROM users
+                WHERE id = $1 AND deleted_at IS NULL
+        `, userIDInt).Scan(&revokedAt)
+        if err != nil {
+                return time.Time{}, dbError(ctx, err, "user")
+        }
+
+        return revokedAt.Time, nil
+}
+
+// CancelAccountDeletion cancels a scheduled account deletion
+func CancelAccountDeletion(ctx context.Context, userID string) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE users
+                SET deletion_scheduled_at = NULL
+                WHERE id = $1 AND deleted_at IS NULL
         `, userIDInt)
         if err != nil {
-                log.Printf("Error getting favorites: %v", err)
-                return []string{}
+                return dbError(ctx, err, "user")
+        }
+
+        return requireRowsAffected(ctx, result, "user")
+}
+
+// GetAccountsDueForPurge retrieves the IDs of accounts whose deletion grace period has ended
+func GetAccountsDueForPurge(ctx context.Context, now time.Time) ([]string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        rows, err := GetDB().QueryContext(ctx, `
+                SELECT id
+                FROM users
+                WHERE deletion_scheduled_at <= $1 AND deleted_at IS NULL
+        `, now)
+        if err != nil {
+                return nil, dbError(ctx, err, "user")
         }
         defer rows.Close()
 
-        favoriteIDs := []string{}
+        userIDs := []string{}
         for rows.Next() {
-                var listingID int
-                err := rows.Scan(&listingID)
-                if err != nil {
-                        log.Printf("Error scanning favorite row: %v", err)
-                        continue
+                var id int
+                if err := rows.Scan(&id); err != nil {
+                        return nil, dbError(ctx, err, "user")
                 }
-                favoriteIDs = append(favoriteIDs, strconv.Itoa(listingID))
+                userIDs = append(userIDs, strconv.Itoa(id))
         }
 
         if err = rows.Err(); err != nil {
-                log.Printf("Error iterating favorite rows: %v", err)
+                return nil, dbError(ctx, err, "user")
         }
 
-        return favoriteIDs
+        return userIDs, nil
 }
 
-// AddFavorite adds a listing to a user's favorites in the database
-func AddFavorite(userID, listingID string) bool {
-        userIDInt, err := strconv.Atoi(userID)
+// PurgeAccount permanently deletes a user's personal data. Listings, favorites,
+// notifications, exports and content held for moderation are deleted. Messages are kept for the other party but
+// the account is anonymized, so they appear to come from a deleted user; messages
+// whose other party has also been deleted are removed, with conversations left empty.
+func PurgeAccount(ctx context.Context, userID string, now time.Time) error {
+        ctx, cancel := withTransactionTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return false
+                return err
         }
 
-        listingIDInt, err := strconv.Atoi(listingID)
+        tx, err := GetDB().BeginTx(ctx, nil)
         if err != nil {
-                log.Printf("Invalid listing ID: %v", err)
-                return false
+                return dbError(ctx, err, "user")
         }
+        defer func() {
+                if err != nil {
+                        tx.Rollback()
+                }
+        }()
 
-        // Check if already favorited
-        var count int
-        err = GetDB().QueryRow(`
-                SELECT COUNT(*)
-                FROM favorites
-                WHERE user_id = $1 AND listing_id = $2
-        `, userIDInt, listingIDInt).Scan(&count)
+        statements := []string{
+                `DELETE FROM listings WHERE user_id = $1`,
+                `DELETE FROM favorites WHERE user_id = $1`,
+                `DELETE FROM follows WHERE follower_id = $1 OR followed_id = $1`,
+                `DELETE FROM notifications WHERE user_id = $1`,
+                `DELETE FROM data_exports WHERE user_id = $1`,
+                `DELETE FROM moderation_queue WHERE user_id = $1`,
+                `DELETE FROM message_attachments WHERE uploader_id = $1 AND message_id IS NULL`,
+                `DELETE FROM messages
+                 WHERE (from_id = $1 AND (to_id = $1 OR to_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)))
+                    OR (to_id = $1 AND from_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL))`,
+                `DELETE FROM conversations c
+                 WHERE (c.user1_id = $1 OR c.user2_id = $1)
+                   AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.conversation_id = c.id)`,
+        }
+        for _, statement := range statements {
+                _, err = tx.ExecContext(ctx, statement, userIDInt)
+                if err != nil {
+                        return dbError(ctx, err, "user")
+                }
+        }
 
+        // Anonymize the account; the row is kept so messages still have a sender
+        _, err = tx.ExecContext(ctx, `
+                UPDATE users
+                SET email = 'deleted-' || id || '@deleted.invalid', username = 'deleted-' || id, password = '',
+                    name = 'Deleted user', location = '', bio = '', profile_pic = '',
+                    deletion_scheduled_at = NULL, deleted_at = $2
+                WHERE id = $1
+        `, userIDInt, now)
         if err != nil {
-                log.Printf("Error checking for existing favorite: %v", err)
-                return false
+                return dbError(ctx, err, "user")
         }
 
-        if count > 0 {
-                // Already favorited
-                return false
+        err = tx.Commit()
+        if err != nil {
+                return dbError(ctx, err, "user")
         }
 
-        // Add to favorites
-        _, err = GetDB().Exec(`
-                INSERT INTO favorites (user_id, listing_id)
-                VALUES ($1, $2)
-        `, userIDInt, listingIDInt)
+        return nil
+}
+
+// normalizedText is the SQL for text with case and runs of whitespace ignored,
+// for comparing messages
+func normalizedText(expr string) string {
+        return `lower(regexp_replace(btrim(` + expr + `), '\s+', ' ', 'g'))`
+}
 
+// CountMessagesSentSince counts the messages a user sent after a time, including
+// those held for moderation
+func CountMessagesSentSince(ctx context.Context, userID string, since time.Time) (int, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Error adding favorite: %v", err)
-                return false
+                return 0, err
         }
 
-        return true
+        // Approved messages were delivered and are counted among the messages
+        var count int
+        err = GetDB().QueryRowContext(ctx, `
+                SELECT (SELECT COUNT(*) FROM messages WHERE from_id = $1 AND created_at > $2)
+                     + (SELECT COUNT(*) FROM moderation_queue
+                        WHERE user_id = $1 AND kind = $3 AND status <> $4 AND created_at > $2)
+        `, userIDInt, since, models.ContentMessage, models.ModerationApproved).Scan(&count)
+        if err != nil {
+                return 0, dbError(ctx, err, "message")
+        }
+
+        return count, nil
 }
 
-// RemoveFavorite removes a listing from a user's favorites in the database
-func RemoveFavorite(userID, listingID string) bool {
-        userIDInt, err := strconv.Atoi(userID)
+// CountDuplicateRecipients counts the users other than recipientID that a user sent
+// the same text to after a time, ignoring case and spacing. Messages held for
+// moderation count too.
+func CountDuplicateRecipients(ctx context.Context, userID, recipientID, content string, since time.Time) (int, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return false
+                return 0, err
+        }
+        recipientIDInt, err := parseID(recipientID, "user")
+        if err != nil {
+                return 0, err
         }
 
-        listingIDInt, err := strconv.Atoi(listingID)
+        var count int
+        err = GetDB().QueryRowContext(ctx, `
+                WITH sent AS (
+                        SELECT to_id, content FROM messages
+                        WHERE from_id = $1 AND created_at > $4
+                        UNION ALL
+                        SELECT (content->>'toId')::integer, content->>'content' FROM moderation_queue
+                        WHERE user_id = $1 AND kind = $5 AND status <> $6 AND created_at > $4
+                )
+                SELECT COUNT(DISTINCT to_id) FROM sent
+                WHERE to_id <> $2 AND `+normalizedText("content")+` = `+normalizedText("$3")+`
+        `, userIDInt, recipientIDInt, content, since, models.ContentMessage, models.ModerationApproved).Scan(&count)
         if err != nil {
-                log.Printf("Invalid listing ID: %v", err)
-                return false
+                return 0, dbError(ctx, err, "message")
         }
 
-        result, err := GetDB().Exec(`
-                DELETE FROM favorites
-                WHERE user_id = $1 AND listing_id = $2
-        `, userIDInt, listingIDInt)
+        return count, nil
+}
+
+// HoldContent adds a new message or listing to the moderation queue as submitted,
+// returning its ID. The listed photos of a held message are kept until it is
+// reviewed, if the user uploaded them and has not sent them yet.
+func HoldContent(ctx context.Context, kind, userID string, content interface{}, attachmentIDs []string, screening models.Screening, createdAt time.Time) (string, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
 
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Error removing favorite: %v", err)
-                return false
+                return "", err
+        }
+        attachmentIDInts := []int{}
+        for _, attachmentID := range attachmentIDs {
+                id, err := parseID(attachmentID, "attachment")
+                if err != nil {
+                        return "", err
+                }
+                attachmentIDInts = append(attachmentIDInts, id)
         }
 
-        rowsAffected, err := result.RowsAffected()
+        contentJSON, err := json.Marshal(content)
         if err != nil {
-                log.Printf("Error getting rows affected: %v", err)
-                return false
+                return "", InternalError(err)
+        }
+        flagsJSON, err := json.Marshal(screening.Flags)
+        if err != nil {
+                return "", InternalError(err)
+        }
+
+        var id int
+        err = GetDB().QueryRowContext(ctx, `
+                INSERT INTO moderation_queue (kind, user_id, content, attachment_ids, score, flags, status, created_at)
+                VALUES ($1, $2, $3, ARRAY(
+                        SELECT id FROM message_attachments
+                        WHERE id = ANY($4) AND uploader_id = $2 AND message_id IS NULL
+                ), $5, $6, $7, $8)
+                RETURNING id
+        `, kind, userIDInt, contentJSON, pq.Array(attachmentIDInts), screening.Score, flagsJSON,
+                models.ModerationPending, createdAt).Scan(&id)
+        if err != nil {
+                return "", dbError(ctx, err, "held content")
         }
 
-        return rowsAffected > 0
+        return strconv.Itoa(id), nil
 }
 
-// IsFavorite checks if a listing is in a user's favorites in the database
-func IsFavorite(userID, listingID string) bool {
-        userIDInt, err := strconv.Atoi(userID)
+// heldContentColumns are the moderation_queue columns read by scanHeldContent
+const heldContentColumns = `id, kind, user_id, content, score, flags, status, created_at, reviewed_at`
+
+// scanHeldContent scans a row selected with heldContentColumns
+func scanHeldContent(row rowScanner) (models.HeldContent, error) {
+        var held models.HeldContent
+        var id, userID int
+        var content, flags []byte
+        var reviewedAt sql.NullTime
+
+        err := row.Scan(&id, &held.Kind, &userID, &content, &held.Screening.Score, &flags,
+                &held.Status, &held.CreatedAt, &reviewedAt)
         if err != nil {
-                log.Printf("Invalid user ID: %v", err)
-                return false
+                return models.HeldContent{}, err
+        }
+
+        held.ID = strconv.Itoa(id)
+        held.UserID = strconv.Itoa(userID)
+        held.Content = json.RawMessage(content)
+        if err := json.Unmarshal(flags, &held.Screening.Flags); err != nil {
+                return models.HeldContent{}, err
+        }
+        held.Screening.Held = true
+        if reviewedAt.Valid {
+                held.ReviewedAt = &reviewedAt.Time
         }
 
-        listingIDInt, err := strconv.Atoi(listingID)
+        return held, nil
+}
+
+// GetHeldContent retrieves content held for moderation by ID
+func GetHeldContent(ctx context.Context, id string) (models.HeldContent, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        heldID, err := parseID(id, "held content")
         if err != nil {
-                log.Printf("Invalid listing ID: %v", err)
-                return false
+                return models.HeldContent{}, err
         }
 
-        var count int
-        err = GetDB().QueryRow(`
-                SELECT COUNT(*)
-                FROM favorites
-                WHERE user_id = $1 AND listing_id = $2
-        `, userIDInt, listingIDInt).Scan(&count)
+        held, err := scanHeldContent(GetDB().QueryRowContext(ctx, `
+                SELECT `+heldContentColumns+`
+                FROM moderation_queue
+                WHERE id = $1
+        `, heldID))
+        if err != nil {
+                return models.HeldContent{}, dbError(ctx, err, "held content")
+        }
+
+        return held, nil
+}
+
+// GetHeldContentByStatus retrieves held content with a moderation status, oldest first
+func GetHeldContentByStatus(ctx context.Context, status string) ([]models.HeldContent, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        return queryHeldContent(ctx, `
+                SELECT `+heldContentColumns+`
+                FROM moderation_queue
+                WHERE status = $1
+                ORDER BY created_at, id
+        `, status)
+}
 
+// GetHeldContentByUser retrieves the content a user submitted that was held for moderation, oldest first
+func GetHeldContentByUser(ctx context.Context, userID string) ([]models.HeldContent, error) {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        userIDInt, err := parseID(userID, "user")
         if err != nil {
-                log.Printf("Error checking if favorite: %v", err)
-                return false
+                return nil, err
+        }
+
+        return queryHeldContent(ctx, `
+                SELECT `+heldContentColumns+`
+                FROM moderation_queue
+                WHERE user_id = $1
+                ORDER BY created_at, id
+        `, userIDInt)
+}
+
+// queryHeldContent runs a query selecting heldContentColumns
+func queryHeldContent(ctx context.Context, query string, args ...interface{}) ([]models.HeldContent, error) {
+        rows, err := GetDB().QueryContext(ctx, query, args...)
+        if err != nil {
+                return nil, dbError(ctx, err, "held content")
+        }
+        defer rows.Close()
+
+        held := []models.HeldContent{}
+        for rows.Next() {
+                item, err := scanHeldContent(rows)
+                if err != nil {
+                        return nil, dbError(ctx, err, "held content")
+                }
+                held = append(held, item)
         }
 
-        return count > 0
-}
\ No newline at end of file
+        if err = rows.Err(); err != nil {
+                return nil, dbError(ctx, err, "held content")
+        }
+
+        return held, nil
+}
+
+// SetHeldContentStatus moves held content from one moderation status to another.
+// It returns a conflict error if the content is no longer in the first status,
+// e.g. because another moderator reviewed it.
+func SetHeldContentStatus(ctx context.Context, id, from, to string, reviewedAt *time.Time) error {
+        ctx, cancel := withQueryTimeout(ctx)
+        defer cancel()
+
+        heldID, err := parseID(id, "held content")
+        if err != nil {
+                return err
+        }
+
+        result, err := GetDB().ExecContext(ctx, `
+                UPDATE moderation_queue
+                SET status = $3, reviewed_at = $4
+                WHERE id = $1 AND status = $2
+        `, heldID, from, to, reviewedAt)
+        if err != nil {
+                return dbError(ctx, err, "held content")
+        }
+
+        if err := requireRowsAffected(ctx, result, "held content"); err != nil {
+                held, getErr := GetHeldContent(ctx, id)
+                if getErr != nil {
+                        return getErr
+                }
+                return ConflictError(fmt.Sprintf("Held content is %s, not %s", held.Status, from), nil)
+        }
+
+        return nil
+}
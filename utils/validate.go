@@ -0,0 +1,187 @@
+package utils
+
+import (
+        "fmt"
+        "net/mail"
+        "regexp"
+        "sort"
+        "strings"
+        "unicode/utf8"
+
+        "github.com/plantexchange/app/models"
+)
+
+// Field limits. String limits match the column sizes in createTables.
+const (
+        MinUsernameLength    = 3
+        MaxUsernameLength    = 50
+        MaxEmailLength       = 100
+        MinPasswordLength    = 8
+        MaxPasswordLength    = 72 // bcrypt ignores anything longer
+        MaxNameLength        = 100
+        MaxLocationLength    = 100
+        MaxBioLength         = 2000
+        MaxTitleLength       = 200
+        MaxDescriptionLength = 5000
+        MaxPlantTypeLength   = 50
+        MaxTradeForLength    = 500
+        MaxListingImages     = 10
+        MaxPrice             = 99999999.99 // NUMERIC(10, 2)
+        MaxMessageLength     = 5000
+)
+
+// usernamePattern is the characters allowed in usernames
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Validator collects problems with a request, keyed by JSON field name, so that
+// all of them can be reported at once. Only the first problem with a field is kept.
+type Validator struct {
+        Fields map[string]string
+}
+
+// NewValidator returns an empty validator
+func NewValidator() *Validator {
+        return &Validator{Fields: make(map[string]string)}
+}
+
+// Add records a problem with a field
+func (v *Validator) Add(field, message string) {
+        if _, exists := v.Fields[field]; !exists {
+                v.Fields[field] = message
+        }
+}
+
+// Check records a problem with a field unless ok is true
+func (v *Validator) Check(ok bool, field, message string) {
+        if !ok {
+                v.Add(field, message)
+        }
+}
+
+// Required checks that a string field is not blank
+func (v *Validator) Required(field, value string) {
+        v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// MaxLength checks that a string field has at most max characters
+func (v *Validator) MaxLength(field, value string, max int) {
+        v.Check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+// OneOf checks that a non-empty string field is one of the allowed values
+func (v *Validator) OneOf(field, value string, allowed []string) {
+        if value == "" {
+                return
+        }
+        for _, candidate := range allowed {
+                if value == candidate {
+                        return
+                }
+        }
+        v.Add(field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+// Range checks that a number field lies between min and max inclusive
+func (v *Validator) Range(field string, value, min, max float64) {
+        v.Check(value >= min && value <= max, field, fmt.Sprintf("must be between %g and %.2f", min, max))
+}
+
+// Email checks that a field is a plain email address
+func (v *Validator) Email(field, value string) {
+        address, err := mail.ParseAddress(value)
+        v.Check(err == nil && address.Address == value, field, "must be a valid email address")
+}
+
+// Valid reports whether no problems have been recorded
+func (v *Validator) Valid() bool {
+        return len(v.Fields) == 0
+}
+
+// Problems lists the recorded problems as "field message" strings, sorted by field
+func (v *Validator) Problems() []string {
+        problems := make([]string, 0, len(v.Fields))
+        for field, message := range v.Fields {
+                problems = append(problems, field+" "+message)
+        }
+        sort.Strings(problems)
+        return problems
+}
+
+// Err returns a validation error listing every problem, or nil if there are none
+func (v *Validator) Err() error {
+        if v.Valid() {
+                return nil
+        }
+        return ValidationError("Request has invalid fields: "+strings.Join(v.Problems(), "; "), v.Fields)
+}
+
+// ValidateNewUser checks the fields of a registration
+func ValidateNewUser(v *Validator, user models.User) {
+        v.Required("email", user.Email)
+        if user.Email != "" {
+                v.MaxLength("email", user.Email, MaxEmailLength)
+                v.Email("email", user.Email)
+        }
+
+        v.Required("username", user.Username)
+        if user.Username != "" {
+                v.Check(utf8.RuneCountInString(user.Username) >= MinUsernameLength, "username", fmt.Sprintf("must be at least %d characters", MinUsernameLength))
+                v.MaxLength("username", user.Username, MaxUsernameLength)
+                v.Check(usernamePattern.MatchString(user.Username), "username", "may only contain letters, digits, '.', '_' and '-'")
+        }
+
+        v.Check(len(user.Password) >= MinPasswordLength, "password", fmt.Sprintf("must be at least %d characters", MinPasswordLength))
+        v.Check(len(user.Password) <= MaxPasswordLength, "password", fmt.Sprintf("must be at most %d bytes", MaxPasswordLength))
+
+        ValidateProfile(v, user)
+}
+
+// ValidateProfile checks the editable profile fields of a user
+func ValidateProfile(v *Validator, user models.User) {
+        v.MaxLength("name", user.Name, MaxNameLength)
+        v.MaxLength("location", user.Location, MaxLocationLength)
+        v.MaxLength("bio", user.Bio, MaxBioLength)
+}
+
+// ValidateListing checks the shape of a listing. Drafts may be incomplete, so
+// required fields are left to Listing.PublishProblems.
+func ValidateListing(v *Validator, listing models.Listing) {
+        v.MaxLength("title", listing.Title, MaxTitleLength)
+        v.MaxLength("description", listing.Description, MaxDescriptionLength)
+        v.OneOf("type", listing.Type, models.ListingTypes)
+        v.MaxLength("plantType", listing.PlantType, MaxPlantTypeLength)
+        v.Range("price", listing.Price, 0, MaxPrice)
+        v.MaxLength("tradeFor", listing.TradeFor, MaxTradeForLength)
+        v.MaxLength("location", listing.Location, MaxLocationLength)
+        v.OneOf("status", listing.Status, models.ListingStatuses)
+        v.Check(len(listing.Images) <= MaxListingImages, "images", fmt.Sprintf("must have at most %d images", MaxListingImages))
+        for i, image := range listing.Images {
+                v.Check(strings.TrimSpace(image) != "", fmt.Sprintf("images[%d]", i), "is required")
+        }
+
+        if listing.CareSheet != nil {
+                ValidateCareSheet(v, *listing.CareSheet)
+        }
+}
+
+// ValidateCareSheet checks a listing's care information against the care sheet columns
+func ValidateCareSheet(v *Validator, careSheet models.CareSheet) {
+        v.MaxLength("careSheet.species", careSheet.Species, 100)
+        v.MaxLength("careSheet.light", careSheet.Light, 200)
+        v.MaxLength("careSheet.water", careSheet.Water, 200)
+        v.MaxLength("careSheet.humidity", careSheet.Humidity, 200)
+        v.MaxLength("careSheet.temperature", careSheet.Temperature, 100)
+        v.OneOf("careSheet.petToxicity", careSheet.PetToxicity, models.PetToxicityLevels)
+        v.MaxLength("careSheet.propagation", careSheet.Propagation, 200)
+}
+
+// ValidateMessage checks a message before it is sent
+func ValidateMessage(v *Validator, msg models.Message) {
+        v.Required("toId", msg.ToID)
+        v.Required("listingId", msg.ListingID)
+        if len(msg.Attachments) == 0 {
+                v.Required("content", msg.Content)
+        }
+        v.MaxLength("content", msg.Content, MaxMessageLength)
+        v.Check(len(msg.Attachments) <= MaxMessageAttachments, "attachmentIds", fmt.Sprintf("must have at most %d photos", MaxMessageAttachments))
+}
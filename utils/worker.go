@@ -0,0 +1,127 @@
+package utils
+
+import (
+        "context"
+        "log/slog"
+        "sync"
+        "time"
+)
+
+// Job is a unit of background work run periodically by a Worker.
+// An error stops the current run of the job and is logged; it is retried on the next tick.
+// The context is cancelled when the worker stops.
+type Job struct {
+        Name string
+        Run  func(ctx context.Context, now time.Time) error
+}
+
+// background tracks work started outside the worker, such as a data export requested over HTTP
+var background sync.WaitGroup
+
+// RunInBackground runs fn in a goroutine that WaitForBackground waits for on shutdown.
+// fn gets a context that keeps the values of ctx, such as the request logger, but is
+// not cancelled with it, so the task outlives the request that started it.
+func RunInBackground(ctx context.Context, name string, fn func(ctx context.Context) error) {
+        ctx = context.WithoutCancel(ctx)
+        background.Add(1)
+        go func() {
+                defer background.Done()
+                if err := fn(ctx); err != nil {
+                        Logger(ctx).Error("Background task failed", "task", name, "error", err)
+                }
+        }()
+}
+
+// WaitForBackground waits for work started with RunInBackground, giving up when ctx is done
+func WaitForBackground(ctx context.Context) error {
+        done := make(chan struct{})
+        go func() {
+                background.Wait()
+                close(done)
+        }()
+
+        select {
+        case <-done:
+                return nil
+        case <-ctx.Done():
+                return ctx.Err()
+        }
+}
+
+// Worker runs its jobs on a fixed interval in a background goroutine
+type Worker struct {
+        interval time.Duration
+        jobs     []Job
+        stop     chan struct{}
+        ctx      context.Context // cancelled by Stop so in-flight queries give up
+        cancel   context.CancelFunc
+        wg       sync.WaitGroup
+        stopOnce sync.Once
+}
+
+// NewWorker creates a worker that runs the given jobs every interval
+func NewWorker(interval time.Duration, jobs ...Job) *Worker {
+        ctx, cancel := context.WithCancel(context.Background())
+        return &Worker{
+                interval: interval,
+                jobs:     jobs,
+                stop:     make(chan struct{}),
+                ctx:      ctx,
+                cancel:   cancel,
+        }
+}
+
+// Start runs all jobs once immediately and then on every tick until Stop is called
+func (w *Worker) Start() {
+        w.wg.Add(1)
+        go func() {
+                defer w.wg.Done()
+
+                ticker := time.NewTicker(w.interval)
+                defer ticker.Stop()
+
+                w.runJobs()
+                for {
+                        select {
+                        case <-ticker.C:
+                                w.runJobs()
+                        case <-w.stop:
+                                return
+                        }
+                }
+        }()
+
+        slog.Info("Background worker started", "jobs", len(w.jobs), "interval", w.interval.String())
+}
+
+// Stop signals the worker to exit, cancels the running job and waits for it to return
+func (w *Worker) Stop() {
+        w.stopOnce.Do(func() {
+                close(w.stop)
+                w.cancel()
+        })
+        w.wg.Wait()
+        slog.Info("Background worker stopped")
+}
+
+// runJobs runs each job in turn, stopping early if shutdown was requested
+func (w *Worker) runJobs() {
+        now := time.Now()
+        for _, job := range w.jobs {
+                select {
+                case <-w.stop:
+                        return
+                default:
+                }
+
+                logger := slog.Default().With("job", job.Name)
+                ctx := WithLogger(w.ctx, logger)
+
+                start := time.Now()
+                if err := job.Run(ctx, now); err != nil {
+                        logger.Error("Job failed", "durationMs", time.Since(start).Milliseconds(), "error", err)
+                        continue
+                }
+                logger.Info("Job finished", "durationMs", time.Since(start).Milliseconds())
+        }
+}